@@ -0,0 +1,270 @@
+// Command e2e runs an end-to-end check against a running server: it uploads
+// files, follows each job through the queue to its output, downloads the
+// output when it is kept on the server, and checks the webhooks the server
+// sent on the way. Point it at a server started with TRANSCODER_BACKEND=fake
+// to test an integration without ffmpeg. It exits non-zero if any check
+// fails.
+//
+// The server and credentials come from TRANSCODER_URL and
+// TRANSCODER_API_KEY, or the -server and -api-key flags. The server must be
+// able to reach -webhook-url, which the command serves on -listen.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/skillcape/transcoder/pkg/client"
+)
+
+func main() {
+	server := flag.String("server", envOr("TRANSCODER_URL", "http://localhost:8080"), "server base URL")
+	apiKey := flag.String("api-key", os.Getenv("TRANSCODER_API_KEY"), "API key")
+	listen := flag.String("listen", ":9099", "address to receive webhooks on")
+	webhookURL := flag.String("webhook-url", "http://localhost:9099/webhook", "URL the server sends this run's webhooks to")
+	secret := flag.String("secret", os.Getenv("WEBHOOK_SECRET"), "WEBHOOK_SECRET to verify webhook signatures with")
+	file := flag.String("file", "", "file to upload (defaults to a generated stub the fake backend accepts)")
+	count := flag.Int("jobs", 3, "jobs to run")
+	preset := flag.String("preset", "", "preset for the jobs")
+	expectCopy := flag.Bool("expect-copy", true, "expect outputs to equal their input, as the fake backend makes them")
+	allowFailures := flag.Bool("allow-failures", false, "pass jobs that fail cleanly, as with FAKE_FAILURE_RATE")
+	timeout := flag.Duration("timeout", 5*time.Minute, "how long the whole run may take")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	input, name := stubInput(), "e2e-stub.mp4"
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		input, name = data, filepath.Base(*file)
+	}
+
+	receiver := newReceiver(*secret)
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen for webhooks: %v", err)
+	}
+	go http.Serve(listener, receiver)
+
+	r := &run{
+		client:     client.New(*server, *apiKey, client.WithUserAgent("transcoder-e2e")),
+		receiver:   receiver,
+		webhookURL: *webhookURL,
+		preset:     *preset,
+		input:      input,
+		name:       name,
+		expectCopy: *expectCopy,
+		allowFails: *allowFailures,
+	}
+
+	var wg sync.WaitGroup
+	results := make([]error, *count)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = r.job(ctx, i+1)
+		}(i)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range results {
+		if err != nil {
+			failed++
+			log.Printf("FAIL job %d: %v", i+1, err)
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d of %d jobs failed their checks", failed, *count)
+		os.Exit(1)
+	}
+	log.Printf("PASS: %d jobs", *count)
+}
+
+// run holds what every job of a run shares
+type run struct {
+	client     *client.Client
+	receiver   *receiver
+	webhookURL string
+	preset     string
+	input      []byte
+	name       string
+	expectCopy bool
+	allowFails bool
+}
+
+// webhookWait is how long webhooks may trail the job finishing
+const webhookWait = 30 * time.Second
+
+// job runs the n'th job of the run through every check
+func (r *run) job(ctx context.Context, n int) error {
+	job, err := r.client.CreateJob(ctx, r.name, bytes.NewReader(r.input), &client.JobOptions{
+		Preset:     r.preset,
+		Labels:     []string{"e2e"},
+		WebhookURL: r.webhookURL,
+		HookEvents: []string{"*"},
+	})
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	log.Printf("job %d: created %s", n, job.ID)
+
+	job, err = r.client.WatchJob(ctx, job.ID, time.Second, func(job *client.Job) error {
+		log.Printf("job %d: %s %d%%", n, job.Status, job.Progress)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("watch: %w", err)
+	}
+
+	final := client.EventJobCompleted
+	switch job.Status {
+	case client.StatusCompleted:
+		if err := r.checkOutput(ctx, job); err != nil {
+			return err
+		}
+	case client.StatusFailed, client.StatusDeadLetter:
+		if !r.allowFails {
+			return fmt.Errorf("job %s: %s", job.Status, job.Error)
+		}
+		if job.Error == "" {
+			return fmt.Errorf("job %s without an error", job.Status)
+		}
+		final = client.EventJobFailed
+	default:
+		return fmt.Errorf("job ended %s", job.Status)
+	}
+
+	want := []string{client.EventJobCreated, client.EventJobStarted, final}
+	if err := r.receiver.wait(ctx, job.ID, want, webhookWait); err != nil {
+		return err
+	}
+	log.Printf("job %d: %s, webhooks %v", n, job.Status, r.receiver.events(job.ID))
+	return nil
+}
+
+// checkOutput fetches a completed job's output, unless it was delivered
+// to a destination
+func (r *run) checkOutput(ctx context.Context, job *client.Job) error {
+	if job.OutputProbe == nil {
+		return fmt.Errorf("completed without an output probe")
+	}
+	if job.DriveURL != "" {
+		return nil
+	}
+	var output bytes.Buffer
+	if _, err := r.client.Download(ctx, job.ID, &output); err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	if output.Len() == 0 {
+		return fmt.Errorf("output is empty")
+	}
+	if r.expectCopy && !bytes.Equal(output.Bytes(), r.input) {
+		return fmt.Errorf("output differs from the input (%d bytes, want %d)", output.Len(), len(r.input))
+	}
+	return nil
+}
+
+// receiver records the webhooks the server sends, by job
+type receiver struct {
+	secret string
+
+	mu       sync.Mutex
+	received map[string][]string
+	changed  chan struct{}
+}
+
+func newReceiver(secret string) *receiver {
+	return &receiver{secret: secret, received: make(map[string][]string), changed: make(chan struct{})}
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := client.ParseWebhook(r, rc.secret)
+	if err != nil {
+		log.Printf("Rejected webhook: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc.mu.Lock()
+	rc.received[payload.JobID] = append(rc.received[payload.JobID], payload.Event)
+	close(rc.changed)
+	rc.changed = make(chan struct{})
+	rc.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// events returns the events received for a job, in order of arrival
+func (rc *receiver) events(jobID string) []string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]string(nil), rc.received[jobID]...)
+}
+
+// wait waits up to timeout for every event in want to arrive for a job
+func (rc *receiver) wait(ctx context.Context, jobID string, want []string, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		rc.mu.Lock()
+		missing := missingEvents(rc.received[jobID], want)
+		changed := rc.changed
+		rc.mu.Unlock()
+		if len(missing) == 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return fmt.Errorf("webhooks %v never arrived", missing)
+		case <-ctx.Done():
+			return fmt.Errorf("webhooks %v never arrived: %w", missing, ctx.Err())
+		}
+	}
+}
+
+// missingEvents returns the events in want that aren't in got
+func missingEvents(got, want []string) []string {
+	seen := make(map[string]bool, len(got))
+	for _, event := range got {
+		seen[event] = true
+	}
+	var missing []string
+	for _, event := range want {
+		if !seen[event] {
+			missing = append(missing, event)
+		}
+	}
+	return missing
+}
+
+// stubInput returns a small file with an MP4 header, enough to pass the
+// server's upload checks when the fake backend probes it
+func stubInput() []byte {
+	data := []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2")
+	return append(data, bytes.Repeat([]byte{0}, 4096)...)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// extractCaptions saves the closed captions of the job's input in each
+// format the preset asks for, shifted past any intro. The output is
+// usable without them, so failures are only logged.
+func extractCaptions(ctx context.Context, job *jobs.Job, preset *transcoder.Preset, intro time.Duration, localStorage *storage.LocalStorage) {
+	job.ClosedCaps = nil
+	info := job.InputProbe
+	if info == nil || !info.HasClosedCaptions() {
+		return
+	}
+	formats := preset.CaptionFormats()
+	if len(formats) == 0 {
+		if preset.ClosedCaptions == "" {
+			requestid.Logf(ctx, "Job %s: input has closed captions, which preset %q neither keeps nor extracts", job.ID, preset.Name)
+		}
+		return
+	}
+	// Caption timings follow the first input only
+	if len(job.InputFiles()) > 1 {
+		requestid.Logf(ctx, "Job %s: closed captions are not extracted from concatenated inputs", job.ID)
+		return
+	}
+
+	for _, format := range formats {
+		path := job.CaptionPath(format)
+		if err := transcoder.ExtractClosedCaptions(ctx, job.InputFiles()[0], info, format, path, intro); err != nil {
+			requestid.Logf(ctx, "Job %s: %v", job.ID, err)
+			localStorage.DeleteFile(path)
+			continue
+		}
+		job.ClosedCaps = append(job.ClosedCaps, jobs.Caption{Format: format})
+	}
+}
+
+// uploadCaptions uploads the job's extracted captions beside its output,
+// skipping any already uploaded
+func uploadCaptions(ctx context.Context, dest *destination, t *tenant.Tenant, job *jobs.Job) error {
+	for i := range job.ClosedCaps {
+		caption := &job.ClosedCaps[i]
+		if caption.FileID != "" {
+			continue
+		}
+		fileID, link, err := uploadTo(ctx, dest, t, job.CaptionPath(caption.Format), job.CaptionName(caption.Format))
+		if err != nil {
+			return err
+		}
+		caption.FileID, caption.URL = fileID, link
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/sigv4"
+)
+
+// googleCredentials returns the service account key for Google APIs:
+// GOOGLE_CREDENTIALS when set, such as from a secret manager, otherwise
+// the contents of GOOGLE_CREDENTIALS_FILE. Either may be base64 encoded.
+func googleCredentials(cfg *config.Config) ([]byte, error) {
+	if cfg.GoogleCredentials != "" {
+		return decodeCredentials([]byte(cfg.GoogleCredentials)), nil
+	}
+	return readCredentials(cfg.GoogleCredentialsFile)
+}
+
+// s3Credentials returns the keys an S3 destination signs with: the
+// profile's own, its credentials_file, S3_CREDENTIALS, S3_CREDENTIALS_FILE,
+// then the standard AWS variables, whichever is set first
+func s3Credentials(cfg *config.Config, profile config.DestinationProfile) (sigv4.Credentials, error) {
+	if profile.AccessKeyID != "" {
+		return sigv4.Credentials{AccessKeyID: profile.AccessKeyID, SecretAccessKey: profile.SecretAccessKey}, nil
+	}
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case profile.CredentialsFile != "":
+		data, err = readCredentials(profile.CredentialsFile)
+	case cfg.S3Credentials != "":
+		data = decodeCredentials([]byte(cfg.S3Credentials))
+	case cfg.S3CredentialsFile != "":
+		data, err = readCredentials(cfg.S3CredentialsFile)
+	default:
+		return sigv4.EnvCredentials(), nil
+	}
+	if err != nil {
+		return sigv4.Credentials{}, err
+	}
+
+	var keys struct {
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+		SessionToken    string `json:"session_token"`
+	}
+	// The decoder's errors don't quote the input, so no key can leak into
+	// the message
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return sigv4.Credentials{}, fmt.Errorf("failed to parse S3 credentials: %v", err)
+	}
+	if keys.AccessKeyID == "" || keys.SecretAccessKey == "" {
+		return sigv4.Credentials{}, fmt.Errorf("S3 credentials need access_key_id and secret_access_key")
+	}
+	return sigv4.Credentials{
+		AccessKeyID:     keys.AccessKeyID,
+		SecretAccessKey: keys.SecretAccessKey,
+		SessionToken:    keys.SessionToken,
+	}, nil
+}
+
+// readCredentials reads a credentials file, such as a mounted secret, and
+// decodes it in memory. A file anyone on the host can read is used but
+// warned about.
+func readCredentials(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		log.Printf("Warning: credentials file %s is world-readable; restrict it to the server's user (chmod 600)", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	return decodeCredentials(data), nil
+}
+
+// decodeCredentials returns JSON credentials as they are, and base64
+// encoded ones decoded. Anything else is returned for the caller's parser
+// to reject.
+func decodeCredentials(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] == '{' {
+		return data
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(string(data)); err == nil {
+			return bytes.TrimSpace(decoded)
+		}
+	}
+	return data
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/sigv4"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+var destinationNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// destinations are the places outputs can be uploaded: the named profiles
+// from the config file, and the Drive folder set by GOOGLE_DRIVE_FOLDER_ID
+type destinations struct {
+	profiles    map[string]storage.Destination
+	types       map[string]string
+	slots       map[string]chan struct{}   // of profiles with max_uploads
+	defaultName string                     // DESTINATION
+	drive       *storage.GoogleDriveClient // used when no profile applies; may be nil
+	driveSlots  chan struct{}              // GOOGLE_DRIVE_MAX_UPLOADS
+}
+
+// destination is where one job's output goes
+type destination struct {
+	name     string // profile name, empty for the GOOGLE_* Drive folder
+	kind     string
+	upload   storage.Destination
+	explicit bool          // chosen by the job or its preset
+	slots    chan struct{} // one per upload in progress; nil when unlimited
+}
+
+// openDestinations creates a client for every destination profile
+func openDestinations(ctx context.Context, cfg *config.Config, drive *storage.GoogleDriveClient) (*destinations, error) {
+	d := &destinations{
+		profiles:    make(map[string]storage.Destination),
+		types:       make(map[string]string),
+		slots:       make(map[string]chan struct{}),
+		defaultName: cfg.DefaultDestination,
+		drive:       drive,
+	}
+	if cfg.GoogleDriveMaxUploads > 0 {
+		d.driveSlots = make(chan struct{}, cfg.GoogleDriveMaxUploads)
+	}
+	for name, profile := range cfg.Destinations {
+		var (
+			upload storage.Destination
+			err    error
+		)
+		switch profile.Type {
+		case config.DestinationGoogleDrive:
+			var credentials []byte
+			if profile.CredentialsFile != "" {
+				credentials, err = readCredentials(profile.CredentialsFile)
+			} else {
+				credentials, err = googleCredentials(cfg)
+			}
+			if err == nil {
+				var drive *storage.GoogleDriveClient
+				drive, err = storage.NewGoogleDriveClient(ctx, credentials, profile.FolderID)
+				if err == nil {
+					drive.SetOnConflict(profile.OnConflict)
+					upload = drive
+				}
+			}
+		case config.DestinationS3:
+			var creds sigv4.Credentials
+			if creds, err = s3Credentials(cfg, profile); err != nil {
+				break
+			}
+			region := profile.Region
+			if region == "" {
+				region = sigv4.EnvRegion()
+			}
+			upload, err = storage.NewS3Client(storage.S3Config{
+				Bucket:      profile.Bucket,
+				Region:      region,
+				Endpoint:    profile.Endpoint,
+				Prefix:      profile.Prefix,
+				BaseURL:     profile.BaseURL,
+				Credentials: creds,
+
+				StorageClass: profile.StorageClass,
+				Tags:         profile.Tags,
+				CacheControl: profile.CacheControl,
+			})
+		case config.DestinationDirectory:
+			upload, err = storage.NewDirectoryDestination(profile.Path, profile.BaseURL)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("destination %q: %v", name, err)
+		}
+		d.profiles[name] = upload
+		d.types[name] = profile.Type
+		if profile.MaxUploads > 0 {
+			d.slots[name] = make(chan struct{}, profile.MaxUploads)
+		}
+	}
+	return d, nil
+}
+
+// forJob picks the job's destination profile, then its preset's, then
+// DESTINATION, then the GOOGLE_* Drive folder. It returns nil when outputs
+// stay on the server.
+func (d *destinations) forJob(job *jobs.Job, preset *transcoder.Preset) (*destination, error) {
+	name, explicit := job.Destination, true
+	if name == "" {
+		name = preset.Destination
+	}
+	if name == "" {
+		name, explicit = d.defaultName, false
+	}
+	if name == "" {
+		if d.drive == nil {
+			return nil, nil
+		}
+		return &destination{kind: config.DestinationGoogleDrive, upload: d.drive, slots: d.driveSlots}, nil
+	}
+	dest, err := d.named(name)
+	if err != nil {
+		return nil, err
+	}
+	dest.explicit = explicit
+	return dest, nil
+}
+
+// named returns the destination profile called name
+func (d *destinations) named(name string) (*destination, error) {
+	upload, ok := d.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown destination %q", name)
+	}
+	return &destination{name: name, kind: d.types[name], upload: upload, explicit: true, slots: d.slots[name]}, nil
+}
+
+// Presign returns a link to a file uploaded to the named destination, of
+// the given type, if it can make one; see api.Presigner
+func (d *destinations) Presign(name, kind, fileID, fileName string, ttl time.Duration) (string, bool, error) {
+	dest, err := d.named(name)
+	if err != nil || kind != "" && dest.kind != kind {
+		return "", false, nil
+	}
+	presigner, ok := dest.upload.(storage.Presigner)
+	if !ok {
+		return "", false, nil
+	}
+	link, err := presigner.PresignGet(fileID, fileName, ttl)
+	return link, err == nil, err
+}
+
+// check makes sure the default destination, the one most jobs upload
+// to, is reachable. Destinations that can't be checked pass.
+func (d *destinations) check(ctx context.Context) error {
+	dest, err := d.forJob(&jobs.Job{}, &transcoder.Preset{})
+	if err != nil || dest == nil {
+		return err
+	}
+	if checker, ok := dest.upload.(storage.Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// reserve waits for a free upload slot at the destination, calling waiting
+// first if there is none, and returns a func that frees the slot again.
+// Destinations without max_uploads never wait.
+func (d *destination) reserve(ctx context.Context, waiting func() error) (release func(), err error) {
+	if d.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		if waiting != nil {
+			if err := waiting(); err != nil {
+				return nil, err
+			}
+		}
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-d.slots }, nil
+}
+
+// uploadTo stores the output at dest. A tenant's Drive folder replaces the
+// folder of a Drive destination the job didn't choose itself.
+func uploadTo(ctx context.Context, dest *destination, t *tenant.Tenant, filePath, fileName string) (fileID, link string, err error) {
+	if drive, ok := dest.upload.(*storage.GoogleDriveClient); ok && !dest.explicit && t != nil && t.DriveFolderID != "" {
+		return drive.UploadFileTo(ctx, t.DriveFolderID, filePath, fileName)
+	}
+	return dest.upload.UploadFile(ctx, filePath, fileName)
+}
+
+// validateDestinations checks the profiles in the config file and that
+// DESTINATION names one of them
+func validateDestinations(cfg *config.Config) error {
+	for name, profile := range cfg.Destinations {
+		if !destinationNameRegex.MatchString(name) {
+			return fmt.Errorf("destination %q: name must be 1-64 lowercase letters, digits, '.', '_' or '-'", name)
+		}
+		var missing string
+		switch profile.Type {
+		case config.DestinationGoogleDrive:
+			if profile.FolderID == "" {
+				missing = "folder_id"
+			} else if profile.CredentialsFile == "" && cfg.GoogleCredentials == "" && cfg.GoogleCredentialsFile == "" {
+				missing = "credentials_file"
+			}
+		case config.DestinationS3:
+			if profile.Bucket == "" {
+				missing = "bucket"
+			}
+		case config.DestinationDirectory:
+			if profile.Path == "" {
+				missing = "path"
+			}
+		default:
+			return fmt.Errorf("destination %q: type must be google_drive, s3 or directory", name)
+		}
+		if missing != "" {
+			return fmt.Errorf("destination %q: %s is required", name, missing)
+		}
+		if profile.MaxUploads < 0 {
+			return fmt.Errorf("destination %q: max_uploads must not be negative", name)
+		}
+		if profile.OnConflict != "" && profile.Type != config.DestinationGoogleDrive {
+			return fmt.Errorf("destination %q: on_conflict only applies to google_drive", name)
+		}
+		if err := storage.ValidateConflict(profile.OnConflict); err != nil {
+			return fmt.Errorf("destination %q: on_conflict %v", name, err)
+		}
+		if (profile.StorageClass != "" || len(profile.Tags) > 0 || profile.CacheControl != "") && profile.Type != config.DestinationS3 {
+			return fmt.Errorf("destination %q: storage_class, tags and cache_control only apply to s3", name)
+		}
+		if err := storage.ValidateStorageClass(profile.StorageClass); err != nil {
+			return fmt.Errorf("destination %q: storage_class %v", name, err)
+		}
+		if err := storage.ValidateTags(profile.Tags); err != nil {
+			return fmt.Errorf("destination %q: tags: %v", name, err)
+		}
+	}
+	if cfg.GoogleDriveMaxUploads < 0 {
+		return fmt.Errorf("GOOGLE_DRIVE_MAX_UPLOADS: must not be negative")
+	}
+	if err := storage.ValidateConflict(cfg.GoogleDriveOnConflict); err != nil {
+		return fmt.Errorf("GOOGLE_DRIVE_ON_CONFLICT: %v", err)
+	}
+	if name := cfg.DefaultDestination; name != "" {
+		if _, ok := cfg.Destinations[name]; !ok {
+			return fmt.Errorf("DESTINATION: unknown destination %q", name)
+		}
+	}
+	for _, preset := range cfg.Presets {
+		if _, ok := cfg.Destinations[preset.Destination]; preset.Destination != "" && !ok {
+			return fmt.Errorf("preset %q: unknown destination %q", preset.Name, preset.Destination)
+		}
+	}
+	return nil
+}
+
+// uploadName describes dest in error messages
+func uploadName(dest *destination) string {
+	if dest.name == "" {
+		return "drive"
+	}
+	return "destination " + dest.name
+}
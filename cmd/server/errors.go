@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/skillcape/transcoder/internal/hooks"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// errorCode returns the job error code for the cause of err, or fallback
+// when its cause isn't known
+func errorCode(err error, fallback string) string {
+	var netErr net.Error
+	switch {
+	// A full destination directory is the destination's problem, not ours
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return jobs.ErrorDestQuotaExceeded
+	case errors.Is(err, transcoder.ErrInputCorrupt):
+		return jobs.ErrorInputCorrupt
+	case errors.Is(err, transcoder.ErrUnsupportedCodec):
+		return jobs.ErrorUnsupportedCodec
+	case errors.Is(err, transcoder.ErrDiskFull), errors.Is(err, syscall.ENOSPC):
+		return jobs.ErrorDiskFull
+	case errors.Is(err, transcoder.ErrOutOfMemory):
+		return jobs.ErrorOutOfMemory
+	case errors.Is(err, transcoder.ErrInterrupted):
+		return jobs.ErrorInterrupted
+	case errors.Is(err, hooks.ErrTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return jobs.ErrorTimeout
+	}
+	return fallback
+}
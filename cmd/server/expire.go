@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/clock"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// expireInterval is how often the queue is checked for jobs pending longer
+// than JOB_MAX_QUEUE_HOURS
+const expireInterval = time.Minute
+
+// expireJobs ends jobs that have been pending longer than maxAge as
+// expired, every expireInterval until ctx is done. Each replica expires
+// the jobs in its own queue.
+func expireJobs(ctx context.Context, repo db.JobRepository, jobQueue *jobs.Queue, localStorage *storage.LocalStorage, notifier *webhook.Notifier, hours int) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		for _, queued := range jobQueue.Expire(time.Duration(hours)*time.Hour, time.Now().UTC()) {
+			expireJob(repo, jobQueue, localStorage, notifier, queued, hours)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireJob marks a job dropped from the queue as expired, removes its
+// uploaded files and sends job.expired. A job changed in the meantime is
+// reloaded; if it is no longer pending it is left as it is.
+func expireJob(repo db.JobRepository, jobQueue *jobs.Queue, localStorage *storage.LocalStorage, notifier *webhook.Notifier, queued *jobs.Job, hours int) {
+	ctx := requestid.NewContext(context.Background(), queued.RequestID)
+	for attempt := 1; ; attempt++ {
+		job, err := repo.GetJob(queued.ID)
+		if err != nil {
+			requestid.Logf(ctx, "Job %s: failed to expire: %v", queued.ID, err)
+			return
+		}
+		if job.Status != jobs.StatusPending {
+			return
+		}
+		if err := job.Transition(jobs.StatusExpired, jobs.ActorExpiry, job.RequestID, clock.System); err != nil {
+			requestid.Logf(ctx, "Job %s: failed to expire: %v", job.ID, err)
+			return
+		}
+		job.Error = fmt.Sprintf("pending for more than %d hours", hours)
+		job.ErrorCode = jobs.ErrorTimeout
+		job.UpdatedAt = time.Now().UTC()
+		err = repo.UpdateJob(job)
+		if errors.Is(err, db.ErrStaleJob) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			// Back in the queue, to be tried again next time
+			requestid.Logf(ctx, "Job %s: failed to expire: %v", job.ID, err)
+			if err := jobQueue.Enqueue(queued); err != nil {
+				log.Printf("Failed to re-enqueue job %s: %v", job.ID, err)
+			}
+			return
+		}
+
+		requestid.Logf(ctx, "Job %s expired: %s", job.ID, job.Error)
+		localStorage.CleanupJob(job.UploadedFiles())
+		repo.RecordJobEvent(job.ID, jobs.EventExpired, job.RequestID, job.Error)
+		var t *tenant.Tenant
+		if job.TenantID != "" {
+			t, _ = db.GetTenant(job.TenantID)
+		}
+		notifier.Notify(job, t, webhook.EventJobExpired, &webhook.Payload{Error: job.Error, ErrorCode: job.ErrorCode})
+		return
+	}
+}
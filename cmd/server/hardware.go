@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/kube"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// hardwareCheckTimeout bounds the startup check of the hardware encoder
+const hardwareCheckTimeout = 30 * time.Second
+
+// hardwareEncoding is the hardware encoder jobs can use, if any
+type hardwareEncoding struct {
+	name        string // HARDWARE_ENCODER
+	unavailable string // why jobs can't use it, when they can't
+}
+
+// newHardwareEncoding checks the HARDWARE_ENCODER works. Encodes in
+// Kubernetes run on other nodes, so it is taken on trust there.
+func newHardwareEncoding(cfg *config.Config, executor *kube.Executor) hardwareEncoding {
+	h := hardwareEncoding{name: cfg.HardwareEncoder}
+	switch {
+	case h.name == "":
+		h.unavailable = "HARDWARE_ENCODER is not set"
+	case executor != nil:
+		if h.name == transcoder.HardwareNVENC && cfg.K8sGPUCount == 0 {
+			log.Printf("Warning: HARDWARE_ENCODER is nvenc but K8S_GPU_COUNT is 0, so encode pods may get no GPU")
+		}
+		log.Printf("Encoding video with %s where jobs allow it", h.name)
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), hardwareCheckTimeout)
+		defer cancel()
+		if err := transcoder.CheckHardware(ctx, h.name); err != nil {
+			log.Printf("Encoding in software only: %v", err)
+			h.unavailable = err.Error()
+			return h
+		}
+		log.Printf("Encoding video with %s where jobs allow it", h.name)
+	}
+	return h
+}
+
+// preset returns the preset a job's video is encoded with: preset itself,
+// or a copy using the hardware encoder. The job's encoding, else the
+// preset's, decides: software never uses it, auto does when it can stand
+// in for the preset's codec, and hardware fails the job when it can't.
+func (h hardwareEncoding) preset(job *jobs.Job, preset *transcoder.Preset) (*transcoder.Preset, error) {
+	encoding := job.Encoding
+	if encoding == "" {
+		encoding = preset.Encoding
+	}
+	switch encoding {
+	case transcoder.EncodingSoftware:
+		return preset, nil
+	case transcoder.EncodingHardware:
+		if h.unavailable != "" {
+			return nil, fmt.Errorf("hardware encoding required but unavailable: %s", h.unavailable)
+		}
+		hardware, err := preset.WithHardware(h.name)
+		if err != nil {
+			return nil, fmt.Errorf("hardware encoding required but unavailable: %v", err)
+		}
+		return hardware, nil
+	default:
+		if h.unavailable != "" {
+			return preset, nil
+		}
+		if hardware, err := preset.WithHardware(h.name); err == nil {
+			return hardware, nil
+		}
+		return preset, nil
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// packageHLS splits the job's output into segments of about segment, and
+// the playlist delivered in its place. Renditions are packaged too, as an
+// adaptive ladder with the output.
+func packageHLS(ctx context.Context, job *jobs.Job, segment time.Duration) error {
+	job.HLS = nil
+	fmp4 := job.Packaging == transcoder.PackagingHLSFMP4
+	count, err := transcoder.PackageHLS(ctx, job.OutputPath, job.HLSPath(), job.HLSDir(), segment, fmp4)
+	if err != nil {
+		os.RemoveAll(job.HLSDir())
+		return err
+	}
+	hls := &jobs.HLSFiles{SegmentType: "ts", Segments: count}
+	if fmp4 {
+		hls.SegmentType = "fmp4"
+	}
+
+	var renditions []string
+	for _, variant := range job.Variants {
+		if variant.Ext != "" {
+			renditions = append(renditions, variant.Name)
+		}
+	}
+	if len(renditions) > 0 {
+		// cleanup removes what was packaged, should any stream fail
+		cleanup := func() {
+			os.Remove(job.HLSPath())
+			os.RemoveAll(job.HLSDir())
+			for _, name := range renditions {
+				os.Remove(job.HLSStreamPath(name))
+				os.RemoveAll(job.HLSStreamDir(name))
+			}
+		}
+		main, err := hlsStream(ctx, job, jobs.HLSMainStream, job.OutputPath, count)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		hls.Streams = append(hls.Streams, main)
+		for _, name := range renditions {
+			count, err := transcoder.PackageHLS(ctx, job.VariantPath(name), job.HLSStreamPath(name), job.HLSStreamDir(name), segment, fmp4)
+			if err != nil {
+				cleanup()
+				return fmt.Errorf("rendition %s: %w", name, err)
+			}
+			stream, err := hlsStream(ctx, job, name, job.VariantPath(name), count)
+			if err != nil {
+				cleanup()
+				return fmt.Errorf("rendition %s: %w", name, err)
+			}
+			hls.Streams = append(hls.Streams, stream)
+		}
+	}
+	job.HLS = hls
+	return nil
+}
+
+// hlsStream describes the packaged stream of a ladder made from the file
+// at path
+func hlsStream(ctx context.Context, job *jobs.Job, name, path string, segments int) (jobs.HLSStream, error) {
+	bandwidth, err := transcoder.PeakBandwidth(job.HLSStreamPath(name), job.HLSStreamDir(name))
+	if err != nil {
+		return jobs.HLSStream{}, err
+	}
+	stream := jobs.HLSStream{Name: name, Bandwidth: bandwidth, Segments: segments}
+	if info := probeFile(ctx, job.ID, path); info != nil {
+		stream.Width, stream.Height = info.Width, info.Height
+		stream.Codecs = transcoder.StreamCodecs(info)
+	}
+	return stream, nil
+}
+
+// uploadHLS uploads the job's HLS segments and playlists, skipping any
+// already uploaded, ending with the playlist delivered in place of the
+// output, and returns that playlist's ID and link
+func uploadHLS(ctx context.Context, dest *destination, t *tenant.Tenant, job *jobs.Job) (fileID, link string, err error) {
+	hls := job.HLS
+	if len(hls.Streams) == 0 {
+		fileID, link, err = uploadHLSStream(ctx, dest, t, job, jobs.HLSMainStream, job.HLSName())
+		if err != nil {
+			return "", "", err
+		}
+		hls.URL = link
+		return fileID, link, nil
+	}
+
+	// A ladder's streams go first, then the master playlist listing them
+	streams := make([]transcoder.MasterStream, 0, len(hls.Streams))
+	for i := range hls.Streams {
+		stream := &hls.Streams[i]
+		if stream.FileID == "" {
+			stream.FileID, stream.URL, err = uploadHLSStream(ctx, dest, t, job, stream.Name, job.HLSStreamName(stream.Name))
+			if err != nil {
+				return "", "", err
+			}
+		}
+		uri := job.HLSStreamName(stream.Name)
+		if dest.kind == config.DestinationGoogleDrive {
+			uri = stream.URL
+		}
+		streams = append(streams, transcoder.MasterStream{URI: uri, Bandwidth: stream.Bandwidth, Width: stream.Width, Height: stream.Height, Codecs: stream.Codecs})
+	}
+	master, err := hlsTempFile(job)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(master)
+	if err := transcoder.WriteMasterPlaylist(master, streams); err != nil {
+		return "", "", err
+	}
+	fileID, link, err = uploadTo(ctx, dest, t, master, job.HLSName())
+	if err != nil {
+		return "", "", err
+	}
+	hls.URL = link
+	return fileID, link, nil
+}
+
+// uploadHLSStream uploads the segments of one of the job's streams,
+// skipping any already uploaded, then its playlist as name, and returns
+// the playlist's ID and link
+func uploadHLSStream(ctx context.Context, dest *destination, t *tenant.Tenant, job *jobs.Job, stream, name string) (fileID, link string, err error) {
+	hls := job.HLS
+	// The output's segments are recorded by name alone, a rendition's
+	// with the stream's name before it
+	key := func(name string) string {
+		if stream == jobs.HLSMainStream {
+			return name
+		}
+		return stream + "/" + name
+	}
+	uploaded := make(map[string]jobs.HLSFile, len(hls.Uploaded))
+	for _, file := range hls.Uploaded {
+		uploaded[file.Name] = file
+	}
+	upload := storage.DestinationFunc(func(ctx context.Context, filePath, fileName string) (string, string, error) {
+		return uploadTo(ctx, dest, t, filePath, fileName)
+	})
+	err = storage.UploadDir(ctx, upload, job.HLSStreamDir(stream), job.HLSStreamSegmentPrefix(stream),
+		func(name string) bool {
+			_, done := uploaded[key(name)]
+			return done
+		},
+		func(name, fileID, link string) {
+			file := jobs.HLSFile{Name: key(name), URL: link, FileID: fileID}
+			uploaded[file.Name] = file
+			hls.Uploaded = append(hls.Uploaded, file)
+		})
+	if err != nil {
+		return "", "", err
+	}
+
+	// The playlist refers to the segments by name, beside it, except on
+	// Drive, whose files can't be found by name
+	segmentURI := func(name string) string {
+		return job.HLSStreamSegmentPrefix(stream) + name
+	}
+	if dest.kind == config.DestinationGoogleDrive {
+		segmentURI = func(name string) string {
+			return uploaded[key(name)].URL
+		}
+	}
+	playlist, err := hlsTempFile(job)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(playlist)
+	if _, err := transcoder.RewritePlaylist(job.HLSStreamPath(stream), playlist, segmentURI); err != nil {
+		return "", "", err
+	}
+	return uploadTo(ctx, dest, t, playlist, name)
+}
+
+// hlsTempFile creates an empty file beside the job's playlist to write a
+// playlist to before it is uploaded
+func hlsTempFile(job *jobs.Job) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(job.HLSPath()), strings.TrimSuffix(filepath.Base(job.HLSPath()), ".m3u8")+"-*.m3u8")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+// playlistURL returns the link to the job's HLS playlist, if it has one
+func playlistURL(job *jobs.Job) string {
+	if job.HLS == nil {
+		return ""
+	}
+	return job.HLS.URL
+}
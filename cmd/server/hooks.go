@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/hooks"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/pipeline"
+	"github.com/skillcape/transcoder/internal/requestid"
+)
+
+// jobHooks are the PRE_TRANSCODE_HOOK and POST_TRANSCODE_HOOK hooks;
+// either may be nil
+type jobHooks struct {
+	pre, post *hooks.Hook
+}
+
+// runHook runs hook for job, if set, recording its output in the job's
+// events. A pre hook replying with an output name renames the output.
+func runHook(ctx context.Context, repo db.JobRepository, hook *hooks.Hook, job *jobs.Job) error {
+	if hook == nil {
+		return nil
+	}
+	result, err := hook.Run(ctx, job)
+	message := hook.Stage + " hook"
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	if result != nil && result.Output != "" {
+		message += ": " + result.Output
+	}
+	if err != nil {
+		requestid.Logf(ctx, "Job %s: %s hook failed: %v", job.ID, hook.Stage, err)
+		repo.RecordJobEvent(job.ID, jobs.EventHookFailed, job.RequestID, message)
+		return fmt.Errorf("%s hook failed: %w", hook.Stage, err)
+	}
+	repo.RecordJobEvent(job.ID, jobs.EventHookSucceeded, job.RequestID, message)
+	if result.OutputName != "" {
+		job.NameTemplate = result.OutputName
+	}
+	return nil
+}
+
+// runSteps runs the pipeline steps registered for phase in order,
+// stopping at the first that fails
+func runSteps(ctx context.Context, repo db.JobRepository, phase string, job *jobs.Job) error {
+	for _, step := range pipeline.Steps(phase) {
+		if err := step.Run(ctx, job); err != nil {
+			requestid.Logf(ctx, "Job %s: step %s failed: %v", job.ID, step.Name(), err)
+			repo.RecordJobEvent(job.ID, jobs.EventStepFailed, job.RequestID, step.Name()+": "+err.Error())
+			return fmt.Errorf("step %s failed: %w", step.Name(), err)
+		}
+		repo.RecordJobEvent(job.ID, jobs.EventStepSucceeded, job.RequestID, step.Name())
+	}
+	return nil
+}
+
+// registerPlugins registers a step for each PIPELINE_PLUGINS entry
+func registerPlugins(specs []string, timeout time.Duration) error {
+	for _, spec := range specs {
+		phase, name, target, err := pipeline.ParsePlugin(spec)
+		if err != nil {
+			return err
+		}
+		step, err := pipeline.DialPlugin(name, phase, target, timeout)
+		if err != nil {
+			return err
+		}
+		pipeline.Register(phase, step)
+	}
+	return nil
+}
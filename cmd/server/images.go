@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// resizeImage makes an image job's variants. The largest becomes the job's
+// output; the rest are kept as its variants.
+func resizeImage(ctx context.Context, job *jobs.Job, settings transcoder.ImageSettings, onProgress func(int)) error {
+	// The format the job was created with, in case IMAGE_FORMAT changed since
+	settings.Format = transcoder.ImageFormatForExt(job.OutputFileExt())
+
+	made, err := transcoder.ResizeImage(ctx, job.InputFiles()[0], settings, job.VariantPath, onProgress)
+	if err != nil {
+		for _, variant := range made {
+			os.Remove(variant.Path)
+		}
+		return err
+	}
+	if err := os.Rename(made[0].Path, job.OutputPath); err != nil {
+		return err
+	}
+
+	job.Variants = nil
+	for _, variant := range made[1:] {
+		size, _ := fileSize(variant.Path)
+		job.Variants = append(job.Variants, jobs.Variant{
+			Name:   variant.Name,
+			Width:  variant.Width,
+			Height: variant.Height,
+			Size:   size,
+		})
+	}
+	return nil
+}
+
+// uploadVariants uploads the variants of a job's output to dest, skipping
+// any an earlier attempt already uploaded
+func uploadVariants(ctx context.Context, dest *destination, t *tenant.Tenant, job *jobs.Job) error {
+	for i := range job.Variants {
+		variant := &job.Variants[i]
+		// Renditions in an HLS ladder are delivered as its streams
+		if variant.FileID != "" || job.HLS.Stream(variant.Name) != nil {
+			continue
+		}
+		fileID, link, err := uploadTo(ctx, dest, t, job.VariantPath(variant.Name), job.VariantName(variant.Name))
+		if err != nil {
+			return err
+		}
+		variant.FileID, variant.URL = fileID, link
+	}
+	return nil
+}
+
+// deliveredBytes returns the size of a job's output files, as uploaded to
+// its destination
+func deliveredBytes(job *jobs.Job) int64 {
+	var total int64
+	for _, path := range job.OutputFiles() {
+		// HLS jobs deliver their segments instead of the output, and of
+		// the renditions in their ladder
+		if job.HLS != nil && path == job.OutputPath {
+			continue
+		}
+		if slices.ContainsFunc(job.Variants, func(v jobs.Variant) bool {
+			return job.HLS.Stream(v.Name) != nil && path == job.VariantPath(v.Name)
+		}) {
+			continue
+		}
+		size, _ := fileSize(path)
+		total += size
+	}
+	return total
+}
+
+// fileSize returns the size of the file at path, or the total of the
+// files in the directory at path
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return info.Size(), nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
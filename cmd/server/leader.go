@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+)
+
+// leaderLease names the lease replicas compete for when LEADER_ELECTION
+// is on
+const leaderLease = "background-tasks"
+
+// replicaName identifies this replica across restarts: the pod name under
+// Kubernetes, where it is the hostname
+func replicaName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// leaderID identifies this run of the replica as a lease holder
+func leaderID() string {
+	return fmt.Sprintf("%s-%d", replicaName(), os.Getpid())
+}
+
+// runAsLeader starts tasks while this replica holds the leader lease, and
+// stops them if it loses the lease, so tasks run on one replica at a time.
+// The lease is renewed every third of ttl until ctx is done. A replica
+// that can't reach the database steps down, since another may take over
+// once the lease expires.
+func runAsLeader(ctx context.Context, holder string, ttl time.Duration, tasks func(ctx context.Context)) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	var stop context.CancelFunc
+	for {
+		held, err := db.AcquireLease(leaderLease, holder, ttl, time.Now())
+		if err != nil {
+			log.Printf("Failed to renew leader lease: %v", err)
+		}
+		switch {
+		case held && stop == nil:
+			log.Printf("Elected leader as %s, running background tasks", holder)
+			var taskCtx context.Context
+			taskCtx, stop = context.WithCancel(ctx)
+			tasks(taskCtx)
+		case !held && stop != nil:
+			log.Printf("No longer leader, stopping background tasks")
+			stop()
+			stop = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if stop != nil {
+				stop()
+				if err := db.ReleaseLease(leaderLease, holder); err != nil {
+					log.Printf("Failed to release leader lease: %v", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,19 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/skillcape/transcoder/db"
 	"github.com/skillcape/transcoder/internal/api"
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/clock"
 	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/email"
+	"github.com/skillcape/transcoder/internal/errreport"
+	"github.com/skillcape/transcoder/internal/eventbus"
+	"github.com/skillcape/transcoder/internal/hooks"
 	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/kube"
+	"github.com/skillcape/transcoder/internal/pipeline"
+	"github.com/skillcape/transcoder/internal/requestid"
 	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/sysstat"
+	"github.com/skillcape/transcoder/internal/tenant"
 	"github.com/skillcape/transcoder/internal/transcoder"
 	"github.com/skillcape/transcoder/internal/webhook"
 )
@@ -24,18 +40,58 @@ func main() {
 	log.Println("Starting Skillcape Transcoder...")
 
 	// Load configuration
-	cfg := config.Load()
+	flags := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file (CONFIG_FILE)")
+	config.RegisterFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] [backup]\n\n", flags.Name())
+		fmt.Fprintln(flags.Output(), "Each flag overrides the environment variable it names, which in turn")
+		fmt.Fprintln(flags.Output(), "overrides the config file. \"backup\" takes one database backup and exits.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	cfg, err := config.Load(*configFile, flags)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	staticEndpoints, err := prepareConfig(cfg)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Check FFmpeg availability
-	if !transcoder.IsFFmpegAvailable() {
-		log.Fatal("FFmpeg is not installed or not in PATH")
+	// "server backup" takes one database backup and exits
+	if flags.Arg(0) == "backup" {
+		runBackupCommand(cfg)
+		return
+	}
+
+	// Report panics and failures to Sentry or ERROR_REPORT_URL when set
+	if err := errreport.Configure(errreport.Config{
+		DSN:         cfg.SentryDSN,
+		URL:         cfg.ErrorReportURL,
+		Environment: cfg.SentryEnvironment,
+		Release:     cfg.SentryRelease,
+	}); err != nil {
+		log.Fatalf("Invalid error reporting configuration: %v", err)
+	}
+
+	// Simulate FFmpeg for testing; the real one is checked before the
+	// workers start
+	if cfg.TranscoderBackend == "fake" {
+		transcoder.SetBackend(cfg.FakeBackend())
+		log.Println("Warning: using the fake transcoder backend; outputs are copies of the inputs")
 	}
-	log.Println("FFmpeg detected")
 
 	// Initialize database
-	if err := db.Init(cfg.TempDir); err != nil {
+	err = db.Init(dbConfig(cfg))
+	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	if err := syncPresets(cfg.Presets); err != nil {
+		log.Fatalf("Failed to save presets from config file: %v", err)
+	}
+	repo := db.NewJobRepository(db.DB)
 
 	// Initialize local storage
 	localStorage, err := storage.NewLocalStorage(cfg.TempDir)
@@ -45,55 +101,239 @@ func main() {
 
 	// Initialize Google Drive client (optional - continues if credentials not found)
 	var driveClient *storage.GoogleDriveClient
-	if cfg.GoogleCredentialsFile != "" && cfg.GoogleDriveFolderID != "" {
-		driveClient, err = storage.NewGoogleDriveClient(
-			context.Background(),
-			cfg.GoogleCredentialsFile,
-			cfg.GoogleDriveFolderID,
-		)
+	if (cfg.GoogleCredentials != "" || cfg.GoogleCredentialsFile != "") && cfg.GoogleDriveFolderID != "" {
+		var credentials []byte
+		credentials, err = googleCredentials(cfg)
+		if err == nil {
+			driveClient, err = storage.NewGoogleDriveClient(
+				context.Background(),
+				credentials,
+				cfg.GoogleDriveFolderID,
+			)
+		}
 		if err != nil {
 			log.Printf("Warning: Google Drive not configured: %v", err)
+		} else {
+			driveClient.SetOnConflict(cfg.GoogleDriveOnConflict)
 		}
 	} else {
 		log.Println("Google Drive integration not configured")
 	}
+	uploads, err := openDestinations(context.Background(), cfg, driveClient)
+	if err != nil {
+		log.Fatalf("Failed to initialize destinations: %v", err)
+	}
 
 	// Initialize webhook client
-	webhookClient := webhook.NewClient(cfg.WebhookRetryCount)
+	webhookClient := webhook.NewClient(cfg.WebhookRetryCount, cfg.WebhookSecret)
+	webhookClient.SetFormat(cfg.WebhookFormat, cfg.CloudEventsSource)
+	webhookTLS, err := webhook.TLSConfig(cfg.WebhookTLSCertFile, cfg.WebhookTLSKeyFile, cfg.WebhookTLSCAFile, cfg.WebhookTLSInsecure)
+	if err != nil {
+		log.Fatalf("Invalid webhook TLS configuration: %v", err)
+	}
+	if webhookTLS != nil {
+		webhookClient.SetTLSConfig(webhookTLS)
+		if cfg.WebhookTLSInsecure {
+			log.Println("Warning: webhook TLS certificate verification is disabled")
+		}
+	}
+	webhookClient.OnResult(func(payload *webhook.Payload, err error) {
+		if err != nil {
+			repo.RecordJobEvent(payload.JobID, jobs.EventWebhookFailed, payload.RequestID, payload.Event+": "+err.Error())
+			errreport.Capture(errreport.Event{
+				Kind:    errreport.KindWebhookFailed,
+				Message: "webhook delivery failed: " + err.Error(),
+				Tags: map[string]string{
+					"job_id":     payload.JobID,
+					"event":      payload.Event,
+					"request_id": payload.RequestID,
+				},
+			})
+			return
+		}
+		repo.RecordJobEvent(payload.JobID, jobs.EventWebhookDelivered, payload.RequestID, payload.Event)
+	})
+	webhookClient.OnAttempt(db.RecordWebhookDelivery)
+	notifier := webhook.NewNotifier(webhookClient, cfg.WebhookURL, cfg.WebhookEvents, db.WebhookEndpointsFor)
+	reload := &reloader{
+		configFile:    *configFile,
+		flags:         flags,
+		notifier:      notifier,
+		webhookClient: webhookClient,
+		apiKey:        auth.NewBootstrapKey(cfg.APIKey),
+		retry:         &atomic.Pointer[retryPolicy]{},
+	}
+	reload.apply(cfg, staticEndpoints)
+
+	// Initialize message bus publishing (optional)
+	if cfg.EventBus != "" {
+		// Only Pub/Sub needs Google credentials
+		var credentials []byte
+		if cfg.EventBus == eventbus.DriverPubSub {
+			if credentials, err = googleCredentials(cfg); err != nil {
+				log.Fatalf("Failed to initialize event bus: %v", err)
+			}
+		}
+		bus, err := eventbus.New(context.Background(), eventbus.Config{
+			Driver:      cfg.EventBus,
+			URL:         cfg.EventBusURL,
+			Topic:       cfg.EventBusTopic,
+			Credentials: credentials,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize event bus: %v", err)
+		}
+		defer bus.Close()
+		notifier.SetPublisher(bus, cfg.EventBusEvents)
+		log.Printf("Publishing job events to %s topic %s", cfg.EventBus, cfg.EventBusTopic)
+	}
+
+	// Initialize email notifications (optional)
+	mailer, err := newMailer(cfg)
+	if err != nil {
+		log.Fatalf("Invalid email configuration: %v", err)
+	}
 
 	// Create job queue
-	jobQueue := jobs.NewQueue(100) // Buffer size of 100 jobs
+	jobQueue := jobs.NewQueue(100, clock.System) // Buffer size of 100 jobs
+
+	// Run encodes as Kubernetes Jobs instead of locally (optional)
+	var executor *kube.Executor
+	if cfg.Executor == "kubernetes" {
+		executor, err = kube.NewExecutor(kubeConfig(cfg))
+		if err != nil {
+			log.Fatalf("Failed to initialize Kubernetes executor: %v", err)
+		}
+		log.Printf("Encoding in Kubernetes Jobs with image %s", cfg.K8sImage)
+	}
+
+	// Hold each local encode to FFMPEG_CPUS and FFMPEG_MEMORY_MB in its own
+	// cgroup where the server's cgroup allows it
+	limits := ffmpegLimits(cfg)
+	if executor == nil && cfg.TranscoderBackend == "ffmpeg" && (limits.CPUs > 0 || limits.MemoryMB > 0) {
+		limits.Cgroups, err = transcoder.EnableCgroups()
+		if err != nil {
+			log.Printf("Cgroups unavailable, limiting ffmpeg memory with prlimit and CPU with threads only: %v", err)
+		} else {
+			log.Printf("Running each encode in its own cgroup")
+		}
+	}
 
 	// Create job processor
-	processor := createJobProcessor(cfg, localStorage, driveClient, webhookClient)
+	if err := registerPlugins(cfg.PipelinePlugins, config.Seconds(cfg.PluginTimeout)); err != nil {
+		log.Fatalf("Failed to set up pipeline plugins: %v", err)
+	}
+	hookSet := jobHooks{
+		pre:  hooks.New(hooks.Pre, cfg.PreTranscodeHook, config.Seconds(cfg.HookTimeout), webhookClient.Secret),
+		post: hooks.New(hooks.Post, cfg.PostTranscodeHook, config.Seconds(cfg.HookTimeout), webhookClient.Secret),
+	}
+	downs := newOutages(jobQueue, cfg.OutageThreshold, config.Seconds(cfg.OutageRetry))
+	hardware := newHardwareEncoding(cfg, executor)
+	processor := reportPanics(createJobProcessor(repo, jobQueue, reload.retry, limits, cfg.RenditionParallelism, hardware, cfg.ImageSettings(), newPosterSettings(cfg), newPlayerSettings(cfg), config.Seconds(cfg.HLSSegmentSeconds), cfg.AcceptanceRules(), executor, hookSet, localStorage, uploads, downs, notifier, mailer, clock.System))
 
-	// Create and start worker pool
+	// Create the worker pool, and start it once the database, destination
+	// and FFmpeg answer, recovering pending jobs from the database first
 	workerPool := jobs.NewWorkerPool(jobQueue, cfg.WorkerCount, processor)
-	workerPool.Start()
+	starting, stopStarting := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		startWorkers(starting, dependencies(cfg.TranscoderBackend, uploads), repo, jobQueue, workerPool)
+	}()
+
+	// Keep the daily stats rollups current, take scheduled backups, purge
+	// old deleted jobs and watch the node's resources. Rollups, backups
+	// and purges cover the shared database, so with LEADER_ELECTION only
+	// one replica runs them.
+	background, stopBackground := context.WithCancel(context.Background())
+	singletons := func(ctx context.Context) {
+		if cfg.StatsInterval > 0 {
+			go refreshStats(ctx, config.Seconds(cfg.StatsInterval))
+		}
+		if cfg.BackupInterval > 0 {
+			go runBackups(ctx, cfg.BackupDir, config.Seconds(cfg.BackupInterval), cfg.BackupRetain)
+		}
+		if cfg.PurgeAfterDays > 0 {
+			go runPurge(ctx, repo, uploads, time.Duration(cfg.PurgeAfterDays)*24*time.Hour)
+		}
+	}
+	if cfg.LeaderElection {
+		go runAsLeader(background, leaderID(), config.Seconds(cfg.LeaderLease), singletons)
+	} else {
+		singletons(background)
+	}
+	if cfg.SecretsRefresh > 0 {
+		go refreshSecrets(background, reload, config.Seconds(cfg.SecretsRefresh))
+	}
+	if cfg.JobMaxQueueHours > 0 {
+		go expireJobs(background, repo, jobQueue, localStorage, notifier, cfg.JobMaxQueueHours)
+	}
+	reconcile := &reconciler{repo: repo, jobQueue: jobQueue, localStorage: localStorage, notifier: notifier, mailer: mailer}
+	if cfg.ReconcileInterval > 0 {
+		go runReconcile(background, reconcile, config.Seconds(cfg.ReconcileInterval))
+	}
+	if cfg.ResourceInterval > 0 {
+		pause := resourceLimits{disk: cfg.PauseDiskPercent, memory: cfg.PauseMemoryPercent}
+		go monitorResources(background, sysstat.NewSampler(cfg.TempDir), jobQueue, pause, config.Seconds(cfg.ResourceInterval))
+	}
+
+	// Reload the changeable settings on SIGHUP or through the admin API
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reload.Reload(); err != nil {
+				log.Printf("Failed to reload configuration: %v", err)
+			}
+		}
+	}()
 
-	// Recover pending jobs from database
-	recoverPendingJobs(jobQueue)
+	// Publications this replica was running when it last stopped can't
+	// resume
+	replica := replicaName()
+	if n, err := db.FailInterruptedPublications(replica, time.Now().UTC()); err != nil {
+		log.Printf("Warning: failed to close interrupted publications: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted publications failed", n)
+	}
+	publish := &publisher{ctx: background, repo: repo, uploads: uploads, notifier: notifier, replica: replica}
 
 	// Setup HTTP router
-	router := api.SetupRouter(cfg, localStorage, jobQueue)
+	router := api.SetupRouter(cfg, repo, localStorage, jobQueue, notifier, reload.apiKey, reload, publish, reconcile, uploads, nil, nil)
 
-	// Create HTTP server
+	// Create HTTP server. Read and write timeouts are defaults that upload
+	// and download routes extend per request (see api.Timeouts).
 	server := &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              ":" + cfg.Port,
+		Handler:           router,
+		ReadHeaderTimeout: config.Seconds(cfg.ReadHeaderTimeout),
+		ReadTimeout:       config.Seconds(cfg.ReadTimeout),
+		WriteTimeout:      config.Seconds(cfg.WriteTimeout),
+		IdleTimeout:       config.Seconds(cfg.IdleTimeout),
+	}
+
+	serve, httpRedirect, err := listener(cfg, server)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
 	}
 
 	// Start server in goroutine
 	go func() {
 		log.Printf("Server listening on port %s", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := serve(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
+	if httpRedirect != nil {
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS", httpRedirect.Addr[1:])
+			if err := httpRedirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("HTTP redirect server failed: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -101,116 +341,767 @@ func main() {
 
 	log.Println("Shutting down server...")
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Stop starting jobs and fail readiness checks, then give load
+	// balancers DRAIN_DELAY to stop sending requests before the listener
+	// closes
+	jobQueue.Drain()
+	if cfg.DrainDelay > 0 {
+		log.Printf("Draining for %ds", cfg.DrainDelay)
+		time.Sleep(config.Seconds(cfg.DrainDelay))
+	}
+
+	// Graceful shutdown: requests in flight and running jobs get what is
+	// left of SHUTDOWN_TIMEOUT to finish
+	ctx, cancel := context.WithTimeout(context.Background(), config.Seconds(cfg.ShutdownTimeout))
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
+	if httpRedirect != nil {
+		httpRedirect.Shutdown(ctx)
+	}
 
-	// Stop worker pool
-	workerPool.Stop()
+	stopStarting()
+	<-started
+	workerPool.Drain(ctx)
+	stopBackground()
+	errreport.Flush(5 * time.Second)
 
 	log.Println("Server exited")
 }
 
+// progressSaveInterval is how often a running job's progress is saved
+const progressSaveInterval = 2 * time.Second
+
+// probeTimeout bounds each ffprobe run recording a job's media metadata
+const probeTimeout = 30 * time.Second
+
+// retryPolicy decides whether a job is tried again after a failed attempt
+type retryPolicy struct {
+	maxAttempts int
+	delay       time.Duration // before the second attempt, doubling for each later one
+}
+
 func createJobProcessor(
-	cfg *config.Config,
+	repo db.JobRepository,
+	jobQueue *jobs.Queue,
+	retry *atomic.Pointer[retryPolicy],
+	limits transcoder.Limits,
+	renditionParallelism int,
+	hardware hardwareEncoding,
+	images transcoder.ImageSettings,
+	posters posterSettings,
+	player playerSettings,
+	hlsSegment time.Duration,
+	acceptance transcoder.AcceptanceRules,
+	executor *kube.Executor,
+	hookSet jobHooks,
 	localStorage *storage.LocalStorage,
-	driveClient *storage.GoogleDriveClient,
-	webhookClient *webhook.Client,
+	uploads *destinations,
+	downs *outages,
+	notifier *webhook.Notifier,
+	mailer *email.Mailer,
+	clk clock.Clock,
 ) jobs.ProcessorFunc {
 	return func(ctx context.Context, job *jobs.Job) error {
+		// Skip jobs that were cancelled or deleted while waiting in the queue
+		current, err := repo.GetJob(job.ID)
+		if err != nil || (current.Status != jobs.StatusPending && current.Status != jobs.StatusRetrying) {
+			requestid.Logf(ctx, "Skipping job %s: no longer queued", job.ID)
+			return nil
+		}
+		job = current
+		// A job interrupted after its encode finished goes straight to the
+		// upload
+		resume := encoded(job)
+
+		// Tenant settings override the global Drive folder and webhook
+		var t *tenant.Tenant
+		if job.TenantID != "" {
+			if t, err = db.GetTenant(job.TenantID); err != nil {
+				requestid.Logf(ctx, "Job %s: tenant %s not found, using global settings", job.ID, job.TenantID)
+				t = nil
+			}
+		}
+
+		// recordWork adds the time spent on the job since the attempt
+		// started, or since the last call, to its wall time
+		workStart := clk.Now()
+		recordWork := func() {
+			now := clk.Now()
+			job.WallSeconds = math.Round((job.WallSeconds+now.Sub(workStart).Seconds())*1000) / 1000
+			workStart = now
+		}
+
+		// fail retries transient failures while attempts remain; otherwise
+		// the job fails, or is dead-lettered once retries are exhausted.
+		// code is one of the jobs.Error codes.
+		fail := func(code, errMsg string, transient bool) error {
+			recordWork()
+			job.ErrorCode = code
+			if jobs.Permanent(code) {
+				transient = false
+			}
+			policy := retry.Load()
+			if transient && job.Attempts < policy.maxAttempts {
+				return retryJob(repo, jobQueue, clk, job, policy.delay, errMsg)
+			}
+			status := jobs.StatusFailed
+			if transient && policy.maxAttempts > 1 {
+				status = jobs.StatusDeadLetter
+			}
+			return handleJobFailure(repo, clk, job, t, notifier, mailer, status, errMsg)
+		}
+
 		// Update job status to processing
-		job.Status = jobs.StatusProcessing
-		job.UpdatedAt = time.Now().UTC()
-		db.UpdateJob(job)
+		if err := job.Transition(jobs.StatusProcessing, jobs.ActorWorker, job.RequestID, clk); err != nil {
+			return err
+		}
+		job.Stage = jobs.StageTranscoding
+		if resume {
+			job.Stage = jobs.StageUploading
+		}
+		job.Attempts++
+		job.UpdatedAt = clk.Now().UTC()
+		if err := saveJob(repo, job); err != nil {
+			return err
+		}
+		repo.RecordJobEvent(job.ID, jobs.EventStarted, job.RequestID, "")
+		notifier.Notify(job, t, webhook.EventJobStarted, nil)
 
-		// Create progress callback
+		// Create progress callback. ffmpeg reports progress several times a
+		// second; the API reads it live from the queue, and it is saved at
+		// most every progressSaveInterval.
+		throttle := notifier.ProgressThrottle()
+		savedProgress, savedAt := job.Progress, job.UpdatedAt
 		progressCallback := func(progress int) {
+			if ctx.Err() != nil {
+				return
+			}
 			job.Progress = progress
-			job.UpdatedAt = time.Now().UTC()
-			db.UpdateJob(job)
+			job.UpdatedAt = clk.Now().UTC()
+			jobQueue.SetProgress(job.ID, progress, job.UpdatedAt)
+			if progress != savedProgress && job.UpdatedAt.Sub(savedAt) >= progressSaveInterval {
+				repo.UpdateJobProgress(job.ID, progress, job.UpdatedAt)
+				savedProgress, savedAt = progress, job.UpdatedAt
+			}
+			if throttle.Due(progress, job.UpdatedAt) {
+				notifier.Notify(job, t, webhook.EventJobProgress, &webhook.Payload{Progress: progress})
+			}
 		}
 
-		// Transcode the video
-		ffmpeg := transcoder.New(job.InputPath, job.OutputPath)
-		ffmpeg.OnProgress(progressCallback)
+		// Jobs that name no preset get their tenant's default
+		presetName := job.Preset
+		if presetName == "" && t != nil {
+			presetName = t.DefaultPreset
+		}
+		preset, err := resolvePreset(job.TenantID, presetName)
+		if err != nil {
+			return fail(jobs.ErrorInvalidSettings, err.Error(), false)
+		}
+		// Audio presets make .mp3 or .m4a outputs; the preset may have
+		// changed since the job was created
+		if ext := preset.Ext(); !resume && job.MediaType != jobs.MediaImage && ext != job.OutputFileExt() {
+			job.OutputExt = ext
+			if ext == ".mp4" {
+				job.OutputExt = ""
+			}
+			job.OutputPath = localStorage.GetOutputPath(job.ID, ext)
+		}
 
-		if err := ffmpeg.Transcode(ctx); err != nil {
-			return handleJobFailure(job, webhookClient, cfg.WebhookURL, fmt.Sprintf("transcoding failed: %v", err))
+		// Uploads are probed on arrival when PROBE_UPLOADS is on
+		if job.InputProbe == nil {
+			job.InputProbe = probeFile(ctx, job.ID, job.InputFiles()[0])
+		}
+		// Inputs nobody wants transcoded fail before the work starts
+		if !acceptance.IsZero() && job.MediaType != jobs.MediaImage && job.InputProbe != nil {
+			if rejection := acceptance.Check(job.InputProbe); rejection != nil {
+				job.Rejection = rejection.Code
+				return fail(jobs.ErrorInputRejected, "input rejected: "+rejection.Message, false)
+			}
+		}
+		// HLS segments can only be delivered to a destination
+		if transcoder.IsHLS(job.Packaging) {
+			if dest, err := uploads.forJob(job, preset); err == nil && dest == nil {
+				return fail(jobs.ErrorInvalidSettings, "HLS packaging needs a destination to deliver to", false)
+			}
 		}
 
-		// Upload to Google Drive if configured
-		if driveClient != nil {
-			outputName := job.OriginalName
-			if len(outputName) > 4 {
-				outputName = outputName[:len(outputName)-4] + ".mp4"
+		var ffmpeg *transcoder.FFmpeg
+		if resume {
+			requestid.Logf(ctx, "Job %s: already encoded, resuming at the upload", job.ID)
+		} else {
+			// The pre hook may reject the job or rename its output
+			if err := runHook(ctx, repo, hookSet.pre, job); err != nil {
+				if ctx.Err() != nil {
+					return jobs.ErrJobCancelled
+				}
+				return fail(errorCode(err, jobs.ErrorHookFailed), err.Error(), false)
+			}
+			if err := runSteps(ctx, repo, pipeline.BeforeTranscode, job); err != nil {
+				if ctx.Err() != nil {
+					return jobs.ErrJobCancelled
+				}
+				return fail(errorCode(err, jobs.ErrorHookFailed), err.Error(), false)
+			}
+
+			renditions, err := renditionPresets(job)
+			if err != nil {
+				return fail(jobs.ErrorInvalidSettings, err.Error(), false)
+			}
+			// Video goes to the hardware encoder where the job and its
+			// presets allow
+			encodePreset := preset
+			if job.MediaType != jobs.MediaImage {
+				if encodePreset, err = hardware.preset(job, preset); err != nil {
+					return fail(jobs.ErrorInvalidSettings, err.Error(), false)
+				}
+				for i, rendition := range renditions {
+					if renditions[i], err = hardware.preset(job, rendition); err != nil {
+						return fail(jobs.ErrorInvalidSettings, fmt.Sprintf("rendition %s: %v", rendition.Name, err), false)
+					}
+				}
+				job.VideoEncoder = encodePreset.VideoCodec
+			}
+			// encoder sets up an encode of the inputs, for the output and
+			// for each rendition
+			encoder := func(preset *transcoder.Preset, output string) *transcoder.FFmpeg {
+				ffmpeg := transcoder.NewConcat(job.InputFiles(), output)
+				ffmpeg.UsePreset(preset)
+				ffmpeg.UseLimits(limits)
+				ffmpeg.UseMetadata(job.Metadata, job.CoverPath)
+				if t != nil {
+					ffmpeg.UseBranding(t.Branding(localStorage.BrandingPath))
+				}
+				if executor != nil {
+					ffmpeg.UseRunner(executor.Runner(job.ID, job.ErrorCode == jobs.ErrorOutOfMemory))
+				}
+				return ffmpeg
+			}
+
+			// Transcode the video, or resize the image
+			encodeStart := clk.Now()
+			if job.MediaType == jobs.MediaImage {
+				err = resizeImage(ctx, job, images, progressCallback)
 			} else {
-				outputName = job.ID + ".mp4"
+				job.Variants = nil
+				ffmpeg = encoder(encodePreset, job.OutputPath)
+				ffmpeg.OnProgress(progressShare(progressCallback, 0, len(renditions)+1))
+				ffmpeg.OnStats(func(stats transcoder.EncodeStats) {
+					jobQueue.SetStats(job.ID, stats)
+				})
+				err = ffmpeg.Transcode(ctx)
+				job.Duration = ffmpeg.Duration().Seconds()
+				job.CPUSeconds += ffmpeg.CPUTime().Seconds()
+			}
+			encodeTime := clk.Now().Sub(encodeStart)
+			if err != nil {
+				if ctx.Err() != nil {
+					// Cancelled by the API, or interrupted by shutdown and left for recovery
+					return jobs.ErrJobCancelled
+				}
+				return fail(errorCode(err, jobs.ErrorTranscodeFailed), fmt.Sprintf("transcoding failed: %v", err), true)
+			}
+			job.OutputProbe = probeFile(ctx, job.ID, job.OutputPath)
+			checkAudioDrift(ctx, repo, job, preset)
+			if ffmpeg != nil {
+				job.EncodeStats = ffmpeg.Stats()
+				recordEncode(job, encodeTime)
 			}
+			// Renditions encoded at once share the encode's CPU budget. Jobs
+			// may ask for fewer at once than the server allows, not more.
+			parallel := min(renditionParallelism, len(renditions))
+			if job.Parallelism > 0 {
+				parallel = min(parallel, job.Parallelism)
+			}
+			renditionEncoder := func(preset *transcoder.Preset, output string) *transcoder.FFmpeg {
+				ffmpeg := encoder(preset, output)
+				ffmpeg.UseLimits(limits.Share(parallel))
+				return ffmpeg
+			}
+			if err := encodeRenditions(ctx, job, renditions, parallel, renditionEncoder, progressCallback); err != nil {
+				if ctx.Err() != nil {
+					return jobs.ErrJobCancelled
+				}
+				return fail(errorCode(err, jobs.ErrorTranscodeFailed), fmt.Sprintf("transcoding failed: %v", err), true)
+			}
+			if err := runSteps(ctx, repo, pipeline.AfterTranscode, job); err != nil {
+				if ctx.Err() != nil {
+					return jobs.ErrJobCancelled
+				}
+				return fail(errorCode(err, jobs.ErrorHookFailed), err.Error(), false)
+			}
+		}
 
-			fileID, webViewLink, err := driveClient.UploadFile(ctx, job.OutputPath, outputName)
+		// Upload to the job's destination, if it has one
+		dest, err := uploads.forJob(job, preset)
+		if err != nil {
+			return fail(jobs.ErrorInvalidSettings, err.Error(), false)
+		}
+		if !resume && posters.wants(job, preset, dest != nil) {
+			makePoster(ctx, job, posters, localStorage)
+		}
+		if !resume && player.wants(job, preset, dest != nil) {
+			makePlayerFiles(ctx, job, player, localStorage)
+		}
+		if !resume && dest != nil && transcoder.IsHLS(job.Packaging) {
+			if err := packageHLS(ctx, job, hlsSegment); err != nil {
+				if ctx.Err() != nil {
+					return jobs.ErrJobCancelled
+				}
+				// Packaging only copies the streams, so it fails the same
+				// way again unless it ran out of disk or was interrupted
+				code := errorCode(err, jobs.ErrorTranscodeFailed)
+				return fail(code, err.Error(), code == jobs.ErrorDiskFull || code == jobs.ErrorInterrupted)
+			}
+		}
+		if ffmpeg != nil {
+			extractCaptions(ctx, job, preset, ffmpeg.IntroDuration(), localStorage)
+		}
+		if dest != nil {
+			// Uploads past the destination's max_uploads wait their turn
+			release, err := dest.reserve(ctx, func() error {
+				job.Stage = jobs.StageUploadWaiting
+				job.UpdatedAt = clk.Now().UTC()
+				return saveJob(repo, job)
+			})
 			if err != nil {
-				return handleJobFailure(job, webhookClient, cfg.WebhookURL, fmt.Sprintf("drive upload failed: %v", err))
+				if ctx.Err() != nil {
+					return jobs.ErrJobCancelled
+				}
+				return err
+			}
+			job.Stage = jobs.StageUploading
+			job.UpdatedAt = clk.Now().UTC()
+			if err := saveJob(repo, job); err != nil {
+				release()
+				return err
 			}
 
+			// HLS jobs deliver their playlist and segments in place of the
+			// output
+			outputName := job.DeliveredName()
+			var fileID, link string
+			if job.HLS != nil {
+				fileID, link, err = uploadHLS(ctx, dest, t, job)
+			} else {
+				fileID, link, err = uploadTo(ctx, dest, t, job.OutputPath, outputName)
+			}
+			if err == nil {
+				err = uploadVariants(ctx, dest, t, job)
+			}
+			if err == nil {
+				err = uploadPoster(ctx, dest, t, job)
+			}
+			if err == nil {
+				err = uploadCaptions(ctx, dest, t, job)
+			}
+			if err == nil {
+				err = uploadPlayerFiles(ctx, dest, t, job, link)
+			}
+			release()
+			if err != nil {
+				if ctx.Err() != nil {
+					return jobs.ErrJobCancelled
+				}
+				errMsg := fmt.Sprintf("%s upload failed: %v", uploadName(dest), err)
+				if downs.failed(dest, err) {
+					recordWork()
+					return deferUpload(repo, jobQueue, clk, job, errMsg)
+				}
+				return fail(errorCode(err, jobs.ErrorUploadFailed), errMsg, true)
+			}
+			downs.succeeded(dest)
+
+			job.BytesOut += deliveredBytes(job)
 			job.DriveFileID = fileID
-			job.DriveURL = webViewLink
+			job.DriveURL = link
+			job.Destination = dest.name
+			job.StorageType = dest.kind
+			notifier.Notify(job, t, webhook.EventJobOutputUploaded, &webhook.Payload{
+				DriveURL:    job.DriveURL,
+				DriveFileID: job.DriveFileID,
+				PlaylistURL: playlistURL(job),
+				OutputName:  outputName,
+			})
+		}
+
+		// The output is delivered, so failing steps and hooks don't fail the job
+		if err := runSteps(ctx, repo, pipeline.AfterDelivery, job); err != nil && ctx.Err() != nil {
+			return jobs.ErrJobCancelled
+		}
+		if err := runHook(ctx, repo, hookSet.post, job); err != nil && ctx.Err() != nil {
+			return jobs.ErrJobCancelled
 		}
 
 		// Mark as completed
-		now := time.Now().UTC()
-		job.Status = jobs.StatusCompleted
+		recordWork()
+		now := clk.Now().UTC()
+		if err := job.Transition(jobs.StatusCompleted, jobs.ActorWorker, job.RequestID, clk); err != nil {
+			return err
+		}
 		job.Progress = 100
 		job.CompletedAt = &now
 		job.UpdatedAt = now
-		db.UpdateJob(job)
+		if err := saveJob(repo, job); err != nil {
+			return err
+		}
+		repo.RecordJobEvent(job.ID, jobs.EventCompleted, job.RequestID, "")
 
 		// Clean up local files after successful upload
-		if driveClient != nil {
-			localStorage.CleanupJob(job.InputPath, job.OutputPath)
+		if dest != nil {
+			localStorage.CleanupJob(job.UploadedFiles(), job.OutputFiles()...)
 		}
 
 		// Send webhook notification
-		webhookClient.SendAsync(cfg.WebhookURL, &webhook.Payload{
-			JobID:        job.ID,
-			Status:       string(job.Status),
-			DriveURL:     job.DriveURL,
-			DriveFileID:  job.DriveFileID,
-			OriginalName: job.OriginalName,
-			CompletedAt:  now.Format(time.RFC3339),
+		notifier.Notify(job, t, webhook.EventJobCompleted, &webhook.Payload{
+			Progress:    job.Progress,
+			DriveURL:    job.DriveURL,
+			DriveFileID: job.DriveFileID,
+			PlaylistURL: playlistURL(job),
+			OutputName:  job.DeliveredName(),
+			CompletedAt: now.Format(time.RFC3339),
 		})
+		emailJobResult(repo, mailer, job)
+
+		return nil
+	}
+}
+
+// reportPanics reports a panic while processing a job, then lets it crash
+// the server as before; interrupted jobs are recovered on restart
+func reportPanics(process jobs.ProcessorFunc) jobs.ProcessorFunc {
+	return func(ctx context.Context, job *jobs.Job) error {
+		defer func() {
+			if value := recover(); value != nil {
+				errreport.CapturePanic(value, map[string]string{
+					"job_id":     job.ID,
+					"request_id": job.RequestID,
+				})
+				errreport.Flush(5 * time.Second)
+				panic(value)
+			}
+		}()
+		return process(ctx, job)
+	}
+}
+
+// saveJob saves the worker's changes to a job. If the job was changed
+// elsewhere in the meantime, in practice cancelled or deleted through the
+// API, it returns jobs.ErrJobCancelled so the worker stops rather than
+// overwriting that change. Other failures are logged and processing goes on.
+func saveJob(repo db.JobRepository, job *jobs.Job) error {
+	err := repo.UpdateJob(job)
+	if errors.Is(err, db.ErrStaleJob) {
+		requestid.Logf(requestid.NewContext(context.Background(), job.RequestID), "Job %s was changed elsewhere, stopping", job.ID)
+		return jobs.ErrJobCancelled
+	}
+	if err != nil {
+		log.Printf("Failed to save job %s: %v", job.ID, err)
+	}
+	return nil
+}
+
+// refreshStats rebuilds changed daily stats rollups every interval until
+// ctx is done
+func refreshStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := db.RefreshStats(time.Now()); err != nil {
+			log.Printf("Failed to refresh stats: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ffmpegLimits returns the resource limits for each encode. Without
+// FFMPEG_THREADS, threads come from splitting FFMPEG_CPU_PERCENT of the
+// CPUs between the workers, or else from FFMPEG_CPUS.
+func ffmpegLimits(cfg *config.Config) transcoder.Limits {
+	limits := transcoder.Limits{
+		Threads:  cfg.FFmpegThreads,
+		Nice:     cfg.FFmpegNice,
+		IOClass:  cfg.FFmpegIOClass,
+		CPUs:     cfg.FFmpegCPUs,
+		MemoryMB: cfg.FFmpegMemoryMB,
+	}
+	if limits.Threads == 0 {
+		limits.Threads = transcoder.ThreadsForBudget(cfg.FFmpegCPUPercent, cfg.WorkerCount)
+	}
+	if limits.Threads == 0 && limits.CPUs > 0 {
+		limits.Threads = int(math.Ceil(limits.CPUs))
+	}
+	return limits
+}
+
+// kubeConfig describes the pods encodes run in with EXECUTOR=kubernetes.
+// They mount TEMP_DIR's volume at the same path as the server does.
+func kubeConfig(cfg *config.Config) kube.Config {
+	return kube.Config{
+		Namespace:      cfg.K8sNamespace,
+		Image:          cfg.K8sImage,
+		ServiceAccount: cfg.K8sServiceAccount,
+		VolumeClaim:    cfg.K8sVolumeClaim,
+		MountPath:      cfg.TempDir,
+		CPURequest:     cfg.K8sCPURequest,
+		MemoryRequest:  cfg.K8sMemoryRequest,
+		CPULimit:       cfg.K8sCPULimit,
+		MemoryLimit:    cfg.K8sMemoryLimit,
+		OOMRequest:     cfg.K8sOOMMemoryRequest,
+		OOMLimit:       cfg.K8sOOMMemoryLimit,
+		GPUs:           cfg.K8sGPUCount,
+		GPUResource:    cfg.K8sGPUResource,
+		TTL:            config.Seconds(cfg.K8sJobTTL),
+	}
+}
+
+// dbConfig selects the database from the configuration
+func dbConfig(cfg *config.Config) db.Config {
+	return db.Config{
+		DataDir:         cfg.TempDir,
+		URL:             cfg.DatabaseURL,
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: config.Seconds(cfg.DBConnMaxLifetime),
+	}
+}
+
+// runBackupCommand takes one backup into BACKUP_DIR and prints its path
+func runBackupCommand(cfg *config.Config) {
+	if err := db.Init(dbConfig(cfg)); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	backup, err := db.Backup(context.Background(), cfg.BackupDir, time.Now())
+	if err != nil {
+		log.Fatalf("Backup failed: %v", err)
+	}
+	if err := db.PruneBackups(cfg.BackupDir, cfg.BackupRetain); err != nil {
+		log.Printf("Failed to prune backups: %v", err)
+	}
+	fmt.Println(filepath.Join(cfg.BackupDir, backup.Name))
+}
 
+// runBackups backs up the database every interval until ctx is done,
+// keeping the newest keep backups
+func runBackups(ctx context.Context, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		backup, err := db.Backup(ctx, dir, time.Now())
+		if err != nil {
+			log.Printf("Backup failed: %v", err)
+			continue
+		}
+		log.Printf("Database backed up to %s", filepath.Join(dir, backup.Name))
+		if err := db.PruneBackups(dir, keep); err != nil {
+			log.Printf("Failed to prune backups: %v", err)
+		}
+	}
+}
+
+// probeFile runs ffprobe over one of a job's files. The metadata is only
+// informational, so failures are logged and leave it unset.
+func probeFile(ctx context.Context, jobID, path string) *transcoder.MediaInfo {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	info, err := transcoder.Probe(ctx, path)
+	if err != nil {
+		requestid.Logf(ctx, "Job %s: could not probe %s: %v", jobID, path, err)
 		return nil
 	}
+	return info
+}
+
+// resolvePreset loads the job's preset, preferring the tenant's own over a
+// global one. Jobs without a preset use a stored "default" preset if one
+// exists, else the built-in default.
+func resolvePreset(tenantID, name string) (*transcoder.Preset, error) {
+	if name == "" {
+		if preset, err := db.ResolvePreset(tenantID, "default"); err == nil {
+			return preset, nil
+		}
+		return transcoder.DefaultPreset(), nil
+	}
+
+	preset, err := db.ResolvePreset(tenantID, name)
+	if err != nil {
+		return nil, fmt.Errorf("preset %q not found", name)
+	}
+	return preset, nil
 }
 
-func handleJobFailure(job *jobs.Job, webhookClient *webhook.Client, webhookURL, errMsg string) error {
-	log.Printf("Job %s failed: %s", job.ID, errMsg)
+// handleJobFailure ends a job as failed or dead-lettered
+func handleJobFailure(repo db.JobRepository, clk clock.Clock, job *jobs.Job, t *tenant.Tenant, notifier *webhook.Notifier, mailer *email.Mailer, status jobs.JobStatus, errMsg string) error {
+	requestid.Logf(requestid.NewContext(context.Background(), job.RequestID), "Job %s failed: %s", job.ID, errMsg)
 
-	now := time.Now().UTC()
-	job.Status = jobs.StatusFailed
+	now := clk.Now().UTC()
+	if err := job.Transition(status, jobs.ActorWorker, job.RequestID, clk); err != nil {
+		return err
+	}
 	job.Error = errMsg
 	job.CompletedAt = &now
 	job.UpdatedAt = now
-	db.UpdateJob(job)
+	if err := saveJob(repo, job); err != nil {
+		return err
+	}
+	repo.RecordJobEvent(job.ID, jobs.EventFailed, job.RequestID, errMsg)
+	errreport.Capture(errreport.Event{
+		Kind:    errreport.KindJobFailed,
+		Message: "job failed: " + errMsg,
+		Tags: map[string]string{
+			"job_id":     job.ID,
+			"status":     string(status),
+			"stage":      job.Stage,
+			"preset":     job.Preset,
+			"tenant_id":  job.TenantID,
+			"request_id": job.RequestID,
+		},
+		Extra: map[string]interface{}{
+			"original_name": job.OriginalName,
+			"attempts":      job.Attempts,
+			"input_size":    job.InputSize,
+			"destination":   job.Destination,
+		},
+	})
 
 	// Send failure webhook
-	webhookClient.SendAsync(webhookURL, &webhook.Payload{
+	notifier.Notify(job, t, webhook.EventJobFailed, &webhook.Payload{
+		Error:       errMsg,
+		ErrorCode:   job.ErrorCode,
+		Rejection:   job.Rejection,
+		CompletedAt: now.Format(time.RFC3339),
+	})
+	emailJobResult(repo, mailer, job)
+
+	return fmt.Errorf(errMsg)
+}
+
+// maxRetryBackoff caps how many times the retry delay is doubled
+const maxRetryBackoff = 10
+
+// retryJob schedules another attempt at a job whose attempt failed, after
+// delay doubled for each earlier attempt
+func retryJob(repo db.JobRepository, jobQueue *jobs.Queue, clk clock.Clock, job *jobs.Job, delay time.Duration, errMsg string) error {
+	requestid.Logf(requestid.NewContext(context.Background(), job.RequestID), "Job %s attempt %d failed, retrying: %s", job.ID, job.Attempts, errMsg)
+
+	if err := job.Transition(jobs.StatusRetrying, jobs.ActorWorker, job.RequestID, clk); err != nil {
+		return err
+	}
+	now := clk.Now().UTC()
+	retryAt := now.Add(delay << min(job.Attempts-1, maxRetryBackoff))
+	job.Error = errMsg
+	job.Stage = ""
+	job.Progress = 0
+	job.ScheduledAt = &retryAt
+	job.UpdatedAt = now
+	if err := saveJob(repo, job); err != nil {
+		return err
+	}
+	repo.RecordJobEvent(job.ID, jobs.EventRetryScheduled, job.RequestID, errMsg)
+
+	queued := *job
+	if err := jobQueue.Enqueue(&queued); err != nil {
+		log.Printf("Failed to re-enqueue job %s: %v", job.ID, err)
+	}
+	return fmt.Errorf(errMsg)
+}
+
+// deferUpload puts a job whose destination is down back in the queue with
+// its outputs, to be uploaded once dispatch resumes. The attempt doesn't
+// count towards JOB_MAX_ATTEMPTS.
+func deferUpload(repo db.JobRepository, jobQueue *jobs.Queue, clk clock.Clock, job *jobs.Job, errMsg string) error {
+	requestid.Logf(requestid.NewContext(context.Background(), job.RequestID), "Job %s waiting for its destination: %s", job.ID, errMsg)
+
+	if err := job.Transition(jobs.StatusPending, jobs.ActorWorker, job.RequestID, clk); err != nil {
+		return err
+	}
+	job.Attempts--
+	job.Stage = jobs.StageUploadWaiting
+	job.UpdatedAt = clk.Now().UTC()
+	if err := saveJob(repo, job); err != nil {
+		return err
+	}
+	repo.RecordJobEvent(job.ID, jobs.EventUploadDeferred, job.RequestID, errMsg)
+
+	queued := *job
+	if err := jobQueue.Enqueue(&queued); err != nil {
+		log.Printf("Failed to re-enqueue job %s: %v", job.ID, err)
+	}
+	return fmt.Errorf("upload deferred: %s", errMsg)
+}
+
+// newMailer creates the email notifier when SMTP_HOST is set
+func newMailer(cfg *config.Config) (*email.Mailer, error) {
+	if cfg.SMTPHost == "" {
+		return nil, nil
+	}
+
+	var body string
+	if cfg.EmailBodyFile != "" {
+		data, err := os.ReadFile(cfg.EmailBodyFile)
+		if err != nil {
+			return nil, err
+		}
+		body = string(data)
+	}
+
+	to, err := email.ValidateRecipients(cfg.EmailTo)
+	if err != nil {
+		return nil, err
+	}
+	return email.NewMailer(email.Config{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+		To:       to,
+	}, cfg.EmailSubject, body)
+}
+
+// emailJobResult mails a finished job's outcome to its recipients and
+// records whether that worked
+func emailJobResult(repo db.JobRepository, mailer *email.Mailer, job *jobs.Job) {
+	msg := &email.Message{
 		JobID:        job.ID,
 		Status:       string(job.Status),
-		Error:        errMsg,
 		OriginalName: job.OriginalName,
-		CompletedAt:  now.Format(time.RFC3339),
-	})
+		OutputName:   job.OutputName(),
+		DriveURL:     job.DriveURL,
+		Error:        job.Error,
+	}
+	if job.CompletedAt != nil {
+		msg.CompletedAt = *job.CompletedAt
+	}
 
-	return fmt.Errorf(errMsg)
+	jobID, requestID := job.ID, job.RequestID
+	mailer.SendAsync(job.NotifyEmails, msg, func(err error) {
+		if err != nil {
+			log.Printf("Failed to email result of job %s: %v", jobID, err)
+			repo.RecordJobEvent(jobID, jobs.EventEmailFailed, requestID, err.Error())
+			return
+		}
+		repo.RecordJobEvent(jobID, jobs.EventEmailSent, requestID, "")
+	})
 }
 
-func recoverPendingJobs(jobQueue *jobs.Queue) {
-	pendingJobs, err := db.GetPendingJobs()
+// recoverPendingJobs queues the jobs left pending or processing when the
+// server last stopped, skipping any already in the queue
+func recoverPendingJobs(repo db.JobRepository, jobQueue *jobs.Queue) {
+	pendingJobs, err := repo.GetPendingJobs()
 	if err != nil {
 		log.Printf("Warning: failed to recover pending jobs: %v", err)
 		return
@@ -223,13 +1114,70 @@ func recoverPendingJobs(jobQueue *jobs.Queue) {
 	log.Printf("Recovering %d pending jobs", len(pendingJobs))
 	for i := range pendingJobs {
 		job := &pendingJobs[i]
-		// Reset status to pending for re-processing
-		job.Status = jobs.StatusPending
-		job.Progress = 0
-		db.UpdateJob(job)
+		if jobQueue.Queued(job.ID) {
+			continue
+		}
+		// Jobs interrupted mid-run start over, unless only the upload was
+		// left; retrying jobs keep their schedule
+		if job.Status == jobs.StatusProcessing {
+			if err := job.Transition(jobs.StatusPending, jobs.ActorRecovery, job.RequestID, clock.System); err != nil {
+				log.Printf("Failed to reset job %s for recovery: %v", job.ID, err)
+				continue
+			}
+		}
+		if !encoded(job) {
+			job.Stage = ""
+			job.Progress = 0
+		}
+		if err := repo.UpdateJob(job); err != nil {
+			log.Printf("Failed to reset job %s for recovery: %v", job.ID, err)
+			continue
+		}
 
 		if err := jobQueue.Enqueue(job); err != nil {
 			log.Printf("Failed to re-enqueue job %s: %v", job.ID, err)
 		}
 	}
 }
+
+// encoded reports whether a job stopped while uploading, with all of its
+// outputs still on disk, so it can skip encoding them again
+func encoded(job *jobs.Job) bool {
+	if job.Stage != jobs.StageUploading && job.Stage != jobs.StageUploadWaiting {
+		return false
+	}
+	for _, file := range job.OutputFiles() {
+		if _, err := os.Stat(file); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// maxAudioDrift is how many seconds the output's audio and video may
+// differ in length before the job is flagged
+const maxAudioDrift = 0.25
+
+// checkAudioDrift compares the lengths of the output's audio and video.
+// Audio much longer or shorter than the video has drifted out of sync,
+// which is recorded on the job as its audio_drift and as an event.
+func checkAudioDrift(ctx context.Context, repo db.JobRepository, job *jobs.Job, preset *transcoder.Preset) {
+	job.AudioDrift = 0
+	if job.OutputProbe == nil {
+		return
+	}
+	drift := job.OutputProbe.AudioDrift()
+	if math.Abs(drift) <= maxAudioDrift {
+		return
+	}
+	job.AudioDrift = math.Round(drift*1000) / 1000
+	message := fmt.Sprintf("audio runs %.2fs longer than the video", drift)
+	if drift < 0 {
+		message = fmt.Sprintf("audio runs %.2fs shorter than the video", -drift)
+	}
+	if !preset.AudioSync {
+		message += "; the preset's audio_sync may correct it"
+	}
+	requestid.Logf(ctx, "Job %s: %s", job.ID, message)
+	repo.RecordJobEvent(job.ID, jobs.EventAudioDrift, job.RequestID, message)
+}
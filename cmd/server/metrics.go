@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/metrics"
+)
+
+// encodeHistogram tracks how long encodes take relative to their length,
+// which is what worker capacity has to be planned around
+var encodeHistogram = metrics.NewHistogram(
+	"transcoder_encode_seconds_per_input_minute",
+	"Seconds spent transcoding per minute of input, by preset and output resolution.",
+	[]float64{5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 300, 600},
+	"preset", "resolution",
+)
+
+// recordEncode notes how long a job's successful transcode took
+func recordEncode(job *jobs.Job, elapsed time.Duration) {
+	job.EncodeTime = elapsed.Seconds()
+	if job.Duration <= 0 {
+		return
+	}
+	preset := job.Preset
+	if preset == "" {
+		preset = "default"
+	}
+	encodeHistogram.Observe(job.EncodeTime/(job.Duration/60), preset, resolutionClass(job))
+}
+
+// resolutionClass buckets the height of a job's output into the usual
+// names, keeping the number of series small
+func resolutionClass(job *jobs.Job) string {
+	probe := job.OutputProbe
+	if probe == nil {
+		probe = job.InputProbe
+	}
+	if probe == nil {
+		return "unknown"
+	}
+	if probe.Height == 0 {
+		return "audio"
+	}
+	for _, height := range []int{2160, 1440, 1080, 720, 480, 360} {
+		if probe.Height >= height {
+			return fmt.Sprintf("%dp", height)
+		}
+	}
+	return "240p"
+}
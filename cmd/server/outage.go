@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/metrics"
+)
+
+// outageCause keys the queue's pause while a destination is down
+const outageCause = "destinations"
+
+var destinationDownGauge = metrics.NewGauge("transcoder_destination_down", "1 while uploads to a destination keep failing and dispatch is paused.", "destination")
+
+// outages tracks failed uploads by destination. After threshold failures
+// in a row a destination counts as down: dispatch pauses, so queued jobs
+// wait instead of all failing, and jobs reaching the upload wait with
+// their outputs. Every retry, dispatch resumes to let a job try again; a
+// successful upload ends the outage and another failure pauses again.
+// A nil *outages never pauses.
+type outages struct {
+	queue     *jobs.Queue
+	threshold int
+	retry     time.Duration
+
+	mu       sync.Mutex
+	failures map[string]int       // consecutive failed uploads
+	down     map[string]time.Time // since when
+	timer    *time.Timer          // resumes dispatch for another try
+}
+
+// newOutages returns the tracker, or nil when threshold is 0
+func newOutages(queue *jobs.Queue, threshold int, retry time.Duration) *outages {
+	if threshold == 0 {
+		return nil
+	}
+	return &outages{
+		queue:     queue,
+		threshold: threshold,
+		retry:     retry,
+		failures:  make(map[string]int),
+		down:      make(map[string]time.Time),
+	}
+}
+
+// outageKey names the destination a job uploads to. The Drive folders
+// without a profile share Drive's availability.
+func outageKey(dest *destination) string {
+	if dest.name == "" {
+		return dest.kind
+	}
+	return dest.name
+}
+
+// failed records a failed upload and reports whether the destination is
+// now down, in which case the job should wait rather than fail
+func (o *outages) failed(dest *destination, err error) bool {
+	if o == nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := outageKey(dest)
+	o.failures[key]++
+	if _, down := o.down[key]; !down {
+		if o.failures[key] < o.threshold {
+			return false
+		}
+		log.Printf("Destination %s is down after %d failed uploads, pausing dispatch: %v", key, o.failures[key], err)
+		o.down[key] = time.Now().UTC()
+		destinationDownGauge.Set(1, key)
+	}
+	o.queue.Pause(outageCause, o.reason())
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	o.timer = time.AfterFunc(o.retry, func() {
+		o.queue.Resume(outageCause)
+	})
+	return true
+}
+
+// succeeded records a successful upload, ending any outage of the
+// destination
+func (o *outages) succeeded(dest *destination) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := outageKey(dest)
+	delete(o.failures, key)
+	since, down := o.down[key]
+	if !down {
+		return
+	}
+	log.Printf("Destination %s is back after %v", key, time.Since(since).Round(time.Second))
+	delete(o.down, key)
+	destinationDownGauge.Set(0, key)
+	if len(o.down) > 0 {
+		o.queue.Pause(outageCause, o.reason())
+		return
+	}
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	o.queue.Resume(outageCause)
+}
+
+// reason describes the outages for Paused; o.mu must be held. Upload
+// errors are left out as the reason is shown by /readyz.
+func (o *outages) reason() string {
+	names := make([]string, 0, len(o.down))
+	for name, since := range o.down {
+		names = append(names, fmt.Sprintf("%s since %s", name, since.Format(time.RFC3339)))
+	}
+	sort.Strings(names)
+	return "destination down: " + strings.Join(names, ", ")
+}
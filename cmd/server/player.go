@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// playerSettings say whether delivered video outputs get player files,
+// and how their sprite sheets are made
+type playerSettings struct {
+	enabled  bool
+	interval time.Duration
+	width    int
+}
+
+// newPlayerSettings reads the player settings from cfg, which
+// prepareConfig has checked
+func newPlayerSettings(cfg *config.Config) playerSettings {
+	return playerSettings{
+		enabled:  cfg.PlayerAssets,
+		interval: config.Seconds(cfg.SpriteInterval),
+		width:    cfg.SpriteWidth,
+	}
+}
+
+// wants reports whether a job's output gets player files. They are only
+// made for outputs delivered to a destination, as that is where the
+// manifest points.
+func (s playerSettings) wants(job *jobs.Job, preset *transcoder.Preset, delivered bool) bool {
+	if job.MediaType == jobs.MediaImage || preset.VideoCodec == "none" {
+		return false
+	}
+	return s.enabled && delivered
+}
+
+// makePlayerFiles saves a sprite sheet of thumbnails from the job's output
+// and the WebVTT file players find them with. The output is usable
+// without them, so failures are only logged.
+func makePlayerFiles(ctx context.Context, job *jobs.Job, settings playerSettings, localStorage *storage.LocalStorage) {
+	job.Player = nil
+	info := job.OutputProbe
+	if info == nil {
+		requestid.Logf(ctx, "Job %s: no sprites made, as the output couldn't be probed", job.ID)
+		return
+	}
+	duration := outputDuration(job)
+	sprite, thumbnails := job.PlayerFilePath(jobs.SpriteSuffix), job.PlayerFilePath(jobs.ThumbnailsSuffix)
+	sheet, err := transcoder.ExtractSprites(ctx, job.OutputPath, sprite, duration, settings.interval, settings.width, info.Width, info.Height)
+	if err == nil {
+		// The sprite sheet is uploaded beside the thumbnails, so is found by
+		// its name
+		err = transcoder.WriteThumbnailsVTT(thumbnails, job.PlayerFileName(jobs.SpriteSuffix), sheet, duration)
+	}
+	if err != nil {
+		requestid.Logf(ctx, "Job %s: %v", job.ID, err)
+		localStorage.DeleteFile(sprite)
+		localStorage.DeleteFile(thumbnails)
+		return
+	}
+	job.Player = &jobs.PlayerFiles{
+		Interval: sheet.Interval.Seconds(),
+		Width:    sheet.Width,
+		Height:   sheet.Height,
+		Columns:  sheet.Columns,
+		Count:    sheet.Count,
+	}
+}
+
+// uploadPlayerFiles uploads the job's sprite sheet, thumbnails and
+// uploaded captions beside its output, skipping any already uploaded,
+// then the manifest listing them with the output at outputLink, its
+// renditions, poster and extracted captions
+func uploadPlayerFiles(ctx context.Context, dest *destination, t *tenant.Tenant, job *jobs.Job, outputLink string) error {
+	player := job.Player
+	if player == nil || player.FileID != "" {
+		return nil
+	}
+	if player.SpriteFileID == "" {
+		fileID, link, err := uploadTo(ctx, dest, t, job.PlayerFilePath(jobs.SpriteSuffix), job.PlayerFileName(jobs.SpriteSuffix))
+		if err != nil {
+			return fmt.Errorf("sprites: %w", err)
+		}
+		player.SpriteFileID, player.SpriteURL = fileID, link
+	}
+	if player.ThumbnailsFileID == "" {
+		path := job.PlayerFilePath(jobs.ThumbnailsSuffix)
+		// Drive files can't be found by name, so the thumbnails point at
+		// the sprite sheet's link instead
+		if dest.kind == config.DestinationGoogleDrive {
+			sheet := transcoder.SpriteSheet{
+				Interval: time.Duration(player.Interval * float64(time.Second)),
+				Width:    player.Width,
+				Height:   player.Height,
+				Columns:  player.Columns,
+				Count:    player.Count,
+			}
+			if err := transcoder.WriteThumbnailsVTT(path, player.SpriteURL, sheet, outputDuration(job)); err != nil {
+				return err
+			}
+		}
+		fileID, link, err := uploadTo(ctx, dest, t, path, job.PlayerFileName(jobs.ThumbnailsSuffix))
+		if err != nil {
+			return fmt.Errorf("thumbnails: %w", err)
+		}
+		player.ThumbnailsFileID, player.ThumbnailsURL = fileID, link
+	}
+	if job.CaptionsPath != "" && player.CaptionsFileID == "" {
+		fileID, link, err := uploadTo(ctx, dest, t, job.CaptionsPath, job.PlayerFileName(jobs.PlayerCaptionsSuffix))
+		if err != nil {
+			return fmt.Errorf("captions: %w", err)
+		}
+		player.CaptionsFileID, player.CaptionsURL = fileID, link
+	}
+
+	data, err := json.MarshalIndent(newPlayerManifest(job, outputLink), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := job.PlayerFilePath(jobs.PlayerManifestSuffix)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write player manifest: %w", err)
+	}
+	defer os.Remove(path)
+	fileID, link, err := uploadTo(ctx, dest, t, path, job.PlayerFileName(jobs.PlayerManifestSuffix))
+	if err != nil {
+		return fmt.Errorf("player manifest: %w", err)
+	}
+	player.FileID, player.URL = fileID, link
+	return nil
+}
+
+// playerManifest is the JSON file a player is configured from
+type playerManifest struct {
+	JobID      string             `json:"job_id"`
+	Name       string             `json:"name"`
+	Duration   float64            `json:"duration,omitempty"` // seconds
+	Width      int                `json:"width,omitempty"`
+	Height     int                `json:"height,omitempty"`
+	Sources    []playerSource     `json:"sources"` // the output first, then its renditions
+	Poster     string             `json:"poster,omitempty"`
+	Thumbnails *playerThumbnails  `json:"thumbnails,omitempty"`
+	Captions   []playerCaptionRef `json:"captions,omitempty"`
+}
+
+type playerSource struct {
+	URL    string `json:"url"`
+	Type   string `json:"type"`            // content type
+	Label  string `json:"label,omitempty"` // the preset
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+type playerThumbnails struct {
+	URL      string  `json:"url"`    // of the WebVTT file
+	Sprite   string  `json:"sprite"` // of the sprite sheet
+	Interval float64 `json:"interval"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+}
+
+type playerCaptionRef struct {
+	URL    string `json:"url"`
+	Format string `json:"format"`
+	Source string `json:"source"` // "uploaded" with the job or "closed_captions" from its input
+}
+
+// newPlayerManifest describes the job's uploaded files for its manifest.
+// Destinations that don't return links, such as directories, have the
+// files referred to by name, relative to the manifest beside them.
+func newPlayerManifest(job *jobs.Job, outputLink string) playerManifest {
+	manifest := playerManifest{
+		JobID:    job.ID,
+		Name:     job.DeliveredName(),
+		Duration: outputDuration(job).Seconds(),
+		Sources: []playerSource{{
+			URL:   playerLink(outputLink, job.DeliveredName()),
+			Type:  storage.ContentType(job.DeliveredName()),
+			Label: job.Preset,
+		}},
+	}
+	if info := job.OutputProbe; info != nil {
+		manifest.Width, manifest.Height = info.Width, info.Height
+		manifest.Sources[0].Width, manifest.Sources[0].Height = info.Width, info.Height
+	}
+	for _, variant := range job.Variants {
+		// Only renditions are playable; the other variants are of images.
+		// Those in an HLS ladder play from its master playlist.
+		if variant.Ext == "" || job.HLS.Stream(variant.Name) != nil {
+			continue
+		}
+		name := job.VariantName(variant.Name)
+		manifest.Sources = append(manifest.Sources, playerSource{
+			URL:    playerLink(variant.URL, name),
+			Type:   storage.ContentType(name),
+			Label:  variant.Name,
+			Width:  variant.Width,
+			Height: variant.Height,
+		})
+	}
+	if job.PosterFileID != "" {
+		manifest.Poster = playerLink(job.PosterURL, job.PosterName())
+	}
+
+	player := job.Player
+	manifest.Thumbnails = &playerThumbnails{
+		URL:      playerLink(player.ThumbnailsURL, job.PlayerFileName(jobs.ThumbnailsSuffix)),
+		Sprite:   playerLink(player.SpriteURL, job.PlayerFileName(jobs.SpriteSuffix)),
+		Interval: player.Interval,
+		Width:    player.Width,
+		Height:   player.Height,
+	}
+	if player.CaptionsFileID != "" {
+		manifest.Captions = append(manifest.Captions, playerCaptionRef{
+			URL:    playerLink(player.CaptionsURL, job.PlayerFileName(jobs.PlayerCaptionsSuffix)),
+			Format: "vtt",
+			Source: "uploaded",
+		})
+	}
+	for _, caption := range job.ClosedCaps {
+		if caption.Format == "vtt" {
+			manifest.Captions = append(manifest.Captions, playerCaptionRef{
+				URL:    playerLink(caption.URL, job.CaptionName(caption.Format)),
+				Format: caption.Format,
+				Source: "closed_captions",
+			})
+		}
+	}
+	return manifest
+}
+
+// playerLink returns link, or the file's name when the destination
+// returned none
+func playerLink(link, name string) string {
+	if link == "" {
+		return name
+	}
+	return link
+}
+
+// outputDuration returns the length of the job's output, or of its input
+// when the output's isn't known
+func outputDuration(job *jobs.Job) time.Duration {
+	if info := job.OutputProbe; info != nil && info.Duration > 0 {
+		return time.Duration(info.Duration * float64(time.Second))
+	}
+	return time.Duration(job.Duration * float64(time.Second))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// posterSettings say which outputs get a poster, and where in them it is
+// looked for
+type posterSettings struct {
+	all      bool // every video output, delivered alongside it
+	playback bool // video outputs kept on the server, for playback pages
+	at       transcoder.PosterTime
+}
+
+// newPosterSettings reads the poster settings from cfg, which
+// prepareConfig has checked
+func newPosterSettings(cfg *config.Config) posterSettings {
+	at, _ := transcoder.ParsePosterTime(cfg.PosterAt)
+	return posterSettings{all: cfg.Posters, playback: cfg.PlaybackPages, at: at}
+}
+
+// wants reports whether a job's output, delivered to a destination or
+// not, gets a poster
+func (s posterSettings) wants(job *jobs.Job, preset *transcoder.Preset, delivered bool) bool {
+	if job.MediaType == jobs.MediaImage || preset.VideoCodec == "none" {
+		return false
+	}
+	return s.all || s.playback && !delivered
+}
+
+// makePoster saves a still from the job's output, chosen near the
+// configured time. The output is usable without one, so failures are
+// only logged.
+func makePoster(ctx context.Context, job *jobs.Job, settings posterSettings, localStorage *storage.LocalStorage) {
+	path := localStorage.GetOutputPath(job.ID+"-poster", ".jpg")
+	at := settings.at.In(time.Duration(job.Duration * float64(time.Second)))
+	if err := transcoder.ExtractPoster(ctx, job.OutputPath, path, at); err != nil {
+		requestid.Logf(ctx, "Job %s: %v", job.ID, err)
+		localStorage.DeleteFile(path)
+		return
+	}
+	job.PosterPath = path
+}
+
+// uploadPoster uploads the job's poster, if it has one, beside its output
+func uploadPoster(ctx context.Context, dest *destination, t *tenant.Tenant, job *jobs.Job) error {
+	if job.PosterPath == "" || job.PosterURL != "" {
+		return nil
+	}
+	fileID, link, err := uploadTo(ctx, dest, t, job.PosterPath, job.PosterName())
+	if err != nil {
+		return err
+	}
+	job.PosterFileID, job.PosterURL = fileID, link
+	return nil
+}
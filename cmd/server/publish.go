@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// publisher copies completed outputs to destination profiles on request;
+// see api.Publisher
+type publisher struct {
+	ctx      context.Context // cancelled at shutdown
+	repo     db.JobRepository
+	uploads  *destinations
+	notifier *webhook.Notifier
+	replica  string // recorded on publications; see replicaName
+}
+
+// Publish uploads the job's output and variants to the publication's
+// destination in the background. The publication is first marked as this
+// replica's, so that only this replica fails it if interrupted.
+func (p *publisher) Publish(job *jobs.Job, publication *jobs.Publication) {
+	publication.Replica = p.replica
+	p.save(publication)
+	go p.publish(job, publication)
+}
+
+func (p *publisher) publish(job *jobs.Job, publication *jobs.Publication) {
+	ctx := requestid.NewContext(p.ctx, publication.RequestID)
+	var t *tenant.Tenant
+	if job.TenantID != "" {
+		t, _ = db.GetTenant(job.TenantID)
+	}
+
+	outputName := job.OutputName()
+	err := p.upload(ctx, job, publication, outputName)
+	now := time.Now().UTC()
+	publication.UpdatedAt = now
+	if err != nil {
+		if p.ctx.Err() != nil {
+			err = fmt.Errorf("interrupted by shutdown")
+		}
+		requestid.Logf(ctx, "Job %s: publishing to %s failed: %v", job.ID, publication.Destination, err)
+		publication.Status = jobs.PublicationFailed
+		publication.Error = err.Error()
+		p.save(publication)
+		p.repo.RecordJobEvent(job.ID, jobs.EventPublishFailed, publication.RequestID, publication.Destination+": "+publication.Error)
+		p.notifier.Notify(job, t, webhook.EventJobPublishFailed, &webhook.Payload{
+			Destination: publication.Destination,
+			OutputName:  outputName,
+			Error:       publication.Error,
+		})
+		return
+	}
+
+	publication.Status = jobs.PublicationCompleted
+	publication.CompletedAt = &now
+	p.save(publication)
+	p.repo.RecordJobEvent(job.ID, jobs.EventPublished, publication.RequestID, publication.Destination)
+	p.notifier.Notify(job, t, webhook.EventJobPublished, &webhook.Payload{
+		Destination: publication.Destination,
+		DriveURL:    publication.URL,
+		DriveFileID: publication.FileID,
+		OutputName:  outputName,
+	})
+}
+
+// upload copies the output, then any image variants, poster and
+// extracted captions, to the destination. The publication stays pending
+// while the destination's upload slots are all taken.
+func (p *publisher) upload(ctx context.Context, job *jobs.Job, publication *jobs.Publication, outputName string) error {
+	dest, err := p.uploads.named(publication.Destination)
+	if err != nil {
+		return err
+	}
+	release, err := dest.reserve(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	publication.Status = jobs.PublicationUploading
+	publication.UpdatedAt = time.Now().UTC()
+	p.save(publication)
+	fileID, link, err := dest.upload.UploadFile(ctx, job.OutputPath, outputName)
+	if err != nil {
+		return err
+	}
+	publication.FileID, publication.URL = fileID, link
+	// Extras are recorded as they go, so a partial copy can still be purged
+	for _, variant := range job.Variants {
+		fileID, _, err := dest.upload.UploadFile(ctx, job.VariantPath(variant.Name), job.VariantName(variant.Name))
+		if err != nil {
+			return fmt.Errorf("%s variant: %w", variant.Name, err)
+		}
+		publication.Extras = append(publication.Extras, fileID)
+	}
+	if job.PosterPath != "" {
+		fileID, _, err := dest.upload.UploadFile(ctx, job.PosterPath, job.PosterName())
+		if err != nil {
+			return fmt.Errorf("poster: %w", err)
+		}
+		publication.Extras = append(publication.Extras, fileID)
+	}
+	for _, caption := range job.ClosedCaps {
+		fileID, _, err := dest.upload.UploadFile(ctx, job.CaptionPath(caption.Format), job.CaptionName(caption.Format))
+		if err != nil {
+			return fmt.Errorf("%s captions: %w", caption.Format, err)
+		}
+		publication.Extras = append(publication.Extras, fileID)
+	}
+	return nil
+}
+
+func (p *publisher) save(publication *jobs.Publication) {
+	if err := db.UpdatePublication(publication); err != nil {
+		log.Printf("Failed to save publication %d of job %s: %v", publication.ID, publication.JobID, err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/storage"
+)
+
+// minPurgeAfterDays keeps deleted jobs for longer than a month, since
+// monthly usage still counts them
+const minPurgeAfterDays = 32
+
+// How often deleted jobs are looked for, and how many are read at a time
+const (
+	purgeInterval  = time.Hour
+	purgeBatchSize = 100
+)
+
+// runPurge purges jobs deleted more than after ago, every purgeInterval
+// until ctx is done
+func runPurge(ctx context.Context, repo db.JobRepository, uploads *destinations, after time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, kept, err := purgeDeletedJobs(ctx, repo, uploads, time.Now().UTC().Add(-after))
+		if err != nil {
+			log.Printf("Failed to purge deleted jobs: %v", err)
+		}
+		if purged > 0 || kept > 0 {
+			log.Printf("Purged %d deleted jobs; %d kept until their files can be removed", purged, kept)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedJobs removes the uploaded files of every job deleted before
+// the cutoff, then the job itself. Jobs whose files can't all be removed
+// are kept, to be tried again next time.
+func purgeDeletedJobs(ctx context.Context, repo db.JobRepository, uploads *destinations, before time.Time) (purged, kept int, err error) {
+	for {
+		page, err := repo.ListPurgeableJobs(before, kept, purgeBatchSize)
+		if err != nil {
+			return purged, kept, err
+		}
+		for i := range page {
+			if ctx.Err() != nil {
+				return purged, kept, ctx.Err()
+			}
+			job := &page[i]
+			if err := deleteUploads(ctx, repo, uploads, job); err != nil {
+				log.Printf("Job %s: keeping deleted job: %v", job.ID, err)
+				kept++
+				continue
+			}
+			if err := repo.PurgeJob(job.ID); err != nil {
+				return purged, kept, err
+			}
+			purged++
+		}
+		if len(page) < purgeBatchSize {
+			return purged, kept, nil
+		}
+	}
+}
+
+// deleteUploads removes the job's files from every destination they were
+// uploaded or published to
+func deleteUploads(ctx context.Context, repo db.JobRepository, uploads *destinations, job *jobs.Job) error {
+	if job.DriveFileID != "" {
+		fileIDs := []string{job.DriveFileID, job.PosterFileID}
+		for _, variant := range job.Variants {
+			fileIDs = append(fileIDs, variant.FileID)
+		}
+		for _, caption := range job.ClosedCaps {
+			fileIDs = append(fileIDs, caption.FileID)
+		}
+		if player := job.Player; player != nil {
+			fileIDs = append(fileIDs, player.SpriteFileID, player.ThumbnailsFileID, player.CaptionsFileID, player.FileID)
+		}
+		if hls := job.HLS; hls != nil {
+			for _, file := range hls.Uploaded {
+				fileIDs = append(fileIDs, file.FileID)
+			}
+			for _, stream := range hls.Streams {
+				fileIDs = append(fileIDs, stream.FileID)
+			}
+		}
+		if err := deleteFrom(ctx, repo, uploads, job.ID, job.Destination, job.StorageType, fileIDs); err != nil {
+			return err
+		}
+	}
+
+	publications, err := db.ListPublications(job.ID)
+	if err != nil {
+		return err
+	}
+	for _, publication := range publications {
+		fileIDs := append([]string{publication.FileID}, publication.Extras...)
+		if err := deleteFrom(ctx, repo, uploads, job.ID, publication.Destination, "", fileIDs); err != nil {
+			return fmt.Errorf("publication %d: %w", publication.ID, err)
+		}
+	}
+	return nil
+}
+
+// deleteFrom removes the job's files from the named destination profile,
+// or from the GOOGLE_* Drive folder when name is empty. A profile whose
+// type no longer matches kind may not hold the files any more, so it is
+// left alone, as are files other jobs still refer to.
+func deleteFrom(ctx context.Context, repo db.JobRepository, uploads *destinations, jobID, name, kind string, fileIDs []string) error {
+	var target storage.Destination
+	if name == "" {
+		if uploads.drive == nil {
+			return fmt.Errorf("Google Drive is not configured")
+		}
+		target = uploads.drive
+	} else {
+		dest, err := uploads.named(name)
+		if err != nil {
+			return err
+		}
+		if kind != "" && dest.kind != kind {
+			return fmt.Errorf("destination %q is now %s, not %s", name, dest.kind, kind)
+		}
+		target = dest.upload
+	}
+
+	deleter, ok := target.(storage.Deleter)
+	if !ok {
+		return fmt.Errorf("destination %q can't delete files", name)
+	}
+	for _, fileID := range fileIDs {
+		if fileID == "" {
+			continue
+		}
+		inUse, err := repo.FileInUse(fileID, jobID)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			continue
+		}
+		if err := deleter.DeleteFile(ctx, fileID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/api"
+	"github.com/skillcape/transcoder/internal/clock"
+	"github.com/skillcape/transcoder/internal/email"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// orphanGrace spares files written recently, such as uploads whose job
+// isn't saved yet and intros being checked
+const orphanGrace = time.Hour
+
+// reconciler checks the files in TEMP_DIR against the jobs in the
+// database. Each replica reconciles its own TEMP_DIR and queue.
+type reconciler struct {
+	repo         db.JobRepository
+	jobQueue     *jobs.Queue
+	localStorage *storage.LocalStorage
+	notifier     *webhook.Notifier
+	mailer       *email.Mailer
+
+	mu   sync.Mutex // held for a whole pass
+	last atomic.Pointer[api.ReconcileReport]
+}
+
+// runReconcile reconciles TEMP_DIR every interval until ctx is done
+func runReconcile(ctx context.Context, r *reconciler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reconcile(); err != nil {
+			log.Printf("Reconciliation failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LastReport returns the report of the last pass, or nil before the first
+func (r *reconciler) LastReport() *api.ReconcileReport {
+	return r.last.Load()
+}
+
+// Reconcile deletes the files in TEMP_DIR that no job refers to, fails
+// the queued jobs whose uploads are missing, and reports completed jobs
+// whose output was kept on the server but is gone
+func (r *reconciler) Reconcile() (*api.ReconcileReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &api.ReconcileReport{
+		StartedAt:      time.Now().UTC(),
+		DeletedFiles:   []string{},
+		FailedJobs:     []string{},
+		MissingOutputs: []string{},
+	}
+	// The files are listed first, so those of jobs created meanwhile are
+	// new enough to be spared
+	files, err := r.localStorage.Files()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	referred := make(map[string]bool)
+	var missing []*jobs.Job
+	err = r.repo.EachJobFiles(func(job *jobs.Job) {
+		for _, path := range append(job.UploadedFiles(), job.OutputFiles()...) {
+			if path != "" {
+				referred[filepath.Clean(path)] = true
+			}
+		}
+		switch {
+		case r.jobQueue.Queued(job.ID) && missingFile(job.UploadedFiles()) != "":
+			queued := *job
+			missing = append(missing, &queued)
+		case job.Status == jobs.StatusCompleted && job.DriveFileID == "" && missingFile([]string{job.OutputPath}) != "":
+			report.MissingOutputs = append(report.MissingOutputs, job.ID)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	for _, job := range missing {
+		if r.failMissing(job) {
+			report.FailedJobs = append(report.FailedJobs, job.ID)
+		}
+	}
+
+	cutoff := time.Now().Add(-orphanGrace)
+	for _, path := range files {
+		if referred[filepath.Clean(path)] {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		name := filepath.Join(filepath.Base(filepath.Dir(path)), filepath.Base(path))
+		if err := os.RemoveAll(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to delete %s: %v", name, err))
+			continue
+		}
+		report.DeletedFiles = append(report.DeletedFiles, name)
+		report.DeletedBytes += info.Size()
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	if len(report.DeletedFiles)+len(report.FailedJobs)+len(report.MissingOutputs)+len(report.Errors) > 0 {
+		log.Printf("Reconciled TEMP_DIR: deleted %d files (%d bytes), failed %d jobs, %d completed jobs missing their output, %d errors",
+			len(report.DeletedFiles), report.DeletedBytes, len(report.FailedJobs), len(report.MissingOutputs), len(report.Errors))
+	}
+	r.last.Store(report)
+	return report, nil
+}
+
+// failMissing takes a job whose uploads are missing out of the queue and
+// fails it, reporting whether it did. A job changed in the meantime is
+// reloaded; if it is no longer waiting it is left as it is.
+func (r *reconciler) failMissing(queued *jobs.Job) bool {
+	ctx := requestid.NewContext(context.Background(), queued.RequestID)
+	file := missingFile(queued.UploadedFiles())
+	removed := false
+	for attempt := 1; ; attempt++ {
+		job, err := r.repo.GetJob(queued.ID)
+		if err != nil {
+			requestid.Logf(ctx, "Job %s: failed to fail for its missing upload: %v", queued.ID, err)
+			return false
+		}
+		if job.Status != jobs.StatusPending && job.Status != jobs.StatusRetrying {
+			return false
+		}
+		// A job already handed to a worker fails there instead
+		if !removed && !r.jobQueue.Remove(job.ID) {
+			return false
+		}
+		removed = true
+		waiting := *job
+
+		now := time.Now().UTC()
+		if err := job.Transition(jobs.StatusFailed, jobs.ActorReconcile, job.RequestID, clock.System); err != nil {
+			requestid.Logf(ctx, "Job %s: failed to fail for its missing upload: %v", job.ID, err)
+			return false
+		}
+		job.Error = fmt.Sprintf("uploaded file %s is missing from the server", filepath.Base(file))
+		job.ErrorCode = jobs.ErrorInputMissing
+		job.CompletedAt = &now
+		job.UpdatedAt = now
+		err = r.repo.UpdateJob(job)
+		if errors.Is(err, db.ErrStaleJob) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			// Back in the queue, to be tried again next time
+			requestid.Logf(ctx, "Job %s: failed to fail for its missing upload: %v", job.ID, err)
+			if err := r.jobQueue.Enqueue(&waiting); err != nil {
+				log.Printf("Failed to re-enqueue job %s: %v", job.ID, err)
+			}
+			return false
+		}
+
+		requestid.Logf(ctx, "Job %s failed: %s", job.ID, job.Error)
+		r.localStorage.CleanupJob(job.UploadedFiles())
+		r.repo.RecordJobEvent(job.ID, jobs.EventFailed, job.RequestID, job.Error)
+		var t *tenant.Tenant
+		if job.TenantID != "" {
+			t, _ = db.GetTenant(job.TenantID)
+		}
+		r.notifier.Notify(job, t, webhook.EventJobFailed, &webhook.Payload{
+			Error:       job.Error,
+			ErrorCode:   job.ErrorCode,
+			CompletedAt: now.Format(time.RFC3339),
+		})
+		emailJobResult(r.repo, r.mailer, job)
+		return true
+	}
+}
+
+// missingFile returns the first of paths that doesn't exist, or ""
+func missingFile(paths []string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/features"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/pipeline"
+	"github.com/skillcape/transcoder/internal/secrets"
+	"github.com/skillcape/transcoder/internal/transcoder"
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// reloader reads the configuration again and applies the settings that
+// are safe to change while jobs run: webhook endpoints, defaults and
+// secrets and timing, API_KEY, presets from the config file, progress
+// thresholds and retry counts. Everything else still needs a restart.
+type reloader struct {
+	mu            sync.Mutex
+	configFile    string
+	flags         *flag.FlagSet
+	notifier      *webhook.Notifier
+	webhookClient *webhook.Client
+	apiKey        *auth.BootstrapKey
+	retry         *atomic.Pointer[retryPolicy]
+	startup       *config.Config
+	current       atomic.Pointer[config.Config]
+}
+
+// reloadable are the variables apply hands on while the server runs
+var reloadable = map[string]bool{
+	"API_KEY":                       true,
+	"WEBHOOK_SECRET":                true,
+	"WEBHOOK_URL":                   true,
+	"WEBHOOK_EVENTS":                true,
+	"WEBHOOK_TIMEOUT":               true,
+	"WEBHOOK_MAX_BACKOFF":           true,
+	"WEBHOOK_BACKOFF_JITTER":        true,
+	"WEBHOOK_DEADLINE":              true,
+	"WEBHOOK_PROGRESS_STEP":         true,
+	"WEBHOOK_PROGRESS_INTERVAL":     true,
+	"WEBHOOK_PROGRESS_MIN_INTERVAL": true,
+	"JOB_MAX_ATTEMPTS":              true,
+	"JOB_RETRY_DELAY":               true,
+	"SECRETS_CACHE_TTL":             true,
+}
+
+// Current returns the configuration as last loaded
+func (r *reloader) Current() *config.Config {
+	return r.current.Load()
+}
+
+// Pending lists variables that changed since startup but can't be
+// applied without a restart
+func (r *reloader) Pending() []string {
+	var pending []string
+	for _, key := range r.startup.Changed(r.Current()) {
+		if !reloadable[key] {
+			pending = append(pending, key)
+		}
+	}
+	return pending
+}
+
+// Reload applies the current environment and config file. Nothing is
+// changed if the new configuration is invalid.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Load(r.configFile, r.flags)
+	if err != nil {
+		return err
+	}
+	endpoints, err := prepareConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if err := syncPresets(cfg.Presets); err != nil {
+		return fmt.Errorf("failed to save presets: %v", err)
+	}
+	r.apply(cfg, endpoints)
+	log.Println("Configuration reloaded")
+	return nil
+}
+
+// apply hands the reloadable settings to the parts that use them
+func (r *reloader) apply(cfg *config.Config, endpoints []webhook.Endpoint) {
+	if r.startup == nil {
+		r.startup = cfg
+	}
+	r.current.Store(cfg)
+	secrets.SetCacheTTL(config.Seconds(cfg.SecretsCacheTTL))
+	r.apiKey.Set(cfg.APIKey)
+	r.webhookClient.SetSecret(cfg.WebhookSecret)
+	r.webhookClient.SetTiming(webhookTiming(cfg))
+	r.notifier.SetDefaults(cfg.WebhookURL, cfg.WebhookEvents)
+	r.notifier.SetStaticEndpoints(endpoints)
+	r.notifier.SetProgressThresholds(cfg.ProgressStep, config.Seconds(cfg.ProgressInterval), config.Seconds(cfg.ProgressMinInterval))
+	r.retry.Store(&retryPolicy{maxAttempts: cfg.JobMaxAttempts, delay: config.Seconds(max(cfg.JobRetryDelay, 1))})
+}
+
+// refreshSecrets reloads the configuration every interval, picking up
+// secrets rotated in a secret manager once their cache entries expire
+func refreshSecrets(ctx context.Context, reload *reloader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := reload.Reload(); err != nil {
+				log.Printf("Failed to refresh secrets: %v", err)
+			}
+		}
+	}
+}
+
+// prepareConfig normalizes and validates cfg, returning the webhook
+// endpoints declared in the config file
+func prepareConfig(cfg *config.Config) ([]webhook.Endpoint, error) {
+	if err := jobs.ValidateOutputName(cfg.OutputNameTemplate); err != nil {
+		return nil, fmt.Errorf("OUTPUT_NAME_TEMPLATE: %v", err)
+	}
+	cfg.WebhookEvents = webhook.NormalizeEvents(cfg.WebhookEvents)
+	if err := webhook.ValidateEvents(cfg.WebhookEvents); err != nil {
+		return nil, fmt.Errorf("WEBHOOK_EVENTS: %v", err)
+	}
+	if err := webhook.ValidateFormat(cfg.WebhookFormat); err != nil {
+		return nil, fmt.Errorf("WEBHOOK_FORMAT: %v", err)
+	}
+	cfg.EventBusEvents = webhook.NormalizeEvents(cfg.EventBusEvents)
+	if err := webhook.ValidateEvents(cfg.EventBusEvents); err != nil {
+		return nil, fmt.Errorf("EVENT_BUS_EVENTS: %v", err)
+	}
+	if cfg.JobMaxQueueHours < 0 {
+		return nil, fmt.Errorf("JOB_MAX_QUEUE_HOURS: must not be negative")
+	}
+	// Monthly usage counts deleted jobs, so they are kept past the month
+	if cfg.PurgeAfterDays != 0 && cfg.PurgeAfterDays < minPurgeAfterDays {
+		return nil, fmt.Errorf("PURGE_AFTER_DAYS: must be 0 or at least %d", minPurgeAfterDays)
+	}
+	if cfg.ReconcileInterval < 0 {
+		return nil, fmt.Errorf("RECONCILE_INTERVAL: must not be negative")
+	}
+	if cfg.WebhookTimeout < 1 {
+		return nil, fmt.Errorf("WEBHOOK_TIMEOUT: must be at least 1")
+	}
+	if err := webhookTiming(cfg).Validate(); err != nil {
+		return nil, fmt.Errorf("webhook timing: %v", err)
+	}
+	if cfg.ProgressStep < 0 || cfg.ProgressStep > 100 {
+		return nil, fmt.Errorf("WEBHOOK_PROGRESS_STEP: must be between 0 and 100")
+	}
+	if err := ffmpegLimits(cfg).Validate(); err != nil {
+		return nil, fmt.Errorf("ffmpeg limits: %v", err)
+	}
+	if cfg.FFmpegCPUPercent < 0 || cfg.FFmpegCPUPercent > 100 {
+		return nil, fmt.Errorf("FFMPEG_CPU_PERCENT: must be between 0 and 100")
+	}
+	if cfg.PauseDiskPercent < 0 || cfg.PauseDiskPercent > 100 {
+		return nil, fmt.Errorf("PAUSE_DISK_PERCENT: must be between 0 and 100")
+	}
+	if cfg.PauseMemoryPercent < 0 || cfg.PauseMemoryPercent > 100 {
+		return nil, fmt.Errorf("PAUSE_MEMORY_PERCENT: must be between 0 and 100")
+	}
+	if (cfg.PauseDiskPercent > 0 || cfg.PauseMemoryPercent > 0) && cfg.ResourceInterval <= 0 {
+		return nil, fmt.Errorf("RESOURCE_SAMPLE_INTERVAL: must be set to pause on resource limits")
+	}
+	if (cfg.PreTranscodeHook != "" || cfg.PostTranscodeHook != "") && cfg.HookTimeout <= 0 {
+		return nil, fmt.Errorf("HOOK_TIMEOUT: must be positive")
+	}
+	for _, spec := range cfg.PipelinePlugins {
+		if _, _, _, err := pipeline.ParsePlugin(spec); err != nil {
+			return nil, fmt.Errorf("PIPELINE_PLUGINS: %v", err)
+		}
+	}
+	if len(cfg.PipelinePlugins) > 0 && cfg.PluginTimeout <= 0 {
+		return nil, fmt.Errorf("PLUGIN_TIMEOUT: must be positive")
+	}
+	if err := cfg.ImageSettings().Validate(); err != nil {
+		return nil, fmt.Errorf("image settings: %v", err)
+	}
+	if err := cfg.AcceptanceRules().Validate(); err != nil {
+		return nil, fmt.Errorf("acceptance rules: %v", err)
+	}
+	if _, err := transcoder.ParsePosterTime(cfg.PosterAt); err != nil {
+		return nil, fmt.Errorf("POSTER_AT: %v", err)
+	}
+	if cfg.SpriteInterval < 1 {
+		return nil, fmt.Errorf("SPRITE_INTERVAL: must be at least 1 second")
+	}
+	if cfg.SpriteWidth < 16 || cfg.SpriteWidth > 640 {
+		return nil, fmt.Errorf("SPRITE_WIDTH: must be between 16 and 640")
+	}
+	if cfg.HLSSegmentSeconds < 1 || cfg.HLSSegmentSeconds > 60 {
+		return nil, fmt.Errorf("HLS_SEGMENT_SECONDS: must be between 1 and 60")
+	}
+	if cfg.RenditionParallelism < 1 {
+		return nil, fmt.Errorf("RENDITION_PARALLELISM: must be at least 1")
+	}
+	if cfg.HardwareEncoder != "" {
+		if err := transcoder.ValidateHardware(cfg.HardwareEncoder); err != nil {
+			return nil, fmt.Errorf("HARDWARE_ENCODER: %v", err)
+		}
+	}
+	if cfg.OutageThreshold < 0 {
+		return nil, fmt.Errorf("DESTINATION_OUTAGE_THRESHOLD: must not be negative")
+	}
+	if cfg.OutageRetry < 1 {
+		return nil, fmt.Errorf("DESTINATION_OUTAGE_RETRY: must be at least 1 second")
+	}
+	if cfg.MinUploadSize < 0 {
+		return nil, fmt.Errorf("MIN_UPLOAD_SIZE: must not be negative")
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return nil, fmt.Errorf("SHUTDOWN_TIMEOUT: must not be negative")
+	}
+	if cfg.DrainDelay < 0 {
+		return nil, fmt.Errorf("DRAIN_DELAY: must not be negative")
+	}
+	if cfg.LeaderElection && cfg.LeaderLease < 3 {
+		return nil, fmt.Errorf("LEADER_LEASE_DURATION: must be at least 3 seconds")
+	}
+	switch cfg.Executor {
+	case "local":
+	case "kubernetes":
+		if cfg.K8sImage == "" || cfg.K8sVolumeClaim == "" {
+			return nil, fmt.Errorf("EXECUTOR: kubernetes requires K8S_IMAGE and K8S_VOLUME_CLAIM")
+		}
+		if !filepath.IsAbs(cfg.TempDir) {
+			return nil, fmt.Errorf("TEMP_DIR: must be an absolute path to run encodes in Kubernetes")
+		}
+		if cfg.K8sGPUCount < 0 || cfg.K8sJobTTL < 0 {
+			return nil, fmt.Errorf("K8S_GPU_COUNT and K8S_JOB_TTL must not be negative")
+		}
+	default:
+		return nil, fmt.Errorf("EXECUTOR: must be local or kubernetes")
+	}
+	switch cfg.TranscoderBackend {
+	case "ffmpeg":
+	case "fake":
+		if cfg.Executor != "local" {
+			return nil, fmt.Errorf("TRANSCODER_BACKEND: fake needs EXECUTOR=local")
+		}
+		if err := cfg.FakeBackend().Validate(); err != nil {
+			return nil, fmt.Errorf("fake backend: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("TRANSCODER_BACKEND: must be ffmpeg or fake")
+	}
+	if cfg.BackupDir == "" {
+		cfg.BackupDir = filepath.Join(cfg.TempDir, "backups")
+	}
+	if err := validateDestinations(cfg); err != nil {
+		return nil, err
+	}
+	enabled, err := features.Parse(cfg.Features)
+	if err != nil {
+		return nil, fmt.Errorf("FEATURES: %v", err)
+	}
+	for i := range cfg.Presets {
+		if err := cfg.Presets[i].Validate(); err != nil {
+			return nil, fmt.Errorf("preset %q: %v", cfg.Presets[i].Name, err)
+		}
+		if err := enabled.Require(cfg.Presets[i].Features()); err != nil {
+			return nil, fmt.Errorf("preset %q: %v", cfg.Presets[i].Name, err)
+		}
+	}
+	return configEndpoints(cfg.WebhookEndpoints)
+}
+
+// syncPresets creates or updates the presets defined in the config file,
+// so the file stays the source of truth for them
+func syncPresets(presets []transcoder.Preset) error {
+	for _, preset := range presets {
+		preset := preset
+		existing, err := db.GetPreset(preset.TenantID, preset.Name)
+		if err != nil {
+			if err := db.CreatePreset(&preset); err != nil {
+				return err
+			}
+			continue
+		}
+		preset.ID = existing.ID
+		preset.CreatedAt = existing.CreatedAt
+		if err := db.UpdatePreset(&preset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookTiming returns the timing of webhooks that don't set their own
+func webhookTiming(cfg *config.Config) webhook.Timing {
+	return webhook.Timing{
+		Timeout:    config.Seconds(cfg.WebhookTimeout),
+		MaxBackoff: config.Seconds(cfg.WebhookMaxBackoff),
+		Jitter:     cfg.WebhookBackoffJitter,
+		Deadline:   config.Seconds(cfg.WebhookDeadline),
+	}
+}
+
+// configEndpoints turns the webhooks in the config file into endpoints
+// for every job, identified by their position in the file
+func configEndpoints(webhooks []config.WebhookEndpoint) ([]webhook.Endpoint, error) {
+	var endpoints []webhook.Endpoint
+	for i, w := range webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhooks[%d]: url must be an absolute http(s) URL", i)
+		}
+		events := webhook.NormalizeEvents(w.Events)
+		if len(events) == 0 {
+			events = webhook.DefaultEvents
+		}
+		if err := webhook.ValidateEvents(events); err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %v", i, err)
+		}
+		if err := webhook.ValidateFormat(w.Format); err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %v", i, err)
+		}
+		endpoint := webhook.Endpoint{
+			ID:                fmt.Sprintf("config-%d", i+1),
+			URL:               w.URL,
+			Secret:            w.Secret,
+			Events:            events,
+			Format:            w.Format,
+			Description:       w.Description,
+			TimeoutSeconds:    w.TimeoutSeconds,
+			MaxBackoffSeconds: w.MaxBackoffSeconds,
+			BackoffJitter:     w.BackoffJitter,
+			DeadlineSeconds:   w.DeadlineSeconds,
+		}
+		if err := endpoint.Timing().Validate(); err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %v", i, err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// renditionPresets resolves the presets of a job's extra renditions
+func renditionPresets(job *jobs.Job) ([]*transcoder.Preset, error) {
+	presets := make([]*transcoder.Preset, 0, len(job.Renditions))
+	for _, name := range job.Renditions {
+		preset, err := resolvePreset(job.TenantID, name)
+		if err != nil {
+			return nil, fmt.Errorf("rendition %s: %w", name, err)
+		}
+		presets = append(presets, preset)
+	}
+	return presets, nil
+}
+
+// encodeRenditions encodes a job's inputs once more for each rendition
+// preset, after the main output, keeping the files as the job's variants.
+// Up to parallel encodes run at once; the first to fail stops the rest.
+// encoder sets up an encode of the inputs to output with a preset. The
+// main output had the first share of the progress.
+func encodeRenditions(ctx context.Context, job *jobs.Job, presets []*transcoder.Preset, parallel int, encoder func(preset *transcoder.Preset, output string) *transcoder.FFmpeg, onProgress func(int)) error {
+	first := len(job.Variants)
+	paths := make([]string, len(presets))
+	for i, preset := range presets {
+		job.Variants = append(job.Variants, jobs.Variant{Name: preset.Name, Ext: preset.Ext()})
+		paths[i] = job.VariantPath(preset.Name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex // guards job, progress and failed
+		wg       sync.WaitGroup
+		failed   error
+		progress = make([]int, len(presets))
+	)
+	// report records one rendition's progress and reports the job's, the
+	// main output counting as done; mu must be held
+	report := func(i, value int) {
+		progress[i] = value
+		total := 100
+		for _, p := range progress {
+			total += p
+		}
+		onProgress(total / (len(presets) + 1))
+	}
+
+	slots := make(chan struct{}, max(parallel, 1))
+	for i, preset := range presets {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			ffmpeg := encoder(preset, paths[i])
+			ffmpeg.OnProgress(func(value int) {
+				mu.Lock()
+				defer mu.Unlock()
+				report(i, value)
+			})
+			err := ffmpeg.Transcode(ctx)
+			size, _ := fileSize(paths[i])
+
+			mu.Lock()
+			defer mu.Unlock()
+			job.CPUSeconds += ffmpeg.CPUTime().Seconds()
+			if err != nil {
+				if failed == nil {
+					failed = fmt.Errorf("rendition %s: %w", preset.Name, err)
+					cancel()
+				}
+				return
+			}
+			job.Variants[first+i].Size = size
+			report(i, 100)
+		}()
+	}
+	wg.Wait()
+	if failed == nil {
+		// Interrupted before every rendition had started
+		failed = ctx.Err()
+	}
+	return failed
+}
+
+// progressShare reports the progress of the part-th of parts equal parts
+// of the work as progress of the whole
+func progressShare(onProgress func(int), part, parts int) func(int) {
+	return func(progress int) {
+		onProgress((part*100 + progress) / parts)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/metrics"
+	"github.com/skillcape/transcoder/internal/sysstat"
+)
+
+// resourcesCause keys the queue's pause for lack of resources
+const resourcesCause = "resources"
+
+// resumeMargin is how many points usage must fall below a limit before a
+// paused queue resumes, so it doesn't flap around the limit
+const resumeMargin = 5
+
+var (
+	cpuGauge        = metrics.NewGauge("transcoder_cpu_percent", "Share of CPU time spent busy.")
+	loadGauge       = metrics.NewGauge("transcoder_load_average", "System load average.", "period")
+	memoryGauge     = metrics.NewGauge("transcoder_memory_used_percent", "Share of memory in use.")
+	memoryFreeGauge = metrics.NewGauge("transcoder_memory_available_bytes", "Memory available without swapping.")
+	diskGauge       = metrics.NewGauge("transcoder_disk_used_percent", "Share of the TEMP_DIR filesystem in use.")
+	diskFreeGauge   = metrics.NewGauge("transcoder_disk_free_bytes", "Free space on the TEMP_DIR filesystem.")
+	queueGauge      = metrics.NewGauge("transcoder_queue_depth", "Jobs waiting for a worker.")
+	pausedGauge     = metrics.NewGauge("transcoder_dispatch_paused", "1 while dispatch is paused for lack of resources.")
+)
+
+// resourceLimits are the usage percentages at which dispatch pauses;
+// zero disables a limit
+type resourceLimits struct {
+	disk   int
+	memory int
+}
+
+// monitorResources samples the machine every interval until ctx is done,
+// publishing the readings as metrics and pausing the queue while usage is
+// over the limits
+func monitorResources(ctx context.Context, sampler *sysstat.Sampler, queue *jobs.Queue, limits resourceLimits, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing, paused := false, false
+	for {
+		sample, err := sampler.Sample()
+		if err != nil && !failing {
+			log.Printf("Failed to sample resources: %v", err)
+		}
+		failing = err != nil
+		recordSample(sample, queue)
+
+		reason := pressure(sample, limits, paused)
+		if paused = reason != ""; paused {
+			queue.Pause(resourcesCause, reason)
+			pausedGauge.Set(1)
+		} else {
+			queue.Resume(resourcesCause)
+			pausedGauge.Set(0)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordSample publishes a sample as metrics
+func recordSample(sample sysstat.Sample, queue *jobs.Queue) {
+	cpuGauge.Set(sample.CPUPercent)
+	loadGauge.Set(sample.Load1, "1m")
+	loadGauge.Set(sample.Load5, "5m")
+	loadGauge.Set(sample.Load15, "15m")
+	memoryGauge.Set(sample.MemoryPercent)
+	memoryFreeGauge.Set(float64(sample.MemoryAvailable))
+	diskGauge.Set(sample.DiskPercent)
+	diskFreeGauge.Set(float64(sample.DiskFree))
+	queueGauge.Set(float64(queue.Size()))
+}
+
+// pressure returns why dispatch should pause, or "" when usage is within
+// limits. While paused, usage must fall resumeMargin below a limit to
+// clear it. Readings that failed are zero and never pause.
+func pressure(sample sysstat.Sample, limits resourceLimits, paused bool) string {
+	over := func(used float64, limit int) bool {
+		if limit == 0 {
+			return false
+		}
+		if paused {
+			return used > float64(limit-resumeMargin)
+		}
+		return used >= float64(limit)
+	}
+	switch {
+	case over(sample.DiskPercent, limits.disk):
+		return fmt.Sprintf("disk %.1f%% used, limit %d%%", sample.DiskPercent, limits.disk)
+	case over(sample.MemoryPercent, limits.memory):
+		return fmt.Sprintf("memory %.1f%% used, limit %d%%", sample.MemoryPercent, limits.memory)
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// Limits on the startup checks: each check gets startupCheckTimeout, and
+// failing checks are tried again after minStartupBackoff, doubling up to
+// maxStartupBackoff
+const (
+	startupCheckTimeout = 10 * time.Second
+	minStartupBackoff   = time.Second
+	maxStartupBackoff   = 30 * time.Second
+)
+
+// dependency is something jobs can't run without
+type dependency struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// dependencies lists what must answer before the workers start: the
+// database, the default destination and, unless the fake backend is in
+// use, ffmpeg
+func dependencies(backend string, uploads *destinations) []dependency {
+	deps := []dependency{
+		{name: "database", check: db.Ping},
+		{name: "destination", check: uploads.check},
+	}
+	if backend != "fake" {
+		deps = append(deps, dependency{name: "ffmpeg", check: func(ctx context.Context) error {
+			if !transcoder.IsFFmpegAvailable() {
+				return errors.New("FFmpeg is not installed or not in PATH")
+			}
+			return nil
+		}})
+	}
+	return deps
+}
+
+// startWorkers waits until every dependency passes its check, then
+// recovers the jobs left pending or processing and starts the worker
+// pool. Until then jobs are only queued, so a destination that is down
+// for a moment at boot doesn't fail every recovered job. It returns
+// without starting anything if ctx is done first.
+func startWorkers(ctx context.Context, deps []dependency, repo db.JobRepository, jobQueue *jobs.Queue, workerPool *jobs.WorkerPool) {
+	backoff := minStartupBackoff
+	waited := false
+	for {
+		failed := false
+		for _, dep := range deps {
+			checkCtx, cancel := context.WithTimeout(ctx, startupCheckTimeout)
+			err := dep.check(checkCtx)
+			cancel()
+			if err != nil {
+				log.Printf("Startup check %s failed, trying again in %s: %v", dep.name, backoff, err)
+				failed = true
+				break
+			}
+		}
+		if !failed {
+			break
+		}
+		waited = true
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxStartupBackoff)
+	}
+	if waited {
+		log.Println("Startup checks passed")
+	}
+
+	// Jobs created while waiting are already queued and are skipped
+	recoverPendingJobs(repo, jobQueue)
+	workerPool.Start()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// listener starts the HTTP server, terminating TLS itself when a
+// certificate or autocert hosts are configured. It returns the optional
+// HTTP server that redirects to HTTPS (and answers ACME challenges) so the
+// caller can shut it down with the main server.
+func listener(cfg *config.Config, server *http.Server) (serve func() error, redirect *http.Server, err error) {
+	switch {
+	case len(cfg.TLSAutocertHosts) > 0:
+		if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+			return nil, nil, errors.New("TLS_AUTOCERT_HOSTS cannot be combined with TLS_CERT_FILE/TLS_KEY_FILE")
+		}
+
+		cacheDir := cfg.TLSAutocertCacheDir
+		if cacheDir == "" {
+			cacheDir = filepath.Join(cfg.TempDir, "autocert")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertHosts...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      cfg.TLSAutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+
+		// HTTP-01 challenges arrive on port 80, so autocert always listens there
+		redirectPort := cfg.HTTPRedirectPort
+		if redirectPort == "" {
+			redirectPort = "80"
+		}
+		redirect = redirectServer(redirectPort, manager.HTTPHandler(redirectToHTTPS(cfg.Port)))
+		log.Printf("TLS enabled with Let's Encrypt certificates for %v", cfg.TLSAutocertHosts)
+		return func() error { return server.ListenAndServeTLS("", "") }, redirect, nil
+
+	case cfg.TLSCertFile != "" || cfg.TLSKeyFile != "":
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		if cfg.HTTPRedirectPort != "" {
+			redirect = redirectServer(cfg.HTTPRedirectPort, redirectToHTTPS(cfg.Port))
+		}
+		log.Printf("TLS enabled with certificate %s", cfg.TLSCertFile)
+		return func() error { return server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile) }, redirect, nil
+	}
+
+	return server.ListenAndServe, nil, nil
+}
+
+// redirectServer serves handler on the plain HTTP port
+func redirectServer(port string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+}
+
+// redirectToHTTPS sends every request to the same URL over HTTPS
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		// 308 keeps the method and body of non-GET requests
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
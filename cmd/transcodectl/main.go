@@ -0,0 +1,481 @@
+// Command transcodectl submits and manages transcoding jobs from the
+// command line. The server and credentials come from TRANSCODER_URL and
+// TRANSCODER_API_KEY (or TRANSCODER_TOKEN for a JWT), or the -server,
+// -api-key, and -token flags. URLs passed to submit are fetched with the
+// credentials in TRANSCODER_SOURCE_TOKEN, TRANSCODER_SOURCE_USER and
+// TRANSCODER_SOURCE_PASSWORD, if set.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skillcape/transcoder/pkg/client"
+)
+
+const usage = `Usage: transcodectl [global flags] <command> [flags] [args]
+
+Commands:
+  submit <file|url>   Upload a file (or fetch a URL and upload it) and create a job
+  status <job-id>     Show a job
+  watch <job-id>      Follow a job's progress until it finishes
+  list                List jobs
+  cancel <job-id>...  Cancel pending/processing jobs or delete finished ones
+  prioritize <job-id> Move a pending job to the front of the queue
+  download <job-id>   Download a completed job's output
+  publish <job-id> <destination>
+                      Copy a completed job's output to another destination
+  play <job-id>       Print a link to a completed job's playback page
+
+Global flags:
+`
+
+func main() {
+	global := flag.NewFlagSet("transcodectl", flag.ExitOnError)
+	server := global.String("server", envOr("TRANSCODER_URL", "http://localhost:8080"), "server base URL")
+	apiKey := global.String("api-key", os.Getenv("TRANSCODER_API_KEY"), "API key")
+	token := global.String("token", os.Getenv("TRANSCODER_TOKEN"), "JWT bearer token (overrides -api-key)")
+	global.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		global.PrintDefaults()
+	}
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	var opts []client.Option
+	if *token != "" {
+		opts = append(opts, client.WithBearerToken(*token))
+	}
+	c := client.New(*server, *apiKey, append(opts, client.WithUserAgent("transcodectl"))...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	commands := map[string]func(context.Context, *client.Client, []string) error{
+		"submit":     submit,
+		"status":     status,
+		"watch":      watch,
+		"list":       list,
+		"cancel":     cancel,
+		"prioritize": prioritize,
+		"download":   download,
+		"publish":    publish,
+		"play":       play,
+	}
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "transcodectl: unknown command %q\n\n", global.Arg(0))
+		global.Usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(ctx, c, global.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "transcodectl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func submit(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	preset := fs.String("preset", "", "encoding preset")
+	renditions := fs.String("renditions", "", "comma-separated presets of extra outputs, e.g. prores-422-hq")
+	parallelism := fs.Int("rendition-parallelism", 0, "renditions to encode at once, at most the server's RENDITION_PARALLELISM (0 for that)")
+	encoding := fs.String("encoding", "", "software or hardware, to override the preset's choice of encoder")
+	packaging := fs.String("packaging", "", "hls or hls_fmp4 to deliver an HLS playlist and segments instead of a file")
+	labels := fs.String("labels", "", "comma-separated labels")
+	priority := fs.Int("priority", 0, "queue priority (-100 to 100)")
+	webhookURL := fs.String("webhook", "", "webhook URL for this job")
+	name := fs.String("name", "", "file name to upload as (defaults to the file or URL name)")
+	outputName := fs.String("output-name", "", "output file name or template, e.g. {{original_basename}}-{{preset}}.mp4")
+	metadata := fs.String("metadata", "", "JSON file of tags and chapters to write into the output")
+	cover := fs.String("cover", "", "PNG or JPEG cover art for audio presets")
+	captions := fs.String("captions", "", "WebVTT captions for the playback page")
+	attach := fs.Bool("attach", false, "join a pending or processing job of the same file and settings instead of creating one")
+	wait := fs.Bool("watch", false, "follow progress until the job finishes")
+	var sourceHeaders headerFlags
+	fs.Var(&sourceHeaders, "source-header", "`header` sent when fetching a URL, e.g. \"X-Origin-Token: ...\" (repeatable)")
+	sourceUser := fs.String("source-user", os.Getenv("TRANSCODER_SOURCE_USER"), "basic auth user for fetching a URL; the password comes from TRANSCODER_SOURCE_PASSWORD")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("submit takes exactly one file or URL")
+	}
+	source := fs.Arg(0)
+
+	input, size, sourceName, err := openSource(ctx, source, newSourceAuth(sourceHeaders, *sourceUser))
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if *name == "" {
+		*name = sourceName
+	}
+
+	opts := &client.JobOptions{
+		Preset:      *preset,
+		Parallelism: *parallelism,
+		Encoding:    *encoding,
+		Packaging:   *packaging,
+		Priority:    *priority,
+		WebhookURL:  *webhookURL,
+		OutputName:  *outputName,
+		Cover:       *cover,
+		Captions:    *captions,
+		Attach:      *attach,
+	}
+	if *labels != "" {
+		opts.Labels = strings.Split(*labels, ",")
+	}
+	if *renditions != "" {
+		opts.Renditions = strings.Split(*renditions, ",")
+	}
+	if *metadata != "" {
+		data, err := os.ReadFile(*metadata)
+		if err != nil {
+			return err
+		}
+		opts.Metadata = &client.Metadata{}
+		if err := json.Unmarshal(data, opts.Metadata); err != nil {
+			return fmt.Errorf("%s: %v", *metadata, err)
+		}
+	}
+
+	bar := newProgressBar(os.Stderr, "uploading")
+	job, err := c.CreateJob(ctx, *name, newCountingReader(input, size, bar), opts)
+	bar.finish()
+	if err != nil {
+		return err
+	}
+	if job.DuplicateOf != "" {
+		fmt.Fprintf(os.Stderr, "warning: the same file was already uploaded as job %s\n", job.DuplicateOf)
+	}
+	fmt.Println(job.ID)
+
+	if *wait {
+		return follow(ctx, c, job.ID)
+	}
+	return nil
+}
+
+// openSource opens a local file, or starts fetching a URL with auth so it
+// can be streamed straight into the upload. A fetch that breaks off is
+// resumed where it stopped; see resumingBody. size is -1 when unknown.
+func openSource(ctx context.Context, source string, auth *sourceAuth) (io.ReadCloser, int64, string, error) {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		// Credentials in the URL itself are sent as basic auth unless
+		// -source-user overrides them
+		redacted := u.Redacted()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("fetching %s: invalid request", redacted)
+		}
+		auth.apply(req)
+		resp, err := auth.client().Do(req)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("fetching %s: %v", redacted, unwrapURLError(err))
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, 0, "", fmt.Errorf("fetching %s: %s", redacted, resp.Status)
+		}
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			name = "input"
+		}
+		return newResumingBody(ctx, req, resp, auth, redacted), resp.ContentLength, name, nil
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, "", err
+	}
+	return f, info.Size(), filepath.Base(source), nil
+}
+
+func status(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("status takes exactly one job ID")
+	}
+	job, err := c.GetJob(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", job.ID)
+	fmt.Fprintf(w, "Status:\t%s\n", job.Status)
+	fmt.Fprintf(w, "Progress:\t%d%%\n", job.Progress)
+	if job.Status == "processing" && job.Stage != "" {
+		fmt.Fprintf(w, "Stage:\t%s\n", job.Stage)
+	}
+	fmt.Fprintf(w, "File:\t%s\n", job.OriginalName)
+	fmt.Fprintf(w, "Output:\t%s\n", job.OutputName)
+	if job.DuplicateOf != "" {
+		fmt.Fprintf(w, "Duplicate of:\t%s\n", job.DuplicateOf)
+	}
+	if job.Preset != "" {
+		fmt.Fprintf(w, "Preset:\t%s\n", job.Preset)
+	}
+	if len(job.Renditions) > 0 {
+		fmt.Fprintf(w, "Renditions:\t%s\n", strings.Join(job.Renditions, ", "))
+	}
+	if job.VideoEncoder != "" {
+		fmt.Fprintf(w, "Encoder:\t%s\n", job.VideoEncoder)
+	}
+	if len(job.Labels) > 0 {
+		fmt.Fprintf(w, "Labels:\t%s\n", strings.Join(job.Labels, ", "))
+	}
+	fmt.Fprintf(w, "Created:\t%s\n", job.CreatedAt.Local().Format(time.RFC3339))
+	if job.CompletedAt != nil {
+		fmt.Fprintf(w, "Completed:\t%s\n", job.CompletedAt.Local().Format(time.RFC3339))
+	}
+	if job.DriveURL != "" {
+		fmt.Fprintf(w, "Drive URL:\t%s\n", job.DriveURL)
+	}
+	if job.HLS != nil {
+		fmt.Fprintf(w, "HLS:\t%d %s segments\n", job.HLS.Segments, job.HLS.SegmentType)
+		for _, stream := range job.HLS.Streams {
+			fmt.Fprintf(w, "HLS stream:\t%s %dx%d, %d kb/s, %d segments\n", stream.Name, stream.Width, stream.Height, stream.Bandwidth/1000, stream.Segments)
+		}
+	}
+	if job.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", job.Error)
+	}
+	if job.ErrorCode != "" {
+		fmt.Fprintf(w, "Error code:\t%s\n", job.ErrorCode)
+	}
+	return w.Flush()
+}
+
+func watch(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("watch takes exactly one job ID")
+	}
+	return follow(ctx, c, args[0])
+}
+
+// follow shows a job's progress until it finishes, failing if it did not
+// complete
+func follow(ctx context.Context, c *client.Client, id string) error {
+	bar := newProgressBar(os.Stderr, "transcoding")
+	job, err := c.WaitForJob(ctx, id, 2*time.Second, func(job *client.Job) error {
+		bar.label = job.Status
+		if job.Status == "processing" && job.Stage != "" {
+			bar.label = job.Stage
+		}
+		bar.set(int64(job.Progress), 100)
+		return nil
+	})
+	bar.finish()
+	if err != nil {
+		return err
+	}
+
+	if job.DriveURL != "" {
+		fmt.Println(job.DriveURL)
+	}
+	return nil
+}
+
+func list(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	statuses := fs.String("status", "", "comma-separated statuses to include")
+	label := fs.String("label", "", "only jobs with this label")
+	preset := fs.String("preset", "", "only jobs with this preset")
+	limit := fs.Int("limit", 20, "maximum jobs to show (up to 100)")
+	offset := fs.Int("offset", 0, "jobs to skip")
+	fs.Parse(args)
+
+	opts := &client.ListOptions{
+		Label:  *label,
+		Preset: *preset,
+		Limit:  *limit,
+		Offset: *offset,
+	}
+	if *statuses != "" {
+		opts.Status = strings.Split(*statuses, ",")
+	}
+
+	page, err := c.ListJobs(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tPROGRESS\tFILE\tCREATED")
+	for _, job := range page.Jobs {
+		fmt.Fprintf(w, "%s\t%s\t%d%%\t%s\t%s\n", job.ID, job.Status, job.Progress, job.OriginalName, job.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d jobs\n", len(page.Jobs), page.Total)
+	return nil
+}
+
+func cancel(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("cancel takes one or more job IDs")
+	}
+
+	var failed int
+	for _, id := range args {
+		if err := c.CancelJob(ctx, id); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Println(id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs could not be cancelled", failed, len(args))
+	}
+	return nil
+}
+
+func prioritize(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("prioritize takes exactly one job ID")
+	}
+	job, err := c.PrioritizeJob(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(job.ID)
+	return nil
+}
+
+func download(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	output := fs.String("o", "", "output path (defaults to the output file name, - for stdout)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("download takes exactly one job ID")
+	}
+
+	job, err := c.GetJob(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if job.Status != client.StatusCompleted {
+		return fmt.Errorf("job %s is %s", job.ID, job.Status)
+	}
+	if job.DriveURL != "" {
+		return fmt.Errorf("output was uploaded to Google Drive: %s", job.DriveURL)
+	}
+
+	if *output == "-" {
+		_, err := c.Download(ctx, job.ID, os.Stdout)
+		return err
+	}
+	if *output == "" {
+		*output = job.OutputName
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Download(ctx, job.ID, f); err != nil {
+		f.Close()
+		os.Remove(*output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println(*output)
+	return nil
+}
+
+func publish(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "wait for the copy to finish")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("publish takes a job ID and a destination")
+	}
+
+	publication, err := c.PublishJob(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	for *wait && (publication.Status == "pending" || publication.Status == "uploading") {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+		publications, err := c.ListPublications(ctx, publication.JobID)
+		if err != nil {
+			return err
+		}
+		for i := range publications {
+			if publications[i].ID == publication.ID {
+				publication = &publications[i]
+			}
+		}
+	}
+
+	switch publication.Status {
+	case "failed":
+		return fmt.Errorf("publishing to %s failed: %s", publication.Destination, publication.Error)
+	case "completed":
+		if publication.URL != "" {
+			fmt.Println(publication.URL)
+		} else {
+			fmt.Println(publication.FileID)
+		}
+	default:
+		fmt.Printf("Publishing %s to %s (publication %d)\n", publication.JobID, publication.Destination, publication.ID)
+	}
+	return nil
+}
+
+func play(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	expires := fs.Duration("expires", 0, "how long the link stays valid (defaults to the server's)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("play takes exactly one job ID")
+	}
+
+	link, err := c.CreatePlaybackLink(ctx, fs.Arg(0), *expires)
+	if err != nil {
+		return err
+	}
+	fmt.Println(link.URL)
+	return nil
+}
+
+func envOr(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const barWidth = 30
+
+// progressBar draws a single-line progress bar. On a terminal it is
+// redrawn in place; otherwise a line is printed every 10%. A bar counting
+// bytes also shows the average speed since it started.
+type progressBar struct {
+	w        io.Writer
+	label    string
+	tty      bool
+	bytes    bool
+	started  time.Time
+	drawn    bool
+	lastDraw time.Time
+	lastStep int64
+}
+
+func newProgressBar(w io.Writer, label string) *progressBar {
+	tty := false
+	if f, ok := w.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			tty = info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return &progressBar{w: w, label: label, tty: tty, started: time.Now(), lastStep: -1}
+}
+
+// set draws done out of total; a non-positive total shows a byte count only
+func (b *progressBar) set(done, total int64) {
+	if total > 0 && done > total {
+		done = total
+	}
+
+	if !b.tty {
+		if total <= 0 {
+			return
+		}
+		step := done * 10 / total
+		if step == b.lastStep {
+			return
+		}
+		b.lastStep = step
+		fmt.Fprintf(b.w, "%s %d%%%s\n", b.label, done*100/total, b.speed(done))
+		return
+	}
+
+	// Throttle redraws; byte counts change on every read
+	if time.Since(b.lastDraw) < 100*time.Millisecond && done != total {
+		return
+	}
+	b.lastDraw = time.Now()
+	b.drawn = true
+
+	if total <= 0 {
+		fmt.Fprintf(b.w, "\r%-12s %-12s%-14s", b.label, formatBytes(done), b.speed(done))
+		return
+	}
+	filled := int(done * barWidth / total)
+	fmt.Fprintf(b.w, "\r%-12s [%s%s] %3d%%%-14s", b.label,
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), done*100/total, b.speed(done))
+}
+
+// speed returns the average rate of a byte count since the bar started,
+// padded to follow the bar, or nothing for bars of other units
+func (b *progressBar) speed(done int64) string {
+	elapsed := time.Since(b.started).Seconds()
+	if !b.bytes || elapsed < 0.1 {
+		return ""
+	}
+	return "  " + formatBytes(int64(float64(done)/elapsed)) + "/s"
+}
+
+// finish ends the bar's line
+func (b *progressBar) finish() {
+	if b.drawn {
+		fmt.Fprintln(b.w)
+	}
+}
+
+// countingReader reports read progress to a progress bar
+type countingReader struct {
+	r     io.Reader
+	total int64
+	read  int64
+	bar   *progressBar
+}
+
+func newCountingReader(r io.Reader, total int64, bar *progressBar) *countingReader {
+	bar.bytes = true
+	return &countingReader{r: r, total: total, bar: bar}
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	r.bar.set(r.read, r.total)
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
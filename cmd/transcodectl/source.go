@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// sourceAuth holds the credentials sent when fetching a source URL from a
+// protected origin. They go only to the source's own host, and are never
+// printed: errors name the URL without its user info.
+type sourceAuth struct {
+	headers  http.Header
+	user     string
+	password string
+}
+
+// headerFlags collects repeated -source-header flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("want \"Name: value\"")
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// newSourceAuth builds the fetch credentials from the -source-header and
+// -source-user flags, TRANSCODER_SOURCE_TOKEN, sent as a bearer token, and
+// TRANSCODER_SOURCE_PASSWORD. Secrets are read from the environment so
+// they stay out of shell history and process listings.
+func newSourceAuth(headers headerFlags, user string) *sourceAuth {
+	auth := &sourceAuth{headers: http.Header{}, user: user, password: os.Getenv("TRANSCODER_SOURCE_PASSWORD")}
+	if token := os.Getenv("TRANSCODER_SOURCE_TOKEN"); token != "" {
+		auth.headers.Set("Authorization", "Bearer "+token)
+	}
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		auth.headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return auth
+}
+
+// apply adds the credentials to a request for the source
+func (a *sourceAuth) apply(req *http.Request) {
+	for name, values := range a.headers {
+		req.Header[name] = values
+	}
+	if a.user != "" {
+		req.SetBasicAuth(a.user, a.password)
+	}
+}
+
+// client returns an HTTP client for fetching the source. Go drops the
+// Authorization header on redirects to another host, but not custom
+// headers such as API tokens, so those are dropped here too.
+func (a *sourceAuth) client() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if req.URL.Host != via[0].URL.Host {
+				for name := range a.headers {
+					req.Header.Del(name)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// unwrapURLError drops the URL from an error of the HTTP client, as the
+// caller names the redacted one already
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// maxResumes is how many times a fetch that breaks off is picked up again
+const maxResumes = 5
+
+// resumingBody reads a fetched source, asking for the rest with a Range
+// request when the connection drops partway. If-Range, with the first
+// response's ETag or else its Last-Modified, makes sure the rest comes
+// from the same version of the file; without either the fetch fails
+// instead. Content-Length and the ETag of each part are checked, so a
+// truncated or changed source is never uploaded as if whole.
+type resumingBody struct {
+	ctx       context.Context
+	req       *http.Request
+	auth      *sourceAuth
+	redacted  string
+	body      io.ReadCloser
+	size      int64  // -1 if unknown
+	etag      string // of the first response, if any
+	validator string // sent as If-Range; empty if the source can't be resumed
+	read      int64
+	resumes   int
+}
+
+func newResumingBody(ctx context.Context, req *http.Request, resp *http.Response, auth *sourceAuth, redacted string) *resumingBody {
+	b := &resumingBody{ctx: ctx, req: req, auth: auth, redacted: redacted, body: resp.Body, size: resp.ContentLength, etag: resp.Header.Get("ETag")}
+	if resp.Header.Get("Accept-Ranges") == "bytes" {
+		// Weak ETags can't be used in If-Range
+		if b.etag != "" && !strings.HasPrefix(b.etag, "W/") {
+			b.validator = b.etag
+		} else {
+			b.validator = resp.Header.Get("Last-Modified")
+		}
+	}
+	return b
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if err == io.EOF && b.size >= 0 && b.read < b.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if err := b.resume(err); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// resume replaces the broken response body with one for the rest of the
+// source, waiting a little longer before each try
+func (b *resumingBody) resume(cause error) error {
+	b.body.Close()
+	if b.ctx.Err() != nil {
+		return b.ctx.Err()
+	}
+	if b.validator == "" {
+		return fmt.Errorf("fetching %s: %v after %s, and the source can't be resumed", b.redacted, unwrapURLError(cause), formatBytes(b.read))
+	}
+	for b.resumes < maxResumes {
+		b.resumes++
+		select {
+		case <-b.ctx.Done():
+			return b.ctx.Err()
+		case <-time.After(time.Duration(b.resumes) * time.Second):
+		}
+
+		req := b.req.Clone(b.ctx)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.read))
+		req.Header.Set("If-Range", b.validator)
+		resp, err := b.auth.client().Do(req)
+		if err != nil {
+			cause = err
+			continue
+		}
+		if err := b.checkPart(resp); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		b.body = resp.Body
+		return nil
+	}
+	return fmt.Errorf("fetching %s: %v after %s and %d retries", b.redacted, unwrapURLError(cause), formatBytes(b.read), maxResumes)
+}
+
+// checkPart makes sure a response to a Range request is the rest of the
+// same file
+func (b *resumingBody) checkPart(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return fmt.Errorf("fetching %s: the source changed after %s", b.redacted, formatBytes(b.read))
+	case resp.StatusCode != http.StatusPartialContent:
+		return fmt.Errorf("fetching %s: resuming after %s: %s", b.redacted, formatBytes(b.read), resp.Status)
+	case b.etag != "" && resp.Header.Get("ETag") != b.etag:
+		return fmt.Errorf("fetching %s: the source changed after %s", b.redacted, formatBytes(b.read))
+	}
+	start, total := fmt.Sprintf("bytes %d-", b.read), ""
+	if b.size >= 0 {
+		total = fmt.Sprintf("/%d", b.size)
+	}
+	if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, start) || !strings.HasSuffix(contentRange, total) {
+		return fmt.Errorf("fetching %s: resuming after %s: unexpected Content-Range %q", b.redacted, formatBytes(b.read), contentRange)
+	}
+	return nil
+}
+
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
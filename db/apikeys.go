@@ -0,0 +1,69 @@
+package db
+
+import (
+	"time"
+
+	"github.com/skillcape/transcoder/internal/auth"
+)
+
+// CreateAPIKey stores a new API key
+func CreateAPIKey(key *auth.APIKey) error {
+	return DB.Create(key).Error
+}
+
+// GetActiveAPIKeyByHash finds a non-revoked key by the hash of its secret
+func GetActiveAPIKeyByHash(hash string) (*auth.APIKey, error) {
+	var key auth.APIKey
+	if err := DB.First(&key, "key_hash = ? AND revoked_at IS NULL", hash).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// HasAPIKeys reports whether any API key was ever issued, revoked or not
+func HasAPIKeys() (bool, error) {
+	var count int64
+	if err := DB.Model(&auth.APIKey{}).Limit(1).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetAPIKey retrieves a key by ID
+func GetAPIKey(id string) (*auth.APIKey, error) {
+	var key auth.APIKey
+	if err := DB.First(&key, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns keys newest first, limited to one tenant if tenantID is set
+func ListAPIKeys(tenantID *string) ([]auth.APIKey, error) {
+	var keys []auth.APIKey
+	query := DB.Order("created_at DESC")
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	}
+	err := query.Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey marks a key as revoked so it can no longer authenticate
+func RevokeAPIKey(id string) error {
+	return DB.Model(&auth.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now().UTC()).Error
+}
+
+// UpdateAPIKeyQuota replaces a key's quota limits
+func UpdateAPIKeyQuota(id string, quota auth.Quota) error {
+	return DB.Model(&auth.APIKey{}).Where("id = ?", id).
+		Select("max_jobs_per_day", "max_minutes_per_month", "max_storage_bytes").
+		Updates(&auth.APIKey{Quota: quota}).Error
+}
+
+// TouchAPIKey records when a key was last used
+func TouchAPIKey(id string) error {
+	return DB.Model(&auth.APIKey{}).Where("id = ?", id).
+		Update("last_used_at", time.Now().UTC()).Error
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupPrefix starts the name of every backup file, so other files in the
+// backup directory are left alone
+const backupPrefix = "transcoder-"
+
+// backupMu keeps scheduled and requested backups from running at once
+var backupMu sync.Mutex
+
+// BackupFile describes one backup in the backup directory
+type BackupFile struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Backup writes a consistent snapshot of the database to dir. SQLite is
+// copied with VACUUM INTO while the server keeps running; Postgres is
+// dumped with pg_dump in its custom format, for pg_restore. The file only
+// appears under its final name once complete.
+func Backup(ctx context.Context, dir string, now time.Time) (*BackupFile, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	ext := ".db"
+	if databaseURL != "" {
+		ext = ".dump"
+	}
+	name := backupPrefix + now.UTC().Format("20060102-150405") + ext
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+
+	var err error
+	if databaseURL != "" {
+		err = pgDump(ctx, tmp)
+	} else {
+		err = DB.WithContext(ctx).Exec("VACUUM INTO ?", tmp).Error
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupFile{Name: name, Size: info.Size(), CreatedAt: info.ModTime().UTC()}, nil
+}
+
+// pgDump runs pg_dump against the configured Postgres database. The
+// password is passed in the environment rather than on the command line.
+func pgDump(ctx context.Context, path string) error {
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid DATABASE_URL")
+	}
+	env := os.Environ()
+	if password, ok := u.User.Password(); ok {
+		env = append(env, "PGPASSWORD="+password)
+		u.User = url.User(u.User.Username())
+	}
+
+	cmd := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--file="+path, "--dbname="+u.String())
+	cmd.Env = env
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pg_dump failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ListBackups returns the backups in dir, newest first
+func ListBackups(dir string) ([]BackupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []BackupFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []BackupFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupFile{Name: name, Size: info.Size(), CreatedAt: info.ModTime().UTC()})
+	}
+	// Names embed the UTC time they were taken, so they sort by age
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// PruneBackups removes all but the newest keep backups in dir; keep of 0
+// keeps them all
+func PruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(dir, backups[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// Throughput sums the jobs of one preset completed over a period, for
+// estimating how fast the workers get through a backlog
+type Throughput struct {
+	Preset        string
+	Jobs          int64
+	InputSeconds  float64 // input duration
+	EncodeSeconds float64 // time spent transcoding it
+}
+
+// ListThroughput returns the throughput of each preset over jobs completed
+// since since. Jobs completed before encode times were recorded are left
+// out.
+func (r *GormJobRepository) ListThroughput(since time.Time) ([]Throughput, error) {
+	var rows []Throughput
+	err := r.db.Unscoped().Model(&jobs.Job{}).
+		Select("preset, COUNT(*) AS jobs, COALESCE(SUM(duration), 0) AS input_seconds, COALESCE(SUM(encode_time), 0) AS encode_seconds").
+		Where("status = ? AND completed_at >= ? AND encode_time > 0 AND duration > 0", jobs.StatusCompleted, since).
+		Group("preset").
+		Order("preset").
+		Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// RecordJobEvent appends an event to a job's history. Failures are logged
+// rather than returned since events never decide the outcome of an action.
+func (r *GormJobRepository) RecordJobEvent(jobID, eventType, requestID, message string) {
+	event := &jobs.JobEvent{
+		JobID:     jobID,
+		Type:      eventType,
+		Message:   message,
+		RequestID: requestID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := r.db.Create(event).Error; err != nil {
+		log.Printf("Failed to record %s event for job %s: %v", eventType, jobID, err)
+	}
+}
+
+// ListJobEvents returns a job's events, oldest first
+func (r *GormJobRepository) ListJobEvents(jobID string) ([]jobs.JobEvent, error) {
+	var events []jobs.JobEvent
+	err := r.db.Where("job_id = ?", jobID).Order("id ASC").Find(&events).Error
+	return events, err
+}
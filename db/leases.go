@@ -0,0 +1,44 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Lease marks which replica runs a task that must only run once across
+// replicas sharing the database, such as the stats refresh. A holder keeps
+// its lease by renewing it before ExpiresAt; once expired, any replica may
+// take it.
+type Lease struct {
+	Name      string    `gorm:"primaryKey"`
+	Holder    string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// AcquireLease takes or renews the named lease for holder until now+ttl,
+// reporting whether holder has it
+func AcquireLease(name, holder string, ttl time.Duration, now time.Time) (bool, error) {
+	now = now.UTC()
+	err := DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}).Error
+	if err != nil {
+		return false, err
+	}
+
+	result := DB.Model(&Lease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", name, holder, now).
+		Updates(map[string]interface{}{"holder": holder, "expires_at": now.Add(ttl)})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleaseLease gives up the named lease if holder has it, so another
+// replica can take over without waiting for it to expire
+func ReleaseLease(name, holder string) error {
+	return DB.Model(&Lease{}).
+		Where("name = ? AND holder = ?", name, holder).
+		Update("expires_at", time.Time{}).Error
+}
@@ -0,0 +1,475 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/clock"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"gorm.io/gorm"
+)
+
+// MemoryJobRepository is a JobRepository that keeps jobs in memory, for
+// tests of code that would otherwise need a database. Jobs are copied in
+// and out, so callers can't change stored jobs without saving them.
+type MemoryJobRepository struct {
+	mu      sync.Mutex
+	jobs    map[string]jobs.Job
+	deleted map[string]jobs.Job // soft-deleted, until purged
+	events  []jobs.JobEvent
+}
+
+// NewMemoryJobRepository creates an empty in-memory repository
+func NewMemoryJobRepository() *MemoryJobRepository {
+	return &MemoryJobRepository{jobs: make(map[string]jobs.Job), deleted: make(map[string]jobs.Job)}
+}
+
+func (m *MemoryJobRepository) CreateJob(job *jobs.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.ID]; ok {
+		return gorm.ErrDuplicatedKey
+	}
+	now := time.Now().UTC()
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = now
+	}
+	if job.UpdatedAt.IsZero() {
+		job.UpdatedAt = now
+	}
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+func (m *MemoryJobRepository) GetJob(id string) (*jobs.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &job, nil
+}
+
+func (m *MemoryJobRepository) UpdateJob(job *jobs.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.jobs[job.ID]; !ok || current.Version != job.Version {
+		return ErrStaleJob
+	}
+	job.Version++
+	m.recordStatusChanges(job)
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+// recordStatusChanges appends a saved job's status changes to the events
+func (m *MemoryJobRepository) recordStatusChanges(job *jobs.Job) {
+	for _, change := range job.StatusChanges() {
+		event := change.Event(job.ID)
+		event.ID = uint(len(m.events) + 1)
+		m.events = append(m.events, event)
+	}
+	job.ClearStatusChanges()
+}
+
+func (m *MemoryJobRepository) UpdatePendingJob(job *jobs.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.jobs[job.ID]
+	if !ok || current.Status != jobs.StatusPending || current.Version != job.Version {
+		return gorm.ErrRecordNotFound
+	}
+	job.Version++
+	current.Version = job.Version
+	current.Priority = job.Priority
+	current.WebhookURL = job.WebhookURL
+	current.HookEvents = job.HookEvents
+	current.NotifyEmails = job.NotifyEmails
+	current.NameTemplate = job.NameTemplate
+	current.Preset = job.Preset
+	current.Destination = job.Destination
+	current.Labels = job.Labels
+	current.ScheduledAt = job.ScheduledAt
+	current.Metadata = job.Metadata
+	current.Renditions = job.Renditions
+	current.Parallelism = job.Parallelism
+	current.Encoding = job.Encoding
+	current.Packaging = job.Packaging
+	current.BoostedAt = job.BoostedAt
+	current.UpdatedAt = job.UpdatedAt
+	m.jobs[job.ID] = current
+	return nil
+}
+
+func (m *MemoryJobRepository) UpdateJobProgress(id string, progress int, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.jobs[id]; ok && current.Status == jobs.StatusProcessing {
+		current.Progress = progress
+		current.UpdatedAt = updatedAt
+		m.jobs[id] = current
+	}
+	return nil
+}
+
+func (m *MemoryJobRepository) ListJobs(filter JobFilter, limit, offset int) ([]jobs.Job, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	matched := m.find(&filter, filter)
+	total := int64(len(matched))
+	if offset >= len(matched) {
+		return []jobs.Job{}, total, nil
+	}
+	matched = matched[offset:]
+	if limit >= 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
+	return matched, total, nil
+}
+
+func (m *MemoryJobRepository) BulkUpdateJobs(action BulkAction, ids []string, filter *JobFilter, limit int, actor, requestID string, allowed func(*jobs.Job) bool, clk clock.Clock) ([]BulkResult, []jobs.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	selected := m.find(filter, JobFilter{})
+	if len(ids) > 0 {
+		wanted := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			wanted[id] = true
+		}
+		var kept []jobs.Job
+		for _, job := range selected {
+			if wanted[job.ID] {
+				kept = append(kept, job)
+			}
+		}
+		selected = kept
+	}
+	if limit >= 0 && limit < len(selected) {
+		selected = selected[:limit]
+	}
+
+	var results []BulkResult
+	var changed []jobs.Job
+	found := make(map[string]bool, len(selected))
+	now := clk.Now().UTC()
+	for i := range selected {
+		job := &selected[i]
+		found[job.ID] = true
+
+		if !allowed(job) {
+			results = append(results, BulkResult{ID: job.ID, Result: "forbidden"})
+			continue
+		}
+
+		active := job.Status.Active()
+		if action == BulkCancel && !active {
+			results = append(results, BulkResult{ID: job.ID, Result: "skipped", Reason: "job is " + string(job.Status)})
+			continue
+		}
+
+		if active {
+			if err := job.Transition(jobs.StatusCancelled, actor, requestID, clk); err != nil {
+				return nil, nil, err
+			}
+			job.UpdatedAt = now
+			job.Version++
+			m.recordStatusChanges(job)
+			m.jobs[job.ID] = *job
+		}
+
+		result := "cancelled"
+		if action == BulkDelete {
+			delete(m.jobs, job.ID)
+			result = "deleted"
+		}
+
+		results = append(results, BulkResult{ID: job.ID, Result: result})
+		changed = append(changed, *job)
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			results = append(results, BulkResult{ID: id, Result: "not_found"})
+		}
+	}
+	return results, changed, nil
+}
+
+func (m *MemoryJobRepository) DeleteJob(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		job.DeletedAt = gorm.DeletedAt{Time: time.Now().UTC(), Valid: true}
+		m.deleted[id] = job
+		delete(m.jobs, id)
+	}
+	return nil
+}
+
+func (m *MemoryJobRepository) GetPendingJobs() ([]jobs.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(&JobFilter{Statuses: []jobs.JobStatus{jobs.StatusPending, jobs.StatusProcessing, jobs.StatusRetrying}}, JobFilter{}), nil
+}
+
+func (m *MemoryJobRepository) RecordJobEvent(jobID, eventType, requestID, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, jobs.JobEvent{
+		ID:        uint(len(m.events) + 1),
+		JobID:     jobID,
+		Type:      eventType,
+		Message:   message,
+		RequestID: requestID,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+func (m *MemoryJobRepository) ListJobEvents(jobID string) ([]jobs.JobEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []jobs.JobEvent
+	for _, event := range m.events {
+		if event.JobID == jobID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *MemoryJobRepository) ListPurgeableJobs(before time.Time, offset, limit int) ([]jobs.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matched []jobs.Job
+	for _, job := range m.deleted {
+		if job.DeletedAt.Time.Before(before) {
+			matched = append(matched, job)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := &matched[i], &matched[j]
+		if !a.DeletedAt.Time.Equal(b.DeletedAt.Time) {
+			return a.DeletedAt.Time.Before(b.DeletedAt.Time)
+		}
+		return a.ID < b.ID
+	})
+	if offset >= len(matched) {
+		return nil, nil
+	}
+	return matched[offset:min(offset+limit, len(matched))], nil
+}
+
+// FileInUse looks only at jobs, as publications aren't kept in memory
+func (m *MemoryJobRepository) FileInUse(fileID, jobID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.ID == jobID {
+			continue
+		}
+		if job.DriveFileID == fileID || job.PosterFileID == fileID {
+			return true, nil
+		}
+		for _, variant := range job.Variants {
+			if variant.FileID == fileID {
+				return true, nil
+			}
+		}
+		for _, caption := range job.ClosedCaps {
+			if caption.FileID == fileID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryJobRepository) PurgeJob(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.deleted[id]; !ok {
+		return nil
+	}
+	delete(m.deleted, id)
+	events := m.events[:0]
+	for _, event := range m.events {
+		if event.JobID != id {
+			events = append(events, event)
+		}
+	}
+	m.events = events
+	return nil
+}
+
+// EachJobFiles calls fn with a copy of every job that isn't deleted
+func (m *MemoryJobRepository) EachJobFiles(fn func(job *jobs.Job)) error {
+	m.mu.Lock()
+	all := m.find(nil, JobFilter{})
+	m.mu.Unlock()
+	for i := range all {
+		fn(&all[i])
+	}
+	return nil
+}
+
+// EachJobCost calls fn for every job, deleted or not, that finished on
+// days from through to, optionally limited to one tenant
+func (m *MemoryJobRepository) EachJobCost(from, to string, tenantID *string, fn func(cost JobCost)) error {
+	start, end, err := costDays(from, to)
+	if err != nil {
+		return err
+	}
+	var costs []JobCost
+	m.mu.Lock()
+	for _, all := range []map[string]jobs.Job{m.jobs, m.deleted} {
+		for _, job := range all {
+			if job.CompletedAt == nil || job.CompletedAt.Before(start) || !job.CompletedAt.Before(end) {
+				continue
+			}
+			if tenantID != nil && job.TenantID != *tenantID {
+				continue
+			}
+			costs = append(costs, jobCost(&job))
+		}
+	}
+	m.mu.Unlock()
+	for _, cost := range costs {
+		fn(cost)
+	}
+	return nil
+}
+
+// ListThroughput returns the throughput of each preset over jobs, deleted
+// or not, completed since since, ordered by preset
+func (m *MemoryJobRepository) ListThroughput(since time.Time) ([]Throughput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byPreset := make(map[string]*Throughput)
+	for _, all := range []map[string]jobs.Job{m.jobs, m.deleted} {
+		for _, job := range all {
+			if job.Status != jobs.StatusCompleted || job.CompletedAt == nil || job.CompletedAt.Before(since) ||
+				job.EncodeTime <= 0 || job.Duration <= 0 {
+				continue
+			}
+			row := byPreset[job.Preset]
+			if row == nil {
+				row = &Throughput{Preset: job.Preset}
+				byPreset[job.Preset] = row
+			}
+			row.Jobs++
+			row.InputSeconds += job.Duration
+			row.EncodeSeconds += job.EncodeTime
+		}
+	}
+	rows := make([]Throughput, 0, len(byPreset))
+	for _, row := range byPreset {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Preset < rows[j].Preset })
+	return rows, nil
+}
+
+// find returns copies of the jobs matching filter (all jobs if nil),
+// sorted by order's sort settings
+func (m *MemoryJobRepository) find(filter *JobFilter, order JobFilter) []jobs.Job {
+	var matched []jobs.Job
+	for _, job := range m.jobs {
+		if filter == nil || filter.matches(&job) {
+			matched = append(matched, job)
+		}
+	}
+
+	column := "created_at"
+	if SortableJobColumns[order.SortBy] {
+		column = order.SortBy
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := &matched[i], &matched[j]
+		cmp := compareJobs(a, b, column)
+		if cmp == 0 {
+			cmp = strings.Compare(a.ID, b.ID)
+		}
+		if order.SortDesc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	return matched
+}
+
+// matches reports whether a job satisfies the filter, as apply does in SQL
+func (f JobFilter) matches(job *jobs.Job) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			found = found || job.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.CreatedAfter != nil && job.CreatedAt.Before(*f.CreatedAfter) {
+		return false
+	}
+	if f.CreatedBefore != nil && !job.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	if f.NameContains != "" && !strings.Contains(strings.ToLower(job.OriginalName), strings.ToLower(f.NameContains)) {
+		return false
+	}
+	if f.Preset != "" && job.Preset != f.Preset {
+		return false
+	}
+	if f.InputHash != "" && job.InputHash != f.InputHash {
+		return false
+	}
+	if f.Owner != "" && job.Owner != f.Owner {
+		return false
+	}
+	if f.TenantID != nil && job.TenantID != *f.TenantID {
+		return false
+	}
+	if f.Label != "" {
+		found := false
+		for _, label := range job.Labels {
+			found = found || label == f.Label
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// compareJobs orders two jobs by one of SortableJobColumns. Jobs without a
+// completion time sort first, like NULLs in SQLite.
+func compareJobs(a, b *jobs.Job, column string) int {
+	switch column {
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	case "completed_at":
+		switch {
+		case a.CompletedAt == nil && b.CompletedAt == nil:
+			return 0
+		case a.CompletedAt == nil:
+			return -1
+		case b.CompletedAt == nil:
+			return 1
+		}
+		return a.CompletedAt.Compare(*b.CompletedAt)
+	case "status":
+		return strings.Compare(string(a.Status), string(b.Status))
+	case "progress":
+		return a.Progress - b.Progress
+	case "priority":
+		return a.Priority - b.Priority
+	case "original_name":
+		return strings.Compare(a.OriginalName, b.OriginalName)
+	default:
+		return a.CreatedAt.Compare(b.CreatedAt)
+	}
+}
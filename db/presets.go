@@ -0,0 +1,64 @@
+package db
+
+import (
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// CreatePreset stores a new preset; returns gorm.ErrDuplicatedKey if the
+// name is already taken within its tenant
+func CreatePreset(preset *transcoder.Preset) error {
+	return DB.Create(preset).Error
+}
+
+// ResolvePreset finds the preset a job in tenantID gets for name: the
+// tenant's own preset if it has one, otherwise the global preset
+func ResolvePreset(tenantID, name string) (*transcoder.Preset, error) {
+	if tenantID != "" {
+		if preset, err := GetPreset(tenantID, name); err == nil {
+			return preset, nil
+		}
+	}
+	return GetPreset("", name)
+}
+
+// GetPreset retrieves the preset with exactly this tenant and name
+func GetPreset(tenantID, name string) (*transcoder.Preset, error) {
+	var preset transcoder.Preset
+	if err := DB.First(&preset, "tenant_id = ? AND name = ?", tenantID, name).Error; err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+// ListPresets returns global presets plus those of tenantID, ordered by name
+func ListPresets(tenantID string) ([]transcoder.Preset, error) {
+	var presets []transcoder.Preset
+	err := DB.Where("tenant_id IN ?", []string{"", tenantID}).
+		Order("name ASC, tenant_id ASC").
+		Find(&presets).Error
+	return presets, err
+}
+
+// UpdatePreset saves changes to an existing preset
+func UpdatePreset(preset *transcoder.Preset) error {
+	return DB.Save(preset).Error
+}
+
+// DeletePreset removes a preset
+func DeletePreset(preset *transcoder.Preset) error {
+	return DB.Delete(preset).Error
+}
+
+// CountActiveJobsWithPreset counts unfinished jobs that may
+// resolve to the preset. For a global preset this spans all tenants.
+func CountActiveJobsWithPreset(preset *transcoder.Preset) (int64, error) {
+	var count int64
+	query := DB.Model(&jobs.Job{}).
+		Where("preset = ? AND status IN ?", preset.Name, []jobs.JobStatus{jobs.StatusPending, jobs.StatusProcessing, jobs.StatusRetrying})
+	if preset.TenantID != "" {
+		query = query.Where("tenant_id = ?", preset.TenantID)
+	}
+	err := query.Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// CreatePublication stores a new publication
+func CreatePublication(publication *jobs.Publication) error {
+	return DB.Create(publication).Error
+}
+
+// UpdatePublication saves changes to a publication
+func UpdatePublication(publication *jobs.Publication) error {
+	return DB.Save(publication).Error
+}
+
+// ListPublications returns a job's publications, oldest first
+func ListPublications(jobID string) ([]jobs.Publication, error) {
+	var publications []jobs.Publication
+	err := DB.Where("job_id = ?", jobID).Order("id ASC").Find(&publications).Error
+	return publications, err
+}
+
+// FailInterruptedPublications marks publications that the given replica
+// left unfinished when it last ran as failed, returning how many there
+// were. Those of other replicas may still be running. Publications from
+// before replicas were recorded count as the caller's.
+func FailInterruptedPublications(replica string, now time.Time) (int64, error) {
+	result := DB.Model(&jobs.Publication{}).
+		Where("status IN ?", []string{jobs.PublicationPending, jobs.PublicationUploading}).
+		Where("replica = ? OR replica = ''", replica).
+		Updates(map[string]interface{}{
+			"status":     jobs.PublicationFailed,
+			"error":      "interrupted by a server restart",
+			"updated_at": now,
+		})
+	return result.RowsAffected, result.Error
+}
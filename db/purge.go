@@ -0,0 +1,58 @@
+package db
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/webhook"
+	"gorm.io/gorm"
+)
+
+// ListPurgeableJobs returns a page of the jobs deleted before the cutoff,
+// longest deleted first
+func (r *GormJobRepository) ListPurgeableJobs(before time.Time, offset, limit int) ([]jobs.Job, error) {
+	var jobList []jobs.Job
+	err := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("deleted_at ASC, id ASC").
+		Offset(offset).
+		Limit(limit).
+		Find(&jobList).Error
+	return jobList, err
+}
+
+// FileInUse reports whether a job other than jobID, or one of its
+// publications, still refers to an uploaded file, as happens when Drive
+// uploads overwrite files of the same name. Jobs waiting to be purged
+// don't count.
+func (r *GormJobRepository) FileInUse(fileID, jobID string) (bool, error) {
+	quoted := "%" + escapeLike(strconv.Quote(fileID)) + "%"
+	var count int64
+	err := r.db.Model(&jobs.Job{}).
+		Where("id <> ?", jobID).
+		Where(`drive_file_id = ? OR poster_file_id = ? OR variants LIKE ? ESCAPE '\' OR closed_caps LIKE ? ESCAPE '\'`, fileID, fileID, quoted, quoted).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	err = r.db.Model(&jobs.Publication{}).
+		Where("job_id <> ? AND job_id IN (?)", jobID, r.db.Model(&jobs.Job{}).Select("id")).
+		Where(`file_id = ? OR extras LIKE ? ESCAPE '\'`, fileID, quoted).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// PurgeJob removes a deleted job for good, along with its events,
+// publications, webhook deliveries and job-scoped webhook endpoints. The
+// daily stats rollups keep counting it.
+func (r *GormJobRepository) PurgeJob(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&jobs.JobEvent{}, &jobs.Publication{}, &webhook.Delivery{}, &webhook.Endpoint{}} {
+			if err := tx.Unscoped().Where("job_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&jobs.Job{}).Error
+	})
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"github.com/skillcape/transcoder/internal/jobs"
+	"gorm.io/gorm"
+)
+
+// reconcileBatchSize is how many jobs EachJobFiles loads at a time
+const reconcileBatchSize = 500
+
+// EachJobFiles calls fn for every job that isn't deleted, loading only the
+// fields that locate its files on the server
+func (r *GormJobRepository) EachJobFiles(fn func(job *jobs.Job)) error {
+	var batch []jobs.Job
+	return r.db.Model(&jobs.Job{}).
+		Select("id", "status", "request_id", "tenant_id", "input_path", "input_paths", "output_path",
+			"variants", "closed_caps", "player", "hls", "cover_path", "captions_path", "poster_path", "drive_file_id").
+		FindInBatches(&batch, reconcileBatchSize, func(tx *gorm.DB, _ int) error {
+			for i := range batch {
+				fn(&batch[i])
+			}
+			return nil
+		}).Error
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/clock"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"gorm.io/gorm"
+)
+
+// JobRepository stores jobs and their event history. GormJobRepository is
+// the real implementation; MemoryJobRepository is an in-memory fake for
+// tests.
+type JobRepository interface {
+	CreateJob(job *jobs.Job) error
+	GetJob(id string) (*jobs.Job, error)
+	UpdateJob(job *jobs.Job) error
+	UpdatePendingJob(job *jobs.Job) error
+	UpdateJobProgress(id string, progress int, updatedAt time.Time) error
+	ListJobs(filter JobFilter, limit, offset int) ([]jobs.Job, int64, error)
+	BulkUpdateJobs(action BulkAction, ids []string, filter *JobFilter, limit int, actor, requestID string, allowed func(*jobs.Job) bool, clk clock.Clock) ([]BulkResult, []jobs.Job, error)
+	DeleteJob(id string) error
+	GetPendingJobs() ([]jobs.Job, error)
+	RecordJobEvent(jobID, eventType, requestID, message string)
+	ListJobEvents(jobID string) ([]jobs.JobEvent, error)
+	ListPurgeableJobs(before time.Time, offset, limit int) ([]jobs.Job, error)
+	FileInUse(fileID, jobID string) (bool, error)
+	PurgeJob(id string) error
+	EachJobFiles(fn func(job *jobs.Job)) error
+	EachJobCost(from, to string, tenantID *string, fn func(cost JobCost)) error
+	ListThroughput(since time.Time) ([]Throughput, error)
+}
+
+// ErrStaleJob is returned when saving a job that was changed by someone
+// else since it was loaded, such as a worker saving progress on a job the
+// API just cancelled. Reload the job and decide again.
+var ErrStaleJob = errors.New("job was modified concurrently")
+
+var (
+	_ JobRepository = (*GormJobRepository)(nil)
+	_ JobRepository = (*MemoryJobRepository)(nil)
+)
+
+// GormJobRepository is a JobRepository backed by the database
+type GormJobRepository struct {
+	db *gorm.DB
+}
+
+// NewJobRepository creates a repository on gdb, usually DB after Init
+func NewJobRepository(gdb *gorm.DB) *GormJobRepository {
+	return &GormJobRepository{db: gdb}
+}
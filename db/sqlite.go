@@ -1,86 +1,457 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/clock"
 	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+	"github.com/skillcape/transcoder/internal/webhook"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
 )
 
 var DB *gorm.DB
 
-func Init(dataDir string) error {
-	// Ensure data directory exists
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+// databaseURL is the Postgres URL DB was opened with, empty for SQLite
+var databaseURL string
+
+// sqliteBusyTimeout is how long SQLite waits for a lock before giving up
+const sqliteBusyTimeout = 5 * time.Second
+
+// Config selects the database. Without a URL, jobs are stored in SQLite
+// under DataDir.
+type Config struct {
+	DataDir         string
+	URL             string // postgres:// connection URL
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func Init(cfg Config) error {
+	dialector, location, err := open(cfg)
+	if err != nil {
 		return err
 	}
 
-	dbPath := filepath.Join(dataDir, "transcoder.db")
-
-	var err error
-	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Warn),
+	DB, err = gorm.Open(dialector, &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Warn),
+		TranslateError: true,
 	})
 	if err != nil {
 		return err
 	}
+	databaseURL = cfg.URL
+
+	if err := configurePool(cfg, dialector); err != nil {
+		return err
+	}
+
+	// Presets' crf column held 0 when unset, before 0 could mean
+	// lossless; the settings that were set move to its replacement
+	migrateCRF := DB.Migrator().HasColumn(&transcoder.Preset{}, "crf")
 
 	// Auto-migrate the schema
-	if err := DB.AutoMigrate(&jobs.Job{}); err != nil {
+	if err := DB.AutoMigrate(&jobs.Job{}, &jobs.JobEvent{}, &jobs.Publication{}, &transcoder.Preset{}, &auth.APIKey{}, &tenant.Tenant{}, &webhook.Delivery{}, &webhook.Endpoint{}, &DailyStats{}, &Lease{}); err != nil {
 		return err
 	}
 
-	log.Printf("Database initialized at %s", dbPath)
+	if migrateCRF {
+		if err := DB.Model(&transcoder.Preset{}).Where("crf <> 0").Update("constant_rate_factor", gorm.Expr("crf")).Error; err != nil {
+			return err
+		}
+		if err := DB.Migrator().DropColumn(&transcoder.Preset{}, "crf"); err != nil {
+			return err
+		}
+	}
+
+	// Preset names were globally unique before tenants existed
+	if DB.Migrator().HasIndex(&transcoder.Preset{}, "idx_presets_name") {
+		if err := DB.Migrator().DropIndex(&transcoder.Preset{}, "idx_presets_name"); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Database initialized at %s", location)
 	return nil
 }
 
+// Ping checks that the database answers
+func Ping(ctx context.Context) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// configurePool sizes the connection pool. SQLite allows only one writer at
+// a time, so writes and transactions share a single connection and queue
+// in Go instead of failing with "database is locked"; reads get their own
+// pool, which WAL lets run alongside the writer.
+func configurePool(cfg Config, dialector gorm.Dialector) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+
+	sqliteDialector, ok := dialector.(*sqlite.Dialector)
+	if !ok {
+		if cfg.MaxOpenConns > 0 {
+			sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+		}
+		if cfg.MaxIdleConns > 0 {
+			sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+		}
+		if cfg.ConnMaxLifetime > 0 {
+			sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+		}
+		return nil
+	}
+
+	sqlDB.SetMaxOpenConns(1)
+	readers := dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{sqlite.Open(sqliteDialector.DSN)},
+	})
+	if cfg.MaxOpenConns > 0 {
+		readers.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		readers.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		readers.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	return DB.Use(readers)
+}
+
+// open returns the dialector for cfg and a description of where the data
+// lives that is safe to log
+func open(cfg Config) (gorm.Dialector, string, error) {
+	if cfg.URL == "" {
+		// Ensure data directory exists
+		if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+			return nil, "", err
+		}
+		dbPath := filepath.Join(cfg.DataDir, "transcoder.db")
+		// WAL lets readers proceed during writes; the busy timeout waits out
+		// brief locks; immediate transactions take the write lock up front
+		// rather than failing when upgrading from a read lock
+		dsn := fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate", dbPath, sqliteBusyTimeout.Milliseconds())
+		return sqlite.Open(dsn), dbPath, nil
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid DATABASE_URL")
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		return postgres.Open(cfg.URL), "postgres://" + u.Host + u.Path, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported DATABASE_URL scheme %q", u.Scheme)
+	}
+}
+
 func GetDB() *gorm.DB {
 	return DB
 }
 
 // CreateJob creates a new job in the database
-func CreateJob(job *jobs.Job) error {
-	return DB.Create(job).Error
+func (r *GormJobRepository) CreateJob(job *jobs.Job) error {
+	return r.db.Create(job).Error
 }
 
 // GetJob retrieves a job by ID
-func GetJob(id string) (*jobs.Job, error) {
+func (r *GormJobRepository) GetJob(id string) (*jobs.Job, error) {
 	var job jobs.Job
-	if err := DB.First(&job, "id = ?", id).Error; err != nil {
+	if err := r.db.First(&job, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 	return &job, nil
 }
 
-// UpdateJob updates an existing job
-func UpdateJob(job *jobs.Job) error {
-	return DB.Save(job).Error
+// UpdateJob saves a job. It returns ErrStaleJob, leaving the row alone, if
+// the job was saved or deleted by someone else since it was loaded.
+func (r *GormJobRepository) UpdateJob(job *jobs.Job) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		return saveJob(tx, job)
+	})
+	if err == nil {
+		job.ClearStatusChanges()
+	}
+	return err
+}
+
+// saveJob writes every column of a job if its version still matches the
+// row's, bumps the version, and records the job's status changes
+func saveJob(tx *gorm.DB, job *jobs.Job) error {
+	version := job.Version
+	job.Version++
+	result := tx.Model(job).Where("version = ?", version).Select("*").Omit("created_at").Updates(job)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrStaleJob
+	}
+	if result.Error == nil {
+		for _, change := range job.StatusChanges() {
+			event := change.Event(job.ID)
+			if err := tx.Create(&event).Error; err != nil {
+				result.Error = err
+				break
+			}
+		}
+	}
+	if result.Error != nil {
+		job.Version = version
+	}
+	return result.Error
 }
 
-// ListJobs returns all jobs ordered by creation time
-func ListJobs(limit, offset int) ([]jobs.Job, int64, error) {
+// UpdatePendingJob saves the mutable fields of a job only if it is still
+// pending, so an edit can't race a worker that just picked the job up.
+// It returns gorm.ErrRecordNotFound if the job is no longer pending.
+func (r *GormJobRepository) UpdatePendingJob(job *jobs.Job) error {
+	version := job.Version
+	job.Version++
+	result := r.db.Model(&jobs.Job{}).
+		Where("id = ? AND status = ? AND version = ?", job.ID, jobs.StatusPending, version).
+		Select("priority", "webhook_url", "hook_events", "notify_emails", "name_template", "preset", "destination", "labels", "scheduled_at", "metadata", "renditions", "parallelism", "encoding", "packaging", "boosted_at", "updated_at", "version").
+		Updates(job)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = gorm.ErrRecordNotFound
+	}
+	if result.Error != nil {
+		job.Version = version
+	}
+	return result.Error
+}
+
+// UpdateJobProgress saves just the progress of a processing job, leaving
+// the rest of the row (such as a status set by a concurrent cancel) alone
+func (r *GormJobRepository) UpdateJobProgress(id string, progress int, updatedAt time.Time) error {
+	return r.db.Model(&jobs.Job{}).
+		Where("id = ? AND status = ?", id, jobs.StatusProcessing).
+		UpdateColumns(map[string]interface{}{"progress": progress, "updated_at": updatedAt}).Error
+}
+
+// JobFilter narrows and orders the result of ListJobs
+type JobFilter struct {
+	Statuses      []jobs.JobStatus
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	NameContains  string
+	Preset        string
+	Label         string
+	InputHash     string
+	Owner         string
+	TenantID      *string
+	SortBy        string
+	SortDesc      bool
+}
+
+// SortableJobColumns lists the columns ListJobs can order by
+var SortableJobColumns = map[string]bool{
+	"created_at":    true,
+	"updated_at":    true,
+	"completed_at":  true,
+	"status":        true,
+	"progress":      true,
+	"priority":      true,
+	"original_name": true,
+}
+
+// apply adds the filter conditions to a query
+func (f JobFilter) apply(query *gorm.DB) *gorm.DB {
+	if len(f.Statuses) > 0 {
+		query = query.Where("status IN ?", f.Statuses)
+	}
+	if f.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		query = query.Where("created_at < ?", *f.CreatedBefore)
+	}
+	if f.NameContains != "" {
+		query = query.Where(`original_name `+caseInsensitiveLike(query)+` ? ESCAPE '\'`, "%"+escapeLike(f.NameContains)+"%")
+	}
+	if f.Preset != "" {
+		query = query.Where("preset = ?", f.Preset)
+	}
+	if f.InputHash != "" {
+		query = query.Where("input_hash = ?", f.InputHash)
+	}
+	if f.Owner != "" {
+		query = query.Where("owner = ?", f.Owner)
+	}
+	if f.TenantID != nil {
+		query = query.Where("tenant_id = ?", *f.TenantID)
+	}
+	if f.Label != "" {
+		// Labels are stored as a JSON array, so match the quoted element
+		encoded, _ := json.Marshal(f.Label)
+		query = query.Where(`labels LIKE ? ESCAPE '\'`, "%"+escapeLike(string(encoded))+"%")
+	}
+	return query
+}
+
+func (f JobFilter) order() string {
+	column := "created_at"
+	if SortableJobColumns[f.SortBy] {
+		column = f.SortBy
+	}
+	direction := "ASC"
+	if f.SortDesc {
+		direction = "DESC"
+	}
+	// Tie-break on ID so paging is stable across equal sort keys
+	return fmt.Sprintf("%s %s, id %s", column, direction, direction)
+}
+
+// caseInsensitiveLike returns the operator that matches like SQLite's LIKE,
+// which ignores case; Postgres' LIKE doesn't
+func caseInsensitiveLike(query *gorm.DB) string {
+	if query.Dialector.Name() == "postgres" {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// ListJobs returns jobs matching the filter, along with the total match count
+func (r *GormJobRepository) ListJobs(filter JobFilter, limit, offset int) ([]jobs.Job, int64, error) {
 	var jobList []jobs.Job
 	var total int64
 
-	DB.Model(&jobs.Job{}).Count(&total)
+	if err := filter.apply(r.db.Model(&jobs.Job{})).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
 
-	err := DB.Order("created_at DESC").Limit(limit).Offset(offset).Find(&jobList).Error
+	err := filter.apply(r.db).Order(filter.order()).Limit(limit).Offset(offset).Find(&jobList).Error
 	return jobList, total, err
 }
 
+// BulkAction is an operation applied to many jobs at once
+type BulkAction string
+
+const (
+	BulkCancel BulkAction = "cancel"
+	BulkDelete BulkAction = "delete"
+)
+
+// BulkResult reports the outcome of a bulk action for one job
+type BulkResult struct {
+	ID     string `json:"id"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// BulkUpdateJobs applies action to the jobs selected by ids and/or filter
+// in a single transaction. At most limit jobs are
+// touched; jobs for which allowed returns false are reported as forbidden.
+// Changes are stamped with clk's time. It returns per-job results and the
+// jobs that were changed.
+func (r *GormJobRepository) BulkUpdateJobs(action BulkAction, ids []string, filter *JobFilter, limit int, actor, requestID string, allowed func(*jobs.Job) bool, clk clock.Clock) ([]BulkResult, []jobs.Job, error) {
+	var results []BulkResult
+	var changed []jobs.Job
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var selected []jobs.Job
+		query := tx.Order("created_at ASC").Limit(limit)
+		if len(ids) > 0 {
+			query = query.Where("id IN ?", ids)
+		}
+		if filter != nil {
+			query = filter.apply(query)
+		}
+		if err := query.Find(&selected).Error; err != nil {
+			return err
+		}
+
+		found := make(map[string]bool, len(selected))
+		now := clk.Now().UTC()
+		for i := range selected {
+			job := &selected[i]
+			found[job.ID] = true
+
+			if !allowed(job) {
+				results = append(results, BulkResult{ID: job.ID, Result: "forbidden"})
+				continue
+			}
+
+			active := job.Status.Active()
+			if action == BulkCancel && !active {
+				results = append(results, BulkResult{ID: job.ID, Result: "skipped", Reason: "job is " + string(job.Status)})
+				continue
+			}
+
+			if active {
+				if err := job.Transition(jobs.StatusCancelled, actor, requestID, clk); err != nil {
+					return err
+				}
+				job.UpdatedAt = now
+				if err := saveJob(tx, job); errors.Is(err, ErrStaleJob) {
+					results = append(results, BulkResult{ID: job.ID, Result: "skipped", Reason: "job changed concurrently"})
+					continue
+				} else if err != nil {
+					return err
+				}
+				job.ClearStatusChanges()
+			}
+
+			result := "cancelled"
+			if action == BulkDelete {
+				if err := tx.Delete(&jobs.Job{}, "id = ?", job.ID).Error; err != nil {
+					return err
+				}
+				result = "deleted"
+			}
+
+			results = append(results, BulkResult{ID: job.ID, Result: result})
+			changed = append(changed, *job)
+		}
+
+		for _, id := range ids {
+			if !found[id] {
+				results = append(results, BulkResult{ID: id, Result: "not_found"})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return results, changed, nil
+}
+
 // DeleteJob soft-deletes a job
-func DeleteJob(id string) error {
-	return DB.Delete(&jobs.Job{}, "id = ?", id).Error
+func (r *GormJobRepository) DeleteJob(id string) error {
+	return r.db.Delete(&jobs.Job{}, "id = ?", id).Error
 }
 
-// GetPendingJobs returns all jobs with pending status (for recovery after restart)
-func GetPendingJobs() ([]jobs.Job, error) {
+// GetPendingJobs returns all unfinished jobs (for recovery after restart)
+func (r *GormJobRepository) GetPendingJobs() ([]jobs.Job, error) {
 	var jobList []jobs.Job
-	err := DB.Where("status IN ?", []jobs.JobStatus{jobs.StatusPending, jobs.StatusProcessing}).
+	err := r.db.Where("status IN ?", []jobs.JobStatus{jobs.StatusPending, jobs.StatusProcessing, jobs.StatusRetrying}).
 		Order("created_at ASC").
 		Find(&jobList).Error
 	return jobList, err
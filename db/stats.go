@@ -0,0 +1,189 @@
+package db
+
+import (
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"gorm.io/gorm"
+)
+
+// statsDayFormat is how DailyStats days are stored
+const statsDayFormat = "2006-01-02"
+
+// DailyStats rolls up the jobs created on one UTC day for one tenant and
+// preset. RefreshStats rebuilds the rows so reports never scan the jobs
+// table. Deleted jobs still count, as they do for usage.
+type DailyStats struct {
+	Day       string    `json:"day" gorm:"primaryKey;size:10"` // YYYY-MM-DD
+	TenantID  string    `json:"tenant_id,omitempty" gorm:"primaryKey"`
+	Preset    string    `json:"preset" gorm:"primaryKey"`
+	Jobs      int64     `json:"jobs"`
+	Completed int64     `json:"completed"`
+	Failed    int64     `json:"failed"`
+	Cancelled int64     `json:"cancelled"`
+	Seconds   float64   `json:"seconds"` // input duration of completed jobs
+	UpdatedAt time.Time `json:"-" gorm:"index"`
+}
+
+// RefreshStats rebuilds the rollups of every day with jobs changed since
+// the last refresh, or of every day when there are no rollups yet
+func RefreshStats(now time.Time) error {
+	now = now.UTC()
+
+	query := DB.Unscoped().Model(&jobs.Job{})
+	var last DailyStats
+	err := DB.Order("updated_at DESC").Limit(1).Find(&last).Error
+	if err != nil {
+		return err
+	}
+	if !last.UpdatedAt.IsZero() {
+		query = query.Where("updated_at >= ?", last.UpdatedAt)
+	}
+
+	var created []time.Time
+	if err := query.Distinct().Pluck("created_at", &created).Error; err != nil {
+		return err
+	}
+	days := make(map[string]bool)
+	for _, t := range created {
+		days[t.UTC().Format(statsDayFormat)] = true
+	}
+
+	for day := range days {
+		if err := refreshDay(day, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshDay replaces one day's rollups
+func refreshDay(day string, now time.Time) error {
+	start, err := time.Parse(statsDayFormat, day)
+	if err != nil {
+		return err
+	}
+
+	var groups []struct {
+		TenantID string
+		Preset   string
+		Status   jobs.JobStatus
+		Jobs     int64
+		Seconds  float64
+	}
+	err = DB.Unscoped().Model(&jobs.Job{}).
+		Select("tenant_id, preset, status, COUNT(*) AS jobs, COALESCE(SUM(duration), 0) AS seconds").
+		Where("created_at >= ? AND created_at < ?", start, start.AddDate(0, 0, 1)).
+		Group("tenant_id, preset, status").
+		Scan(&groups).Error
+	if err != nil {
+		return err
+	}
+
+	rows := make(map[[2]string]*DailyStats)
+	var order []*DailyStats
+	for _, g := range groups {
+		key := [2]string{g.TenantID, g.Preset}
+		row := rows[key]
+		if row == nil {
+			row = &DailyStats{Day: day, TenantID: g.TenantID, Preset: g.Preset, UpdatedAt: now}
+			rows[key] = row
+			order = append(order, row)
+		}
+		row.Jobs += g.Jobs
+		switch g.Status {
+		case jobs.StatusCompleted:
+			row.Completed += g.Jobs
+			row.Seconds += g.Seconds
+		case jobs.StatusFailed, jobs.StatusDeadLetter:
+			row.Failed += g.Jobs
+		case jobs.StatusCancelled, jobs.StatusExpired:
+			row.Cancelled += g.Jobs
+		}
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("day = ?", day).Delete(&DailyStats{}).Error; err != nil {
+			return err
+		}
+		if len(order) == 0 {
+			return nil
+		}
+		return tx.Create(order).Error
+	})
+}
+
+// ListDailyStats returns the rollups for days from through to (inclusive,
+// YYYY-MM-DD), optionally limited to one tenant, ordered by day
+func ListDailyStats(from, to string, tenantID *string) ([]DailyStats, error) {
+	query := DB.Where("day >= ? AND day <= ?", from, to)
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	}
+	var stats []DailyStats
+	err := query.Order("day, tenant_id, preset").Find(&stats).Error
+	return stats, err
+}
+
+// JobCost is what one finished job consumed, for cost attribution
+type JobCost struct {
+	TenantID    string
+	Labels      jobs.StringList
+	Duration    float64 // input seconds
+	CPUSeconds  float64
+	WallSeconds float64
+	InputSize   int64
+	BytesOut    int64
+}
+
+// jobCostBatchSize is how many jobs EachJobCost loads at a time
+const jobCostBatchSize = 500
+
+// EachJobCost calls fn for every job that finished on days from through to
+// (inclusive, YYYY-MM-DD), optionally limited to one tenant. Deleted jobs
+// count, as they do for the rollups.
+func (r *GormJobRepository) EachJobCost(from, to string, tenantID *string, fn func(cost JobCost)) error {
+	start, end, err := costDays(from, to)
+	if err != nil {
+		return err
+	}
+	query := r.db.Unscoped().Model(&jobs.Job{}).
+		Select("id", "tenant_id", "labels", "duration", "cpu_seconds", "wall_seconds", "input_size", "bytes_out").
+		Where("completed_at >= ? AND completed_at < ?", start, end)
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	}
+	var batch []jobs.Job
+	return query.FindInBatches(&batch, jobCostBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			fn(jobCost(&batch[i]))
+		}
+		return nil
+	}).Error
+}
+
+// costDays parses the days of a cost report into the instants bounding it
+func costDays(from, to string) (start, end time.Time, err error) {
+	start, err = time.Parse(statsDayFormat, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err = time.Parse(statsDayFormat, to)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+// jobCost returns what the job consumed
+func jobCost(job *jobs.Job) JobCost {
+	return JobCost{
+		TenantID:    job.TenantID,
+		Labels:      job.Labels,
+		Duration:    job.Duration,
+		CPUSeconds:  job.CPUSeconds,
+		WallSeconds: job.WallSeconds,
+		InputSize:   job.InputSize,
+		BytesOut:    job.BytesOut,
+	}
+}
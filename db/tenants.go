@@ -0,0 +1,59 @@
+package db
+
+import (
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/webhook"
+	"gorm.io/gorm"
+)
+
+// CreateTenant stores a new tenant; returns gorm.ErrDuplicatedKey if the ID is taken
+func CreateTenant(t *tenant.Tenant) error {
+	return DB.Create(t).Error
+}
+
+// GetTenant retrieves a tenant by ID
+func GetTenant(id string) (*tenant.Tenant, error) {
+	var t tenant.Tenant
+	if err := DB.First(&t, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTenants returns all tenants ordered by ID
+func ListTenants() ([]tenant.Tenant, error) {
+	var tenants []tenant.Tenant
+	err := DB.Order("id ASC").Find(&tenants).Error
+	return tenants, err
+}
+
+// UpdateTenant saves changes to a tenant
+func UpdateTenant(t *tenant.Tenant) error {
+	return DB.Save(t).Error
+}
+
+// DeleteTenant removes a tenant that owns no jobs or active keys, along
+// with its webhook endpoints
+func DeleteTenant(id string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&webhook.Endpoint{}, "tenant_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tenant.Tenant{}, "id = ?", id).Error
+	})
+}
+
+// TenantInUse reports whether any job or non-revoked API key belongs to the tenant
+func TenantInUse(id string) (bool, error) {
+	var count int64
+	if err := DB.Model(&jobs.Job{}).Where("tenant_id = ?", id).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	err := DB.Model(&auth.APIKey{}).Where("tenant_id = ? AND revoked_at IS NULL", id).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"time"
+
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// GetUsage totals what owner has consumed in the current UTC day and month.
+// Deleted jobs still count toward jobs and minutes; storage only counts
+// uploads still held locally, i.e. jobs not deleted and not moved to Drive.
+func GetUsage(owner string, now time.Time) (auth.Usage, error) {
+	var usage auth.Usage
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	err := DB.Unscoped().Model(&jobs.Job{}).
+		Where("owner = ? AND created_at >= ?", owner, dayStart).
+		Count(&usage.JobsToday).Error
+	if err != nil {
+		return usage, err
+	}
+
+	var seconds float64
+	err = DB.Unscoped().Model(&jobs.Job{}).
+		Where("owner = ? AND created_at >= ?", owner, monthStart).
+		Select("COALESCE(SUM(duration), 0)").
+		Scan(&seconds).Error
+	if err != nil {
+		return usage, err
+	}
+	usage.MinutesThisMonth = seconds / 60
+
+	err = DB.Model(&jobs.Job{}).
+		Where("owner = ? AND drive_file_id = ''", owner).
+		Select("COALESCE(SUM(input_size), 0)").
+		Scan(&usage.StorageBytes).Error
+	return usage, err
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"log"
+
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// RecordWebhookDelivery stores a delivery attempt. Failures are logged
+// since the log never decides whether a delivery happened.
+func RecordWebhookDelivery(delivery *webhook.Delivery) {
+	if err := DB.Create(delivery).Error; err != nil {
+		log.Printf("Failed to record webhook delivery for job %s: %v", delivery.JobID, err)
+	}
+}
+
+// ListWebhookDeliveries returns a job's delivery attempts, oldest first
+func ListWebhookDeliveries(jobID string) ([]webhook.Delivery, error) {
+	var deliveries []webhook.Delivery
+	err := DB.Where("job_id = ?", jobID).Order("id ASC").Find(&deliveries).Error
+	return deliveries, err
+}
+
+// GetWebhookDelivery retrieves one of a job's delivery attempts
+func GetWebhookDelivery(jobID string, id uint) (*webhook.Delivery, error) {
+	var delivery webhook.Delivery
+	if err := DB.First(&delivery, "id = ? AND job_id = ?", id, jobID).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// CreateWebhookEndpoint stores a new endpoint
+func CreateWebhookEndpoint(endpoint *webhook.Endpoint) error {
+	return DB.Create(endpoint).Error
+}
+
+// GetWebhookEndpoint retrieves an endpoint by ID
+func GetWebhookEndpoint(id string) (*webhook.Endpoint, error) {
+	var endpoint webhook.Endpoint
+	if err := DB.First(&endpoint, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// ListWebhookEndpoints returns endpoints oldest first, limited to one
+// tenant if tenantID is set
+func ListWebhookEndpoints(tenantID *string) ([]webhook.Endpoint, error) {
+	var endpoints []webhook.Endpoint
+	query := DB.Order("created_at ASC")
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	}
+	err := query.Find(&endpoints).Error
+	return endpoints, err
+}
+
+// WebhookEndpointsFor returns the enabled endpoints that receive a job's
+// events: global ones, its tenant's, and its own
+func WebhookEndpointsFor(tenantID, jobID string) ([]webhook.Endpoint, error) {
+	var endpoints []webhook.Endpoint
+	err := DB.Where("disabled = ?", false).
+		Where("(job_id = '' AND (tenant_id = '' OR tenant_id = ?)) OR job_id = ?", tenantID, jobID).
+		Order("created_at ASC").Find(&endpoints).Error
+	return endpoints, err
+}
+
+// UpdateWebhookEndpoint saves changes to an endpoint
+func UpdateWebhookEndpoint(endpoint *webhook.Endpoint) error {
+	return DB.Save(endpoint).Error
+}
+
+// DeleteWebhookEndpoint removes an endpoint. Its delivery log is kept.
+func DeleteWebhookEndpoint(id string) error {
+	return DB.Delete(&webhook.Endpoint{}, "id = ?", id).Error
+}
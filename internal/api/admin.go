@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/auth"
+)
+
+// ListAPIKeys returns stored API keys (without their secrets). Tenant
+// admins only see their own tenant's keys.
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	var tenantID *string
+	if principal := currentPrincipal(c); !principal.IsGlobal() {
+		tenantID = &principal.Tenant
+	}
+	keys, err := db.ListAPIKeys(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list API keys",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys": keys,
+	})
+}
+
+// CreateAPIKey issues a new API key. The secret is only returned here.
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req struct {
+		Name     string `json:"name"`
+		Role     string `json:"role"`
+		TenantID string `json:"tenant_id"`
+		auth.Quota
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "name is required",
+		})
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "role must be admin, operator, submitter, or viewer",
+		})
+		return
+	}
+	if err := req.Quota.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Tenant admins can only issue keys for their own tenant
+	if principal := currentPrincipal(c); !principal.IsGlobal() {
+		req.TenantID = principal.Tenant
+	} else if req.TenantID != "" {
+		if _, err := db.GetTenant(req.TenantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "unknown tenant_id",
+			})
+			return
+		}
+	}
+
+	secret, err := auth.GenerateKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to generate API key",
+		})
+		return
+	}
+
+	key := &auth.APIKey{
+		ID:        h.ids.NewID(),
+		Name:      req.Name,
+		Prefix:    secret[:10],
+		KeyHash:   auth.HashKey(secret),
+		Role:      req.Role,
+		TenantID:  req.TenantID,
+		Quota:     req.Quota,
+		CreatedAt: h.clock.Now().UTC(),
+	}
+	if err := db.CreateAPIKey(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to create API key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"key":    key,
+		"secret": secret,
+	})
+}
+
+// RevokeAPIKey disables an API key
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+
+	key, err := db.GetAPIKey(id)
+	if err != nil || !currentPrincipal(c).InTenant(key.TenantID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API key not found",
+		})
+		return
+	}
+
+	if err := db.RevokeAPIKey(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to revoke API key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key revoked",
+	})
+}
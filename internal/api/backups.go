@@ -0,0 +1,43 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+)
+
+// ListBackups lists the database backups in BACKUP_DIR, newest first
+func (h *Handler) ListBackups(c *gin.Context) {
+	backups, err := db.ListBackups(h.cfg.BackupDir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list backups",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"backups": backups,
+	})
+}
+
+// CreateBackup snapshots the database into BACKUP_DIR
+func (h *Handler) CreateBackup(c *gin.Context) {
+	backup, err := db.Backup(c.Request.Context(), h.cfg.BackupDir, h.clock.Now())
+	if err != nil {
+		log.Printf("Backup failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "backup failed",
+		})
+		return
+	}
+	if err := db.PruneBackups(h.cfg.BackupDir, h.cfg.BackupRetain); err != nil {
+		log.Printf("Failed to prune backups: %v", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"backup": backup,
+	})
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// Limits on the branding files a tenant may upload
+const (
+	maxWatermarkSize = 5 << 20
+	maxIntroSize     = 200 << 20
+)
+
+// SetTenantWatermark stores the PNG image in the "file" part as the
+// tenant's watermark, replacing any earlier one
+func (h *Handler) SetTenantWatermark(c *gin.Context) {
+	t, ok := brandingTenant(c)
+	if !ok {
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no file uploaded",
+		})
+		return
+	}
+	if header.Size > maxWatermarkSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("watermark must be at most %d MB", maxWatermarkSize>>20),
+		})
+		return
+	}
+	if container, err := sniffUpload(header); err != nil || container != "png" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "watermark must be a PNG image",
+		})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to read uploaded file",
+		})
+		return
+	}
+	defer file.Close()
+	name := t.ID + "-watermark.png"
+	if err := h.localStorage.SaveBranding(name, file); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to save watermark",
+		})
+		return
+	}
+
+	t.Watermark = name
+	h.saveBranding(c, t, "")
+}
+
+// SetTenantIntro stores the video in the "file" part as the clip played
+// before the tenant's outputs, replacing any earlier one
+func (h *Handler) SetTenantIntro(c *gin.Context) {
+	t, ok := brandingTenant(c)
+	if !ok {
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no file uploaded",
+		})
+		return
+	}
+	if header.Size > maxIntroSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("intro must be at most %d MB", maxIntroSize>>20),
+		})
+		return
+	}
+	if container, err := sniffUpload(header); err != nil || transcoder.IsImage(container) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "intro must be a video",
+		})
+		return
+	}
+
+	// The intro is checked before it replaces the current one
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to read uploaded file",
+		})
+		return
+	}
+	defer file.Close()
+	uploaded, err := h.localStorage.SaveUpload("intro-"+h.ids.NewID(), header.Filename, file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to save intro",
+		})
+		return
+	}
+	defer h.localStorage.DeleteFile(uploaded)
+
+	info, err := transcoder.ProbeMedia(c.Request.Context(), uploaded, probeTimeout)
+	if err == nil && !hasVideo(info) {
+		err = fmt.Errorf("intro has no video stream")
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if ext == "" {
+		ext = ".mp4"
+	}
+	name := t.ID + "-intro" + ext
+	if err := os.Rename(uploaded, h.localStorage.BrandingPath(name)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to save intro",
+		})
+		return
+	}
+
+	previous := t.Intro
+	t.Intro = name
+	if previous == name {
+		previous = ""
+	}
+	h.saveBranding(c, t, previous)
+}
+
+// DeleteTenantWatermark stops watermarking the tenant's outputs
+func (h *Handler) DeleteTenantWatermark(c *gin.Context) {
+	t, ok := brandingTenant(c)
+	if !ok {
+		return
+	}
+	previous := t.Watermark
+	t.Watermark = ""
+	h.saveBranding(c, t, previous)
+}
+
+// DeleteTenantIntro stops adding an intro to the tenant's outputs
+func (h *Handler) DeleteTenantIntro(c *gin.Context) {
+	t, ok := brandingTenant(c)
+	if !ok {
+		return
+	}
+	previous := t.Intro
+	t.Intro = ""
+	h.saveBranding(c, t, previous)
+}
+
+// brandingTenant loads the tenant named in the path, writing a 404
+// response and returning false if there is none
+func brandingTenant(c *gin.Context) (*tenant.Tenant, bool) {
+	t, err := db.GetTenant(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "tenant not found",
+		})
+		return nil, false
+	}
+	return t, true
+}
+
+// saveBranding saves the tenant's new branding and responds with the
+// tenant, then removes the branding file it replaced, if any
+func (h *Handler) saveBranding(c *gin.Context, t *tenant.Tenant, replaced string) {
+	if err := db.UpdateTenant(t); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to update tenant",
+		})
+		return
+	}
+	if replaced != "" {
+		h.localStorage.DeleteFile(h.localStorage.BrandingPath(replaced))
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// hasVideo reports whether a probe found a video stream
+func hasVideo(info *transcoder.MediaInfo) bool {
+	for _, stream := range info.Streams {
+		if stream.Type == "video" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// maxCapacityHours bounds the throughput window of a capacity report
+const maxCapacityHours = 24 * 30
+
+// capacityRate is how fast some completed jobs were transcoded
+type capacityRate struct {
+	Jobs                  int64   `json:"jobs"`
+	InputMinutes          float64 `json:"input_minutes"`
+	EncodeMinutes         float64 `json:"encode_minutes"`
+	SecondsPerInputMinute float64 `json:"seconds_per_input_minute"`
+	rate                  float64 // unrounded SecondsPerInputMinute
+}
+
+func newCapacityRate(jobs int64, inputSeconds, encodeSeconds float64) capacityRate {
+	r := capacityRate{
+		Jobs:          jobs,
+		InputMinutes:  math.Round(inputSeconds/60*100) / 100,
+		EncodeMinutes: math.Round(encodeSeconds/60*100) / 100,
+	}
+	if inputSeconds > 0 {
+		r.rate = encodeSeconds / (inputSeconds / 60)
+		r.SecondsPerInputMinute = math.Round(r.rate*100) / 100
+	}
+	return r
+}
+
+// GetCapacity estimates how long the workers will take to clear the jobs
+// waiting and running, at the throughput of the jobs completed over the
+// last hours (24 by default). Jobs whose length isn't known yet are
+// assumed to be as long as the average completed job.
+func (h *Handler) GetCapacity(c *gin.Context) {
+	hours := 24
+	if param := c.Query("hours"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 || n > maxCapacityHours {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "hours must be between 1 and 720",
+			})
+			return
+		}
+		hours = n
+	}
+
+	now := h.clock.Now().UTC()
+	throughput, err := h.repo.ListThroughput(now.Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to load throughput",
+		})
+		return
+	}
+	pending, err := h.repo.GetPendingJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to load jobs",
+		})
+		return
+	}
+
+	type presetRate struct {
+		Preset string `json:"preset"`
+		capacityRate
+	}
+	presets := []presetRate{}
+	rates := make(map[string]float64)
+	var jobCount int64
+	var inputSeconds, encodeSeconds float64
+	for _, t := range throughput {
+		name := t.Preset
+		if name == "" {
+			name = "default"
+		}
+		rate := newCapacityRate(t.Jobs, t.InputSeconds, t.EncodeSeconds)
+		presets = append(presets, presetRate{Preset: name, capacityRate: rate})
+		rates[t.Preset] = rate.rate
+		jobCount += t.Jobs
+		inputSeconds += t.InputSeconds
+		encodeSeconds += t.EncodeSeconds
+	}
+	overall := newCapacityRate(jobCount, inputSeconds, encodeSeconds)
+	var averageInput float64
+	if jobCount > 0 {
+		averageInput = inputSeconds / float64(jobCount)
+	}
+
+	var backlog struct {
+		Queued        int     `json:"queued"`
+		Running       int     `json:"running"`
+		Scheduled     int     `json:"scheduled"` // held for later, not counted
+		InputMinutes  float64 `json:"input_minutes"`
+		EstimatedJobs int     `json:"estimated_jobs"` // length assumed from the average
+	}
+	var workSeconds, backlogSeconds float64
+	for i := range pending {
+		job := &pending[i]
+		if job.ScheduledAt != nil && job.ScheduledAt.After(now) {
+			backlog.Scheduled++
+			continue
+		}
+
+		length := job.Duration
+		if length == 0 && job.InputProbe != nil {
+			length = job.InputProbe.Duration
+		}
+		if length == 0 {
+			length = averageInput
+			backlog.EstimatedJobs++
+		}
+		if job.Status == jobs.StatusProcessing {
+			h.jobQueue.ApplyProgress(job)
+			length *= float64(100-job.Progress) / 100
+			backlog.Running++
+		} else {
+			backlog.Queued++
+		}
+
+		rate, ok := rates[job.Preset]
+		if !ok {
+			rate = overall.rate
+		}
+		backlogSeconds += length
+		workSeconds += length / 60 * rate
+	}
+	backlog.InputMinutes = math.Round(backlogSeconds/60*100) / 100
+
+	workers := h.cfg.WorkerCount
+	response := gin.H{
+		"window_hours": hours,
+		"workers":      workers,
+		"backlog":      backlog,
+		"throughput":   overall,
+		"presets":      presets,
+	}
+	if overall.rate > 0 && workers > 0 {
+		response["input_minutes_per_hour"] = math.Round(float64(workers)*60/overall.rate*60*100) / 100
+		clear := workSeconds / float64(workers)
+		response["estimated_clear_seconds"] = math.Round(clear)
+		response["estimated_clear_at"] = now.Add(time.Duration(clear * float64(time.Second))).Format(time.RFC3339)
+	}
+	if reason := h.jobQueue.Paused(); reason != "" {
+		response["dispatch_paused"] = reason
+	}
+	c.JSON(http.StatusOK, response)
+}
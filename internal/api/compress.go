@@ -0,0 +1,205 @@
+package api
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minCompressSize is the smallest body worth compressing; smaller ones
+// (most error responses) are sent as is
+const minCompressSize = 1024
+
+// compressibleTypes lists the media types Compress encodes. Media files
+// are already compressed, so they are never re-encoded.
+var compressibleTypes = map[string]bool{
+	"application/json": true,
+	"text/plain":       true,
+	"text/html":        true,
+	"text/csv":         true,
+}
+
+// Encoders are pooled since each allocates sizeable compression state
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// Compress encodes text and JSON responses with gzip or deflate when the
+// client accepts it
+func Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead || c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip and honoring q=0
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(name)] = q > 0
+	}
+
+	switch {
+	case accepted["gzip"], accepted["*"] && !hasKey(accepted, "gzip"):
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+func hasKey(m map[string]bool, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// the body is large and compressible enough, then streams the rest
+// through the encoder
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= minCompressSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. for the
+// deadlines set by Timeouts
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide starts compressing if the response qualifies, then writes out
+// whatever has been buffered
+func (w *compressWriter) decide() error {
+	w.decided = true
+
+	if w.compressible() {
+		header := w.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+
+		if w.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(w.ResponseWriter)
+			w.encoder = gz
+		} else {
+			fl := flateWriters.Get().(*flate.Writer)
+			fl.Reset(w.ResponseWriter)
+			w.encoder = fl
+		}
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible reports whether the buffered response should be encoded
+func (w *compressWriter) compressible() bool {
+	if len(w.buf) < minCompressSize {
+		return false
+	}
+	status := w.Status()
+	if status == http.StatusNoContent || status == http.StatusNotModified || status < 200 {
+		return false
+	}
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && compressibleTypes[mediaType]
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close writes any buffered body and finishes the encoded stream
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.encoder == nil {
+		return
+	}
+
+	w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *flate.Writer:
+		flateWriters.Put(encoder)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/internal/config"
+)
+
+// Reloader holds the configuration the server is running with and reads
+// it again on request. Pending lists variables changed by reloads that
+// only take effect after a restart.
+type Reloader interface {
+	Current() *config.Config
+	Pending() []string
+	Reload() error
+}
+
+// GetConfig reports the configuration as last loaded with secrets
+// redacted: every variable with its value and where it came from, the
+// presets and webhooks declared in the config file, and which changed
+// variables await a restart
+func (h *Handler) GetConfig(c *gin.Context) {
+	cfg, pending := h.cfg, []string{}
+	if h.reloader != nil {
+		cfg = h.reloader.Current()
+		pending = append(pending, h.reloader.Pending()...)
+	}
+
+	presets := []string{}
+	for _, preset := range cfg.Presets {
+		presets = append(presets, preset.Name)
+	}
+	type webhookSummary struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Format string   `json:"format,omitempty"`
+		Signed bool     `json:"signed"`
+	}
+	webhooks := []webhookSummary{}
+	for _, endpoint := range cfg.WebhookEndpoints {
+		webhooks = append(webhooks, webhookSummary{
+			URL:    config.RedactURL(endpoint.URL),
+			Events: endpoint.Events,
+			Format: endpoint.Format,
+			Signed: endpoint.Secret != "",
+		})
+	}
+	destinations := map[string]string{}
+	for name, profile := range cfg.Destinations {
+		destinations[name] = profile.Type
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"config_file":     cfg.ConfigFile,
+		"loaded_at":       cfg.LoadedAt,
+		"settings":        cfg.Settings(),
+		"presets":         presets,
+		"webhooks":        webhooks,
+		"destinations":    destinations,
+		"restart_pending": pending,
+	})
+}
+
+// ReloadConfig reads the environment and config file again and applies
+// the settings that can change without a restart, as SIGHUP does
+func (h *Handler) ReloadConfig(c *gin.Context) {
+	if h.reloader == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"error": "config reload is not available",
+		})
+		return
+	}
+	if err := h.reloader.Reload(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "reloaded",
+	})
+}
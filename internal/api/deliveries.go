@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// GetWebhookDeliveries returns every webhook delivery attempt for a job
+func (h *Handler) GetWebhookDeliveries(c *gin.Context) {
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !currentPrincipal(c).CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	deliveries, err := db.ListWebhookDeliveries(job.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list webhook deliveries",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+	})
+}
+
+// RedeliverWebhook sends a recorded delivery's payload to its URL again,
+// so a receiver that was down can catch up on missed events
+func (h *Handler) RedeliverWebhook(c *gin.Context) {
+	principal := currentPrincipal(c)
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !principal.CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+	if !principal.CanModify(job.TenantID, job.Owner) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "not allowed to modify this job",
+		})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "delivery not found",
+		})
+		return
+	}
+	previous, err := db.GetWebhookDelivery(job.ID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "delivery not found",
+		})
+		return
+	}
+
+	// Deliveries to registered and config file endpoints are signed with
+	// the endpoint's current secret
+	var endpoint *webhook.Endpoint
+	if previous.EndpointID != "" {
+		endpoint = h.notifier.StaticEndpoint(previous.EndpointID)
+		if endpoint == nil {
+			if endpoint, err = db.GetWebhookEndpoint(previous.EndpointID); err != nil {
+				c.JSON(http.StatusGone, gin.H{
+					"error": "webhook endpoint has been deleted",
+				})
+				return
+			}
+		}
+	}
+
+	delivery := h.notifier.Redeliver(c.Request.Context(), previous, endpoint, currentRequestID(c))
+
+	c.JSON(http.StatusOK, gin.H{
+		"delivery": delivery,
+	})
+}
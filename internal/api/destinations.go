@@ -0,0 +1,41 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListDestinations returns the destination profiles jobs and presets may
+// select, and the one used when they select none
+func (h *Handler) ListDestinations(c *gin.Context) {
+	list := make([]gin.H, 0, len(h.cfg.Destinations))
+	for name, profile := range h.cfg.Destinations {
+		list = append(list, gin.H{
+			"name": name,
+			"type": profile.Type,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["name"].(string) < list[j]["name"].(string)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"destinations": list,
+		"default":      h.cfg.DefaultDestination,
+	})
+}
+
+// checkDestination accepts an empty name (use the default) or the name of
+// a configured destination profile
+func (h *Handler) checkDestination(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := h.cfg.Destinations[name]; !ok {
+		return fmt.Errorf("unknown destination %q", name)
+	}
+	return nil
+}
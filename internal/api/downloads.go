@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// maxDownloadLinkTTL caps how long a download link may stay valid
+const maxDownloadLinkTTL = 7 * 24 * time.Hour
+
+// Presigner hands out links to outputs kept at a destination rather than
+// on this server. Presign returns false if the destination, named by its
+// profile and type when the output was uploaded, can't make them.
+type Presigner interface {
+	Presign(destination, kind, fileID, fileName string, ttl time.Duration) (string, bool, error)
+}
+
+// CreateDownloadLink issues a time-limited signed URL for a job's output
+// that can be handed to someone without an API key
+func (h *Handler) CreateDownloadLink(c *gin.Context) {
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !currentPrincipal(c).CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	var req struct {
+		ExpiresIn int    `json:"expires_in"`
+		Variant   string `json:"variant"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid request body",
+			})
+			return
+		}
+	}
+
+	ttl, ok := h.linkTTL(req.ExpiresIn)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "expires_in must be between 1 and 604800 seconds",
+		})
+		return
+	}
+
+	if job.Status != jobs.StatusCompleted {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "job is not completed",
+		})
+		return
+	}
+	path, _, ok := downloadFile(job, req.Variant)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "variant not found",
+		})
+		return
+	}
+	expires := h.clock.Now().Add(ttl).UTC().Truncate(time.Second)
+	if !h.localStorage.FileExists(path) {
+		// Outputs kept only at a destination that can presign links, such
+		// as S3, are served from there
+		fileID, name, ok := remoteFile(job, req.Variant)
+		if ok && h.presigner != nil && job.Destination != "" {
+			link, presigned, err := h.presigner.Presign(job.Destination, job.StorageType, fileID, name, ttl)
+			if err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			if presigned {
+				c.JSON(http.StatusOK, gin.H{
+					"url":        link,
+					"expires_at": expires,
+				})
+				return
+			}
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "output is not stored on this server",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        h.baseURL(c) + "/download/" + job.ID + "?" + h.downloadQuery(job.ID, req.Variant, expires).Encode(),
+		"expires_at": expires,
+	})
+}
+
+// linkTTL returns how long a link requested with expires_in stays valid,
+// or false if that is out of range. Zero means the configured default.
+func (h *Handler) linkTTL(expiresIn int) (time.Duration, bool) {
+	ttl := time.Duration(h.cfg.DownloadLinkTTL) * time.Second
+	if expiresIn != 0 {
+		ttl = time.Duration(expiresIn) * time.Second
+	}
+	return ttl, ttl > 0 && ttl <= maxDownloadLinkTTL
+}
+
+// downloadQuery returns the query string of a download link for a job's
+// output, or one of its variants, that is valid until expires
+func (h *Handler) downloadQuery(jobID, variant string, expires time.Time) url.Values {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", h.signer.Sign(downloadResource(jobID, variant), expires))
+	if variant != "" {
+		query.Set("variant", variant)
+	}
+	return query
+}
+
+// Download serves a job's output to holders of a valid signed link
+func (h *Handler) Download(c *gin.Context) {
+	jobID := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "invalid download link",
+		})
+		return
+	}
+
+	variant := c.Query("variant")
+	if err := h.signer.Verify(downloadResource(jobID, variant), expires, c.Query("sig"), h.clock.Now()); err != nil {
+		if errors.Is(err, storage.ErrLinkExpired) {
+			c.JSON(http.StatusGone, gin.H{
+				"error": "download link expired",
+			})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "invalid download link",
+		})
+		return
+	}
+
+	job, err := h.repo.GetJob(jobID)
+	if err != nil || job.Status != jobs.StatusCompleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "output not available",
+		})
+		return
+	}
+	path, name, ok := downloadFile(job, variant)
+	if !ok || !h.localStorage.FileExists(path) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "output not available",
+		})
+		return
+	}
+
+	// Set the type from the extension, as the system's MIME tables may not
+	// know .mkv or .ts
+	if contentType := storage.ContentType(name); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.FileAttachment(path, name)
+}
+
+// downloadFile returns the path and delivered name of a job's output, or
+// of one of its variants: an image variant, the poster or captions shown
+// on its playback page, or "cc-vtt" or "cc-scc" for extracted captions
+func downloadFile(job *jobs.Job, variant string) (path, name string, ok bool) {
+	switch {
+	case variant == "":
+		return job.OutputPath, job.OutputName(), true
+	case variant == posterVariant && job.PosterPath != "":
+		return job.PosterPath, job.PosterName(), true
+	case variant == captionsVariant && job.CaptionsPath != "":
+		return job.CaptionsPath, job.CaptionName(transcoder.CaptionFormatVTT), true
+	}
+	// Captions extracted from the input stand in for uploaded ones
+	for _, caption := range job.ClosedCaps {
+		if variant == "cc-"+caption.Format || variant == captionsVariant && caption.Format == transcoder.CaptionFormatVTT {
+			return job.CaptionPath(caption.Format), job.CaptionName(caption.Format), true
+		}
+	}
+	for _, v := range job.Variants {
+		if v.Name == variant {
+			return job.VariantPath(v.Name), job.VariantName(v.Name), true
+		}
+	}
+	return "", "", false
+}
+
+// remoteFile returns the destination's ID and the delivered name of a
+// job's output, or of one of its variants as for downloadFile, or false if
+// it wasn't uploaded on its own. An HLS output's playlist is left out, as
+// its segments can't be fetched with the same link.
+func remoteFile(job *jobs.Job, variant string) (fileID, name string, ok bool) {
+	switch {
+	case variant == "":
+		return job.DriveFileID, job.OutputName(), job.DriveFileID != "" && job.HLS == nil
+	case variant == posterVariant:
+		return job.PosterFileID, job.PosterName(), job.PosterFileID != ""
+	case variant == captionsVariant && job.CaptionsPath != "":
+		if job.Player == nil {
+			return "", "", false
+		}
+		return job.Player.CaptionsFileID, job.CaptionName(transcoder.CaptionFormatVTT), job.Player.CaptionsFileID != ""
+	}
+	for _, caption := range job.ClosedCaps {
+		if variant == "cc-"+caption.Format || variant == captionsVariant && caption.Format == transcoder.CaptionFormatVTT {
+			return caption.FileID, job.CaptionName(caption.Format), caption.FileID != ""
+		}
+	}
+	for _, v := range job.Variants {
+		if v.Name == variant {
+			return v.FileID, job.VariantName(v.Name), v.FileID != ""
+		}
+	}
+	return "", "", false
+}
+
+// downloadResource is what a download link signs: the job ID, and the
+// variant if the link is for one
+func downloadResource(jobID, variant string) string {
+	if variant == "" {
+		return jobID
+	}
+	return jobID + "/" + variant
+}
+
+// baseURL returns the externally visible origin links should point at
+func (h *Handler) baseURL(c *gin.Context) string {
+	if h.cfg.PublicBaseURL != "" {
+		return strings.TrimRight(h.cfg.PublicBaseURL, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/internal/features"
+)
+
+// ListFeatures returns every experimental feature and whether this request
+// may use it, taking an admin's X-Feature-Flags header into account
+func (h *Handler) ListFeatures(c *gin.Context) {
+	enabled := currentFeatures(c)
+	list := make([]gin.H, 0, len(features.Known))
+	for name, description := range features.Known {
+		list = append(list, gin.H{
+			"name":        name,
+			"description": description,
+			"enabled":     enabled.Enabled(name),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["name"].(string) < list[j]["name"].(string)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"features": list,
+	})
+}
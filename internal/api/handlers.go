@@ -1,113 +1,743 @@
 package api
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/clock"
+	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/email"
+	"github.com/skillcape/transcoder/internal/features"
+	"github.com/skillcape/transcoder/internal/ids"
 	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/metrics"
+	"github.com/skillcape/transcoder/internal/requestid"
 	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/sysstat"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+	"github.com/skillcape/transcoder/internal/webhook"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
+	cfg          *config.Config
+	repo         db.JobRepository
 	localStorage *storage.LocalStorage
 	jobQueue     *jobs.Queue
+	signer       *storage.URLSigner
+	notifier     *webhook.Notifier
+	reloader     Reloader
+	publisher    Publisher
+	reconciler   Reconciler
+	presigner    Presigner
+	uploads      *uploadTracker
+	clock        clock.Clock
+	ids          ids.Generator
+	attaching    sync.Mutex // held from looking for jobs to attach to until the new ones exist
 }
 
-func NewHandler(localStorage *storage.LocalStorage, jobQueue *jobs.Queue) *Handler {
+func NewHandler(cfg *config.Config, repo db.JobRepository, localStorage *storage.LocalStorage, jobQueue *jobs.Queue, notifier *webhook.Notifier) *Handler {
 	return &Handler{
+		cfg:          cfg,
+		repo:         repo,
 		localStorage: localStorage,
 		jobQueue:     jobQueue,
+		signer:       storage.NewURLSigner(cfg.DownloadSigningKey),
+		notifier:     notifier,
+		uploads:      newUploadTracker(),
+		clock:        clock.System,
+		ids:          ids.UUIDs,
 	}
 }
 
-// HealthCheck returns the service health status
+// SetClock replaces the clock that stamps jobs and other records and
+// checks the expiry of signed links
+func (h *Handler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
+// SetIDs replaces the generator of IDs for jobs and other records, random
+// UUIDs by default
+func (h *Handler) SetIDs(generator ids.Generator) {
+	h.ids = generator
+}
+
+// notify sends a lifecycle webhook for a job changed through the API
+func (h *Handler) notify(job *jobs.Job, event string) {
+	var t *tenant.Tenant
+	if job.TenantID != "" {
+		t, _ = db.GetTenant(job.TenantID)
+	}
+	h.notifier.Notify(job, t, event, nil)
+}
+
+// HealthCheck returns the service health status, with the latest resource
+// sample and why dispatch is paused, if it is
 func (h *Handler) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	body := gin.H{
 		"status":    "healthy",
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-	})
+		"timestamp": h.clock.Now().UTC().Format(time.RFC3339),
+	}
+	if sample := sysstat.Latest(); sample != nil {
+		body["resources"] = sample
+	}
+	if reason := h.jobQueue.Paused(); reason != "" {
+		body["dispatch_paused"] = reason
+	}
+	c.JSON(http.StatusOK, body)
 }
 
-// CreateJob handles video upload and job creation
+// Metrics serves the metrics in the Prometheus text format
+func (h *Handler) Metrics(c *gin.Context) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	if err := metrics.Write(c.Writer); err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+	}
+}
+
+// CreateJob handles video upload and job creation. A single "file" part
+// creates one job; "files[]" parts create one job per file, or a single
+// job joining them in order when concat=true.
 func (h *Handler) CreateJob(c *gin.Context) {
-	// Get the uploaded file
-	file, header, err := c.Request.FormFile("file")
+	headers, multi, err := uploadedFiles(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no file uploaded",
+			"error": err.Error(),
 		})
 		return
 	}
-	defer file.Close()
 
-	// Generate job ID
-	jobID := uuid.New().String()
+	// Reject empty, truncated and non-media uploads before spending disk
+	// space on them; they would only fail later in ffprobe
+	containers := make(map[*multipart.FileHeader]string)
+	for _, header := range headers {
+		if err := checkUploadSize(header, int64(h.cfg.MinUploadSize)); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": uploadError(header, multi, err),
+			})
+			return
+		}
+		container, err := sniffUpload(header)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": uploadError(header, multi, err),
+			})
+			return
+		}
+		containers[header] = container
+	}
 
-	// Save the uploaded file
-	inputPath, err := h.localStorage.SaveUpload(jobID, header.Filename, file)
+	req, err := parseCreatePayload(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to save uploaded file",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
-	// Create job record
-	job := &jobs.Job{
-		ID:           jobID,
-		Status:       jobs.StatusPending,
-		InputPath:    inputPath,
-		OutputPath:   h.localStorage.GetOutputPath(jobID),
-		OriginalName: header.Filename,
-		Progress:     0,
-		CreatedAt:    time.Now().UTC(),
-		UpdatedAt:    time.Now().UTC(),
+	cover, err := coverArt(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
-
-	// Save to database
-	if err := db.CreateJob(job); err != nil {
-		h.localStorage.DeleteFile(inputPath)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to create job",
+	captions, err := captionsFile(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	captionsLang := strings.TrimSpace(c.PostForm("captions_language"))
+	if captionsLang != "" && !languageTag.MatchString(captionsLang) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "captions_language must be a language tag such as en or pt-BR",
 		})
 		return
 	}
 
-	// Enqueue the job
-	if err := h.jobQueue.Enqueue(job); err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"error": "job queue is full, please try again later",
+	concat, err := strconv.ParseBool(c.DefaultPostForm("concat", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "concat must be true or false",
+		})
+		return
+	}
+	attach, err := strconv.ParseBool(c.DefaultPostForm("attach", strconv.FormatBool(h.cfg.AttachDuplicates)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "attach must be true or false",
 		})
 		return
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"job": job.ToResponse(),
+	// One job per file, or one job for all of them
+	groups := make([][]*multipart.FileHeader, 0, len(headers))
+	if concat && len(headers) > 1 {
+		for _, header := range headers {
+			if transcoder.IsImage(containers[header]) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": uploadError(header, multi, fmt.Errorf("images can't be concatenated")),
+				})
+				return
+			}
+		}
+		groups = append(groups, headers)
+	} else {
+		for _, header := range headers {
+			groups = append(groups, []*multipart.FileHeader{header})
+		}
+	}
+
+	principal := currentPrincipal(c)
+	update := jobs.UpdateJobRequest(*req)
+	newJobs := make([]*jobs.Job, len(groups))
+	var totalSize int64
+	for i, group := range groups {
+		job := h.newJob(c, group, transcoder.IsImage(containers[group[0]]))
+		if err := h.applyJobUpdate(job, &update, principal.Tenant, currentFeatures(c)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		newJobs[i] = job
+		totalSize += job.InputSize
+	}
+
+	if !h.checkQuota(c, len(newJobs), totalSize) {
+		return
+	}
+
+	// Save every upload before creating any job so a bad file fails the
+	// whole request
+	var saved []string
+	discard := func() {
+		for _, path := range saved {
+			h.localStorage.DeleteFile(path)
+		}
+	}
+	for i, job := range newJobs {
+		hash := sha256.New()
+		for j, header := range groups[i] {
+			// ffprobe can't read HEIC; it is checked when converted
+			inputPath, err := h.saveUpload(c, job, j, header, hash, containers[header] != "heic")
+			if err != nil {
+				discard()
+				status := http.StatusInternalServerError
+				if errors.Is(err, transcoder.ErrNotMedia) {
+					status = http.StatusUnprocessableEntity
+				}
+				c.JSON(status, gin.H{
+					"error": uploadError(header, multi, err),
+				})
+				return
+			}
+			saved = append(saved, inputPath)
+			if len(groups[i]) > 1 {
+				job.InputPaths = append(job.InputPaths, inputPath)
+			}
+			if j == 0 {
+				job.InputPath = inputPath
+			}
+		}
+		job.InputHash = hex.EncodeToString(hash.Sum(nil))
+		if cover != nil {
+			coverPath, err := h.saveAttachment(job, cover, "-cover", "cover art")
+			if err != nil {
+				discard()
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			saved = append(saved, coverPath)
+			job.CoverPath = coverPath
+		}
+		if captions != nil {
+			captionsPath, err := h.saveAttachment(job, captions, "-captions", "captions")
+			if err != nil {
+				discard()
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			saved = append(saved, captionsPath)
+			job.CaptionsPath = captionsPath
+			job.CaptionsLang = captionsLang
+		}
+	}
+
+	// Re-uploads are only pointed out; the client decides what to do,
+	// unless it asked to join a job still encoding the same file
+	h.markDuplicates(c, newJobs)
+	var attached map[*jobs.Job]*jobs.Job
+	if attach {
+		h.attaching.Lock()
+		defer h.attaching.Unlock()
+		attached = h.activeDuplicates(c, newJobs)
+	}
+
+	// Jobs that were never created leave their uploads behind otherwise
+	abandon := func(rest []*jobs.Job) {
+		for _, job := range rest {
+			h.localStorage.CleanupJob(job.UploadedFiles())
+		}
+	}
+
+	created := make([]interface{}, 0, len(newJobs))
+	ids := make([]string, 0, len(newJobs))
+	for i, job := range newJobs {
+		if existing := attached[job]; existing != nil {
+			h.localStorage.CleanupJob(job.UploadedFiles())
+			h.repo.RecordJobEvent(existing.ID, jobs.EventAttached, job.RequestID, "upload of "+job.OriginalName)
+			h.jobQueue.ApplyProgress(existing)
+			created = append(created, jobBody(c, existing, nil))
+			ids = append(ids, existing.ID)
+			continue
+		}
+
+		// Save to database
+		if err := h.repo.CreateJob(job); err != nil {
+			abandon(newJobs[i:])
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to create job",
+				"jobs":  created,
+			})
+			return
+		}
+
+		// Enqueue the job (it stays pending in the database if this fails)
+		if err := h.jobQueue.Enqueue(job); err != nil {
+			abandon(newJobs[i+1:])
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "job queue is full, please try again later",
+				"jobs":  append(created, jobBody(c, job, nil)),
+			})
+			return
+		}
+
+		h.repo.RecordJobEvent(job.ID, jobs.EventCreated, job.RequestID, "")
+		h.notify(job, webhook.EventJobCreated)
+		created = append(created, jobBody(c, job, nil))
+		ids = append(ids, job.ID)
+	}
+	c.Set(createdJobsKey, ids)
+
+	// Nothing new was accepted when every upload joined an existing job
+	status := http.StatusAccepted
+	if len(attached) == len(newJobs) {
+		status = http.StatusOK
+	}
+	if multi {
+		c.JSON(status, gin.H{
+			"jobs": created,
+		})
+		return
+	}
+	c.JSON(status, gin.H{
+		"job": created[0],
 	})
 }
 
+// maxUploadFiles caps how many files a single create request may carry
+const maxUploadFiles = 50
+
+// uploadedFiles returns the request's upload parts: the single "file"
+// part, or the "files[]" parts (multi is true for the latter)
+func uploadedFiles(c *gin.Context) (headers []*multipart.FileHeader, multi bool, err error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, false, fmt.Errorf("no file uploaded")
+	}
+
+	headers = append(form.File["files[]"], form.File["files"]...)
+	if len(headers) > 0 {
+		if len(form.File["file"]) > 0 {
+			return nil, false, fmt.Errorf("send either file or files[], not both")
+		}
+		if len(headers) > maxUploadFiles {
+			return nil, false, fmt.Errorf("at most %d files may be uploaded at once", maxUploadFiles)
+		}
+		return headers, true, nil
+	}
+
+	if len(form.File["file"]) == 0 {
+		return nil, false, fmt.Errorf("no file uploaded")
+	}
+	return form.File["file"][:1], false, nil
+}
+
+// uploadError prefixes err with the file it concerns when several were sent
+func uploadError(header *multipart.FileHeader, multi bool, err error) string {
+	if multi {
+		return header.Filename + ": " + err.Error()
+	}
+	return err.Error()
+}
+
+// checkUploadSize rejects an empty upload, or one smaller than min bytes
+func checkUploadSize(header *multipart.FileHeader, min int64) error {
+	if header.Size == 0 {
+		return fmt.Errorf("file is empty")
+	}
+	if header.Size < min {
+		return fmt.Errorf("file is %d bytes; uploads must be at least %d bytes", header.Size, min)
+	}
+	return nil
+}
+
+// sniffUpload checks an upload's leading bytes for a media container
+func sniffUpload(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to read uploaded file")
+	}
+	defer file.Close()
+
+	sniff := make([]byte, transcoder.SniffHeaderSize)
+	n, _ := io.ReadFull(file, sniff)
+	return transcoder.SniffContainer(sniff[:n])
+}
+
+// maxCoverSize bounds cover art uploaded with a job
+const maxCoverSize = 10 << 20
+
+// coverArt returns the request's "cover" part, if it has one. It must be a
+// PNG or JPEG image.
+func coverArt(c *gin.Context) (*multipart.FileHeader, error) {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["cover"]) == 0 {
+		return nil, nil
+	}
+	header := form.File["cover"][0]
+	if header.Size > maxCoverSize {
+		return nil, fmt.Errorf("cover art must be at most %d MB", maxCoverSize>>20)
+	}
+	if container, err := sniffUpload(header); err != nil || (container != "png" && container != "jpeg") {
+		return nil, fmt.Errorf("cover art must be a PNG or JPEG image")
+	}
+	return header, nil
+}
+
+// maxCaptionsSize bounds the captions uploaded with a job
+const maxCaptionsSize = 1 << 20
+
+// languageTag matches the BCP 47 language tags captions may be labelled
+// with, such as en, pt-BR or zh-Hant
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// captionsFile returns the request's "captions" part, if it has one. It
+// must be a WebVTT file.
+func captionsFile(c *gin.Context) (*multipart.FileHeader, error) {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["captions"]) == 0 {
+		return nil, nil
+	}
+	header := form.File["captions"][0]
+	if header.Size > maxCaptionsSize {
+		return nil, fmt.Errorf("captions must be at most %d MB", maxCaptionsSize>>20)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read captions")
+	}
+	defer file.Close()
+	start := make([]byte, 9)
+	n, _ := io.ReadFull(file, start)
+	if !bytes.HasPrefix(bytes.TrimPrefix(start[:n], []byte("\xEF\xBB\xBF")), []byte("WEBVTT")) {
+		return nil, fmt.Errorf("captions must be a WebVTT file")
+	}
+	return header, nil
+}
+
+// saveAttachment stores a copy of a file uploaded alongside job's inputs,
+// such as its cover art, as the job ID plus suffix
+func (h *Handler) saveAttachment(job *jobs.Job, header *multipart.FileHeader, suffix, what string) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s", what)
+	}
+	defer file.Close()
+	path, err := h.localStorage.SaveUpload(job.ID+suffix, header.Filename, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to save %s", what)
+	}
+	return path, nil
+}
+
+// newJob builds a pending job for one upload, or for several concatenated
+// in order. Image jobs make resized variants in IMAGE_FORMAT instead of
+// a transcode.
+func (h *Handler) newJob(c *gin.Context, headers []*multipart.FileHeader, image bool) *jobs.Job {
+	jobID := h.ids.NewID()
+	principal := currentPrincipal(c)
+	now := h.clock.Now().UTC()
+
+	job := &jobs.Job{
+		ID:           jobID,
+		Status:       jobs.StatusPending,
+		OriginalName: headers[0].Filename,
+		NameTemplate: h.cfg.OutputNameTemplate,
+		Owner:        principal.Subject,
+		TenantID:     principal.Tenant,
+		RequestID:    currentRequestID(c),
+		Progress:     0,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if image {
+		job.MediaType, job.OutputExt = jobs.MediaImage, h.cfg.ImageSettings().Ext()
+	}
+	job.OutputPath = h.localStorage.GetOutputPath(jobID, job.OutputFileExt())
+	for _, header := range headers {
+		job.InputSize += header.Size
+		if len(headers) > 1 {
+			job.InputNames = append(job.InputNames, header.Filename)
+		}
+	}
+	return job
+}
+
+// saveUpload stores the index'th input of a job, writing it to hash as
+// well, and, when enabled and probe is set, checks it with ffprobe. The
+// file is removed again if the check rejects it; the first input's probe
+// result is kept on the job.
+func (h *Handler) saveUpload(c *gin.Context, job *jobs.Job, index int, header *multipart.FileHeader, hash io.Writer, probe bool) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to read uploaded file")
+	}
+	defer file.Close()
+
+	name := job.ID
+	if index > 0 {
+		name = fmt.Sprintf("%s-%d", job.ID, index)
+	}
+	inputPath, err := h.localStorage.SaveUpload(name, header.Filename, io.TeeReader(file, hash))
+	if err != nil {
+		return "", fmt.Errorf("failed to save uploaded file")
+	}
+
+	if h.cfg.ProbeUploads && probe {
+		info, err := transcoder.ProbeMedia(c.Request.Context(), inputPath, probeTimeout)
+		if err != nil {
+			if errors.Is(err, transcoder.ErrNotMedia) {
+				h.localStorage.DeleteFile(inputPath)
+				return "", err
+			}
+			requestid.Logf(c.Request.Context(), "Warning: could not inspect upload for job %s: %v", job.ID, err)
+		} else if index == 0 {
+			job.InputProbe = info
+		}
+	}
+	return inputPath, nil
+}
+
+// markDuplicates sets DuplicateOf on new jobs whose inputs match an
+// existing job the caller can see, or an earlier job of the same request.
+// The oldest such job is named.
+func (h *Handler) markDuplicates(c *gin.Context, newJobs []*jobs.Job) {
+	principal := currentPrincipal(c)
+	seen := make(map[string]string, len(newJobs))
+	for _, job := range newJobs {
+		if id, ok := seen[job.InputHash]; ok {
+			job.DuplicateOf = id
+			continue
+		}
+		filter := db.JobFilter{InputHash: job.InputHash, SortBy: "created_at"}
+		if !principal.IsGlobal() {
+			filter.TenantID = &principal.Tenant
+		}
+		if !principal.SeesAllJobs() {
+			filter.Owner = principal.Subject
+		}
+		earlier, _, err := h.repo.ListJobs(filter, 1, 0)
+		if err != nil {
+			requestid.Logf(c.Request.Context(), "Warning: could not look for duplicates of job %s: %v", job.ID, err)
+		}
+		if len(earlier) > 0 {
+			job.DuplicateOf = earlier[0].ID
+			seen[job.InputHash] = job.DuplicateOf
+		} else {
+			seen[job.InputHash] = job.ID
+		}
+	}
+}
+
+// activeDuplicates finds, for each new job, a pending or processing job the
+// caller can see with the same input, preset, renditions and destination,
+// which would make the same output. The new job can join it rather than
+// encode the file again, as when a client retries an upload it thinks
+// failed. h.attaching must be held until the other new jobs are created.
+func (h *Handler) activeDuplicates(c *gin.Context, newJobs []*jobs.Job) map[*jobs.Job]*jobs.Job {
+	principal := currentPrincipal(c)
+	attached := make(map[*jobs.Job]*jobs.Job)
+	for _, job := range newJobs {
+		filter := db.JobFilter{
+			InputHash: job.InputHash,
+			Statuses:  []jobs.JobStatus{jobs.StatusPending, jobs.StatusProcessing, jobs.StatusRetrying},
+			SortBy:    "created_at",
+		}
+		if !principal.IsGlobal() {
+			filter.TenantID = &principal.Tenant
+		}
+		if !principal.SeesAllJobs() {
+			filter.Owner = principal.Subject
+		}
+		active, _, err := h.repo.ListJobs(filter, maxAttachCandidates, 0)
+		if err != nil {
+			requestid.Logf(c.Request.Context(), "Warning: could not look for active duplicates of job %s: %v", job.ID, err)
+			continue
+		}
+		for i := range active {
+			existing := &active[i]
+			if existing.Preset == job.Preset && slices.Equal(existing.Renditions, job.Renditions) &&
+				existing.Destination == job.Destination && existing.Encoding == job.Encoding && existing.Packaging == job.Packaging {
+				attached[job] = existing
+				break
+			}
+		}
+	}
+	return attached
+}
+
+// maxAttachCandidates bounds the active jobs of one input compared with a
+// new job
+const maxAttachCandidates = 20
+
+// probeTimeout bounds the ffprobe check run on each upload
+const probeTimeout = 15 * time.Second
+
+// maxPayloadSize bounds the JSON "payload" part of a job upload
+const maxPayloadSize = 64 << 10
+
+// parseCreatePayload reads the optional "payload" part of a job upload,
+// sent either as a plain form field or as a file part. The legacy "preset"
+// form field is used when the payload names no preset.
+func parseCreatePayload(c *gin.Context) (*jobs.CreateJobRequest, error) {
+	req := &jobs.CreateJobRequest{}
+
+	raw := []byte(c.PostForm("payload"))
+	if len(raw) == 0 {
+		if part, _, err := c.Request.FormFile("payload"); err == nil {
+			defer part.Close()
+			raw, err = io.ReadAll(io.LimitReader(part, maxPayloadSize+1))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read payload")
+			}
+		}
+	}
+	if len(raw) > maxPayloadSize {
+		return nil, fmt.Errorf("payload is too large")
+	}
+
+	if len(raw) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(req); err != nil {
+			return nil, fmt.Errorf("invalid payload: %v", err)
+		}
+	}
+
+	if req.Preset == nil {
+		if preset, ok := c.GetPostForm("preset"); ok {
+			req.Preset = &preset
+		}
+	}
+	return req, nil
+}
+
 // GetJob returns the status of a specific job
 func (h *Handler) GetJob(c *gin.Context) {
 	jobID := c.Param("id")
 
-	job, err := db.GetJob(jobID)
-	if err != nil {
+	job, err := h.repo.GetJob(jobID)
+	if err != nil || !currentPrincipal(c).CanView(job.TenantID, job.Owner) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "job not found",
 		})
 		return
 	}
 
+	h.jobQueue.ApplyProgress(job)
+
+	// v2 embeds the event history
+	var events []jobs.JobEvent
+	if apiVersion(c) >= 2 {
+		events, _ = h.repo.ListJobEvents(job.ID)
+	}
+
+	// Pollers revalidate with If-None-Match and get a bodiless 304 while
+	// the job is unchanged
+	etag := jobETag(job, apiVersion(c), len(events))
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"job": job.ToResponse(),
+		"job": jobBody(c, job, events),
 	})
 }
 
-// ListJobs returns a paginated list of all jobs
+// jobBody renders a job for the API version being served; v2 responses
+// embed events when given
+func jobBody(c *gin.Context, job *jobs.Job, events []jobs.JobEvent) interface{} {
+	if apiVersion(c) < 2 {
+		return job.ToResponse()
+	}
+	return job.ToResource(events)
+}
+
+// jobETag identifies one representation of a job. It changes whenever the
+// job is saved (every status and progress change bumps UpdatedAt), and in
+// v2 when an event is recorded.
+func jobETag(job *jobs.Job, version, events int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%d|v%d|%d",
+		job.ID, job.UpdatedAt.UnixNano(), job.Status, job.Progress, version, events)))
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison RFC 9110 specifies for it
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ListJobs returns a filtered, sorted, paginated list of jobs
 func (h *Handler) ListJobs(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
@@ -122,7 +752,23 @@ func (h *Handler) ListJobs(c *gin.Context) {
 		offset = 0
 	}
 
-	jobList, total, err := db.ListJobs(limit, offset)
+	filter, err := parseJobFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	principal := currentPrincipal(c)
+	if !principal.IsGlobal() {
+		filter.TenantID = &principal.Tenant
+	}
+	if !principal.SeesAllJobs() {
+		filter.Owner = principal.Subject
+	}
+
+	jobList, total, err := h.repo.ListJobs(filter, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to list jobs",
@@ -131,9 +777,10 @@ func (h *Handler) ListJobs(c *gin.Context) {
 	}
 
 	// Convert to response format
-	responses := make([]jobs.JobResponse, len(jobList))
-	for i, job := range jobList {
-		responses[i] = job.ToResponse()
+	responses := make([]interface{}, len(jobList))
+	for i := range jobList {
+		h.jobQueue.ApplyProgress(&jobList[i])
+		responses[i] = jobBody(c, &jobList[i], nil)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -144,37 +791,564 @@ func (h *Handler) ListJobs(c *gin.Context) {
 	})
 }
 
+// UpdateJob changes priority, webhook, preset, labels, or schedule of a pending job
+func (h *Handler) UpdateJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	var req jobs.UpdateJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	principal := currentPrincipal(c)
+	job, err := h.repo.GetJob(jobID)
+	if err != nil || !principal.CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	if !principal.CanModify(job.TenantID, job.Owner) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "not allowed to modify this job",
+		})
+		return
+	}
+
+	if job.Status != jobs.StatusPending {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "job can only be modified while pending",
+		})
+		return
+	}
+
+	if err := h.applyJobUpdate(job, &req, principal.Tenant, currentFeatures(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	job.UpdatedAt = h.clock.Now().UTC()
+
+	if err := h.repo.UpdatePendingJob(job); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "job can only be modified while pending",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to update job",
+		})
+		return
+	}
+
+	h.jobQueue.Reschedule(job.ID, job.Priority, job.ScheduledAt)
+	h.repo.RecordJobEvent(job.ID, jobs.EventUpdated, currentRequestID(c), "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"job": jobBody(c, job, nil),
+	})
+}
+
+// PrioritizeJob moves a pending job to the front of the queue, ahead of
+// every other job however high its priority, for support to rush one
+// through a bulk import. A job scheduled for later is made ready at once.
+func (h *Handler) PrioritizeJob(c *gin.Context) {
+	principal := currentPrincipal(c)
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !principal.CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	if !principal.CanModify(job.TenantID, job.Owner) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "not allowed to modify this job",
+		})
+		return
+	}
+
+	if job.Status != jobs.StatusPending {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "only pending jobs can be prioritized",
+		})
+		return
+	}
+
+	now := h.clock.Now().UTC()
+	job.BoostedAt = &now
+	job.ScheduledAt = nil
+	job.UpdatedAt = now
+	if err := h.repo.UpdatePendingJob(job); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "only pending jobs can be prioritized",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to update job",
+		})
+		return
+	}
+
+	h.jobQueue.Boost(job.ID, now)
+	h.repo.RecordJobEvent(job.ID, jobs.EventPrioritized, currentRequestID(c), "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"job": jobBody(c, job, nil),
+	})
+}
+
+// GetJobEvents returns a job's history
+func (h *Handler) GetJobEvents(c *gin.Context) {
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !currentPrincipal(c).CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	events, err := h.repo.ListJobEvents(job.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list job events",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+	})
+}
+
+// applyJobUpdate validates the request and copies the provided fields onto
+// job. enabled holds the experimental features the caller may use.
+func (h *Handler) applyJobUpdate(job *jobs.Job, req *jobs.UpdateJobRequest, tenantID string, enabled features.Set) error {
+	if req.Priority != nil {
+		if *req.Priority < jobs.MinPriority || *req.Priority > jobs.MaxPriority {
+			return fmt.Errorf("priority must be between %d and %d", jobs.MinPriority, jobs.MaxPriority)
+		}
+		job.Priority = *req.Priority
+	}
+
+	if req.WebhookURL != nil {
+		if err := validateWebhookURL(*req.WebhookURL); err != nil {
+			return err
+		}
+		job.WebhookURL = *req.WebhookURL
+	}
+
+	if req.Recipients != nil {
+		recipients, err := email.ValidateRecipients(*req.Recipients)
+		if err != nil {
+			return err
+		}
+		job.NotifyEmails = recipients
+	}
+
+	if req.HookEvents != nil {
+		events := webhook.NormalizeEvents(*req.HookEvents)
+		if err := webhook.ValidateEvents(events); err != nil {
+			return err
+		}
+		job.HookEvents = events
+	}
+
+	if req.Preset != nil {
+		preset := strings.TrimSpace(*req.Preset)
+		if err := checkPreset(tenantID, preset, enabled); err != nil {
+			return err
+		}
+		job.Preset = preset
+	}
+
+	if req.Renditions != nil {
+		renditions, err := checkRenditions(tenantID, *req.Renditions, job.MediaType, enabled)
+		if err != nil {
+			return err
+		}
+		job.Renditions = renditions
+	}
+
+	if req.Parallelism != nil {
+		if *req.Parallelism < 0 || *req.Parallelism > maxRenditions {
+			return fmt.Errorf("rendition_parallelism must be between 0 and %d", maxRenditions)
+		}
+		job.Parallelism = *req.Parallelism
+	}
+
+	if req.Encoding != nil {
+		encoding := strings.TrimSpace(*req.Encoding)
+		if err := transcoder.ValidateEncoding(encoding); err != nil {
+			return err
+		}
+		job.Encoding = encoding
+	}
+
+	if req.Packaging != nil {
+		packaging := strings.TrimSpace(*req.Packaging)
+		if err := transcoder.ValidatePackaging(packaging); err != nil {
+			return err
+		}
+		if transcoder.IsHLS(packaging) && job.MediaType == jobs.MediaImage {
+			return fmt.Errorf("images can't be packaged for HLS")
+		}
+		job.Packaging = packaging
+	}
+	// The output's stream in an HLS ladder is named main
+	if transcoder.IsHLS(job.Packaging) && job.Renditions.Contains(jobs.HLSMainStream) {
+		return fmt.Errorf("HLS jobs can't have a rendition named %s", jobs.HLSMainStream)
+	}
+	if transcoder.IsHLS(job.Packaging) && (req.Packaging != nil || req.Preset != nil || req.Renditions != nil) {
+		if err := checkHLSPresets(tenantID, job); err != nil {
+			return err
+		}
+	}
+
+	if req.Destination != nil {
+		destination := strings.TrimSpace(*req.Destination)
+		if err := h.checkDestination(destination); err != nil {
+			return err
+		}
+		job.Destination = destination
+	}
+
+	if req.Labels != nil {
+		labels, err := normalizeLabels(*req.Labels)
+		if err != nil {
+			return err
+		}
+		job.Labels = labels
+	}
+
+	if req.OutputName != nil {
+		name := strings.TrimSpace(*req.OutputName)
+		if err := jobs.ValidateOutputName(name); err != nil {
+			return err
+		}
+		if name == "" {
+			name = h.cfg.OutputNameTemplate
+		}
+		job.NameTemplate = name
+	}
+
+	if req.Metadata != nil {
+		if err := req.Metadata.Validate(); err != nil {
+			return err
+		}
+		job.Metadata = req.Metadata
+		if job.Metadata.IsEmpty() {
+			job.Metadata = nil
+		}
+	}
+
+	if req.ScheduledAt != nil {
+		if *req.ScheduledAt == "" {
+			job.ScheduledAt = nil
+		} else {
+			t, err := time.Parse(time.RFC3339, *req.ScheduledAt)
+			if err != nil {
+				return fmt.Errorf("scheduled_at must be an RFC 3339 timestamp")
+			}
+			t = t.UTC()
+			job.ScheduledAt = &t
+		}
+	}
+
+	return nil
+}
+
+// validateWebhookURL accepts an empty string (use the default) or an absolute http(s) URL
+func validateWebhookURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook_url must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// Label limits keep the labels column small and filterable
+const (
+	maxLabels      = 20
+	maxLabelLength = 64
+)
+
+// normalizeLabels trims and de-duplicates labels, rejecting oversized input
+func normalizeLabels(labels []string) (jobs.StringList, error) {
+	if len(labels) > maxLabels {
+		return nil, fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+	result := jobs.StringList{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || result.Contains(label) {
+			continue
+		}
+		if len(label) > maxLabelLength {
+			return nil, fmt.Errorf("labels must be at most %d characters", maxLabelLength)
+		}
+		result = append(result, label)
+	}
+	return result, nil
+}
+
 // DeleteJob cancels or deletes a job
 func (h *Handler) DeleteJob(c *gin.Context) {
 	jobID := c.Param("id")
 
-	job, err := db.GetJob(jobID)
-	if err != nil {
+	principal := currentPrincipal(c)
+	job, err := h.repo.GetJob(jobID)
+	if err != nil || !principal.CanView(job.TenantID, job.Owner) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "job not found",
 		})
 		return
 	}
 
-	// If job is still running, mark it as cancelled
-	if job.Status == jobs.StatusPending || job.Status == jobs.StatusProcessing {
-		job.Status = jobs.StatusCancelled
-		job.UpdatedAt = time.Now().UTC()
-		db.UpdateJob(job)
+	if !principal.CanDelete(job.TenantID, job.Owner) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "only admins can delete other users' jobs",
+		})
+		return
+	}
+
+	// If job is still running, stop it and mark it as cancelled
+	if job.Status.Active() {
+		h.jobQueue.Cancel(jobID)
+		cancelled, err := h.cancelJob(c, job)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to cancel job",
+			})
+			return
+		}
+		if cancelled {
+			h.repo.RecordJobEvent(jobID, jobs.EventCancelled, currentRequestID(c), "")
+			h.notify(job, webhook.EventJobCancelled)
+		}
 	}
 
 	// Clean up files
-	h.localStorage.CleanupJob(job.InputPath, job.OutputPath)
+	h.localStorage.CleanupJob(job.UploadedFiles(), job.OutputFiles()...)
 
 	// Soft delete from database
-	if err := db.DeleteJob(jobID); err != nil {
+	if err := h.repo.DeleteJob(jobID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to delete job",
 		})
 		return
 	}
 
+	h.repo.RecordJobEvent(jobID, jobs.EventDeleted, currentRequestID(c), "")
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "job deleted",
 	})
 }
+
+// cancelJob marks an active job cancelled, reporting false if it finished
+// first. If a worker saves the job at the same moment the update is stale,
+// so it is retried on the fresh row.
+func (h *Handler) cancelJob(c *gin.Context, job *jobs.Job) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		if err := job.Transition(jobs.StatusCancelled, currentPrincipal(c).Subject, currentRequestID(c), h.clock); err != nil {
+			return false, err
+		}
+		job.UpdatedAt = h.clock.Now().UTC()
+		err := h.repo.UpdateJob(job)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, db.ErrStaleJob) || attempt == 3 {
+			return false, err
+		}
+
+		fresh, err := h.repo.GetJob(job.ID)
+		if err != nil {
+			return false, err
+		}
+		*job = *fresh
+		if !job.Status.Active() {
+			return false, nil
+		}
+	}
+}
+
+// maxBulkJobs caps how many jobs a single bulk request may touch
+const maxBulkJobs = 1000
+
+// BulkJobs cancels or deletes many jobs in one transaction
+func (h *Handler) BulkJobs(c *gin.Context) {
+	var req jobs.BulkJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	action := db.BulkAction(req.Action)
+	if action != db.BulkCancel && action != db.BulkDelete {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "action must be cancel or delete",
+		})
+		return
+	}
+
+	var filter *db.JobFilter
+	switch {
+	case len(req.IDs) > 0 && req.Filter != nil:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "specify either ids or filter, not both",
+		})
+		return
+	case len(req.IDs) > maxBulkJobs:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("at most %d ids per request", maxBulkJobs),
+		})
+		return
+	case len(req.IDs) == 0:
+		if req.Filter == nil || req.Filter.IsEmpty() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "ids or a non-empty filter is required",
+			})
+			return
+		}
+		f, err := bulkFilterToJobFilter(req.Filter)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		filter = &f
+	}
+
+	// Callers never see jobs outside their tenant (or, for submitters,
+	// other callers' jobs), even when selecting by ID
+	principal := currentPrincipal(c)
+	if filter == nil {
+		filter = &db.JobFilter{}
+	}
+	if !principal.IsGlobal() {
+		filter.TenantID = &principal.Tenant
+	}
+	if !principal.SeesAllJobs() {
+		filter.Owner = principal.Subject
+	}
+	allowed := func(job *jobs.Job) bool {
+		if action == db.BulkDelete {
+			return principal.CanDelete(job.TenantID, job.Owner)
+		}
+		return principal.CanModify(job.TenantID, job.Owner)
+	}
+
+	results, changed, err := h.repo.BulkUpdateJobs(action, req.IDs, filter, maxBulkJobs, principal.Subject, currentRequestID(c), allowed, h.clock)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "bulk operation failed",
+		})
+		return
+	}
+
+	// Stop running encodes and release files once the transaction has committed
+	event := jobs.EventCancelled
+	if action == db.BulkDelete {
+		event = jobs.EventDeleted
+	}
+	for i, job := range changed {
+		h.jobQueue.Cancel(job.ID)
+		h.localStorage.CleanupJob(job.UploadedFiles(), job.OutputFiles()...)
+		h.repo.RecordJobEvent(job.ID, event, currentRequestID(c), "bulk")
+		if action == db.BulkCancel {
+			h.notify(&changed[i], webhook.EventJobCancelled)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"action":   action,
+		"affected": len(changed),
+		"results":  results,
+	})
+}
+
+// bulkFilterToJobFilter validates a bulk request filter
+func bulkFilterToJobFilter(f *jobs.BulkJobFilter) (db.JobFilter, error) {
+	filter := db.JobFilter{
+		CreatedAfter:  f.CreatedAfter,
+		CreatedBefore: f.CreatedBefore,
+		NameContains:  f.OriginalName,
+		Preset:        f.Preset,
+		Label:         f.Label,
+	}
+	for _, s := range f.Status {
+		status, ok := jobs.ParseStatus(s)
+		if !ok {
+			return filter, fmt.Errorf("invalid status %q", s)
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	return filter, nil
+}
+
+// parseJobFilter builds a job filter from the list query parameters
+func parseJobFilter(c *gin.Context) (db.JobFilter, error) {
+	filter := db.JobFilter{
+		NameContains: c.Query("original_name"),
+		Preset:       c.Query("preset"),
+		Label:        c.Query("label"),
+		InputHash:    c.Query("input_hash"),
+		SortBy:       c.DefaultQuery("sort", "created_at"),
+		SortDesc:     true,
+	}
+
+	if statuses := c.Query("status"); statuses != "" {
+		for _, s := range strings.Split(statuses, ",") {
+			status, ok := jobs.ParseStatus(strings.TrimSpace(s))
+			if !ok {
+				return filter, fmt.Errorf("invalid status %q", s)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	for param, target := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			*target = &t
+		}
+	}
+
+	if !db.SortableJobColumns[filter.SortBy] {
+		return filter, fmt.Errorf("invalid sort field %q", filter.SortBy)
+	}
+
+	switch order := c.DefaultQuery("order", "desc"); order {
+	case "asc":
+		filter.SortDesc = false
+	case "desc":
+		filter.SortDesc = true
+	default:
+		return filter, fmt.Errorf("order must be asc or desc")
+	}
+
+	return filter, nil
+}
@@ -1,18 +1,127 @@
 package api
 
 import (
+	"crypto/subtle"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/errreport"
+	"github.com/skillcape/transcoder/internal/features"
+	"github.com/skillcape/transcoder/internal/requestid"
 )
 
-// APIKeyAuth validates the API key from the X-API-Key header
-func APIKeyAuth(apiKey string) gin.HandlerFunc {
+// Gin context keys set by Authenticate
+const (
+	principalKey = "principal"  // the authenticated *auth.Principal
+	apiKeyKey    = "api_key"    // the stored *auth.APIKey, for callers using one
+	requestIDKey = "request_id" // the request ID set by RequestID
+	versionKey   = "api_version"
+	featuresKey  = "features" // the features.Set enabled by FeatureFlags
+)
+
+// FeatureFlagsHeader lets admins turn experimental features on or off for
+// a single request, e.g. "av1" or "-av1"
+const FeatureFlagsHeader = "X-Feature-Flags"
+
+// APIVersion records which API version a route group serves
+func APIVersion(version int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(versionKey, version)
+		c.Next()
+	}
+}
+
+// apiVersion returns the version set by APIVersion (1 if unset)
+func apiVersion(c *gin.Context) int {
+	if version := c.GetInt(versionKey); version > 0 {
+		return version
+	}
+	return 1
+}
+
+// RequestID accepts the caller's X-Request-ID (or generates one), echoes it
+// in the response, and makes it available to handlers and log lines
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestid.Normalize(c.GetHeader(requestid.Header))
+		c.Set(requestIDKey, id)
+		c.Header(requestid.Header, id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// currentRequestID returns the ID set by RequestID
+func currentRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// Authenticate accepts the bootstrap API_KEY, a stored API key, or, when a
+// JWT verifier is configured, an "Authorization: Bearer <token>" header.
+// Bearer tokens without a role claim get defaultJWTRole.
+// The API is open only until some authentication exists: an API_KEY, a
+// JWT verifier or an issued key. Once closed it stays closed, so a rotated
+// key never leaves the API open.
+func Authenticate(bootstrapKey *auth.BootstrapKey, verifier *auth.JWTVerifier, defaultJWTRole string) gin.HandlerFunc {
+	var closed atomic.Bool
+	isOpen := func() bool {
+		if closed.Load() {
+			return false
+		}
+		if bootstrapKey.Get() != "" || verifier != nil {
+			closed.Store(true)
+			return false
+		}
+		// A failed lookup fails closed, without latching
+		issued, err := db.HasAPIKeys()
+		if err != nil {
+			return false
+		}
+		if issued {
+			closed.Store(true)
+		}
+		return !issued
+	}
 	return func(c *gin.Context) {
-		if apiKey == "" {
-			// No API key configured, skip authentication
+		if isOpen() {
+			// No authentication configured, everyone acts as admin
+			c.Set(principalKey, &auth.Principal{Subject: "anonymous", Role: auth.RoleAdmin, Method: auth.MethodNone})
+			c.Next()
+			return
+		}
+
+		if bearer := c.GetHeader("Authorization"); verifier != nil && strings.HasPrefix(bearer, "Bearer ") {
+			principal, err := verifier.Authenticate(c.Request.Context(), strings.TrimPrefix(bearer, "Bearer "))
+			if err != nil {
+				message := "invalid token"
+				if errors.Is(err, auth.ErrTokenExpired) {
+					message = "token expired"
+				}
+				requestid.Logf(c.Request.Context(), "JWT rejected: %v", err)
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": message,
+				})
+				return
+			}
+			if principal.Role == "" {
+				principal.Role = defaultJWTRole
+			}
+			if !auth.ValidRole(principal.Role) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "unknown role",
+				})
+				return
+			}
+			c.Set(principalKey, principal)
 			c.Next()
 			return
 		}
@@ -25,17 +134,115 @@ func APIKeyAuth(apiKey string) gin.HandlerFunc {
 			return
 		}
 
-		if providedKey != apiKey {
+		if apiKey := bootstrapKey.Get(); apiKey != "" && subtle.ConstantTimeCompare([]byte(providedKey), []byte(apiKey)) == 1 {
+			c.Set(principalKey, &auth.Principal{Subject: "api-key", Role: auth.RoleAdmin, Method: auth.MethodAPIKey})
+			c.Next()
+			return
+		}
+
+		key, err := db.GetActiveAPIKeyByHash(auth.HashKey(providedKey))
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"error": "invalid API key",
 			})
 			return
 		}
 
+		// Record usage at most once a minute to avoid a write per request
+		if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > time.Minute {
+			db.TouchAPIKey(key.ID)
+		}
+
+		c.Set(principalKey, key.Principal())
+		c.Set(apiKeyKey, key)
+		c.Next()
+	}
+}
+
+// RequireRole rejects callers that hold none of the given roles
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentPrincipal(c).HasRole(roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "insufficient permissions",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireGlobal rejects callers bound to a tenant
+func RequireGlobal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentPrincipal(c).IsGlobal() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "insufficient permissions",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentPrincipal returns the caller set by Authenticate
+func currentPrincipal(c *gin.Context) *auth.Principal {
+	if value, ok := c.Get(principalKey); ok {
+		if principal, ok := value.(*auth.Principal); ok {
+			return principal
+		}
+	}
+	return &auth.Principal{Subject: "anonymous", Method: auth.MethodNone}
+}
+
+// FeatureFlags settles which experimental features a request may use:
+// those in FEATURES, adjusted by an admin's X-Feature-Flags header. It
+// must run after Authenticate.
+func FeatureFlags(enabled features.Set) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		set := enabled
+		if header := c.GetHeader(FeatureFlagsHeader); header != "" {
+			if !currentPrincipal(c).HasRole(auth.RoleAdmin) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "only admins may set " + FeatureFlagsHeader,
+				})
+				return
+			}
+			override, err := enabled.Override(header)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			set = override
+		}
+		c.Set(featuresKey, set)
 		c.Next()
 	}
 }
 
+// currentFeatures returns the features set by FeatureFlags
+func currentFeatures(c *gin.Context) features.Set {
+	if value, ok := c.Get(featuresKey); ok {
+		if set, ok := value.(features.Set); ok {
+			return set
+		}
+	}
+	return features.Set{}
+}
+
+// currentAPIKey returns the stored API key the caller authenticated with,
+// or nil for the bootstrap key, JWT callers, and unauthenticated setups
+func currentAPIKey(c *gin.Context) *auth.APIKey {
+	if value, ok := c.Get(apiKeyKey); ok {
+		if key, ok := value.(*auth.APIKey); ok {
+			return key
+		}
+	}
+	return nil
+}
+
 // RequestLogger logs incoming requests
 func RequestLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -54,24 +261,65 @@ func RequestLogger() gin.HandlerFunc {
 			path = path + "?" + raw
 		}
 
-		log.Printf("%s | %3d | %13v | %15s | %-7s %s",
+		log.Printf("%s | %3d | %13v | %15s | %s | %-7s %s",
 			time.Now().Format("2006/01/02 - 15:04:05"),
 			statusCode,
 			latency,
 			clientIP,
+			currentRequestID(c),
 			method,
 			path,
 		)
 	}
 }
 
-// CORS configures Cross-Origin Resource Sharing
-func CORS() gin.HandlerFunc {
+// CORSConfig controls which browser origins may call the API
+type CORSConfig struct {
+	AllowedOrigins   []string // exact origins, "*", or "https://*.example.com"
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+// CORS configures Cross-Origin Resource Sharing. Allowed origins are
+// echoed back individually; the wildcard is only sent without credentials,
+// since browsers reject credentialed responses to "*".
+func CORS(cfg CORSConfig) gin.HandlerFunc {
+	wildcard := false
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			wildcard = true
+		}
+	}
+	if wildcard && cfg.AllowCredentials {
+		log.Println("Warning: CORS credentials are not allowed with origin \"*\"; list origins explicitly")
+		cfg.AllowCredentials = false
+	}
+
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(cfg.MaxAge)
+
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, X-API-Key")
-		c.Header("Access-Control-Max-Age", "86400")
+		origin := c.GetHeader("Origin")
+		if !wildcard {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+
+		if origin != "" && (wildcard || originAllowed(cfg.AllowedOrigins, origin)) {
+			if wildcard {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.Header("Access-Control-Max-Age", maxAge)
+		}
 
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -82,12 +330,59 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+// originAllowed matches an Origin header against the allowlist. An entry
+// like "https://*.example.com" matches any subdomain but not the apex.
+func originAllowed(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if entry == origin {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(entry, "://*."); ok {
+			prefix := scheme + "://"
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Timeouts overrides the server-wide read and write deadlines for a route,
+// measured from when the route starts. A zero duration removes the
+// deadline, e.g. for responses streamed for as long as the client reads.
+func Timeouts(read, write time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		now := time.Now()
+
+		// Errors mean the writer doesn't support deadlines (e.g. in tests);
+		// the server defaults then stay in place
+		rc.SetReadDeadline(deadline(now, read))
+		rc.SetWriteDeadline(deadline(now, write))
+		c.Next()
+	}
+}
+
+// deadline returns now+timeout, or the zero time (no deadline) for 0
+func deadline(now time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
+
 // Recovery recovers from panics and returns a 500 error
 func Recovery() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
+				requestid.Logf(c.Request.Context(), "Panic recovered: %v", err)
+				errreport.CapturePanic(err, map[string]string{
+					"request_id": currentRequestID(c),
+					"route":      c.Request.Method + " " + c.FullPath(),
+				})
 				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
 					"error": "internal server error",
 				})
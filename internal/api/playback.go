@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/storage"
+)
+
+// The download variants a playback page shows besides the output
+const (
+	posterVariant   = "poster"
+	captionsVariant = "captions"
+)
+
+// CreatePlaybackLink issues a time-limited signed URL for a web page that
+// plays a completed job's output, with its poster and captions
+func (h *Handler) CreatePlaybackLink(c *gin.Context) {
+	if !h.cfg.PlaybackPages {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "playback pages are disabled",
+		})
+		return
+	}
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !currentPrincipal(c).CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	var req struct {
+		ExpiresIn int `json:"expires_in"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid request body",
+			})
+			return
+		}
+	}
+	ttl, ok := h.linkTTL(req.ExpiresIn)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "expires_in must be between 1 and 604800 seconds",
+		})
+		return
+	}
+
+	if job.Status != jobs.StatusCompleted {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "job is not completed",
+		})
+		return
+	}
+	if job.MediaType == jobs.MediaImage {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "image jobs have no playback page",
+		})
+		return
+	}
+	if !h.localStorage.FileExists(job.OutputPath) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "output is not stored on this server",
+		})
+		return
+	}
+
+	expires := h.clock.Now().Add(ttl).UTC().Truncate(time.Second)
+	query := h.downloadQuery(job.ID, "", expires)
+	query.Set("sig", h.signer.Sign(playResource(job.ID), expires))
+	c.JSON(http.StatusOK, gin.H{
+		"url":        h.baseURL(c) + "/play/" + job.ID + "?" + query.Encode(),
+		"expires_at": expires,
+	})
+}
+
+// Play serves the playback page of a job to holders of a valid signed
+// link. The media on the page is fetched through download links that
+// expire with the page's.
+func (h *Handler) Play(c *gin.Context) {
+	if !h.cfg.PlaybackPages {
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+	jobID := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		c.String(http.StatusForbidden, "Invalid playback link")
+		return
+	}
+	if err := h.signer.Verify(playResource(jobID), expires, c.Query("sig"), h.clock.Now()); err != nil {
+		if errors.Is(err, storage.ErrLinkExpired) {
+			c.String(http.StatusGone, "This playback link has expired")
+			return
+		}
+		c.String(http.StatusForbidden, "Invalid playback link")
+		return
+	}
+
+	job, err := h.repo.GetJob(jobID)
+	if err != nil || job.Status != jobs.StatusCompleted || !h.localStorage.FileExists(job.OutputPath) {
+		c.String(http.StatusNotFound, "This video is no longer available")
+		return
+	}
+
+	until := time.Unix(expires, 0)
+	link := func(variant string) string {
+		return "../download/" + job.ID + "?" + h.downloadQuery(job.ID, variant, until).Encode()
+	}
+	page := playbackPage{
+		Title: job.OriginalName,
+		Video: link(""),
+		Audio: job.OutputProbe != nil && !hasVideo(job.OutputProbe),
+	}
+	if job.PosterPath != "" && h.localStorage.FileExists(job.PosterPath) {
+		page.Poster = link(posterVariant)
+	}
+	if path, _, ok := downloadFile(job, captionsVariant); ok && h.localStorage.FileExists(path) {
+		page.Captions = link(captionsVariant)
+		page.CaptionsLanguage = job.CaptionsLanguage()
+	}
+
+	// The page only loads media from this server
+	c.Header("Content-Security-Policy", "default-src 'none'; media-src 'self'; img-src 'self'; style-src 'unsafe-inline'")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := playbackTemplate.Execute(c.Writer, page); err != nil {
+		c.Error(err)
+	}
+}
+
+// playResource is what a playback link signs, kept apart from download
+// links so that one can't be used as the other
+func playResource(jobID string) string {
+	return "play:" + jobID
+}
+
+// playbackPage is what the playback page template shows
+type playbackPage struct {
+	Title            string
+	Video            string
+	Poster           string
+	Captions         string
+	CaptionsLanguage string
+	Audio            bool // the output has no video stream
+}
+
+var playbackTemplate = template.Must(template.New("play").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; background: #111; color: #eee; font-family: system-ui, sans-serif; }
+main { max-width: 1280px; margin: 0 auto; padding: 1rem; }
+h1 { font-size: 1.1rem; font-weight: normal; margin: 0 0 .75rem; overflow-wrap: anywhere; }
+video, audio { display: block; width: 100%; background: #000; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+{{- if .Audio}}
+<audio controls preload="metadata" src="{{.Video}}"></audio>
+{{- else}}
+<video controls playsinline preload="metadata" src="{{.Video}}"{{if .Poster}} poster="{{.Poster}}"{{end}}>
+{{- if .Captions}}
+<track kind="captions" src="{{.Captions}}" srclang="{{.CaptionsLanguage}}" label="Captions" default>
+{{- end}}
+</video>
+{{- end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/features"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/transcoder"
+	"gorm.io/gorm"
+)
+
+// ListPresets returns the global presets and the caller's tenant presets
+func (h *Handler) ListPresets(c *gin.Context) {
+	presets, err := db.ListPresets(currentPrincipal(c).Tenant)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list presets",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"presets": presets,
+	})
+}
+
+// GetPreset returns the preset a job submitted by the caller would use
+func (h *Handler) GetPreset(c *gin.Context) {
+	preset, err := db.ResolvePreset(currentPrincipal(c).Tenant, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "preset not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"preset": preset,
+	})
+}
+
+// CreatePreset stores a new named preset
+func (h *Handler) CreatePreset(c *gin.Context) {
+	var preset transcoder.Preset
+	if err := c.ShouldBindJSON(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	tenantID, ok := presetTenant(c)
+	if !ok {
+		return
+	}
+	preset.ID = 0
+	preset.TenantID = tenantID
+	applyPresetDefaults(&preset)
+	if err := preset.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := currentFeatures(c).Require(preset.Features()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := h.checkDestination(preset.Destination); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := db.CreatePreset(&preset); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "preset already exists",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to create preset",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"preset": preset,
+	})
+}
+
+// UpdatePreset replaces the settings of an existing preset
+func (h *Handler) UpdatePreset(c *gin.Context) {
+	tenantID, ok := presetTenant(c)
+	if !ok {
+		return
+	}
+	existing, err := db.GetPreset(tenantID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "preset not found",
+		})
+		return
+	}
+
+	var preset transcoder.Preset
+	if err := c.ShouldBindJSON(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	// The name is the preset's identity; renaming would orphan jobs that use it
+	preset.ID = existing.ID
+	preset.TenantID = existing.TenantID
+	preset.Name = existing.Name
+	preset.CreatedAt = existing.CreatedAt
+	applyPresetDefaults(&preset)
+	if err := preset.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := currentFeatures(c).Require(preset.Features()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := h.checkDestination(preset.Destination); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := db.UpdatePreset(&preset); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to update preset",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"preset": preset,
+	})
+}
+
+// DeletePreset removes a preset that no active job depends on
+func (h *Handler) DeletePreset(c *gin.Context) {
+	tenantID, ok := presetTenant(c)
+	if !ok {
+		return
+	}
+	preset, err := db.GetPreset(tenantID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "preset not found",
+		})
+		return
+	}
+
+	active, err := db.CountActiveJobsWithPreset(preset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to delete preset",
+		})
+		return
+	}
+	if active > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "preset is used by pending or processing jobs",
+		})
+		return
+	}
+
+	if err := db.DeletePreset(preset); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to delete preset",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "preset deleted",
+	})
+}
+
+// presetTenant returns the tenant whose presets a write applies to.
+// Tenant callers always write their own; global callers write global
+// presets unless they pass ?tenant_id=, which must name a tenant. It
+// responds and returns false when it doesn't.
+func presetTenant(c *gin.Context) (string, bool) {
+	if principal := currentPrincipal(c); !principal.IsGlobal() {
+		return principal.Tenant, true
+	}
+	tenantID := c.Query("tenant_id")
+	if tenantID != "" {
+		if _, err := db.GetTenant(tenantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "unknown tenant_id",
+			})
+			return "", false
+		}
+	}
+	return tenantID, true
+}
+
+// applyPresetDefaults fills in codecs left empty by the client. Audio
+// formats default to no video and their own audio codec.
+func applyPresetDefaults(preset *transcoder.Preset) {
+	defaults := transcoder.DefaultPreset()
+	switch preset.Format {
+	case transcoder.FormatMP3:
+		defaults.VideoCodec, defaults.AudioCodec = "none", "libmp3lame"
+	case transcoder.FormatM4A:
+		defaults.VideoCodec = "none"
+	}
+	if preset.VideoCodec == "" {
+		preset.VideoCodec = defaults.VideoCodec
+	}
+	if preset.AudioCodec == "" {
+		preset.AudioCodec = defaults.AudioCodec
+	}
+}
+
+// checkPreset reports whether a job in tenantID may reference the named
+// preset: it must exist and rely only on enabled features. An empty name
+// selects the default preset and is always allowed.
+func checkPreset(tenantID, name string, enabled features.Set) error {
+	if name == "" {
+		return nil
+	}
+	preset, err := db.ResolvePreset(tenantID, name)
+	if err != nil {
+		return fmt.Errorf("unknown preset")
+	}
+	return enabled.Require(preset.Features())
+}
+
+// maxRenditions bounds the extra outputs of a job, each a full encode
+const maxRenditions = 4
+
+// checkRenditions trims and de-duplicates the presets a job asks for as
+// extra renditions, each checked like the job's own preset. Their names
+// become variant names, so those the download endpoint reserves are
+// refused.
+func checkRenditions(tenantID string, names []string, mediaType string, enabled features.Set) (jobs.StringList, error) {
+	result := jobs.StringList{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || result.Contains(name) {
+			continue
+		}
+		if name == posterVariant || name == captionsVariant || strings.HasPrefix(name, "cc-") {
+			return nil, fmt.Errorf("preset %q can't be used as a rendition", name)
+		}
+		if err := checkPreset(tenantID, name, enabled); err != nil {
+			return nil, fmt.Errorf("rendition %s: %w", name, err)
+		}
+		result = append(result, name)
+	}
+	if len(result) > maxRenditions {
+		return nil, fmt.Errorf("at most %d renditions are allowed", maxRenditions)
+	}
+	if len(result) > 0 && mediaType == jobs.MediaImage {
+		return nil, fmt.Errorf("images can't have renditions")
+	}
+	return result, nil
+}
+
+// checkHLSPresets checks that the outputs of an HLS job, its own and its
+// renditions', can be copied into segments. Jobs without a preset get the
+// stored "default" one, else the built-in default, as the worker does.
+func checkHLSPresets(tenantID string, job *jobs.Job) error {
+	fmp4 := job.Packaging == transcoder.PackagingHLSFMP4
+	name := job.Preset
+	if name == "" {
+		name = "default"
+	}
+	preset, err := db.ResolvePreset(tenantID, name)
+	if err != nil && job.Preset == "" {
+		preset, err = transcoder.DefaultPreset(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("unknown preset")
+	}
+	if err := preset.CheckHLS(fmp4); err != nil {
+		return err
+	}
+	for _, name := range job.Renditions {
+		preset, err := db.ResolvePreset(tenantID, name)
+		if err != nil {
+			return fmt.Errorf("rendition %s: unknown preset", name)
+		}
+		if err := preset.CheckHLS(fmp4); err != nil {
+			return fmt.Errorf("rendition %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+)
+
+// readinessTimeout bounds each readiness check
+const readinessTimeout = 2 * time.Second
+
+// Liveness reports whether the process is able to serve at all. It checks
+// nothing external, so a database outage doesn't get every replica
+// restarted.
+func (h *Handler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "alive",
+	})
+}
+
+// Readiness reports whether the server should receive traffic: the
+// database answers, TEMP_DIR is writable and it isn't shutting down.
+// Failing checks return 503; the errors are logged rather than returned,
+// as the endpoint is public. A server that has paused dispatch, such as
+// while a destination is down, is still ready: it accepts and queues jobs.
+func (h *Handler) Readiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := gin.H{}
+	ready := true
+	check := func(name string, err error) {
+		if err != nil {
+			log.Printf("Readiness check %s failed: %v", name, err)
+			checks[name] = "failed"
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+	check("database", db.Ping(ctx))
+	check("temp_dir", writable(h.cfg.TempDir))
+
+	body := gin.H{
+		"status": "ready",
+		"checks": checks,
+	}
+	if h.jobQueue.Draining() {
+		body["draining"] = true
+		ready = false
+	} else if reason := h.jobQueue.Paused(); reason != "" {
+		body["dispatch_paused"] = reason
+	}
+	if !ready {
+		body["status"] = "not ready"
+		c.JSON(http.StatusServiceUnavailable, body)
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// writable checks that files can be created in dir
+func writable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
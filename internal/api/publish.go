@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// Publisher copies a completed job's output to another destination in
+// the background, recording how it went in the publication
+type Publisher interface {
+	Publish(job *jobs.Job, publication *jobs.Publication)
+}
+
+// PublishJob copies a completed job's output, and its image variants, to
+// another destination profile without transcoding it again. The copy runs
+// in the background; the response is the pending publication.
+func (h *Handler) PublishJob(c *gin.Context) {
+	principal := currentPrincipal(c)
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !principal.CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+	if !principal.CanModify(job.TenantID, job.Owner) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "not allowed to modify this job",
+		})
+		return
+	}
+
+	var req struct {
+		Destination string `json:"destination"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+	if req.Destination == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "destination is required",
+		})
+		return
+	}
+	if err := h.checkDestination(req.Destination); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if job.Status != jobs.StatusCompleted {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "job is not completed",
+		})
+		return
+	}
+	// Outputs delivered to a destination are removed from the server
+	if !h.localStorage.FileExists(job.OutputPath) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "output is not stored on this server",
+		})
+		return
+	}
+	if h.publisher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "publishing is not available",
+		})
+		return
+	}
+
+	now := h.clock.Now().UTC()
+	publication := &jobs.Publication{
+		JobID:       job.ID,
+		Destination: req.Destination,
+		Status:      jobs.PublicationPending,
+		RequestID:   currentRequestID(c),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := db.CreatePublication(publication); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to create publication",
+		})
+		return
+	}
+	h.publisher.Publish(job, publication)
+
+	c.JSON(http.StatusAccepted, publication)
+}
+
+// ListPublications returns every publication of a job
+func (h *Handler) ListPublications(c *gin.Context) {
+	job, err := h.repo.GetJob(c.Param("id"))
+	if err != nil || !currentPrincipal(c).CanView(job.TenantID, job.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	publications, err := db.ListPublications(job.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list publications",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"publications": publications,
+	})
+}
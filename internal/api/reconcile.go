@@ -0,0 +1,67 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReconcileReport is what one pass over TEMP_DIR found and did
+type ReconcileReport struct {
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	DeletedFiles   []string  `json:"deleted_files"`   // no job refers to them
+	DeletedBytes   int64     `json:"deleted_bytes"`   // the size of DeletedFiles
+	FailedJobs     []string  `json:"failed_jobs"`     // queued jobs whose uploads are missing
+	MissingOutputs []string  `json:"missing_outputs"` // completed jobs whose output was kept on the server but is gone
+	Errors         []string  `json:"errors,omitempty"`
+}
+
+// Reconciler checks the files in TEMP_DIR against the jobs in the
+// database. LastReport returns nil until the first pass.
+type Reconciler interface {
+	Reconcile() (*ReconcileReport, error)
+	LastReport() *ReconcileReport
+}
+
+// GetReconcileReport returns the report of this server's last pass over
+// TEMP_DIR
+func (h *Handler) GetReconcileReport(c *gin.Context) {
+	var report *ReconcileReport
+	if h.reconciler != nil {
+		report = h.reconciler.LastReport()
+	}
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "no reconciliation has run yet",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
+
+// Reconcile checks TEMP_DIR against the database now, deleting files no
+// job refers to and failing queued jobs whose uploads are missing
+func (h *Handler) Reconcile(c *gin.Context) {
+	if h.reconciler == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"error": "reconciliation is not available",
+		})
+		return
+	}
+	report, err := h.reconciler.Reconcile()
+	if err != nil {
+		log.Printf("Reconciliation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "reconciliation failed",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
@@ -1,37 +1,182 @@
 package api
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/clock"
 	"github.com/skillcape/transcoder/internal/config"
+	"github.com/skillcape/transcoder/internal/features"
+	"github.com/skillcape/transcoder/internal/ids"
 	"github.com/skillcape/transcoder/internal/jobs"
 	"github.com/skillcape/transcoder/internal/storage"
+	"github.com/skillcape/transcoder/internal/webhook"
 )
 
-func SetupRouter(cfg *config.Config, localStorage *storage.LocalStorage, jobQueue *jobs.Queue) *gin.Engine {
+// SetupRouter builds the HTTP handler. apiKey holds API_KEY, which reloads
+// may rotate; nil uses cfg.APIKey. reloader serves the admin config
+// endpoints and may be nil, leaving cfg fixed. publisher copies outputs
+// for the publish endpoint; nil disables it. clk and ids stamp and
+// identify what the handlers create; nil uses the system clock and random
+// UUIDs.
+func SetupRouter(cfg *config.Config, repo db.JobRepository, localStorage *storage.LocalStorage, jobQueue *jobs.Queue, notifier *webhook.Notifier, apiKey *auth.BootstrapKey, reloader Reloader, publisher Publisher, reconciler Reconciler, presigner Presigner, clk clock.Clock, generator ids.Generator) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
 
 	// Global middleware
+	router.Use(RequestID())
 	router.Use(Recovery())
 	router.Use(RequestLogger())
-	router.Use(CORS())
+	router.Use(CORS(CORSConfig{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+	}))
 
 	// Create handler
-	handler := NewHandler(localStorage, jobQueue)
+	handler := NewHandler(cfg, repo, localStorage, jobQueue, notifier)
+	handler.reloader = reloader
+	handler.publisher = publisher
+	handler.reconciler = reconciler
+	handler.presigner = presigner
+	if clk != nil {
+		handler.SetClock(clk)
+	}
+	if generator != nil {
+		handler.SetIDs(generator)
+	}
 
-	// Health check (no auth required)
+	// Health checks and metrics (no auth required). /livez and /readyz
+	// suit Kubernetes liveness and readiness probes.
 	router.GET("/health", handler.HealthCheck)
+	router.GET("/livez", handler.Liveness)
+	router.GET("/readyz", handler.Readiness)
+	router.GET("/metrics", handler.Metrics)
 
-	// API v1 routes (auth required)
-	v1 := router.Group("/api/v1")
-	v1.Use(APIKeyAuth(cfg.APIKey))
-	{
-		v1.POST("/jobs", handler.CreateJob)
-		v1.GET("/jobs", handler.ListJobs)
-		v1.GET("/jobs/:id", handler.GetJob)
-		v1.DELETE("/jobs/:id", handler.DeleteJob)
+	// Signed download links (the signature is the credential). Outputs can
+	// be large, so the response has no write deadline.
+	router.GET("/download/:id", Timeouts(config.Seconds(cfg.ReadTimeout), 0), handler.Download)
+	router.GET("/play/:id", handler.Play)
+
+	if apiKey == nil {
+		apiKey = auth.NewBootstrapKey(cfg.APIKey)
 	}
+	authenticate := Authenticate(apiKey, auth.NewJWTVerifier(auth.JWTConfig{
+		Secret:      cfg.JWTSecret,
+		JWKSURL:     cfg.JWTJWKSURL,
+		Issuer:      cfg.JWTIssuer,
+		Audience:    cfg.JWTAudience,
+		TenantClaim: cfg.JWTTenantClaim,
+		RoleClaim:   cfg.JWTRoleClaim,
+		AllowNoExp:  cfg.JWTAllowNoExp,
+	}), cfg.JWTDefaultRole)
+
+	// API routes (auth required). v2 serves the same endpoints but
+	// represents jobs as the richer JobResource.
+	// API responses are compressed when enabled; /download is left alone
+	// since media doesn't compress
+	// FEATURES was validated at startup, so a bad name here is dropped
+	enabled, err := features.Parse(cfg.Features)
+	if err != nil {
+		enabled = features.Set{}
+	}
+	middleware := []gin.HandlerFunc{authenticate, FeatureFlags(enabled)}
+	if cfg.CompressResponses {
+		middleware = append(middleware, Compress())
+	}
+
+	v1 := router.Group("/api/v1")
+	v1.Use(append(middleware, APIVersion(1))...)
+	registerRoutes(v1, handler)
+
+	v2 := router.Group("/api/v2")
+	v2.Use(append(middleware, APIVersion(2))...)
+	registerRoutes(v2, handler)
 
 	return router
 }
+
+func registerRoutes(api *gin.RouterGroup, handler *Handler) {
+	anyRole := RequireRole(auth.RoleAdmin, auth.RoleOperator, auth.RoleSubmitter, auth.RoleViewer)
+	submitters := RequireRole(auth.RoleAdmin, auth.RoleOperator, auth.RoleSubmitter)
+	operators := RequireRole(auth.RoleAdmin, auth.RoleOperator)
+	admins := RequireRole(auth.RoleAdmin)
+
+	// Uploads get UploadTimeout to arrive, plus the usual write timeout to
+	// answer once they have
+	uploadRead, uploadWrite := config.Seconds(handler.cfg.UploadTimeout), time.Duration(0)
+	if uploadRead > 0 {
+		uploadWrite = uploadRead + config.Seconds(handler.cfg.WriteTimeout)
+	}
+	upload := Timeouts(uploadRead, uploadWrite)
+
+	api.POST("/jobs", upload, submitters, handler.TrackUpload, handler.CreateJob)
+	api.GET("/jobs", anyRole, handler.ListJobs)
+	api.POST("/jobs/bulk", submitters, handler.BulkJobs)
+	api.GET("/jobs/:id", anyRole, handler.GetJob)
+	api.GET("/jobs/:id/events", anyRole, handler.GetJobEvents)
+	api.GET("/jobs/:id/webhook-deliveries", anyRole, handler.GetWebhookDeliveries)
+	api.POST("/jobs/:id/webhook-deliveries/:delivery_id/redeliver", submitters, handler.RedeliverWebhook)
+	api.PATCH("/jobs/:id", submitters, handler.UpdateJob)
+	api.POST("/jobs/:id/prioritize", operators, handler.PrioritizeJob)
+	api.DELETE("/jobs/:id", submitters, handler.DeleteJob)
+	api.POST("/jobs/:id/download-link", anyRole, handler.CreateDownloadLink)
+	api.POST("/jobs/:id/playback-link", anyRole, handler.CreatePlaybackLink)
+	api.POST("/jobs/:id/publish", submitters, handler.PublishJob)
+	api.GET("/jobs/:id/publications", anyRole, handler.ListPublications)
+
+	// The progress stream lasts as long as the upload, so it has no write
+	// deadline
+	api.GET("/uploads/:id", anyRole, handler.GetUpload)
+	api.GET("/uploads/:id/events", Timeouts(config.Seconds(handler.cfg.ReadTimeout), 0), anyRole, handler.StreamUpload)
+
+	api.GET("/webhooks", submitters, handler.ListWebhookEndpoints)
+	api.POST("/webhooks", submitters, handler.CreateWebhookEndpoint)
+	api.GET("/webhooks/:id", submitters, handler.GetWebhookEndpoint)
+	api.PATCH("/webhooks/:id", submitters, handler.UpdateWebhookEndpoint)
+	api.DELETE("/webhooks/:id", submitters, handler.DeleteWebhookEndpoint)
+
+	api.GET("/usage", anyRole, handler.GetUsage)
+	api.GET("/features", anyRole, handler.ListFeatures)
+	api.GET("/destinations", anyRole, handler.ListDestinations)
+
+	api.GET("/presets", anyRole, handler.ListPresets)
+	api.POST("/presets", operators, handler.CreatePreset)
+	api.GET("/presets/:name", anyRole, handler.GetPreset)
+	api.PUT("/presets/:name", operators, handler.UpdatePreset)
+	api.DELETE("/presets/:name", operators, handler.DeletePreset)
+
+	api.GET("/admin/keys", admins, handler.ListAPIKeys)
+	api.POST("/admin/keys", admins, handler.CreateAPIKey)
+	api.DELETE("/admin/keys/:id", admins, handler.RevokeAPIKey)
+	api.GET("/admin/keys/:id/usage", admins, handler.GetAPIKeyUsage)
+	api.PUT("/admin/keys/:id/quota", admins, handler.UpdateAPIKeyQuota)
+
+	api.GET("/admin/stats", admins, handler.GetStats)
+	api.GET("/admin/stats/costs", admins, handler.GetCosts)
+	api.GET("/admin/capacity", admins, RequireGlobal(), handler.GetCapacity)
+
+	api.GET("/admin/tenants", admins, RequireGlobal(), handler.ListTenants)
+	api.POST("/admin/tenants", admins, RequireGlobal(), handler.CreateTenant)
+	api.GET("/admin/tenants/:id", admins, RequireGlobal(), handler.GetTenant)
+	api.PUT("/admin/tenants/:id", admins, RequireGlobal(), handler.UpdateTenant)
+	api.DELETE("/admin/tenants/:id", admins, RequireGlobal(), handler.DeleteTenant)
+	api.PUT("/admin/tenants/:id/watermark", admins, RequireGlobal(), handler.SetTenantWatermark)
+	api.DELETE("/admin/tenants/:id/watermark", admins, RequireGlobal(), handler.DeleteTenantWatermark)
+	api.PUT("/admin/tenants/:id/intro", admins, RequireGlobal(), handler.SetTenantIntro)
+	api.DELETE("/admin/tenants/:id/intro", admins, RequireGlobal(), handler.DeleteTenantIntro)
+
+	api.GET("/admin/backups", admins, RequireGlobal(), handler.ListBackups)
+	api.POST("/admin/backups", admins, RequireGlobal(), handler.CreateBackup)
+
+	api.GET("/admin/reconcile", admins, RequireGlobal(), handler.GetReconcileReport)
+	api.POST("/admin/reconcile", admins, RequireGlobal(), handler.Reconcile)
+
+	api.GET("/admin/config", admins, RequireGlobal(), handler.GetConfig)
+	api.POST("/admin/config/reload", admins, RequireGlobal(), handler.ReloadConfig)
+}
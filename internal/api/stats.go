@@ -0,0 +1,229 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+)
+
+// maxStatsDays bounds the range one stats request may cover
+const maxStatsDays = 366
+
+// statsTotals sums daily rollups
+type statsTotals struct {
+	Jobs            int64   `json:"jobs"`
+	Completed       int64   `json:"completed"`
+	Failed          int64   `json:"failed"`
+	Cancelled       int64   `json:"cancelled"`
+	Minutes         float64 `json:"minutes"`          // input minutes of completed jobs
+	AverageDuration float64 `json:"average_duration"` // seconds per completed job
+	seconds         float64
+}
+
+func (t *statsTotals) add(s db.DailyStats) {
+	t.Jobs += s.Jobs
+	t.Completed += s.Completed
+	t.Failed += s.Failed
+	t.Cancelled += s.Cancelled
+	t.seconds += s.Seconds
+	t.Minutes = math.Round(t.seconds/60*100) / 100
+	if t.Completed > 0 {
+		t.AverageDuration = math.Round(t.seconds/float64(t.Completed)*100) / 100
+	}
+}
+
+// GetStats reports job counts, failures and minutes transcoded per day and
+// per preset, read from the daily rollups
+func (h *Handler) GetStats(c *gin.Context) {
+	from, to, err := parseStatsRange(c.Query("from"), c.Query("to"), h.clock.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var tenantID *string
+	principal := currentPrincipal(c)
+	if !principal.IsGlobal() {
+		tenantID = &principal.Tenant
+	} else if tenant, ok := c.GetQuery("tenant_id"); ok {
+		tenantID = &tenant
+	}
+
+	stats, err := db.ListDailyStats(from, to, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to load stats",
+		})
+		return
+	}
+
+	type dayStats struct {
+		Day string `json:"day"`
+		statsTotals
+	}
+	type presetStats struct {
+		Preset string `json:"preset"`
+		statsTotals
+	}
+	days := []*dayStats{}
+	presets := []*presetStats{}
+	byPreset := make(map[string]*presetStats)
+	var totals statsTotals
+	for _, s := range stats {
+		if len(days) == 0 || days[len(days)-1].Day != s.Day {
+			days = append(days, &dayStats{Day: s.Day})
+		}
+		days[len(days)-1].add(s)
+
+		name := s.Preset
+		if name == "" {
+			name = "default"
+		}
+		if byPreset[name] == nil {
+			byPreset[name] = &presetStats{Preset: name}
+			presets = append(presets, byPreset[name])
+		}
+		byPreset[name].add(s)
+		totals.add(s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"totals":  totals,
+		"days":    days,
+		"presets": presets,
+	})
+}
+
+// parseStatsRange reads the from/to days of a stats request, defaulting to
+// the 30 days ending today
+func parseStatsRange(fromParam, toParam string, now time.Time) (string, string, error) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if toParam != "" {
+		t, err := time.Parse("2006-01-02", toParam)
+		if err != nil {
+			return "", "", fmt.Errorf("to must be a date (YYYY-MM-DD)")
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if fromParam != "" {
+		t, err := time.Parse("2006-01-02", fromParam)
+		if err != nil {
+			return "", "", fmt.Errorf("from must be a date (YYYY-MM-DD)")
+		}
+		from = t
+	}
+
+	if from.After(to) {
+		return "", "", fmt.Errorf("from must not be after to")
+	}
+	if to.Sub(from) >= maxStatsDays*24*time.Hour {
+		return "", "", fmt.Errorf("at most %d days may be requested", maxStatsDays)
+	}
+	return from.Format("2006-01-02"), to.Format("2006-01-02"), nil
+}
+
+// costTotals sums what jobs consumed, for billing it back
+type costTotals struct {
+	Jobs         int64   `json:"jobs"`
+	InputMinutes float64 `json:"input_minutes"`
+	CPUSeconds   float64 `json:"cpu_seconds"`
+	WallSeconds  float64 `json:"wall_seconds"`
+	BytesIn      int64   `json:"bytes_in"`  // uploaded to the server
+	BytesOut     int64   `json:"bytes_out"` // delivered to destinations
+	seconds      float64
+}
+
+func (t *costTotals) add(cost db.JobCost) {
+	t.Jobs++
+	t.seconds += cost.Duration
+	t.InputMinutes = math.Round(t.seconds/60*100) / 100
+	t.CPUSeconds = math.Round((t.CPUSeconds+cost.CPUSeconds)*1000) / 1000
+	t.WallSeconds = math.Round((t.WallSeconds+cost.WallSeconds)*1000) / 1000
+	t.BytesIn += cost.InputSize
+	t.BytesOut += cost.BytesOut
+}
+
+// GetCosts attributes what finished jobs consumed, CPU and wall time and
+// bytes transferred, to tenants and labels. A job counts toward each of
+// its labels, and unlabelled jobs toward an empty label.
+func (h *Handler) GetCosts(c *gin.Context) {
+	from, to, err := parseStatsRange(c.Query("from"), c.Query("to"), h.clock.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var tenantID *string
+	principal := currentPrincipal(c)
+	if !principal.IsGlobal() {
+		tenantID = &principal.Tenant
+	} else if tenant, ok := c.GetQuery("tenant_id"); ok {
+		tenantID = &tenant
+	}
+
+	type tenantCosts struct {
+		TenantID string `json:"tenant_id"`
+		costTotals
+	}
+	type labelCosts struct {
+		Label string `json:"label"`
+		costTotals
+	}
+	byTenant := make(map[string]*tenantCosts)
+	byLabel := make(map[string]*labelCosts)
+	var totals costTotals
+	err = h.repo.EachJobCost(from, to, tenantID, func(cost db.JobCost) {
+		totals.add(cost)
+		if byTenant[cost.TenantID] == nil {
+			byTenant[cost.TenantID] = &tenantCosts{TenantID: cost.TenantID}
+		}
+		byTenant[cost.TenantID].add(cost)
+		labels := cost.Labels
+		if len(labels) == 0 {
+			labels = []string{""}
+		}
+		for _, label := range labels {
+			if byLabel[label] == nil {
+				byLabel[label] = &labelCosts{Label: label}
+			}
+			byLabel[label].add(cost)
+		}
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to load costs",
+		})
+		return
+	}
+
+	tenants := make([]*tenantCosts, 0, len(byTenant))
+	for _, t := range byTenant {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].TenantID < tenants[j].TenantID })
+	labels := make([]*labelCosts, 0, len(byLabel))
+	for _, l := range byLabel {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Label < labels[j].Label })
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"totals":  totals,
+		"tenants": tenants,
+		"labels":  labels,
+	})
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/tenant"
+	"github.com/skillcape/transcoder/internal/transcoder"
+	"github.com/skillcape/transcoder/internal/webhook"
+	"gorm.io/gorm"
+)
+
+// ListTenants returns all tenants
+func (h *Handler) ListTenants(c *gin.Context) {
+	tenants, err := db.ListTenants()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list tenants",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenants": tenants,
+	})
+}
+
+// GetTenant returns a single tenant by ID
+func (h *Handler) GetTenant(c *gin.Context) {
+	t, err := db.GetTenant(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "tenant not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// CreateTenant registers a new tenant
+func (h *Handler) CreateTenant(c *gin.Context) {
+	var t tenant.Tenant
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	if err := tenant.ValidateID(t.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	// Branding files are uploaded separately
+	t.Watermark, t.Intro = "", ""
+	if !applyTenantSettings(c, &t) {
+		return
+	}
+
+	if err := db.CreateTenant(&t); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "tenant already exists",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to create tenant",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, t)
+}
+
+// UpdateTenant replaces the settings of an existing tenant
+func (h *Handler) UpdateTenant(c *gin.Context) {
+	existing, err := db.GetTenant(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "tenant not found",
+		})
+		return
+	}
+
+	var t tenant.Tenant
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	t.ID = existing.ID
+	t.CreatedAt = existing.CreatedAt
+	t.Watermark, t.Intro = existing.Watermark, existing.Intro
+	if !applyTenantSettings(c, &t) {
+		return
+	}
+
+	if err := db.UpdateTenant(&t); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to update tenant",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// DeleteTenant removes a tenant that no job or active API key belongs to,
+// with its branding files
+func (h *Handler) DeleteTenant(c *gin.Context) {
+	id := c.Param("id")
+	t, err := db.GetTenant(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "tenant not found",
+		})
+		return
+	}
+
+	inUse, err := db.TenantInUse(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to delete tenant",
+		})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "tenant still has jobs or active API keys",
+		})
+		return
+	}
+
+	if err := db.DeleteTenant(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to delete tenant",
+		})
+		return
+	}
+	for _, name := range []string{t.Watermark, t.Intro} {
+		if name != "" {
+			h.localStorage.DeleteFile(h.localStorage.BrandingPath(name))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "tenant deleted",
+	})
+}
+
+// applyTenantSettings trims and validates the editable tenant fields,
+// writing a 400 response and returning false if they are invalid
+func applyTenantSettings(c *gin.Context, t *tenant.Tenant) bool {
+	t.Name = strings.TrimSpace(t.Name)
+	t.DriveFolderID = strings.TrimSpace(t.DriveFolderID)
+	t.WebhookURL = strings.TrimSpace(t.WebhookURL)
+	t.DefaultPreset = strings.TrimSpace(t.DefaultPreset)
+	t.WatermarkPosition = strings.TrimSpace(t.WatermarkPosition)
+
+	if t.Name == "" {
+		t.Name = t.ID
+	}
+	if t.WebhookURL != "" {
+		if err := validateWebhookURL(t.WebhookURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return false
+		}
+	}
+	t.WebhookEvents = webhook.NormalizeEvents(t.WebhookEvents)
+	if err := webhook.ValidateEvents(t.WebhookEvents); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+	if t.DefaultPreset != "" {
+		if _, err := db.ResolvePreset(t.ID, t.DefaultPreset); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "default preset not found",
+			})
+			return false
+		}
+	}
+	if err := transcoder.ValidateWatermarkPosition(t.WatermarkPosition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+	return true
+}
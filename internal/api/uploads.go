@@ -0,0 +1,231 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadIDHeader names an upload so its progress can be followed, from
+// another connection, while it is still arriving
+const uploadIDHeader = "X-Upload-ID"
+
+var uploadIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// Limits on tracked uploads: finished ones are kept for uploadRetention,
+// and at most maxTrackedUploads are kept at once
+const (
+	uploadRetention   = 10 * time.Minute
+	maxTrackedUploads = 10000
+)
+
+// How often the progress stream checks an upload
+const uploadStreamInterval = 500 * time.Millisecond
+
+// Upload status values
+const (
+	UploadReceiving = "receiving"
+	UploadCompleted = "completed"
+	UploadFailed    = "failed"
+)
+
+// createdJobsKey is where CreateJob leaves the IDs of the jobs it created,
+// for the upload tracker
+const createdJobsKey = "created_jobs"
+
+// UploadProgress is how much of an upload has arrived
+type UploadProgress struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Received   int64      `json:"bytes_received"`
+	Expected   int64      `json:"bytes_expected"` // the request's Content-Length; -1 if it had none
+	Percent    *int       `json:"percent,omitempty"`
+	JobIDs     []string   `json:"job_ids,omitempty"` // once completed
+	HTTPStatus int        `json:"http_status,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// trackedUpload is an upload in progress, or recently finished
+type trackedUpload struct {
+	tenantID, owner string
+	received        atomic.Int64
+
+	mu       sync.Mutex
+	progress UploadProgress
+}
+
+// snapshot returns the upload's progress so far
+func (u *trackedUpload) snapshot() UploadProgress {
+	u.mu.Lock()
+	progress := u.progress
+	u.mu.Unlock()
+	progress.Received = u.received.Load()
+	if progress.Expected > 0 {
+		percent := int(min(progress.Received*100/progress.Expected, 100))
+		progress.Percent = &percent
+	}
+	return progress
+}
+
+// uploadTracker follows uploads sent with an X-Upload-ID header. Progress
+// is kept in memory, so it can only be read from the server receiving the
+// upload.
+type uploadTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*trackedUpload
+}
+
+func newUploadTracker() *uploadTracker {
+	return &uploadTracker{uploads: make(map[string]*trackedUpload)}
+}
+
+// start begins tracking an upload, first forgetting finished uploads past
+// their retention. It returns false if the ID is taken or too many
+// uploads are tracked. now is when the upload starts.
+func (t *uploadTracker) start(id, tenantID, owner string, expected int64, now time.Time) (*trackedUpload, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, u := range t.uploads {
+		if finished := u.snapshot().FinishedAt; finished != nil && now.Sub(*finished) > uploadRetention {
+			delete(t.uploads, key)
+		}
+	}
+	if _, taken := t.uploads[id]; taken || len(t.uploads) >= maxTrackedUploads {
+		return nil, false
+	}
+
+	u := &trackedUpload{tenantID: tenantID, owner: owner}
+	u.progress = UploadProgress{ID: id, Status: UploadReceiving, Expected: expected, StartedAt: now}
+	t.uploads[id] = u
+	return u, true
+}
+
+// get returns a tracked upload
+func (t *uploadTracker) get(id string) (*trackedUpload, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.uploads[id]
+	return u, ok
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count.Add(int64(n))
+	return n, err
+}
+
+// TrackUpload records the progress of a job upload sent with an
+// X-Upload-ID header, for GetUpload and StreamUpload to report while it
+// arrives. Uploads without the header pass straight through.
+func (h *Handler) TrackUpload(c *gin.Context) {
+	id := c.GetHeader(uploadIDHeader)
+	if id == "" {
+		c.Next()
+		return
+	}
+	if !uploadIDRegex.MatchString(id) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "X-Upload-ID must be 8-64 letters, digits, '_' or '-'",
+		})
+		return
+	}
+
+	principal := currentPrincipal(c)
+	expected := c.Request.ContentLength
+	if expected < 0 {
+		expected = -1
+	}
+	u, ok := h.uploads.start(id, principal.Tenant, principal.Subject, expected, h.clock.Now().UTC())
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "X-Upload-ID is already in use",
+		})
+		return
+	}
+	c.Request.Body = &countingBody{ReadCloser: c.Request.Body, count: &u.received}
+
+	c.Next()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.progress.HTTPStatus = c.Writer.Status()
+	now := h.clock.Now().UTC()
+	u.progress.FinishedAt = &now
+	u.progress.Status = UploadFailed
+	if ids, ok := c.Get(createdJobsKey); ok && u.progress.HTTPStatus < 300 {
+		u.progress.Status = UploadCompleted
+		u.progress.JobIDs = ids.([]string)
+	}
+}
+
+// GetUpload reports how much of an upload has arrived
+func (h *Handler) GetUpload(c *gin.Context) {
+	u, ok := h.viewableUpload(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, u.snapshot())
+}
+
+// StreamUpload sends an upload's progress as server-sent events: a
+// "progress" event whenever more has arrived, then a "completed" or
+// "failed" event, after which the stream ends
+func (h *Handler) StreamUpload(c *gin.Context) {
+	u, ok := h.viewableUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(uploadStreamInterval)
+	defer ticker.Stop()
+	last := int64(-1)
+	for {
+		progress := u.snapshot()
+		if progress.Status != UploadReceiving {
+			c.SSEvent(progress.Status, progress)
+			c.Writer.Flush()
+			return
+		}
+		if progress.Received != last {
+			last = progress.Received
+			c.SSEvent("progress", progress)
+			c.Writer.Flush()
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// viewableUpload returns the upload named in the path, writing a 404
+// response and returning false if there is none the caller may see
+func (h *Handler) viewableUpload(c *gin.Context) (*trackedUpload, bool) {
+	u, ok := h.uploads.get(c.Param("id"))
+	if !ok || !currentPrincipal(c).CanView(u.tenantID, u.owner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "upload not found",
+		})
+		return nil, false
+	}
+	return u, true
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/auth"
+)
+
+// GetUsage returns the caller's quota and what it has consumed so far
+func (h *Handler) GetUsage(c *gin.Context) {
+	usage, err := db.GetUsage(currentPrincipal(c).Subject, h.clock.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to load usage",
+		})
+		return
+	}
+
+	var quota auth.Quota
+	if key := currentAPIKey(c); key != nil {
+		quota = key.Quota
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quota": quota,
+		"usage": usage,
+	})
+}
+
+// GetAPIKeyUsage returns the quota and usage of a stored API key
+func (h *Handler) GetAPIKeyUsage(c *gin.Context) {
+	key, err := db.GetAPIKey(c.Param("id"))
+	if err != nil || !currentPrincipal(c).InTenant(key.TenantID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API key not found",
+		})
+		return
+	}
+
+	usage, err := db.GetUsage(key.Principal().Subject, h.clock.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to load usage",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quota": key.Quota,
+		"usage": usage,
+	})
+}
+
+// UpdateAPIKeyQuota replaces the quota of a stored API key
+func (h *Handler) UpdateAPIKeyQuota(c *gin.Context) {
+	key, err := db.GetAPIKey(c.Param("id"))
+	if err != nil || !currentPrincipal(c).InTenant(key.TenantID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API key not found",
+		})
+		return
+	}
+
+	var quota auth.Quota
+	if err := c.ShouldBindJSON(&quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+	if err := quota.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := db.UpdateAPIKeyQuota(key.ID, quota); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to update quota",
+		})
+		return
+	}
+	key.Quota = quota
+
+	c.JSON(http.StatusOK, gin.H{
+		"key": key,
+	})
+}
+
+// checkQuota rejects an upload creating newJobs jobs from size bytes if it
+// would exceed the caller's API key quota, writing the response and
+// returning false.
+// Running out of daily jobs is temporary (429); running out of minutes or
+// storage needs a quota change or cleanup (402).
+func (h *Handler) checkQuota(c *gin.Context, newJobs int, size int64) bool {
+	key := currentAPIKey(c)
+	if key == nil || key.Quota == (auth.Quota{}) {
+		return true
+	}
+
+	now := h.clock.Now().UTC()
+	usage, err := db.GetUsage(key.Principal().Subject, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to check quota",
+		})
+		return false
+	}
+
+	err = key.Quota.Allow(usage, newJobs, size)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, auth.ErrJobQuotaExceeded):
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		c.Header("Retry-After", strconv.Itoa(int(tomorrow.Sub(now).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error": err.Error(),
+		})
+	}
+	return false
+}
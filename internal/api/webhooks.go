@@ -0,0 +1,314 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skillcape/transcoder/db"
+	"github.com/skillcape/transcoder/internal/auth"
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// webhookEndpointRequest holds the editable endpoint fields. tenant_id and
+// job_id only apply when creating.
+type webhookEndpointRequest struct {
+	URL          *string   `json:"url"`
+	Events       *[]string `json:"events"`
+	Format       *string   `json:"format"`
+	Secret       *string   `json:"secret"`
+	RotateSecret bool      `json:"rotate_secret"`
+	Description  *string   `json:"description"`
+	Disabled     *bool     `json:"disabled"`
+	TenantID     string    `json:"tenant_id"`
+	JobID        string    `json:"job_id"`
+
+	TimeoutSeconds    *int     `json:"timeout_seconds"`
+	MaxBackoffSeconds *int     `json:"max_backoff_seconds"`
+	BackoffJitter     *float64 `json:"backoff_jitter"`
+	DeadlineSeconds   *int     `json:"deadline_seconds"`
+}
+
+// ListWebhookEndpoints returns the registered endpoints the caller manages
+func (h *Handler) ListWebhookEndpoints(c *gin.Context) {
+	principal := currentPrincipal(c)
+	var tenantID *string
+	if !principal.IsGlobal() {
+		tenantID = &principal.Tenant
+	}
+	endpoints, err := db.ListWebhookEndpoints(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to list webhooks",
+		})
+		return
+	}
+
+	visible := make([]webhook.Endpoint, 0, len(endpoints))
+	for i := range endpoints {
+		if h.canManageEndpoint(principal, &endpoints[i]) {
+			visible = append(visible, endpoints[i])
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": visible,
+	})
+}
+
+// GetWebhookEndpoint returns a single endpoint
+func (h *Handler) GetWebhookEndpoint(c *gin.Context) {
+	endpoint, ok := h.managedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhook": endpoint,
+	})
+}
+
+// CreateWebhookEndpoint registers an endpoint for every job, a tenant's
+// jobs, or one job. The signing secret is only returned here.
+func (h *Handler) CreateWebhookEndpoint(c *gin.Context) {
+	var req webhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+	if req.URL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "url is required",
+		})
+		return
+	}
+
+	principal := currentPrincipal(c)
+	now := h.clock.Now().UTC()
+	endpoint := &webhook.Endpoint{
+		ID:        h.ids.NewID(),
+		Events:    h.cfg.WebhookEvents,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if len(endpoint.Events) == 0 {
+		endpoint.Events = webhook.DefaultEvents
+	}
+
+	// Job endpoints belong to the job's tenant; tenant-scoped callers can
+	// only register endpoints within their tenant
+	switch {
+	case req.JobID != "":
+		job, err := h.repo.GetJob(req.JobID)
+		if err != nil || !principal.CanView(job.TenantID, job.Owner) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "unknown job_id",
+			})
+			return
+		}
+		endpoint.JobID = job.ID
+		endpoint.TenantID = job.TenantID
+	case !principal.IsGlobal():
+		endpoint.TenantID = principal.Tenant
+	case req.TenantID != "":
+		if _, err := db.GetTenant(req.TenantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "unknown tenant_id",
+			})
+			return
+		}
+		endpoint.TenantID = req.TenantID
+	}
+	if !h.canManageEndpoint(principal, endpoint) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "only admins can register tenant or global webhooks",
+		})
+		return
+	}
+
+	if req.Secret == nil {
+		req.RotateSecret = true
+	}
+	secret, ok := applyEndpointSettings(c, endpoint, &req)
+	if !ok {
+		return
+	}
+
+	if err := db.CreateWebhookEndpoint(endpoint); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to create webhook",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": endpoint,
+		"secret":  secret,
+	})
+}
+
+// UpdateWebhookEndpoint changes the fields present in the request. A new
+// secret is returned only when rotate_secret is set.
+func (h *Handler) UpdateWebhookEndpoint(c *gin.Context) {
+	endpoint, ok := h.managedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	var req webhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body",
+		})
+		return
+	}
+
+	secret, ok := applyEndpointSettings(c, endpoint, &req)
+	if !ok {
+		return
+	}
+	endpoint.UpdatedAt = h.clock.Now().UTC()
+
+	if err := db.UpdateWebhookEndpoint(endpoint); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to update webhook",
+		})
+		return
+	}
+
+	body := gin.H{
+		"webhook": endpoint,
+	}
+	if req.RotateSecret {
+		body["secret"] = secret
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// DeleteWebhookEndpoint removes an endpoint
+func (h *Handler) DeleteWebhookEndpoint(c *gin.Context) {
+	endpoint, ok := h.managedEndpoint(c)
+	if !ok {
+		return
+	}
+
+	if err := db.DeleteWebhookEndpoint(endpoint.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "failed to delete webhook",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "webhook deleted",
+	})
+}
+
+// managedEndpoint loads the endpoint named in the path, writing a 404 and
+// returning false if it doesn't exist or the caller can't manage it
+func (h *Handler) managedEndpoint(c *gin.Context) (*webhook.Endpoint, bool) {
+	endpoint, err := db.GetWebhookEndpoint(c.Param("id"))
+	if err != nil || !h.canManageEndpoint(currentPrincipal(c), endpoint) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "webhook not found",
+		})
+		return nil, false
+	}
+	return endpoint, true
+}
+
+// canManageEndpoint reports whether the principal may see and change an
+// endpoint: job endpoints follow the job's permissions, tenant and global
+// endpoints need an admin of that scope
+func (h *Handler) canManageEndpoint(principal *auth.Principal, endpoint *webhook.Endpoint) bool {
+	if principal.Role == auth.RoleAdmin && principal.InTenant(endpoint.TenantID) {
+		return true
+	}
+	if endpoint.JobID != "" {
+		job, err := h.repo.GetJob(endpoint.JobID)
+		return err == nil && principal.CanModify(job.TenantID, job.Owner)
+	}
+	return false
+}
+
+// applyEndpointSettings validates the request and copies the provided
+// fields onto endpoint, returning the new secret if one was generated. It
+// writes a 400 response and returns false if the request is invalid.
+func applyEndpointSettings(c *gin.Context, endpoint *webhook.Endpoint, req *webhookEndpointRequest) (string, bool) {
+	fail := func(message string) (string, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+		return "", false
+	}
+
+	if req.URL != nil {
+		url := strings.TrimSpace(*req.URL)
+		if url == "" || validateWebhookURL(url) != nil {
+			return fail("url must be an absolute http(s) URL")
+		}
+		endpoint.URL = url
+	}
+
+	if req.Events != nil {
+		events := webhook.NormalizeEvents(*req.Events)
+		if len(events) == 0 {
+			return fail("events must not be empty")
+		}
+		if err := webhook.ValidateEvents(events); err != nil {
+			return fail(err.Error())
+		}
+		endpoint.Events = events
+	}
+
+	if req.Format != nil {
+		format := strings.TrimSpace(*req.Format)
+		if err := webhook.ValidateFormat(format); err != nil {
+			return fail(err.Error())
+		}
+		endpoint.Format = format
+	}
+
+	if req.Description != nil {
+		endpoint.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Disabled != nil {
+		endpoint.Disabled = *req.Disabled
+	}
+
+	// Zero reverts a timing field to the server's, and -1 turns it off
+	if req.TimeoutSeconds != nil {
+		endpoint.TimeoutSeconds = *req.TimeoutSeconds
+	}
+	if req.MaxBackoffSeconds != nil {
+		endpoint.MaxBackoffSeconds = *req.MaxBackoffSeconds
+	}
+	if req.BackoffJitter != nil {
+		endpoint.BackoffJitter = *req.BackoffJitter
+	}
+	if req.DeadlineSeconds != nil {
+		endpoint.DeadlineSeconds = *req.DeadlineSeconds
+	}
+	if err := endpoint.Timing().Validate(); err != nil {
+		return fail(err.Error())
+	}
+
+	switch {
+	case req.Secret != nil && req.RotateSecret:
+		return fail("specify either secret or rotate_secret, not both")
+	case req.Secret != nil:
+		endpoint.Secret = *req.Secret
+	case req.RotateSecret:
+		secret, err := webhook.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to generate secret",
+			})
+			return "", false
+		}
+		endpoint.Secret = secret
+	}
+	return endpoint.Secret, true
+}
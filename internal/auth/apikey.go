@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// keyPrefix marks generated keys so they are recognizable in logs and scanners
+const keyPrefix = "tk_"
+
+// APIKey is a stored API credential. Only a hash of the secret is kept.
+type APIKey struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	Role       string     `json:"role"`
+	TenantID   string     `json:"tenant_id,omitempty" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// Quota fields are inlined in the JSON and stored as columns
+	Quota `gorm:"embedded"`
+}
+
+// Principal returns the identity requests made with this key act as
+func (k *APIKey) Principal() *Principal {
+	return &Principal{
+		Subject: "key:" + k.ID,
+		Tenant:  k.TenantID,
+		Role:    k.Role,
+		Method:  MethodAPIKey,
+	}
+}
+
+// GenerateKey returns a new random API key secret
+func GenerateKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(buf), nil
+}
+
+// HashKey returns the stored form of an API key secret
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
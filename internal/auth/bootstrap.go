@@ -0,0 +1,29 @@
+package auth
+
+import "sync"
+
+// BootstrapKey holds the API_KEY admin key. It can be replaced while the
+// server runs, so a key kept in a secret manager can be rotated.
+type BootstrapKey struct {
+	mu  sync.RWMutex
+	key string
+}
+
+// NewBootstrapKey holds key; an empty key accepts nothing
+func NewBootstrapKey(key string) *BootstrapKey {
+	return &BootstrapKey{key: key}
+}
+
+// Get returns the current key
+func (k *BootstrapKey) Get() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.key
+}
+
+// Set replaces the key
+func (k *BootstrapKey) Set(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.key = key
+}
@@ -0,0 +1,377 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// clockSkew is tolerated when checking exp and nbf
+const clockSkew = 60 * time.Second
+
+// JWTConfig configures bearer-token validation. Either Secret (HS256) or
+// JWKSURL (RS256/ES256) must be set.
+type JWTConfig struct {
+	Secret      string
+	JWKSURL     string
+	Issuer      string
+	Audience    string
+	TenantClaim string
+	RoleClaim   string
+	AllowNoExp  bool // accept tokens without an exp claim, which never expire
+}
+
+// Claims is the decoded JWT payload
+type Claims map[string]interface{}
+
+// String returns a string claim, or "" if absent or not a string
+func (c Claims) String(name string) string {
+	if v, ok := c[name].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// JWTVerifier validates bearer tokens and maps their claims to a Principal
+type JWTVerifier struct {
+	cfg  JWTConfig
+	jwks *jwksCache
+}
+
+// NewJWTVerifier returns nil if neither a secret nor a JWKS URL is configured
+func NewJWTVerifier(cfg JWTConfig) *JWTVerifier {
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil
+	}
+	v := &JWTVerifier{cfg: cfg}
+	if cfg.JWKSURL != "" {
+		v.jwks = newJWKSCache(cfg.JWKSURL)
+	}
+	return v
+}
+
+// Authenticate verifies a token and returns the principal it identifies
+func (v *JWTVerifier) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	claims, err := v.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{
+		Subject: claims.String("sub"),
+		Tenant:  claims.String(v.cfg.TenantClaim),
+		Role:    roleFromClaim(claims[v.cfg.RoleClaim]),
+		Method:  MethodJWT,
+	}
+	if principal.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+	return principal, nil
+}
+
+// roleFromClaim accepts a role claim given as a string or a list of strings
+func roleFromClaim(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// Verify checks the token signature and registered claims
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	if err := v.verifySignature(ctx, header.Alg, header.Kid, signed, signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature only accepts the algorithm family matching the configured
+// key source, so an HMAC token can never be checked against a public key.
+func (v *JWTVerifier) verifySignature(ctx context.Context, alg, kid string, signed, signature []byte) error {
+	switch alg {
+	case "HS256":
+		if v.cfg.Secret == "" {
+			return fmt.Errorf("%w: HS256 not accepted", ErrInvalidToken)
+		}
+		mac := hmac.New(sha256.New, []byte(v.cfg.Secret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidToken
+		}
+		return nil
+
+	case "RS256", "ES256":
+		if v.jwks == nil {
+			return fmt.Errorf("%w: %s not accepted", ErrInvalidToken, alg)
+		}
+		key, err := v.jwks.key(ctx, kid)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		digest := sha256.Sum256(signed)
+		switch pub := key.(type) {
+		case *rsa.PublicKey:
+			if alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+				return ErrInvalidToken
+			}
+		case *ecdsa.PublicKey:
+			if alg != "ES256" || len(signature) != 64 {
+				return ErrInvalidToken
+			}
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if !ecdsa.Verify(pub, digest[:], r, s) {
+				return ErrInvalidToken
+			}
+		default:
+			return ErrInvalidToken
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, alg)
+}
+
+func (v *JWTVerifier) checkClaims(claims Claims) error {
+	now := time.Now()
+
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+			return ErrTokenExpired
+		}
+	} else if !v.cfg.AllowNoExp {
+		return fmt.Errorf("%w: no exp claim", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+			return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+		}
+	}
+	if v.cfg.Issuer != "" && claims.String("iss") != v.cfg.Issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// hasAudience handles aud given as a single string or an array
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwksCache fetches signing keys and refreshes them periodically, or early
+// when a token names an unknown key ID (rate limited to avoid hammering the IdP).
+type jwksCache struct {
+	url        string
+	httpClient *http.Client
+	mu         sync.Mutex
+	keys       map[string]crypto.PublicKey
+	fetchedAt  time.Time
+}
+
+const (
+	jwksTTL             = time.Hour
+	jwksMinRefreshDelay = 5 * time.Minute
+)
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.lookup(kid)
+	stale := time.Since(c.fetchedAt) > jwksTTL
+	canRefresh := time.Since(c.fetchedAt) > jwksMinRefreshDelay
+	if (!ok && canRefresh) || stale {
+		if err := c.refresh(ctx); err != nil {
+			if ok {
+				// Keep serving the cached key if the IdP is briefly unreachable
+				return key, nil
+			}
+			return nil, err
+		}
+		key, ok = c.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// lookup finds a key by ID; tokens without a kid match a single-key set
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+func (c *jwksCache) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC key is not on curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,16 @@
+package auth
+
+// Authentication methods recorded on a Principal
+const (
+	MethodNone   = "none"
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal identifies the caller of an API request
+type Principal struct {
+	Subject string `json:"subject"`
+	Tenant  string `json:"tenant,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Method  string `json:"method"`
+}
@@ -0,0 +1,47 @@
+package auth
+
+import "errors"
+
+var (
+	ErrJobQuotaExceeded     = errors.New("daily job quota exceeded")
+	ErrMinuteQuotaExceeded  = errors.New("monthly video minutes quota exceeded")
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// Quota limits what an API key may consume. Zero means unlimited.
+type Quota struct {
+	MaxJobsPerDay      int   `json:"max_jobs_per_day,omitempty"`
+	MaxMinutesPerMonth int   `json:"max_minutes_per_month,omitempty"`
+	MaxStorageBytes    int64 `json:"max_storage_bytes,omitempty"`
+}
+
+// Usage is what a caller has consumed in the current quota periods.
+// Days and months are UTC calendar periods.
+type Usage struct {
+	JobsToday        int64   `json:"jobs_today"`
+	MinutesThisMonth float64 `json:"minutes_this_month"`
+	StorageBytes     int64   `json:"storage_bytes"`
+}
+
+// Validate rejects negative limits
+func (q Quota) Validate() error {
+	if q.MaxJobsPerDay < 0 || q.MaxMinutesPerMonth < 0 || q.MaxStorageBytes < 0 {
+		return errors.New("quota limits must not be negative")
+	}
+	return nil
+}
+
+// Allow checks whether newJobs new jobs uploading uploadSize bytes in total
+// fit within the quota
+func (q Quota) Allow(usage Usage, newJobs int, uploadSize int64) error {
+	if q.MaxJobsPerDay > 0 && usage.JobsToday+int64(newJobs) > int64(q.MaxJobsPerDay) {
+		return ErrJobQuotaExceeded
+	}
+	if q.MaxMinutesPerMonth > 0 && usage.MinutesThisMonth >= float64(q.MaxMinutesPerMonth) {
+		return ErrMinuteQuotaExceeded
+	}
+	if q.MaxStorageBytes > 0 && usage.StorageBytes+uploadSize > q.MaxStorageBytes {
+		return ErrStorageQuotaExceeded
+	}
+	return nil
+}
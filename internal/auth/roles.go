@@ -0,0 +1,67 @@
+package auth
+
+// Roles, from most to least privileged
+const (
+	RoleAdmin     = "admin"
+	RoleOperator  = "operator"
+	RoleSubmitter = "submitter"
+	RoleViewer    = "viewer"
+)
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleOperator, RoleSubmitter, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// HasRole reports whether the principal holds any of the given roles
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGlobal reports whether the principal is not confined to a tenant
+func (p *Principal) IsGlobal() bool {
+	return p.Tenant == ""
+}
+
+// InTenant reports whether the principal may act on resources of tenant
+func (p *Principal) InTenant(tenant string) bool {
+	return p.IsGlobal() || p.Tenant == tenant
+}
+
+// SeesAllJobs reports whether the principal may read jobs it doesn't own
+// (within its tenant)
+func (p *Principal) SeesAllJobs() bool {
+	return p.HasRole(RoleAdmin, RoleOperator, RoleViewer)
+}
+
+// CanView reports whether the principal may read a job in tenant owned by owner
+func (p *Principal) CanView(tenant, owner string) bool {
+	return p.InTenant(tenant) && (p.SeesAllJobs() || p.owns(owner))
+}
+
+// CanModify reports whether the principal may edit or cancel a job in
+// tenant owned by owner
+func (p *Principal) CanModify(tenant, owner string) bool {
+	return p.InTenant(tenant) &&
+		(p.HasRole(RoleAdmin, RoleOperator) || (p.Role == RoleSubmitter && p.owns(owner)))
+}
+
+// CanDelete reports whether the principal may delete a job in tenant owned
+// by owner. Only admins may delete other callers' jobs.
+func (p *Principal) CanDelete(tenant, owner string) bool {
+	return p.InTenant(tenant) &&
+		(p.Role == RoleAdmin || (p.HasRole(RoleOperator, RoleSubmitter) && p.owns(owner)))
+}
+
+func (p *Principal) owns(owner string) bool {
+	return owner != "" && owner == p.Subject
+}
@@ -0,0 +1,90 @@
+// Package clock supplies the time to the API handlers, the job queue and
+// processor, and the webhook client. The real clock is used unless they
+// are given another, such as Fake, so that tests of code embedding them
+// can pin timestamps, retry schedules and backoff. IDs come from package
+// ids.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// System is the real clock
+var System Clock = system{}
+
+type system struct{}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+func (system) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake is a clock that stands still until advanced, firing the waits that
+// are then over
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFake returns a fake clock set to now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock on by d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	waiting := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = waiting
+}
+
+// Waiting returns the number of waits not yet over, so a test can tell
+// when the code it drives is blocked on the clock before advancing it
+func (f *Fake) Waiting() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
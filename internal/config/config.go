@@ -1,8 +1,14 @@
 package config
 
 import (
-	"os"
+	"flag"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/transcoder"
 )
 
 type Config struct {
@@ -10,37 +16,460 @@ type Config struct {
 	APIKey                string
 	WorkerCount           int
 	TempDir               string
+	DatabaseURL           string
+	DBMaxOpenConns        int
+	DBMaxIdleConns        int
+	DBConnMaxLifetime     int
+	StatsInterval         int
+	BackupDir             string
+	BackupInterval        int
+	BackupRetain          int
+	PurgeAfterDays        int
+	ReconcileInterval     int
+	JobMaxAttempts        int
+	JobRetryDelay         int
+	JobMaxQueueHours      int
 	GoogleCredentialsFile string
+	GoogleCredentials     string
+	S3Credentials         string
+	S3CredentialsFile     string
 	GoogleDriveFolderID   string
+	GoogleDriveMaxUploads int
+	GoogleDriveOnConflict string
 	WebhookURL            string
 	WebhookRetryCount     int
+	WebhookTimeout        int
+	WebhookMaxBackoff     int
+	WebhookBackoffJitter  float64
+	WebhookDeadline       int
+	WebhookSecret         string
+	WebhookEvents         []string
+	WebhookFormat         string
+	CloudEventsSource     string
+	WebhookTLSCertFile    string
+	WebhookTLSKeyFile     string
+	WebhookTLSCAFile      string
+	WebhookTLSInsecure    bool
+	EventBus              string
+	EventBusURL           string
+	EventBusTopic         string
+	EventBusEvents        []string
+	ProgressStep          int
+	ProgressInterval      int
+	ProgressMinInterval   int
+	JWTSecret             string
+	JWTJWKSURL            string
+	JWTIssuer             string
+	JWTAudience           string
+	JWTTenantClaim        string
+	JWTRoleClaim          string
+	JWTDefaultRole        string
+	JWTAllowNoExp         bool
+	DownloadSigningKey    string
+	DownloadLinkTTL       int
+	PublicBaseURL         string
+	PlaybackPages         bool
+	Posters               bool
+	PosterAt              string
+	PlayerAssets          bool
+	SpriteInterval        int
+	SpriteWidth           int
+	HLSSegmentSeconds     int
+	CORSAllowedOrigins    []string
+	CORSAllowedMethods    []string
+	CORSAllowedHeaders    []string
+	CORSAllowCredentials  bool
+	CORSMaxAge            int
+	ProbeUploads          bool
+	AttachDuplicates      bool
+	MinUploadSize         int
+	OutageThreshold       int
+	OutageRetry           int
+	AcceptMinDuration     int
+	AcceptMaxDuration     int
+	AcceptMaxResolution   string
+	AcceptVideoCodecs     []string
+	AcceptAudioCodecs     []string
+	AcceptContainers      []string
+	FFmpegThreads         int
+	FFmpegNice            int
+	FFmpegIOClass         string
+	FFmpegCPUPercent      int
+	FFmpegCPUs            float64
+	FFmpegMemoryMB        int
+	RenditionParallelism  int
+	HardwareEncoder       string
+	PreTranscodeHook      string
+	PostTranscodeHook     string
+	HookTimeout           int
+	PipelinePlugins       []string
+	PluginTimeout         int
+	ImageWidths           []string
+	ImageThumbnailSize    int
+	ImageFormat           string
+	ImageQuality          int
+	Features              []string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSAutocertHosts      []string
+	TLSAutocertEmail      string
+	TLSAutocertCacheDir   string
+	HTTPRedirectPort      string
+	ReadHeaderTimeout     int
+	ReadTimeout           int
+	WriteTimeout          int
+	IdleTimeout           int
+	UploadTimeout         int
+	CompressResponses     bool
+	OutputNameTemplate    string
+	SMTPHost              string
+	SMTPPort              int
+	SMTPUsername          string
+	SMTPPassword          string
+	SMTPFrom              string
+	EmailTo               []string
+	EmailSubject          string
+	EmailBodyFile         string
+	SentryDSN             string
+	SentryEnvironment     string
+	SentryRelease         string
+	ErrorReportURL        string
+	ResourceInterval      int
+	PauseDiskPercent      int
+	PauseMemoryPercent    int
+	ShutdownTimeout       int
+	DrainDelay            int
+	LeaderElection        bool
+	LeaderLease           int
+	Executor              string
+	TranscoderBackend     string
+	FakeEncodeSeconds     float64
+	FakeMediaSeconds      float64
+	FakeFailureRate       float64
+	FakeCPUCores          float64
+	K8sNamespace          string
+	K8sImage              string
+	K8sServiceAccount     string
+	K8sVolumeClaim        string
+	K8sCPURequest         string
+	K8sMemoryRequest      string
+	K8sCPULimit           string
+	K8sMemoryLimit        string
+	K8sOOMMemoryRequest   string
+	K8sOOMMemoryLimit     string
+	K8sGPUCount           int
+	K8sGPUResource        string
+	K8sJobTTL             int
+	ConfigFile            string
+	SecretsCacheTTL       int
+	SecretsRefresh        int
+	Presets               []transcoder.Preset
+	WebhookEndpoints      []WebhookEndpoint
+	Destinations          map[string]DestinationProfile
+	DefaultDestination    string
+	LoadedAt              time.Time
+	settings              map[string]Setting
+}
+
+// Load reads the configuration from the command-line flags registered by
+// RegisterFlags, the environment and, when file is set, a YAML or TOML
+// config file, in that order of precedence. flags may be nil.
+func Load(file string, flags *flag.FlagSet) (*Config, error) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	sections := &fileSections{}
+	fileValues, usedKeys, secretErr = nil, make(map[string]bool), nil
+	flagValues, loaded = setFlags(flags), make(map[string]Setting)
+	defer func() { fileValues, flagValues, usedKeys, secretErr, loaded = nil, nil, nil, nil, nil }()
+	if file != "" {
+		var err error
+		if fileValues, sections, err = readFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := build(file, sections)
+	cfg.settings = loaded
+	cfg.LoadedAt = time.Now().UTC()
+
+	if unknown := unknownKeys(fileValues, usedKeys); len(unknown) > 0 {
+		return nil, fmt.Errorf("%s: unknown settings: %s", file, strings.Join(unknown, ", "))
+	}
+	for i := range cfg.WebhookEndpoints {
+		endpoint := &cfg.WebhookEndpoints[i]
+		endpoint.Secret = resolveSecret(fmt.Sprintf("webhooks[%d].secret", i), endpoint.Secret)
+	}
+	for name, profile := range cfg.Destinations {
+		profile.SecretAccessKey = resolveSecret("destinations.profiles."+name+".secret_access_key", profile.SecretAccessKey)
+		cfg.Destinations[name] = profile
+	}
+	if secretErr != nil {
+		return nil, secretErr
+	}
+	return cfg, nil
 }
 
-func Load() *Config {
+// build reads every setting through the getters below
+func build(file string, sections *fileSections) *Config {
 	return &Config{
 		Port:                  getEnv("PORT", "8080"),
 		APIKey:                getEnv("API_KEY", ""),
 		WorkerCount:           getEnvInt("WORKER_COUNT", 2),
 		TempDir:               getEnv("TEMP_DIR", "/tmp/transcoder"),
+		DatabaseURL:           getEnv("DATABASE_URL", ""),
+		DBMaxOpenConns:        getEnvInt("DB_MAX_OPEN_CONNS", 10),
+		DBMaxIdleConns:        getEnvInt("DB_MAX_IDLE_CONNS", 5),
+		DBConnMaxLifetime:     getEnvInt("DB_CONN_MAX_LIFETIME", 1800),
+		StatsInterval:         getEnvInt("STATS_INTERVAL", 300),
+		BackupDir:             getEnv("BACKUP_DIR", ""),
+		BackupInterval:        getEnvInt("BACKUP_INTERVAL", 0),
+		BackupRetain:          getEnvInt("BACKUP_RETAIN", 7),
+		PurgeAfterDays:        getEnvInt("PURGE_AFTER_DAYS", 0),
+		ReconcileInterval:     getEnvInt("RECONCILE_INTERVAL", 0),
+		JobMaxAttempts:        getEnvInt("JOB_MAX_ATTEMPTS", 1),
+		JobRetryDelay:         getEnvInt("JOB_RETRY_DELAY", 60),
+		JobMaxQueueHours:      getEnvInt("JOB_MAX_QUEUE_HOURS", 0),
 		GoogleCredentialsFile: getEnv("GOOGLE_CREDENTIALS_FILE", "/config/credentials.json"),
+		GoogleCredentials:     getEnv("GOOGLE_CREDENTIALS", ""),
+		S3Credentials:         getEnv("S3_CREDENTIALS", ""),
+		S3CredentialsFile:     getEnv("S3_CREDENTIALS_FILE", ""),
 		GoogleDriveFolderID:   getEnv("GOOGLE_DRIVE_FOLDER_ID", ""),
+		GoogleDriveMaxUploads: getEnvInt("GOOGLE_DRIVE_MAX_UPLOADS", 0),
+		GoogleDriveOnConflict: getEnv("GOOGLE_DRIVE_ON_CONFLICT", "duplicate"),
 		WebhookURL:            getEnv("WEBHOOK_URL", ""),
 		WebhookRetryCount:     getEnvInt("WEBHOOK_RETRY_COUNT", 3),
+		WebhookTimeout:        getEnvInt("WEBHOOK_TIMEOUT", 30),
+		WebhookMaxBackoff:     getEnvInt("WEBHOOK_MAX_BACKOFF", 0),
+		WebhookBackoffJitter:  getEnvFloat("WEBHOOK_BACKOFF_JITTER", 0),
+		WebhookDeadline:       getEnvInt("WEBHOOK_DEADLINE", 300),
+		WebhookSecret:         getEnv("WEBHOOK_SECRET", ""),
+		WebhookEvents:         getEnvList("WEBHOOK_EVENTS", "job.completed,job.failed"),
+		WebhookFormat:         getEnv("WEBHOOK_FORMAT", "json"),
+		CloudEventsSource:     getEnv("CLOUDEVENTS_SOURCE", "/skillcape-transcoder"),
+		WebhookTLSCertFile:    getEnv("WEBHOOK_TLS_CERT_FILE", ""),
+		WebhookTLSKeyFile:     getEnv("WEBHOOK_TLS_KEY_FILE", ""),
+		WebhookTLSCAFile:      getEnv("WEBHOOK_TLS_CA_FILE", ""),
+		WebhookTLSInsecure:    getEnvBool("WEBHOOK_TLS_INSECURE_SKIP_VERIFY", false),
+		EventBus:              getEnv("EVENT_BUS", ""),
+		EventBusURL:           getEnv("EVENT_BUS_URL", ""),
+		EventBusTopic:         getEnv("EVENT_BUS_TOPIC", ""),
+		EventBusEvents:        getEnvList("EVENT_BUS_EVENTS", "*"),
+		ProgressStep:          getEnvInt("WEBHOOK_PROGRESS_STEP", 10),
+		ProgressInterval:      getEnvInt("WEBHOOK_PROGRESS_INTERVAL", 0),
+		ProgressMinInterval:   getEnvInt("WEBHOOK_PROGRESS_MIN_INTERVAL", 5),
+		JWTSecret:             getEnv("JWT_SECRET", ""),
+		JWTJWKSURL:            getEnv("JWT_JWKS_URL", ""),
+		JWTIssuer:             getEnv("JWT_ISSUER", ""),
+		JWTAudience:           getEnv("JWT_AUDIENCE", ""),
+		JWTTenantClaim:        getEnv("JWT_TENANT_CLAIM", "tenant"),
+		JWTRoleClaim:          getEnv("JWT_ROLE_CLAIM", "role"),
+		JWTDefaultRole:        getEnv("JWT_DEFAULT_ROLE", "viewer"),
+		JWTAllowNoExp:         getEnvBool("JWT_ALLOW_NO_EXP", false),
+		DownloadSigningKey:    getEnv("DOWNLOAD_SIGNING_KEY", ""),
+		DownloadLinkTTL:       getEnvInt("DOWNLOAD_LINK_TTL", 3600),
+		PublicBaseURL:         getEnv("PUBLIC_BASE_URL", ""),
+		PlaybackPages:         getEnvBool("PLAYBACK_PAGES", false),
+		Posters:               getEnvBool("POSTERS", false),
+		PosterAt:              getEnv("POSTER_AT", "10%"),
+		PlayerAssets:          getEnvBool("PLAYER_ASSETS", false),
+		SpriteInterval:        getEnvInt("SPRITE_INTERVAL", 10),
+		SpriteWidth:           getEnvInt("SPRITE_WIDTH", 160),
+		HLSSegmentSeconds:     getEnvInt("HLS_SEGMENT_SECONDS", 6),
+		CORSAllowedOrigins:    getEnvList("CORS_ALLOWED_ORIGINS", "*"),
+		CORSAllowedMethods:    getEnvList("CORS_ALLOWED_METHODS", "GET, POST, PUT, PATCH, DELETE, OPTIONS"),
+		CORSAllowedHeaders:    getEnvList("CORS_ALLOWED_HEADERS", "Origin, Content-Type, Accept, Authorization, X-API-Key, X-Upload-ID"),
+		CORSAllowCredentials:  getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+		CORSMaxAge:            getEnvInt("CORS_MAX_AGE", 86400),
+		ProbeUploads:          getEnvBool("PROBE_UPLOADS", true),
+		AttachDuplicates:      getEnvBool("ATTACH_DUPLICATES", false),
+		MinUploadSize:         getEnvInt("MIN_UPLOAD_SIZE", 1024),
+		OutageThreshold:       getEnvInt("DESTINATION_OUTAGE_THRESHOLD", 3),
+		OutageRetry:           getEnvInt("DESTINATION_OUTAGE_RETRY", 60),
+		AcceptMinDuration:     getEnvInt("ACCEPT_MIN_DURATION", 0),
+		AcceptMaxDuration:     getEnvInt("ACCEPT_MAX_DURATION", 0),
+		AcceptMaxResolution:   getEnv("ACCEPT_MAX_RESOLUTION", ""),
+		AcceptVideoCodecs:     getEnvList("ACCEPT_VIDEO_CODECS", ""),
+		AcceptAudioCodecs:     getEnvList("ACCEPT_AUDIO_CODECS", ""),
+		AcceptContainers:      getEnvList("ACCEPT_CONTAINERS", ""),
+		FFmpegThreads:         getEnvInt("FFMPEG_THREADS", 0),
+		FFmpegNice:            getEnvInt("FFMPEG_NICE", 0),
+		FFmpegIOClass:         getEnv("FFMPEG_IONICE", ""),
+		FFmpegCPUPercent:      getEnvInt("FFMPEG_CPU_PERCENT", 0),
+		FFmpegCPUs:            getEnvFloat("FFMPEG_CPUS", 0),
+		FFmpegMemoryMB:        getEnvInt("FFMPEG_MEMORY_MB", 0),
+		RenditionParallelism:  getEnvInt("RENDITION_PARALLELISM", 1),
+		HardwareEncoder:       getEnv("HARDWARE_ENCODER", ""),
+		PreTranscodeHook:      getEnv("PRE_TRANSCODE_HOOK", ""),
+		PostTranscodeHook:     getEnv("POST_TRANSCODE_HOOK", ""),
+		HookTimeout:           getEnvInt("HOOK_TIMEOUT", 300),
+		PipelinePlugins:       getEnvList("PIPELINE_PLUGINS", ""),
+		PluginTimeout:         getEnvInt("PLUGIN_TIMEOUT", 300),
+		ImageWidths:           getEnvList("IMAGE_WIDTHS", "1920,1280,640"),
+		ImageThumbnailSize:    getEnvInt("IMAGE_THUMBNAIL_SIZE", 320),
+		ImageFormat:           getEnv("IMAGE_FORMAT", "webp"),
+		ImageQuality:          getEnvInt("IMAGE_QUALITY", 80),
+		Features:              getEnvList("FEATURES", ""),
+		TLSCertFile:           getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:            getEnv("TLS_KEY_FILE", ""),
+		TLSAutocertHosts:      getEnvList("TLS_AUTOCERT_HOSTS", ""),
+		TLSAutocertEmail:      getEnv("TLS_AUTOCERT_EMAIL", ""),
+		TLSAutocertCacheDir:   getEnv("TLS_AUTOCERT_CACHE_DIR", ""),
+		HTTPRedirectPort:      getEnv("HTTP_REDIRECT_PORT", ""),
+		ReadHeaderTimeout:     getEnvInt("HTTP_READ_HEADER_TIMEOUT", 10),
+		ReadTimeout:           getEnvInt("HTTP_READ_TIMEOUT", 30),
+		WriteTimeout:          getEnvInt("HTTP_WRITE_TIMEOUT", 30),
+		IdleTimeout:           getEnvInt("HTTP_IDLE_TIMEOUT", 60),
+		UploadTimeout:         getEnvInt("UPLOAD_TIMEOUT", 3600),
+		CompressResponses:     getEnvBool("COMPRESS_RESPONSES", true),
+		OutputNameTemplate:    getEnv("OUTPUT_NAME_TEMPLATE", jobs.DefaultOutputNameTemplate),
+		SMTPHost:              getEnv("SMTP_HOST", ""),
+		SMTPPort:              getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:          getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:          getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:              getEnv("SMTP_FROM", ""),
+		EmailTo:               getEnvList("EMAIL_TO", ""),
+		EmailSubject:          getEnv("EMAIL_SUBJECT_TEMPLATE", ""),
+		EmailBodyFile:         getEnv("EMAIL_BODY_TEMPLATE_FILE", ""),
+		SentryDSN:             getEnv("SENTRY_DSN", ""),
+		SentryEnvironment:     getEnv("SENTRY_ENVIRONMENT", "production"),
+		SentryRelease:         getEnv("SENTRY_RELEASE", ""),
+		ErrorReportURL:        getEnv("ERROR_REPORT_URL", ""),
+		ResourceInterval:      getEnvInt("RESOURCE_SAMPLE_INTERVAL", 15),
+		PauseDiskPercent:      getEnvInt("PAUSE_DISK_PERCENT", 0),
+		PauseMemoryPercent:    getEnvInt("PAUSE_MEMORY_PERCENT", 0),
+		ShutdownTimeout:       getEnvInt("SHUTDOWN_TIMEOUT", 30),
+		DrainDelay:            getEnvInt("DRAIN_DELAY", 0),
+		LeaderElection:        getEnvBool("LEADER_ELECTION", false),
+		LeaderLease:           getEnvInt("LEADER_LEASE_DURATION", 15),
+		Executor:              getEnv("EXECUTOR", "local"),
+		TranscoderBackend:     getEnv("TRANSCODER_BACKEND", "ffmpeg"),
+		FakeEncodeSeconds:     getEnvFloat("FAKE_ENCODE_SECONDS", 5),
+		FakeMediaSeconds:      getEnvFloat("FAKE_MEDIA_DURATION", 60),
+		FakeFailureRate:       getEnvFloat("FAKE_FAILURE_RATE", 0),
+		FakeCPUCores:          getEnvFloat("FAKE_CPU_CORES", 4),
+		K8sNamespace:          getEnv("K8S_NAMESPACE", ""),
+		K8sImage:              getEnv("K8S_IMAGE", ""),
+		K8sServiceAccount:     getEnv("K8S_SERVICE_ACCOUNT", ""),
+		K8sVolumeClaim:        getEnv("K8S_VOLUME_CLAIM", ""),
+		K8sCPURequest:         getEnv("K8S_CPU_REQUEST", ""),
+		K8sMemoryRequest:      getEnv("K8S_MEMORY_REQUEST", ""),
+		K8sCPULimit:           getEnv("K8S_CPU_LIMIT", ""),
+		K8sMemoryLimit:        getEnv("K8S_MEMORY_LIMIT", ""),
+		K8sOOMMemoryRequest:   getEnv("K8S_OOM_MEMORY_REQUEST", ""),
+		K8sOOMMemoryLimit:     getEnv("K8S_OOM_MEMORY_LIMIT", ""),
+		K8sGPUCount:           getEnvInt("K8S_GPU_COUNT", 0),
+		K8sGPUResource:        getEnv("K8S_GPU_RESOURCE", "nvidia.com/gpu"),
+		K8sJobTTL:             getEnvInt("K8S_JOB_TTL", 3600),
+		ConfigFile:            file,
+		SecretsCacheTTL:       getEnvInt("SECRETS_CACHE_TTL", 300),
+		SecretsRefresh:        getEnvInt("SECRETS_REFRESH_INTERVAL", 0),
+		Presets:               sections.Presets,
+		WebhookEndpoints:      sections.Webhooks,
+		Destinations:          sections.Destinations.Profiles,
+		DefaultDestination:    getEnv("DESTINATION", ""),
 	}
 }
 
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	register(key, defaultValue)
+	if value := lookup(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
 func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+	register(key, defaultValue)
+	if value := lookup(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			return intValue
 		}
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	register(key, defaultValue)
+	if value := lookup(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	register(key, defaultValue)
+	if value := lookup(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// ImageSettings returns the variants image jobs make. Widths that aren't
+// numbers are kept as 0, which Validate rejects.
+func (c *Config) ImageSettings() transcoder.ImageSettings {
+	widths := make([]int, len(c.ImageWidths))
+	for i, width := range c.ImageWidths {
+		widths[i], _ = strconv.Atoi(width)
+	}
+	return transcoder.ImageSettings{
+		Widths:        widths,
+		ThumbnailSize: c.ImageThumbnailSize,
+		Format:        c.ImageFormat,
+		Quality:       c.ImageQuality,
+	}
+}
+
+// AcceptanceRules returns the rules inputs must meet to be transcoded. A
+// maximum resolution that isn't WIDTHxHEIGHT is kept as -1x-1, which
+// Validate rejects.
+func (c *Config) AcceptanceRules() transcoder.AcceptanceRules {
+	rules := transcoder.AcceptanceRules{
+		MinDuration: time.Duration(c.AcceptMinDuration) * time.Second,
+		MaxDuration: time.Duration(c.AcceptMaxDuration) * time.Second,
+		VideoCodecs: c.AcceptVideoCodecs,
+		AudioCodecs: c.AcceptAudioCodecs,
+		Containers:  c.AcceptContainers,
+	}
+	if c.AcceptMaxResolution != "" {
+		width, height, ok := strings.Cut(strings.ToLower(c.AcceptMaxResolution), "x")
+		rules.MaxWidth, rules.MaxHeight = -1, -1
+		if w, err := strconv.Atoi(width); ok && err == nil && w > 0 {
+			if h, err := strconv.Atoi(height); err == nil && h > 0 {
+				rules.MaxWidth, rules.MaxHeight = max(w, h), min(w, h)
+			}
+		}
+	}
+	return rules
+}
+
+// FakeBackend returns the simulated ffmpeg TRANSCODER_BACKEND=fake selects
+func (c *Config) FakeBackend() *transcoder.FakeBackend {
+	return &transcoder.FakeBackend{
+		EncodeTime:    time.Duration(c.FakeEncodeSeconds * float64(time.Second)),
+		MediaDuration: time.Duration(c.FakeMediaSeconds * float64(time.Second)),
+		FailureRate:   c.FakeFailureRate,
+		CPUCores:      c.FakeCPUCores,
+	}
+}
+
+// Seconds converts a setting given in whole seconds, such as a timeout,
+// where 0 usually turns it off
+func Seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
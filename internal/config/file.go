@@ -0,0 +1,232 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/skillcape/transcoder/internal/secrets"
+	"github.com/skillcape/transcoder/internal/transcoder"
+	"gopkg.in/yaml.v3"
+)
+
+// WebhookEndpoint is a webhook receiver declared in the config file. It is
+// delivered to alongside endpoints registered through the API.
+type WebhookEndpoint struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret"`
+	Format      string   `json:"format"`
+	Description string   `json:"description"`
+
+	// Delivery timing in place of WEBHOOK_TIMEOUT and the like; zero
+	// keeps theirs
+	TimeoutSeconds    int     `json:"timeout_seconds"`
+	MaxBackoffSeconds int     `json:"max_backoff_seconds"`
+	BackoffJitter     float64 `json:"backoff_jitter"`
+	DeadlineSeconds   int     `json:"deadline_seconds"`
+}
+
+// DestinationProfile is a named place outputs can be delivered, declared
+// in the config file. Type selects which of the other fields apply.
+type DestinationProfile struct {
+	Type            string `json:"type"` // google_drive, s3 or directory
+	CredentialsFile string `json:"credentials_file"`
+	FolderID        string `json:"folder_id"`
+	Bucket          string `json:"bucket"`
+	Region          string `json:"region"`
+	Endpoint        string `json:"endpoint"`
+	Prefix          string `json:"prefix"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	Path            string `json:"path"`
+	BaseURL         string `json:"base_url"`
+	MaxUploads      int    `json:"max_uploads"` // at once, from this server; 0 is unlimited
+	OnConflict      string `json:"on_conflict"` // google_drive only; see storage.ValidateConflict
+
+	// s3 only, sent with every upload; see storage.S3Config
+	StorageClass string            `json:"storage_class"`
+	Tags         map[string]string `json:"tags"`
+	CacheControl string            `json:"cache_control"`
+}
+
+// Destination types
+const (
+	DestinationGoogleDrive = "google_drive"
+	DestinationS3          = "s3"
+	DestinationDirectory   = "directory"
+)
+
+// fileSections are the structured parts of the config file, which have no
+// environment variable equivalent
+type fileSections struct {
+	Presets      []transcoder.Preset `json:"presets"`
+	Webhooks     []WebhookEndpoint   `json:"webhooks"`
+	Destinations struct {
+		GoogleDrive struct {
+			CredentialsFile string `json:"credentials_file"`
+			FolderID        string `json:"folder_id"`
+			MaxUploads      int    `json:"max_uploads"`
+			OnConflict      string `json:"on_conflict"`
+		} `json:"google_drive"`
+		Profiles map[string]DestinationProfile `json:"profiles"`
+	} `json:"destinations"`
+}
+
+// fileValues holds the settings read from the config file, keyed by the
+// environment variable each one stands in for. usedKeys records the keys
+// Load asked for so unknown settings can be reported, and secretErr the
+// first secret reference that could not be resolved.
+var (
+	loadMu     sync.Mutex
+	fileValues map[string]string
+	usedKeys   map[string]bool
+	secretErr  error
+)
+
+// lookup returns the value of a setting: the flag when given, the
+// environment variable when set, otherwise the config file value. Values
+// naming a secret in a secret manager are replaced by the secret.
+func lookup(key string) string {
+	if registry != nil {
+		return ""
+	}
+	if usedKeys != nil {
+		usedKeys[key] = true
+	}
+	value, source := flagValues[key], SourceFlag
+	if value == "" {
+		value, source = os.Getenv(key), SourceEnv
+	}
+	if value == "" {
+		value, source = fileValues[key], SourceFile
+	}
+	if value != "" && loaded != nil {
+		loaded[key] = Setting{Value: value, Source: source}
+	}
+	return resolveSecret(key, value)
+}
+
+// resolveSecret returns value, or the secret it names
+func resolveSecret(name, value string) string {
+	if !secrets.IsReference(value) {
+		return value
+	}
+	resolved, err := secrets.Resolve(context.Background(), value)
+	if err != nil {
+		if secretErr == nil {
+			secretErr = fmt.Errorf("%s: %v", name, err)
+		}
+		return ""
+	}
+	return resolved
+}
+
+// readFile parses a YAML or TOML config file. Plain settings use the
+// environment variable names in lower case; lists may be written as lists.
+func readFile(path string) (map[string]string, *fileSections, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, nil, fmt.Errorf("%s: config file must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// The structured sections are decoded through JSON so they share the
+	// field names used by the API
+	structured := make(map[string]interface{})
+	values := make(map[string]string)
+	for key, value := range raw {
+		switch key {
+		case "presets", "webhooks", "destinations":
+			structured[key] = value
+			continue
+		}
+		s, err := settingString(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s %v", path, key, err)
+		}
+		values[strings.ToUpper(key)] = s
+	}
+
+	sections := &fileSections{}
+	encoded, err := json.Marshal(structured)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(sections); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	drive := sections.Destinations.GoogleDrive
+	if drive.CredentialsFile != "" {
+		values["GOOGLE_CREDENTIALS_FILE"] = drive.CredentialsFile
+	}
+	if drive.FolderID != "" {
+		values["GOOGLE_DRIVE_FOLDER_ID"] = drive.FolderID
+	}
+	if drive.MaxUploads != 0 {
+		values["GOOGLE_DRIVE_MAX_UPLOADS"] = strconv.Itoa(drive.MaxUploads)
+	}
+	if drive.OnConflict != "" {
+		values["GOOGLE_DRIVE_ON_CONFLICT"] = drive.OnConflict
+	}
+	return values, sections, nil
+}
+
+// settingString renders a plain setting the way it would be written in
+// the environment
+func settingString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := settingString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("must be a string, number, boolean or list")
+}
+
+// unknownKeys lists config file settings that Load never looked up
+func unknownKeys(values map[string]string, used map[string]bool) []string {
+	var unknown []string
+	for key := range values {
+		if !used[key] {
+			unknown = append(unknown, strings.ToLower(key))
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
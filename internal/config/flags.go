@@ -0,0 +1,78 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// declaredSetting is one environment variable and its default, as
+// collected by RegisterFlags
+type declaredSetting struct {
+	key          string
+	defaultValue interface{}
+}
+
+// registry collects settings instead of reading them while RegisterFlags
+// runs; flagValues holds the flags given on the command line during Load
+var (
+	registry   *[]declaredSetting
+	flagValues map[string]string
+)
+
+// register notes a setting read by one of the getters
+func register(key string, defaultValue interface{}) {
+	if registry != nil {
+		*registry = append(*registry, declaredSetting{key: key, defaultValue: defaultValue})
+	}
+	if loaded != nil {
+		loaded[key] = Setting{Value: fmt.Sprint(defaultValue), Source: SourceDefault}
+	}
+}
+
+// RegisterFlags adds a flag for every setting to fs, named after its
+// environment variable: WORKER_COUNT becomes --worker-count. Flags given
+// on the command line override the environment; pass fs to Load once
+// parsed.
+func RegisterFlags(fs *flag.FlagSet) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	var settings []declaredSetting
+	registry = &settings
+	build("", &fileSections{})
+	registry = nil
+
+	for _, s := range settings {
+		name := flagName(s.key)
+		if fs.Lookup(name) != nil {
+			continue
+		}
+		usage := "overrides " + s.key
+		switch value := s.defaultValue.(type) {
+		case int:
+			fs.Int(name, value, usage)
+		case bool:
+			fs.Bool(name, value, usage)
+		case string:
+			fs.String(name, value, usage)
+		}
+	}
+}
+
+// setFlags returns the flags given on the command line, keyed by the
+// environment variable each overrides
+func setFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	if fs == nil {
+		return values
+	}
+	fs.Visit(func(f *flag.Flag) {
+		values[strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))] = f.Value.String()
+	})
+	return values
+}
+
+func flagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+}
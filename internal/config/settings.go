@@ -0,0 +1,86 @@
+package config
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/skillcape/transcoder/internal/secrets"
+)
+
+// Where a setting's value came from
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// redacted replaces secret values in Settings
+const redacted = "[redacted]"
+
+// Setting is the value a variable was loaded with, before any secret
+// reference in it was resolved
+type Setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// loaded collects the settings read during Load
+var loaded map[string]Setting
+
+// Settings returns every variable's value and source, keyed by variable
+// name. Secrets, and passwords and query strings in URLs, are redacted;
+// secret references are shown as they are.
+func (c *Config) Settings() map[string]Setting {
+	settings := make(map[string]Setting, len(c.settings))
+	for key, setting := range c.settings {
+		switch {
+		case setting.Value == "" || secrets.IsReference(setting.Value):
+		case isSecretSetting(key):
+			setting.Value = redacted
+		default:
+			setting.Value = RedactURL(setting.Value)
+		}
+		settings[key] = setting
+	}
+	return settings
+}
+
+// Changed lists the variables whose values differ between c and other
+func (c *Config) Changed(other *Config) []string {
+	var changed []string
+	for key, setting := range c.settings {
+		if other.settings[key].Value != setting.Value {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// isSecretSetting reports whether a variable holds a credential
+func isSecretSetting(key string) bool {
+	for _, suffix := range []string{"_KEY", "_SECRET", "_PASSWORD", "_TOKEN", "_CREDENTIALS"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return key == "SENTRY_DSN"
+}
+
+// RedactURL hides the password and query of a URL value, where tokens are
+// often carried; other values are returned unchanged
+func RedactURL(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+	return u.Redacted()
+}
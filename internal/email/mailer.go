@@ -0,0 +1,205 @@
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default templates, rendered with a Message
+const (
+	DefaultSubjectTemplate = `Transcode {{.Status}}: {{.OriginalName}}`
+	DefaultBodyTemplate    = `Your video "{{.OriginalName}}" has {{.Status}}.
+{{if .DriveURL}}
+Download it from Google Drive: {{.DriveURL}}
+{{end}}{{if .Error}}
+Error: {{.Error}}
+{{end}}
+Job ID: {{.JobID}}
+Output: {{.OutputName}}
+`
+)
+
+// MaxRecipients caps the addresses a single job may notify
+const MaxRecipients = 10
+
+// Config holds the SMTP server settings and the recipients used when a job
+// names none
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Message is the data templates are rendered with
+type Message struct {
+	JobID        string
+	Status       string
+	OriginalName string
+	OutputName   string
+	DriveURL     string
+	Error        string
+	CompletedAt  time.Time
+}
+
+// Mailer sends job notification emails over SMTP
+type Mailer struct {
+	cfg     Config
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewMailer parses the subject and body templates (empty uses the
+// defaults) and checks the sender address
+func NewMailer(cfg Config, subjectTemplate, bodyTemplate string) (*Mailer, error) {
+	if subjectTemplate == "" {
+		subjectTemplate = DefaultSubjectTemplate
+	}
+	if bodyTemplate == "" {
+		bodyTemplate = DefaultBodyTemplate
+	}
+
+	subject, err := template.New("subject").Parse(subjectTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	body, err := template.New("body").Parse(bodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+
+	return &Mailer{cfg: cfg, subject: subject, body: body}, nil
+}
+
+// ValidateRecipients checks a recipient list, returning the bare addresses
+func ValidateRecipients(recipients []string) ([]string, error) {
+	if len(recipients) > MaxRecipients {
+		return nil, fmt.Errorf("at most %d email recipients are allowed", MaxRecipients)
+	}
+	addresses := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		addr, err := mail.ParseAddress(strings.TrimSpace(recipient))
+		if err != nil {
+			return nil, fmt.Errorf("invalid email address %q", recipient)
+		}
+		addresses = append(addresses, addr.Address)
+	}
+	return addresses, nil
+}
+
+// Send renders the templates for msg and mails the result to every
+// recipient
+func (m *Mailer) Send(to []string, msg *Message) error {
+	var subject, body bytes.Buffer
+	if err := m.subject.Execute(&subject, msg); err != nil {
+		return fmt.Errorf("rendering subject: %w", err)
+	}
+	if err := m.body.Execute(&body, msg); err != nil {
+		return fmt.Errorf("rendering body: %w", err)
+	}
+
+	// Subjects come from file names; keep them to one header line
+	subjectLine := strings.Join(strings.Fields(subject.String()), " ")
+
+	var data bytes.Buffer
+	fmt.Fprintf(&data, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&data, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&data, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subjectLine))
+	fmt.Fprintf(&data, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	data.WriteString("MIME-Version: 1.0\r\n")
+	data.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	data.WriteString("\r\n")
+	data.WriteString(strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n"))
+
+	return m.deliver(to, data.Bytes())
+}
+
+// deliver hands a message to the SMTP server. Port 465 uses implicit TLS;
+// other ports upgrade with STARTTLS when the server offers it.
+func (m *Mailer) deliver(to []string, data []byte) error {
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	if m.cfg.Port != 465 {
+		return smtp.SendMail(addr, auth, from.Address, to, data)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, &tls.Config{ServerName: m.cfg.Host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// SendAsync mails msg in the background to to, or to the default
+// recipients if to is empty, then calls done with the outcome. Nothing is
+// sent, and done is not called, if there are no recipients or the Mailer
+// is nil.
+func (m *Mailer) SendAsync(to []string, msg *Message, done func(err error)) {
+	if m == nil {
+		return
+	}
+	if len(to) == 0 {
+		to = m.cfg.To
+	}
+	if len(to) == 0 {
+		return
+	}
+	go func() {
+		done(m.Send(to, msg))
+	}()
+}
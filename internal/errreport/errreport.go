@@ -0,0 +1,195 @@
+// Package errreport sends panics and failures to Sentry, or as JSON to any
+// HTTP endpoint, so they reach alerting instead of only the logs. Events
+// are sent in the background and dropped if the tracker falls behind.
+package errreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Levels
+const (
+	LevelError = "error"
+	LevelFatal = "fatal"
+)
+
+// Kinds of event
+const (
+	KindPanic         = "panic"
+	KindJobFailed     = "job_failed"
+	KindWebhookFailed = "webhook_failed"
+)
+
+// queueSize bounds the events waiting to be sent
+const queueSize = 100
+
+// Event is one failure. Tags are short values to search and group by, such
+// as the job ID; Extra holds longer context.
+type Event struct {
+	Kind    string
+	Level   string
+	Message string
+	Tags    map[string]string
+	Extra   map[string]interface{}
+	Stack   string
+}
+
+// Config chooses where events are sent; both may be set
+type Config struct {
+	DSN         string // Sentry DSN
+	URL         string // generic endpoint that receives each event as JSON
+	Environment string
+	Release     string
+}
+
+type reporter struct {
+	config  Config
+	sentry  *sentryTarget
+	client  *http.Client
+	events  chan *Event
+	pending sync.WaitGroup
+	host    string
+}
+
+var (
+	mu      sync.RWMutex
+	current *reporter
+)
+
+// Configure starts sending events. With neither DSN nor URL set events
+// are discarded.
+func Configure(config Config) error {
+	if config.DSN == "" && config.URL == "" {
+		return nil
+	}
+	r := &reporter{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan *Event, queueSize),
+	}
+	r.host, _ = os.Hostname()
+	if config.DSN != "" {
+		target, err := parseDSN(config.DSN)
+		if err != nil {
+			return err
+		}
+		r.sentry = target
+	}
+	go r.run()
+
+	mu.Lock()
+	current = r
+	mu.Unlock()
+	return nil
+}
+
+// Capture queues an event for sending
+func Capture(event Event) {
+	mu.RLock()
+	r := current
+	mu.RUnlock()
+	if r == nil {
+		return
+	}
+	if event.Level == "" {
+		event.Level = LevelError
+	}
+	r.pending.Add(1)
+	select {
+	case r.events <- &event:
+	default:
+		r.pending.Done()
+		log.Printf("Error report dropped, queue full: %s", event.Message)
+	}
+}
+
+// CapturePanic reports a recovered panic value with the current stack
+func CapturePanic(value interface{}, tags map[string]string) {
+	Capture(Event{
+		Kind:    KindPanic,
+		Level:   LevelFatal,
+		Message: fmt.Sprintf("panic: %v", value),
+		Tags:    tags,
+		Stack:   string(debug.Stack()),
+	})
+}
+
+// Flush waits up to timeout for queued events to be sent
+func Flush(timeout time.Duration) {
+	mu.RLock()
+	r := current
+	mu.RUnlock()
+	if r == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (r *reporter) run() {
+	for event := range r.events {
+		if r.sentry != nil {
+			if err := r.sendSentry(event); err != nil {
+				log.Printf("Failed to send error report to Sentry: %v", err)
+			}
+		}
+		if r.config.URL != "" {
+			if err := r.sendHook(event); err != nil {
+				log.Printf("Failed to send error report: %v", err)
+			}
+		}
+		r.pending.Done()
+	}
+}
+
+// sendHook posts the event to the generic endpoint
+func (r *reporter) sendHook(event *Event) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"kind":        event.Kind,
+		"level":       event.Level,
+		"message":     event.Message,
+		"tags":        event.Tags,
+		"extra":       event.Extra,
+		"stack":       event.Stack,
+		"environment": r.config.Environment,
+		"release":     r.config.Release,
+		"server_name": r.host,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Post(r.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", r.config.URL, resp.Status)
+	}
+	return nil
+}
+
+// newEventID returns 32 random hex digits, the form Sentry expects
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,93 @@
+package errreport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sentryTarget is the envelope endpoint and key from a DSN such as
+// https://<key>@o0.ingest.sentry.io/<project>
+type sentryTarget struct {
+	endpoint string
+	key      string
+}
+
+func parseDSN(dsn string) (*sentryTarget, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN")
+	}
+	path := strings.TrimRight(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	project := path[i+1:]
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: no project ID")
+	}
+	return &sentryTarget{
+		endpoint: u.Scheme + "://" + u.Host + path[:i] + "/api/" + project + "/envelope/",
+		key:      u.User.Username(),
+	}, nil
+}
+
+// sendSentry posts the event as a Sentry envelope
+func (r *reporter) sendSentry(event *Event) error {
+	tags := map[string]string{"kind": event.Kind}
+	for k, v := range event.Tags {
+		tags[k] = v
+	}
+	extra := map[string]interface{}{}
+	for k, v := range event.Extra {
+		extra[k] = v
+	}
+	if event.Stack != "" {
+		extra["stack"] = event.Stack
+	}
+
+	id := newEventID()
+	now := time.Now().UTC()
+	item, err := json.Marshal(map[string]interface{}{
+		"event_id":    id,
+		"timestamp":   now.Format(time.RFC3339),
+		"platform":    "go",
+		"logger":      "transcoder",
+		"level":       event.Level,
+		"message":     map[string]string{"formatted": event.Message},
+		"environment": r.config.Environment,
+		"release":     r.config.Release,
+		"server_name": r.host,
+		"tags":        tags,
+		"extra":       extra,
+	})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "{\"event_id\":%q,\"sent_at\":%q}\n", id, now.Format(time.RFC3339))
+	fmt.Fprintf(&body, "{\"type\":\"event\",\"length\":%d}\n", len(item))
+	body.Write(item)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, r.sentry.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=skillcape-transcoder/1.0, sentry_key="+r.sentry.key)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sentry returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
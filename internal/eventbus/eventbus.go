@@ -0,0 +1,54 @@
+// Package eventbus publishes job lifecycle events to a message bus (Kafka,
+// NATS, or Google Pub/Sub) for consumers that shouldn't depend on webhooks.
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Supported buses
+const (
+	DriverKafka  = "kafka"
+	DriverNATS   = "nats"
+	DriverPubSub = "pubsub"
+)
+
+// Publisher sends messages to one topic. key groups related messages (the
+// job ID) where the bus supports it.
+type Publisher interface {
+	Publish(ctx context.Context, key string, body []byte) error
+	Close() error
+}
+
+// Config selects and addresses a bus
+type Config struct {
+	Driver      string
+	URL         string // Kafka brokers (comma-separated) or NATS server URL
+	Topic       string // Kafka topic, NATS subject, or projects/<project>/topics/<topic>
+	Credentials []byte // Google service account key for Pub/Sub
+}
+
+// New connects to the configured bus
+func New(ctx context.Context, cfg Config) (Publisher, error) {
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("a topic is required")
+	}
+	switch cfg.Driver {
+	case DriverKafka:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("kafka needs at least one broker")
+		}
+		return newKafka(strings.Split(cfg.URL, ","), cfg.Topic), nil
+	case DriverNATS:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("nats needs a server URL")
+		}
+		return newNATS(cfg.URL, cfg.Topic)
+	case DriverPubSub:
+		return newPubSub(ctx, cfg.Credentials, cfg.Topic)
+	default:
+		return nil, fmt.Errorf("unknown driver %q (want %s, %s, or %s)", cfg.Driver, DriverKafka, DriverNATS, DriverPubSub)
+	}
+}
@@ -0,0 +1,36 @@
+package eventbus
+
+import (
+	"context"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// newKafka creates a publisher that hashes keys to partitions, so each
+// job's events stay in order
+func newKafka(brokers []string, topic string) *kafkaPublisher {
+	for i := range brokers {
+		brokers[i] = strings.TrimSpace(brokers[i])
+	}
+	return &kafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+func (k *kafkaPublisher) Publish(ctx context.Context, key string, body []byte) error {
+	return k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: body})
+}
+
+func (k *kafkaPublisher) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,38 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// newNATS connects to a NATS server, reconnecting indefinitely if the
+// connection drops
+func newNATS(url, subject string) (*natsPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("skillcape-transcoder"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &natsPublisher{conn: conn, subject: subject}, nil
+}
+
+func (n *natsPublisher) Publish(ctx context.Context, key string, body []byte) error {
+	msg := nats.NewMsg(n.subject)
+	msg.Header.Set("Job-ID", key)
+	msg.Data = body
+	if err := n.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	// Core NATS is fire-and-forget; flushing surfaces connection errors
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *natsPublisher) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,44 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+)
+
+type pubsubPublisher struct {
+	service *pubsub.Service
+	topic   string
+}
+
+// newPubSub creates a publisher for a topic named
+// projects/<project>/topics/<topic>, authenticating with a service account
+func newPubSub(ctx context.Context, credentials []byte, topic string) (*pubsubPublisher, error) {
+	config, err := google.JWTConfigFromJSON(credentials, pubsub.PubsubScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	service, err := pubsub.NewService(ctx, option.WithHTTPClient(config.Client(ctx)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub service: %w", err)
+	}
+	return &pubsubPublisher{service: service, topic: topic}, nil
+}
+
+func (p *pubsubPublisher) Publish(ctx context.Context, key string, body []byte) error {
+	_, err := p.service.Projects.Topics.Publish(p.topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{
+			Data:       base64.StdEncoding.EncodeToString(body),
+			Attributes: map[string]string{"job_id": key},
+		}},
+	}).Context(ctx).Do()
+	return err
+}
+
+func (p *pubsubPublisher) Close() error {
+	return nil
+}
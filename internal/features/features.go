@@ -0,0 +1,87 @@
+// Package features gates experimental capabilities, so they can be turned
+// on per environment with FEATURES and tried out by admins per request.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AV1 allows presets to encode video as AV1 with libsvtav1
+const AV1 = "av1"
+
+// Known describes every experimental feature
+var Known = map[string]string{
+	AV1: "AV1 video (video_codec \"libsvtav1\") in presets",
+}
+
+// Set holds the features that are enabled
+type Set map[string]bool
+
+// Parse builds a set from feature names, rejecting unknown ones
+func Parse(names []string) (Set, error) {
+	set := make(Set)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := Known[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
+
+// Override returns a copy of s changed by a comma-separated list of
+// feature names, each turned on, or off when prefixed with "-"
+func (s Set) Override(list string) (Set, error) {
+	set := make(Set, len(s))
+	for name := range s {
+		set[name] = true
+	}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		on := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if name == "" {
+			continue
+		}
+		if _, ok := Known[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		if on {
+			set[name] = true
+		} else {
+			delete(set, name)
+		}
+	}
+	return set, nil
+}
+
+// Enabled reports whether feature is on
+func (s Set) Enabled(feature string) bool {
+	return s[feature]
+}
+
+// Require fails unless every one of features is on
+func (s Set) Require(features []string) error {
+	for _, feature := range features {
+		if !s[feature] {
+			return fmt.Errorf("%s is an experimental feature that is not enabled", feature)
+		}
+	}
+	return nil
+}
+
+// List returns the enabled features, sorted
+func (s Set) List() []string {
+	list := make([]string, 0, len(s))
+	for name := range s {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
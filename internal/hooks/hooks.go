@@ -0,0 +1,214 @@
+// Package hooks runs operator-configured commands or HTTP endpoints before
+// and after a job's transcode, such as to validate or rename inputs, purge
+// a CDN or update an LMS.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/webhook"
+)
+
+// Hook stages
+const (
+	Pre  = "pre"  // before the transcode; failing fails the job
+	Post = "post" // after the output is delivered
+)
+
+// ErrTimeout is wrapped by the errors of hooks that ran past their timeout
+var ErrTimeout = errors.New("timed out")
+
+// maxOutput is how much of a hook's output is kept for the job's events
+const maxOutput = 4096
+
+// Hook is a command, run with sh -c, or an http(s) URL that is POSTed to
+type Hook struct {
+	Stage   string
+	Target  string
+	Timeout time.Duration
+	Secret  func() string // signs HTTP hooks as webhooks are; may be nil
+}
+
+// New returns the hook for target, or nil when target is empty
+func New(stage, target string, timeout time.Duration, secret func() string) *Hook {
+	if target == "" {
+		return nil
+	}
+	return &Hook{Stage: stage, Target: target, Timeout: timeout, Secret: secret}
+}
+
+// Result is what a hook run produced
+type Result struct {
+	Output     string // combined output or response body, truncated
+	OutputName string // output name template a pre hook asked for
+}
+
+// Payload is the JSON hooks receive, on stdin or as the request body
+type Payload struct {
+	Hook       string           `json:"hook"`
+	Job        jobs.JobResponse `json:"job"`
+	InputPaths []string         `json:"input_paths"`
+	OutputPath string           `json:"output_path"`
+}
+
+// response is what a hook may reply with, as its last line of output or
+// its response body
+type response struct {
+	OutputName string `json:"output_name"`
+}
+
+// Run runs the hook for job. A command that exits non-zero or an HTTP
+// hook that doesn't respond 2xx is an error; the result still holds the
+// output.
+func (h *Hook) Run(ctx context.Context, job *jobs.Job) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	body, err := json.Marshal(Payload{
+		Hook:       h.Stage,
+		Job:        job.ToResponse(),
+		InputPaths: job.InputFiles(),
+		OutputPath: job.OutputPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result *Result
+	if strings.HasPrefix(h.Target, "http://") || strings.HasPrefix(h.Target, "https://") {
+		result, err = h.post(ctx, body)
+	} else {
+		result, err = h.exec(ctx, job, body)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%w after %s", ErrTimeout, h.Timeout)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if result.OutputName != "" {
+		if err := jobs.ValidateOutputName(result.OutputName); err != nil {
+			return result, fmt.Errorf("hook returned an invalid output name: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// exec runs a command hook with the payload on stdin and the job's
+// details in TRANSCODER_* variables
+func (h *Hook) exec(ctx context.Context, job *jobs.Job, body []byte) (*Result, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Target)
+	// On timeout, stop whatever the script started too rather than waiting
+	// for it to close its output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"TRANSCODER_HOOK="+h.Stage,
+		"TRANSCODER_JOB_ID="+job.ID,
+		"TRANSCODER_INPUT="+job.InputFiles()[0],
+		"TRANSCODER_OUTPUT="+job.OutputPath,
+		"TRANSCODER_OUTPUT_NAME="+job.OutputName(),
+		"TRANSCODER_PRESET="+job.Preset,
+		"TRANSCODER_TENANT_ID="+job.TenantID,
+		"TRANSCODER_REQUEST_ID="+job.RequestID,
+		"TRANSCODER_DRIVE_URL="+job.DriveURL,
+	)
+	stdout := &limitedBuffer{max: 1 << 20}
+	output := &limitedBuffer{max: maxOutput}
+	cmd.Stdout = io.MultiWriter(stdout, output)
+	cmd.Stderr = output
+
+	err := cmd.Run()
+	result := &Result{Output: output.String()}
+	if err != nil {
+		return result, fmt.Errorf("hook failed: %w", err)
+	}
+
+	// A reply is the last line written to stdout
+	lines := strings.Split(stdout.String(), "\n")
+	result.OutputName = parseResponse(lines[len(lines)-1])
+	return result, nil
+}
+
+// post sends the payload to an HTTP hook
+func (h *Hook) post(ctx context.Context, body []byte) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Skillcape-Transcoder/1.0")
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	if h.Secret != nil {
+		if secret := h.Secret(); secret != "" {
+			req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, time.Now(), body))
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("hook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	result := &Result{Output: strings.ToValidUTF8(string(data), "")}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("hook returned status %d", resp.StatusCode)
+	}
+	result.OutputName = parseResponse(string(data))
+	return result, nil
+}
+
+// parseResponse returns the output name in a hook's JSON reply, if any
+func parseResponse(s string) string {
+	var r response
+	if err := json.Unmarshal([]byte(strings.TrimSpace(s)), &r); err != nil {
+		return ""
+	}
+	return r.OutputName
+}
+
+// limitedBuffer keeps the first max bytes written to it. It is safe for
+// stdout and stderr to write to at once.
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// String returns what was kept, as valid UTF-8 without surrounding space
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(strings.ToValidUTF8(b.buf.String(), ""))
+}
@@ -0,0 +1,34 @@
+// Package ids makes the IDs of jobs, webhook events and other records.
+// The API handlers and webhook notifier use UUIDs unless they are given
+// another Generator, such as Sequence, so that tests can pin IDs.
+package ids
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Generator makes unique IDs
+type Generator interface {
+	NewID() string
+}
+
+// Func makes IDs with a function
+type Func func() string
+
+func (f Func) NewID() string {
+	return f()
+}
+
+// UUIDs makes random UUIDs, the IDs used unless others are given
+var UUIDs Generator = Func(func() string { return uuid.New().String() })
+
+// Sequence makes the IDs prefix-1, prefix-2 and so on
+func Sequence(prefix string) Generator {
+	var n atomic.Int64
+	return Func(func() string {
+		return fmt.Sprintf("%s-%d", prefix, n.Add(1))
+	})
+}
@@ -0,0 +1,30 @@
+package jobs
+
+// Error codes of failed jobs, stable for programs to act on where Error
+// is for people
+const (
+	ErrorInputCorrupt      = "INPUT_CORRUPT"       // ffmpeg couldn't read the input
+	ErrorUnsupportedCodec  = "UNSUPPORTED_CODEC"   // no decoder for the input, or encoder for the preset
+	ErrorDiskFull          = "DISK_FULL"           // the server, or the pod's node, ran out of disk
+	ErrorOutOfMemory       = "OUT_OF_MEMORY"       // ffmpeg ran out of memory, or was killed by the OOM killer
+	ErrorInterrupted       = "INTERRUPTED"         // ffmpeg was stopped by a signal, such as a node shutting down
+	ErrorDestQuotaExceeded = "DEST_QUOTA_EXCEEDED" // the destination is full or over quota
+	ErrorTimeout           = "TIMEOUT"             // a hook, step or upload ran out of time, or the job expired
+	ErrorInputRejected     = "INPUT_REJECTED"      // the acceptance rules refused the input, see Rejection
+	ErrorInputMissing      = "INPUT_MISSING"       // the uploaded input is gone from the server
+	ErrorInvalidSettings   = "INVALID_SETTINGS"    // the job's preset, renditions or packaging can't be used
+	ErrorHookFailed        = "HOOK_FAILED"         // a hook or pipeline step failed the job
+	ErrorTranscodeFailed   = "TRANSCODE_FAILED"    // encoding failed for another reason
+	ErrorUploadFailed      = "UPLOAD_FAILED"       // delivery failed for another reason
+)
+
+// Permanent reports whether a failure with code would happen again on
+// every attempt, so isn't worth retrying. Running out of memory or disk
+// may not, on another worker or after a cleanup.
+func Permanent(code string) bool {
+	switch code {
+	case ErrorInputCorrupt, ErrorUnsupportedCodec, ErrorInputRejected, ErrorInputMissing, ErrorInvalidSettings:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import "time"
+
+// Job event types
+const (
+	EventCreated          = "created"
+	EventUpdated          = "updated"
+	EventPrioritized      = "prioritized"
+	EventCancelled        = "cancelled"
+	EventDeleted          = "deleted"
+	EventExpired          = "expired"
+	EventStarted          = "started"
+	EventCompleted        = "completed"
+	EventFailed           = "failed"
+	EventWebhookDelivered = "webhook_delivered"
+	EventWebhookFailed    = "webhook_failed"
+	EventEmailSent        = "email_sent"
+	EventEmailFailed      = "email_failed"
+	EventStatusChanged    = "status_changed"
+	EventRetryScheduled   = "retry_scheduled"
+	EventHookSucceeded    = "hook_succeeded"
+	EventHookFailed       = "hook_failed"
+	EventStepSucceeded    = "step_succeeded"
+	EventStepFailed       = "step_failed"
+	EventPublished        = "published"
+	EventPublishFailed    = "publish_failed"
+	EventAudioDrift       = "audio_drift"
+	EventUploadDeferred   = "upload_deferred"
+	EventAttached         = "attached" // a duplicate upload joined the job instead of making its own
+)
+
+// JobEvent records something that happened to a job, tagged with the ID of
+// the API request that caused it (or that created the job, for events from
+// the worker and webhook delivery)
+type JobEvent struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	JobID     string    `json:"job_id" gorm:"index"`
+	Type      string    `json:"type"`
+	Message   string    `json:"message,omitempty"`
+	RequestID string    `json:"request_id,omitempty" gorm:"index"`
+	Actor     string    `json:"actor,omitempty"` // who or what made a status change
+	CreatedAt time.Time `json:"created_at"`
+}
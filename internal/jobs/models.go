@@ -1,8 +1,12 @@
 package jobs
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/skillcape/transcoder/internal/transcoder"
 	"gorm.io/gorm"
 )
 
@@ -14,33 +18,468 @@ const (
 	StatusCompleted  JobStatus = "completed"
 	StatusFailed     JobStatus = "failed"
 	StatusCancelled  JobStatus = "cancelled"
+	StatusRetrying   JobStatus = "retrying"    // an attempt failed and another is scheduled
+	StatusDeadLetter JobStatus = "dead_letter" // every allowed attempt failed
+	StatusExpired    JobStatus = "expired"     // pending longer than JOB_MAX_QUEUE_HOURS
 )
 
+// ParseStatus validates a status string from user input
+func ParseStatus(s string) (JobStatus, bool) {
+	switch status := JobStatus(s); status {
+	case StatusPending, StatusProcessing, StatusCompleted, StatusFailed, StatusCancelled, StatusRetrying, StatusDeadLetter, StatusExpired:
+		return status, true
+	}
+	return "", false
+}
+
+// Stages a processing job moves through. A failed job keeps the stage it
+// failed in.
+const (
+	StageTranscoding   = "transcoding"
+	StageUploadWaiting = "uploading (waiting)" // for a free upload slot at the destination
+	StageUploading     = "uploading"
+)
+
+// Media types of jobs. Video jobs, which include audio, leave it empty.
+const MediaImage = "image"
+
+// MediaInfo is ffprobe's description of a job's input or output, stored as
+// a JSON column
+type MediaInfo = transcoder.MediaInfo
+
+// EncodeStats are a job's encoding speed figures, stored as a JSON column
+type EncodeStats = transcoder.EncodeStats
+
+// Metadata holds the tags and chapters written into a job's output, stored
+// as a JSON column
+type Metadata = transcoder.Metadata
+
+// StringList is a list of strings stored as a JSON array column
+type StringList []string
+
+// Value implements driver.Valuer
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = StringList{}
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported type %T for StringList", value)
+	}
+	if len(data) == 0 {
+		*l = nil
+		return nil
+	}
+	return json.Unmarshal(data, l)
+}
+
+// Variant is an extra output of a job, such as a smaller copy of an image
+// or a rendition in another preset, delivered alongside the main output
+type Variant struct {
+	Name   string `json:"name"`          // the width, "thumbnail", or the rendition's preset
+	Ext    string `json:"ext,omitempty"` // of renditions, with the dot; others share the output's
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Size   int64  `json:"size"`
+	URL    string `json:"url,omitempty"`
+	FileID string `json:"file_id,omitempty"`
+}
+
+// Variants is a list of variants stored as a JSON array column
+type Variants []Variant
+
+// Value implements driver.Valuer
+func (v Variants) Value() (driver.Value, error) {
+	if v == nil {
+		v = Variants{}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (v *Variants) Scan(value interface{}) error {
+	var data []byte
+	switch value := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return fmt.Errorf("unsupported type %T for Variants", value)
+	}
+	if len(data) == 0 {
+		*v = nil
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Caption is a file of closed captions extracted from a job's input and
+// delivered alongside the output
+type Caption struct {
+	Format string `json:"format"` // vtt or scc
+	URL    string `json:"url,omitempty"`
+	FileID string `json:"file_id,omitempty"`
+}
+
+// Captions is a list of extracted captions stored as a JSON array column
+type Captions []Caption
+
+// Value implements driver.Valuer
+func (c Captions) Value() (driver.Value, error) {
+	if c == nil {
+		c = Captions{}
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (c *Captions) Scan(value interface{}) error {
+	var data []byte
+	switch value := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return fmt.Errorf("unsupported type %T for Captions", value)
+	}
+	if len(data) == 0 {
+		*c = nil
+		return nil
+	}
+	return json.Unmarshal(data, c)
+}
+
+// PlayerFiles are the files made for a video player alongside a job's
+// output: a sprite sheet of thumbnails with the WebVTT file that maps
+// times to them, and a manifest pointing at everything the player needs
+type PlayerFiles struct {
+	Interval         float64 `json:"interval"` // seconds between thumbnails
+	Width            int     `json:"width"`    // of each thumbnail
+	Height           int     `json:"height"`
+	Columns          int     `json:"columns"`
+	Count            int     `json:"count"`
+	SpriteURL        string  `json:"sprite_url,omitempty"`
+	SpriteFileID     string  `json:"sprite_file_id,omitempty"`
+	ThumbnailsURL    string  `json:"thumbnails_url,omitempty"`
+	ThumbnailsFileID string  `json:"thumbnails_file_id,omitempty"`
+	CaptionsURL      string  `json:"captions_url,omitempty"` // of the captions uploaded with the job
+	CaptionsFileID   string  `json:"captions_file_id,omitempty"`
+	URL              string  `json:"url,omitempty"` // of the manifest, uploaded last
+	FileID           string  `json:"file_id,omitempty"`
+}
+
+// Value implements driver.Valuer
+func (p PlayerFiles) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (p *PlayerFiles) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case []byte:
+		return json.Unmarshal(v, p)
+	default:
+		return fmt.Errorf("unsupported type %T for PlayerFiles", value)
+	}
+}
+
+// HLSFiles describe a job's output packaged for HLS: a playlist,
+// delivered in place of the output file, and the segments it lists
+type HLSFiles struct {
+	SegmentType string    `json:"segment_type"` // ts or fmp4
+	Segments    int       `json:"segments"`
+	URL         string    `json:"url,omitempty"` // of the playlist, uploaded last
+	Uploaded    []HLSFile `json:"uploaded,omitempty"`
+
+	// Jobs with renditions make an adaptive ladder of them and the output,
+	// each a stream with its own playlist and segments, and the playlist
+	// is then the master playlist listing the streams
+	Streams []HLSStream `json:"streams,omitempty"`
+}
+
+// HLSFile is a segment, or fmp4's init segment, once uploaded
+type HLSFile struct {
+	Name   string `json:"name"` // in Job.HLSDir, or <stream>/<name> in a rendition's Job.HLSStreamDir
+	URL    string `json:"url,omitempty"`
+	FileID string `json:"file_id,omitempty"`
+}
+
+// HLSMainStream names the output's stream in an adaptive ladder
+const HLSMainStream = "main"
+
+// HLSStream is a stream of an adaptive ladder, as listed in the master
+// playlist
+type HLSStream struct {
+	Name      string `json:"name"` // the rendition's preset, or HLSMainStream
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Codecs    string `json:"codecs,omitempty"` // RFC 6381, as the master playlist lists them
+	Bandwidth int64  `json:"bandwidth"`        // peak bits per second over its segments
+	Segments  int    `json:"segments"`
+	URL       string `json:"url,omitempty"` // of its playlist, once uploaded
+	FileID    string `json:"file_id,omitempty"`
+}
+
+// Stream returns the ladder's stream of the named rendition, or nil
+func (h *HLSFiles) Stream(name string) *HLSStream {
+	if h == nil {
+		return nil
+	}
+	for i := range h.Streams {
+		if h.Streams[i].Name == name {
+			return &h.Streams[i]
+		}
+	}
+	return nil
+}
+
+// Value implements driver.Valuer
+func (h HLSFiles) Value() (driver.Value, error) {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (h *HLSFiles) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	case []byte:
+		return json.Unmarshal(v, h)
+	default:
+		return fmt.Errorf("unsupported type %T for HLSFiles", value)
+	}
+}
+
+// summary returns the HLS files without the list of uploaded segments,
+// which runs to hundreds of entries for long videos
+func (h *HLSFiles) summary() *HLSFiles {
+	if h == nil {
+		return nil
+	}
+	summary := *h
+	summary.Uploaded = nil
+	return &summary
+}
+
+// Contains reports whether the list contains s
+func (l StringList) Contains(s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 type Job struct {
-	ID            string         `json:"id" gorm:"primaryKey"`
-	Status        JobStatus      `json:"status" gorm:"index"`
-	InputPath     string         `json:"input_path"`
-	OutputPath    string         `json:"output_path,omitempty"`
-	DriveURL      string         `json:"drive_url,omitempty"`
-	DriveFileID   string         `json:"drive_file_id,omitempty"`
-	Progress      int            `json:"progress"`
-	Error         string         `json:"error,omitempty"`
-	OriginalName  string         `json:"original_name"`
-	CreatedAt     time.Time      `json:"created_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	CompletedAt   *time.Time     `json:"completed_at,omitempty"`
-	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
+	ID           string         `json:"id" gorm:"primaryKey"`
+	Status       JobStatus      `json:"status" gorm:"index"`
+	InputPath    string         `json:"input_path"`
+	InputPaths   StringList     `json:"-" gorm:"type:text"` // all inputs, in order, for concatenated jobs
+	OutputPath   string         `json:"output_path,omitempty"`
+	OutputExt    string         `json:"-"` // of the output file, with the dot; empty for .mp4
+	MediaType    string         `json:"media_type,omitempty"`
+	Variants     Variants       `json:"variants,omitempty" gorm:"type:text"`
+	ClosedCaps   Captions       `json:"closed_captions,omitempty" gorm:"type:text"`
+	Player       *PlayerFiles   `json:"player_assets,omitempty" gorm:"type:text"`
+	HLS          *HLSFiles      `json:"hls,omitempty" gorm:"type:text"`
+	Metadata     *Metadata      `json:"metadata,omitempty" gorm:"type:text"`
+	CoverPath    string         `json:"-"`                       // cover art uploaded with the job, if any
+	CaptionsPath string         `json:"-"`                       // WebVTT captions uploaded with the job, if any
+	CaptionsLang string         `json:"-"`                       // BCP 47 language of the uploaded captions, if given
+	PosterPath   string         `json:"-"`                       // still from the output, if one was made
+	PosterURL    string         `json:"poster_url,omitempty"`    // of the poster uploaded beside the output
+	PosterFileID string         `json:"-"`                       // the destination's ID for the poster
+	NameTemplate string         `json:"name_template,omitempty"` // output file name template, see OutputName
+	DriveURL     string         `json:"drive_url,omitempty"`
+	DriveFileID  string         `json:"drive_file_id,omitempty"`
+	Progress     int            `json:"progress"`
+	Stage        string         `json:"stage,omitempty"`
+	Attempts     int            `json:"attempts"`
+	Error        string         `json:"error,omitempty"`
+	ErrorCode    string         `json:"error_code,omitempty"`
+	Rejection    string         `json:"rejection,omitempty"` // why the acceptance rules rejected the input, as a code
+	OriginalName string         `json:"original_name"`
+	InputNames   StringList     `json:"input_names,omitempty" gorm:"type:text"` // original names of concatenated inputs
+	Preset       string         `json:"preset,omitempty" gorm:"index"`
+	Encoding     string         `json:"encoding,omitempty"`      // overrides the preset's, see transcoder.EncodingAuto
+	Packaging    string         `json:"packaging,omitempty"`     // how the output is delivered, see transcoder.PackagingFile
+	VideoEncoder string         `json:"video_encoder,omitempty"` // ffmpeg's encoder of the output's video, once started
+	Renditions   StringList     `json:"renditions,omitempty" gorm:"type:text"`
+	Parallelism  int            `json:"rendition_parallelism,omitempty"`
+	Destination  string         `json:"destination,omitempty"` // profile the output goes to; DriveURL and DriveFileID locate it there
+	StorageType  string         `json:"-"`                     // the profile's type, once uploaded
+	Labels       StringList     `json:"labels,omitempty" gorm:"type:text"`
+	Priority     int            `json:"priority"`
+	ScheduledAt  *time.Time     `json:"scheduled_at,omitempty"`
+	BoostedAt    *time.Time     `json:"boosted_at,omitempty"`
+	WebhookURL   string         `json:"webhook_url,omitempty"`
+	HookEvents   StringList     `json:"webhook_events,omitempty" gorm:"type:text"` // events sent to WebhookURL
+	NotifyEmails StringList     `json:"notify_emails,omitempty" gorm:"type:text"`  // overrides EMAIL_TO
+	Owner        string         `json:"owner,omitempty" gorm:"index"`
+	TenantID     string         `json:"tenant_id,omitempty" gorm:"index"`
+	RequestID    string         `json:"request_id,omitempty"`
+	InputSize    int64          `json:"input_size"`
+	InputHash    string         `json:"input_hash,omitempty" gorm:"index"`
+	DuplicateOf  string         `json:"duplicate_of,omitempty"`
+	Duration     float64        `json:"duration,omitempty"` // seconds, known once transcoding starts
+	EncodeTime   float64        `json:"-"`                  // seconds spent transcoding, for capacity planning
+	InputProbe   *MediaInfo     `json:"input_probe,omitempty" gorm:"type:text"`
+	OutputProbe  *MediaInfo     `json:"output_probe,omitempty" gorm:"type:text"`
+	AudioDrift   float64        `json:"audio_drift,omitempty"`
+	CPUSeconds   float64        `json:"cpu_seconds,omitempty"`
+	WallSeconds  float64        `json:"wall_seconds,omitempty"`
+	BytesOut     int64          `json:"bytes_out,omitempty"`
+	EncodeStats  *EncodeStats   `json:"encode_stats,omitempty" gorm:"type:text"` // live while encoding, then averages
+	CreatedAt    time.Time      `json:"created_at" gorm:"index"`
+	UpdatedAt    time.Time      `json:"updated_at" gorm:"index"`
+	CompletedAt  *time.Time     `json:"completed_at,omitempty"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+	Version      int            `json:"-" gorm:"not null;default:0"` // bumped by every save, see db.ErrStaleJob
+
+	changes []StatusChange // transitions not yet recorded, see Transition
 }
 
 type JobResponse struct {
-	ID           string     `json:"id"`
-	Status       JobStatus  `json:"status"`
-	Progress     int        `json:"progress"`
-	DriveURL     string     `json:"drive_url,omitempty"`
-	Error        string     `json:"error,omitempty"`
-	OriginalName string     `json:"original_name"`
-	CreatedAt    time.Time  `json:"created_at"`
-	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	ID           string       `json:"id"`
+	Status       JobStatus    `json:"status"`
+	Progress     int          `json:"progress"`
+	Stage        string       `json:"stage,omitempty"`
+	DriveURL     string       `json:"drive_url,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	ErrorCode    string       `json:"error_code,omitempty"`
+	Rejection    string       `json:"rejection,omitempty"`
+	OriginalName string       `json:"original_name"`
+	InputNames   []string     `json:"input_names,omitempty"`
+	OutputName   string       `json:"output_name"`
+	MediaType    string       `json:"media_type,omitempty"`
+	Variants     Variants     `json:"variants,omitempty"`
+	Metadata     *Metadata    `json:"metadata,omitempty"`
+	Cover        bool         `json:"cover,omitempty"`
+	Captions     bool         `json:"captions,omitempty"`
+	Poster       bool         `json:"poster,omitempty"`
+	PosterURL    string       `json:"poster_url,omitempty"`
+	ClosedCaps   Captions     `json:"closed_captions,omitempty"`
+	Player       *PlayerFiles `json:"player_assets,omitempty"`
+	HLS          *HLSFiles    `json:"hls,omitempty"`
+	Preset       string       `json:"preset,omitempty"`
+	Renditions   []string     `json:"renditions,omitempty"`
+	Parallelism  int          `json:"rendition_parallelism,omitempty"`
+	Encoding     string       `json:"encoding,omitempty"`
+	Packaging    string       `json:"packaging,omitempty"`
+	VideoEncoder string       `json:"video_encoder,omitempty"`
+	Destination  string       `json:"destination,omitempty"`
+	Labels       []string     `json:"labels,omitempty"`
+	Priority     int          `json:"priority"`
+	ScheduledAt  *time.Time   `json:"scheduled_at,omitempty"`
+	BoostedAt    *time.Time   `json:"boosted_at,omitempty"`
+	WebhookURL   string       `json:"webhook_url,omitempty"`
+	HookEvents   []string     `json:"webhook_events,omitempty"`
+	NotifyEmails []string     `json:"notify_emails,omitempty"`
+	Owner        string       `json:"owner,omitempty"`
+	TenantID     string       `json:"tenant_id,omitempty"`
+	RequestID    string       `json:"request_id,omitempty"`
+	InputSize    int64        `json:"input_size"`
+	InputHash    string       `json:"input_hash,omitempty"`
+	DuplicateOf  string       `json:"duplicate_of,omitempty"`
+	Duration     float64      `json:"duration,omitempty"`
+	InputProbe   *MediaInfo   `json:"input_probe,omitempty"`
+	OutputProbe  *MediaInfo   `json:"output_probe,omitempty"`
+	AudioDrift   float64      `json:"audio_drift,omitempty"`
+	CPUSeconds   float64      `json:"cpu_seconds,omitempty"`
+	WallSeconds  float64      `json:"wall_seconds,omitempty"`
+	BytesOut     int64        `json:"bytes_out,omitempty"`
+	EncodeStats  *EncodeStats `json:"encode_stats,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	CompletedAt  *time.Time   `json:"completed_at,omitempty"`
+}
+
+// InputFiles returns the paths of every uploaded input, in order
+func (j *Job) InputFiles() []string {
+	if len(j.InputPaths) > 0 {
+		return j.InputPaths
+	}
+	return []string{j.InputPath}
+}
+
+// CaptionsLanguage returns the language of the captions the playback page
+// shows: that given with uploaded captions, else that of the input's
+// stream carrying closed captions, else "und", undetermined
+func (j *Job) CaptionsLanguage() string {
+	language := ""
+	if j.CaptionsPath != "" {
+		language = j.CaptionsLang
+	} else if j.InputProbe != nil {
+		for _, stream := range j.InputProbe.Streams {
+			if stream.ClosedCaptions || stream.Codec == "eia_608" {
+				language = stream.Language
+				break
+			}
+		}
+	}
+	if language == "" {
+		return "und"
+	}
+	return language
+}
+
+// UploadedFiles returns the paths of every file uploaded with the job: its
+// inputs, cover art and captions
+func (j *Job) UploadedFiles() []string {
+	files := append([]string(nil), j.InputFiles()...)
+	for _, path := range []string{j.CoverPath, j.CaptionsPath} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
 }
 
 func (j *Job) ToResponse() JobResponse {
@@ -48,14 +487,121 @@ func (j *Job) ToResponse() JobResponse {
 		ID:           j.ID,
 		Status:       j.Status,
 		Progress:     j.Progress,
+		Stage:        j.Stage,
 		DriveURL:     j.DriveURL,
 		Error:        j.Error,
+		ErrorCode:    j.ErrorCode,
+		Rejection:    j.Rejection,
 		OriginalName: j.OriginalName,
+		InputNames:   j.InputNames,
+		OutputName:   j.OutputName(),
+		MediaType:    j.MediaType,
+		Variants:     j.Variants,
+		Metadata:     j.Metadata,
+		Cover:        j.CoverPath != "",
+		Captions:     j.CaptionsPath != "",
+		Poster:       j.PosterPath != "",
+		PosterURL:    j.PosterURL,
+		ClosedCaps:   j.ClosedCaps,
+		Player:       j.Player,
+		HLS:          j.HLS.summary(),
+		Preset:       j.Preset,
+		Renditions:   j.Renditions,
+		Parallelism:  j.Parallelism,
+		Encoding:     j.Encoding,
+		Packaging:    j.Packaging,
+		VideoEncoder: j.VideoEncoder,
+		Destination:  j.Destination,
+		Labels:       j.Labels,
+		Priority:     j.Priority,
+		ScheduledAt:  j.ScheduledAt,
+		BoostedAt:    j.BoostedAt,
+		WebhookURL:   j.WebhookURL,
+		HookEvents:   j.HookEvents,
+		NotifyEmails: j.NotifyEmails,
+		Owner:        j.Owner,
+		TenantID:     j.TenantID,
+		RequestID:    j.RequestID,
+		InputSize:    j.InputSize,
+		InputHash:    j.InputHash,
+		DuplicateOf:  j.DuplicateOf,
+		Duration:     j.Duration,
+		InputProbe:   j.InputProbe,
+		OutputProbe:  j.OutputProbe,
+		AudioDrift:   j.AudioDrift,
+		CPUSeconds:   j.CPUSeconds,
+		WallSeconds:  j.WallSeconds,
+		BytesOut:     j.BytesOut,
+		EncodeStats:  j.EncodeStats,
 		CreatedAt:    j.CreatedAt,
 		CompletedAt:  j.CompletedAt,
 	}
 }
 
+// CreateJobRequest holds the optional job settings sent alongside the
+// upload in the multipart "payload" part. It accepts the same fields as
+// UpdateJobRequest.
 type CreateJobRequest struct {
-	WebhookURL string `json:"webhook_url,omitempty"`
+	Priority    *int      `json:"priority,omitempty"`
+	WebhookURL  *string   `json:"webhook_url,omitempty"`
+	Preset      *string   `json:"preset,omitempty"`
+	Renditions  *[]string `json:"renditions,omitempty"`
+	Parallelism *int      `json:"rendition_parallelism,omitempty"`
+	Encoding    *string   `json:"encoding,omitempty"`
+	Packaging   *string   `json:"packaging,omitempty"`
+	Destination *string   `json:"destination,omitempty"`
+	Labels      *[]string `json:"labels,omitempty"`
+	ScheduledAt *string   `json:"scheduled_at,omitempty"`
+	OutputName  *string   `json:"output_name,omitempty"`
+	HookEvents  *[]string `json:"webhook_events,omitempty"`
+	Recipients  *[]string `json:"notify_emails,omitempty"`
+	Metadata    *Metadata `json:"metadata,omitempty"`
+}
+
+// Priority bounds accepted from clients; higher runs first
+const (
+	MinPriority = -100
+	MaxPriority = 100
+)
+
+// UpdateJobRequest lists the fields that may change while a job is pending.
+// Omitted fields are left untouched; an empty scheduled_at clears the schedule.
+type UpdateJobRequest struct {
+	Priority    *int      `json:"priority,omitempty"`
+	WebhookURL  *string   `json:"webhook_url,omitempty"`
+	Preset      *string   `json:"preset,omitempty"`
+	Renditions  *[]string `json:"renditions,omitempty"`
+	Parallelism *int      `json:"rendition_parallelism,omitempty"`
+	Encoding    *string   `json:"encoding,omitempty"`
+	Packaging   *string   `json:"packaging,omitempty"`
+	Destination *string   `json:"destination,omitempty"`
+	Labels      *[]string `json:"labels,omitempty"`
+	ScheduledAt *string   `json:"scheduled_at,omitempty"`
+	OutputName  *string   `json:"output_name,omitempty"`
+	HookEvents  *[]string `json:"webhook_events,omitempty"`
+	Recipients  *[]string `json:"notify_emails,omitempty"`
+	Metadata    *Metadata `json:"metadata,omitempty"`
+}
+
+// BulkJobRequest selects jobs for a bulk action, either by ID or by filter
+type BulkJobRequest struct {
+	Action string         `json:"action"`
+	IDs    []string       `json:"ids,omitempty"`
+	Filter *BulkJobFilter `json:"filter,omitempty"`
+}
+
+// BulkJobFilter mirrors the list endpoint's query filters
+type BulkJobFilter struct {
+	Status        []string   `json:"status,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	OriginalName  string     `json:"original_name,omitempty"`
+	Preset        string     `json:"preset,omitempty"`
+	Label         string     `json:"label,omitempty"`
+}
+
+// IsEmpty reports whether the filter has no criteria (and would match everything)
+func (f *BulkJobFilter) IsEmpty() bool {
+	return len(f.Status) == 0 && f.CreatedAfter == nil && f.CreatedBefore == nil &&
+		f.OriginalName == "" && f.Preset == "" && f.Label == ""
 }
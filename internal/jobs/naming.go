@@ -0,0 +1,267 @@
+package jobs
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultOutputNameTemplate names outputs after the uploaded file
+const DefaultOutputNameTemplate = "{{original_basename}}.mp4"
+
+// maxOutputNameLength keeps names within common filesystem and Drive limits
+const maxOutputNameLength = 255
+
+// outputPlaceholder matches a {{name}} placeholder in an output name template
+var outputPlaceholder = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*\}\}`)
+
+// outputPlaceholders lists the values a template may use
+var outputPlaceholders = map[string]func(j *Job) string{
+	"original_basename": func(j *Job) string { return strings.TrimSuffix(j.OriginalName, filepath.Ext(j.OriginalName)) },
+	"original_name":     func(j *Job) string { return j.OriginalName },
+	"preset": func(j *Job) string {
+		if j.Preset == "" {
+			return "default"
+		}
+		return j.Preset
+	},
+	"date":      func(j *Job) string { return j.CreatedAt.UTC().Format("2006-01-02") },
+	"time":      func(j *Job) string { return j.CreatedAt.UTC().Format("150405") },
+	"job_id":    func(j *Job) string { return j.ID },
+	"tenant_id": func(j *Job) string { return j.TenantID },
+}
+
+// ValidateOutputName checks an output name or template for unknown
+// placeholders and path separators
+func ValidateOutputName(template string) error {
+	if len(template) > maxOutputNameLength {
+		return fmt.Errorf("output_name must be at most %d characters", maxOutputNameLength)
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("output_name must not contain path separators")
+	}
+	for _, match := range outputPlaceholder.FindAllStringSubmatch(template, -1) {
+		if _, ok := outputPlaceholders[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder {{%s}} in output_name", match[1])
+		}
+	}
+	if rest := outputPlaceholder.ReplaceAllString(template, ""); strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		return fmt.Errorf("malformed placeholder in output_name")
+	}
+	return nil
+}
+
+// OutputName returns the file name the transcoded output is delivered as,
+// rendered from the job's output name template. The result is always a
+// safe, non-empty file name ending in the output's extension, .mp4 unless
+// the job makes another format.
+func (j *Job) OutputName() string {
+	template := j.NameTemplate
+	if template == "" {
+		template = DefaultOutputNameTemplate
+	}
+
+	name := outputPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		key := outputPlaceholder.FindStringSubmatch(placeholder)[1]
+		if value, ok := outputPlaceholders[key]; ok {
+			return value(j)
+		}
+		return ""
+	})
+
+	ext := j.OutputFileExt()
+	name = sanitizeFileName(strings.TrimSuffix(strings.TrimSuffix(name, ".mp4"), ext))
+	if name == "" {
+		name = j.ID
+	}
+	return truncateUTF8(name, maxOutputNameLength-len(ext)) + ext
+}
+
+// OutputFileExt returns the output's file extension, with the dot
+func (j *Job) OutputFileExt() string {
+	if j.OutputExt == "" {
+		return ".mp4"
+	}
+	return j.OutputExt
+}
+
+// VariantName returns the file name a variant is delivered as, the output
+// name with the variant's name appended
+func (j *Job) VariantName(name string) string {
+	ext := j.OutputFileExt()
+	return strings.TrimSuffix(j.OutputName(), ext) + "-" + name + j.variantExt(name, ext)
+}
+
+// VariantPath returns where a variant is stored, beside the output
+func (j *Job) VariantPath(name string) string {
+	ext := filepath.Ext(j.OutputPath)
+	return strings.TrimSuffix(j.OutputPath, ext) + "-" + name + j.variantExt(name, ext)
+}
+
+// variantExt returns the extension of the named variant: its own for
+// renditions, otherwise ext, the output's
+func (j *Job) variantExt(name, ext string) string {
+	for _, variant := range j.Variants {
+		if variant.Name == name && variant.Ext != "" {
+			return variant.Ext
+		}
+	}
+	return ext
+}
+
+// PosterName returns the file name the poster is delivered as, the output
+// name with "-poster.jpg" in place of its extension
+func (j *Job) PosterName() string {
+	return strings.TrimSuffix(j.OutputName(), j.OutputFileExt()) + "-poster.jpg"
+}
+
+// CaptionName returns the file name closed captions in format are
+// delivered as, the output name with the format's extension
+func (j *Job) CaptionName(format string) string {
+	return strings.TrimSuffix(j.OutputName(), j.OutputFileExt()) + "." + format
+}
+
+// CaptionPath returns where closed captions in format are stored, beside
+// the output
+func (j *Job) CaptionPath(format string) string {
+	return strings.TrimSuffix(j.OutputPath, filepath.Ext(j.OutputPath)) + "-cc." + format
+}
+
+// PlayerFileName returns the file name a player file is delivered as, the
+// output name with suffix, such as "-sprite.jpg", in place of its extension
+func (j *Job) PlayerFileName(suffix string) string {
+	return strings.TrimSuffix(j.OutputName(), j.OutputFileExt()) + suffix
+}
+
+// PlayerFilePath returns where a player file is stored, beside the output
+func (j *Job) PlayerFilePath(suffix string) string {
+	return strings.TrimSuffix(j.OutputPath, filepath.Ext(j.OutputPath)) + suffix
+}
+
+// Suffixes of the player files, see PlayerFiles
+const (
+	SpriteSuffix         = "-sprite.jpg"
+	ThumbnailsSuffix     = "-thumbnails.vtt"
+	PlayerCaptionsSuffix = "-captions.vtt"
+	PlayerManifestSuffix = "-player.json"
+)
+
+// HLSName returns the file name the HLS playlist is delivered as, the
+// output name with ".m3u8" in place of its extension
+func (j *Job) HLSName() string {
+	return strings.TrimSuffix(j.OutputName(), j.OutputFileExt()) + ".m3u8"
+}
+
+// HLSSegmentPrefix returns what the names of HLS segments are prefixed
+// with when delivered, the output name without its extension and a dash
+func (j *Job) HLSSegmentPrefix() string {
+	return strings.TrimSuffix(j.OutputName(), j.OutputFileExt()) + "-"
+}
+
+// HLSPath returns where the HLS playlist is stored, beside the output
+func (j *Job) HLSPath() string {
+	return strings.TrimSuffix(j.OutputPath, filepath.Ext(j.OutputPath)) + ".m3u8"
+}
+
+// HLSDir returns the directory the HLS segments are stored in, beside the
+// output
+func (j *Job) HLSDir() string {
+	return strings.TrimSuffix(j.OutputPath, filepath.Ext(j.OutputPath)) + "-hls"
+}
+
+// HLSStreamName returns the file name the playlist of a stream of an
+// adaptive ladder is delivered as, the output name with "-<stream>.m3u8"
+// in place of its extension
+func (j *Job) HLSStreamName(stream string) string {
+	return j.HLSSegmentPrefix() + stream + ".m3u8"
+}
+
+// HLSStreamSegmentPrefix returns what the names of a stream's segments
+// are prefixed with when delivered; the output's keep HLSSegmentPrefix
+func (j *Job) HLSStreamSegmentPrefix(stream string) string {
+	if stream == HLSMainStream {
+		return j.HLSSegmentPrefix()
+	}
+	return j.HLSSegmentPrefix() + stream + "-"
+}
+
+// HLSStreamPath returns where a stream's playlist is stored; the
+// output's is HLSPath
+func (j *Job) HLSStreamPath(stream string) string {
+	if stream == HLSMainStream {
+		return j.HLSPath()
+	}
+	return strings.TrimSuffix(j.OutputPath, filepath.Ext(j.OutputPath)) + "-" + stream + ".m3u8"
+}
+
+// HLSStreamDir returns the directory a stream's segments are stored in;
+// the output's is HLSDir
+func (j *Job) HLSStreamDir(stream string) string {
+	if stream == HLSMainStream {
+		return j.HLSDir()
+	}
+	return strings.TrimSuffix(j.OutputPath, filepath.Ext(j.OutputPath)) + "-" + stream + "-hls"
+}
+
+// DeliveredName returns the file name the job's output is delivered as:
+// its HLS playlist's when packaged for HLS, else OutputName
+func (j *Job) DeliveredName() string {
+	if j.HLS != nil {
+		return j.HLSName()
+	}
+	return j.OutputName()
+}
+
+// OutputFiles returns the paths of the output, its variants, its poster,
+// its extracted captions, its player files and its HLS playlist and
+// segment directory, and those of its ladder's streams
+func (j *Job) OutputFiles() []string {
+	files := []string{j.OutputPath}
+	for _, variant := range j.Variants {
+		files = append(files, j.VariantPath(variant.Name))
+	}
+	if j.PosterPath != "" {
+		files = append(files, j.PosterPath)
+	}
+	for _, caption := range j.ClosedCaps {
+		files = append(files, j.CaptionPath(caption.Format))
+	}
+	if j.Player != nil {
+		files = append(files, j.PlayerFilePath(SpriteSuffix), j.PlayerFilePath(ThumbnailsSuffix))
+	}
+	if j.HLS != nil {
+		files = append(files, j.HLSPath(), j.HLSDir())
+		for _, stream := range j.HLS.Streams {
+			if stream.Name != HLSMainStream {
+				files = append(files, j.HLSStreamPath(stream.Name), j.HLSStreamDir(stream.Name))
+			}
+		}
+	}
+	return files
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// sanitizeFileName replaces characters that are unsafe in file names on
+// common filesystems and trims leading/trailing dots and spaces
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, ". ")
+}
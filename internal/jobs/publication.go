@@ -0,0 +1,34 @@
+package jobs
+
+import "time"
+
+// Publication states
+const (
+	PublicationPending   = "pending"
+	PublicationUploading = "uploading"
+	PublicationCompleted = "completed"
+	PublicationFailed    = "failed"
+)
+
+// Publication records a completed job's output being copied to another
+// destination after the job finished, without transcoding it again
+type Publication struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	JobID       string     `json:"job_id" gorm:"index"`
+	Destination string     `json:"destination"`
+	Status      string     `json:"status"`
+	FileID      string     `json:"file_id,omitempty"` // where the destination stored it
+	URL         string     `json:"url,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	RequestID   string     `json:"request_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Extras      StringList `json:"-" gorm:"type:text"` // file IDs of the variants, poster and captions copied with the output
+	Replica     string     `json:"-" gorm:"index"`     // server copying it, so only that one fails it after a restart
+}
+
+// TableName keeps publications from sharing a generic table name
+func (Publication) TableName() string {
+	return "job_publications"
+}
@@ -1,21 +1,49 @@
 package jobs
 
 import (
+	"context"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/clock"
 )
 
+// Queue holds jobs waiting for a worker. Jobs are handed out boosted jobs
+// first, then highest priority first, then oldest first; jobs scheduled in
+// the future are held back until their time comes.
 type Queue struct {
-	jobs    chan *Job
-	mu      sync.RWMutex
-	running map[string]bool
+	jobs     chan *Job
+	mu       sync.RWMutex
+	pending  []*Job
+	capacity int
+	running  map[string]context.CancelFunc
+	progress map[string]liveProgress
+	notify   chan struct{}
+	done     chan struct{}
+	closed   bool
+	paused   map[string]string // reasons by cause
+	draining bool
+	clock    clock.Clock
 }
 
-func NewQueue(bufferSize int) *Queue {
-	return &Queue{
-		jobs:    make(chan *Job, bufferSize),
-		running: make(map[string]bool),
+// NewQueue creates a queue of at most bufferSize jobs. clk tells when
+// scheduled jobs and retries are due.
+func NewQueue(bufferSize int, clk clock.Clock) *Queue {
+	q := &Queue{
+		jobs:     make(chan *Job),
+		capacity: bufferSize,
+		running:  make(map[string]context.CancelFunc),
+		progress: make(map[string]liveProgress),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		paused:   make(map[string]string),
+		clock:    clk,
 	}
+	go q.dispatch()
+	return q
 }
 
 // Enqueue adds a job to the queue
@@ -23,13 +51,104 @@ func (q *Queue) Enqueue(job *Job) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	select {
-	case q.jobs <- job:
-		log.Printf("Job %s enqueued", job.ID)
-		return nil
-	default:
+	if q.closed || len(q.pending) >= q.capacity {
 		return ErrQueueFull
 	}
+
+	q.pending = append(q.pending, job)
+	q.wake()
+	log.Printf("Job %s enqueued", job.ID)
+	return nil
+}
+
+// Reschedule changes the priority and scheduled time of a waiting job.
+// It reports whether the job was found in the queue.
+func (q *Queue) Reschedule(jobID string, priority int, scheduledAt *time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.pending {
+		if job.ID == jobID {
+			job.Priority = priority
+			job.ScheduledAt = scheduledAt
+			q.wake()
+			return true
+		}
+	}
+	return false
+}
+
+// Boost moves a waiting job to the front of the queue, ahead of jobs
+// boosted earlier, and drops any schedule it had. It reports whether the
+// job was found in the queue.
+func (q *Queue) Boost(jobID string, boostedAt time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.pending {
+		if job.ID == jobID {
+			job.BoostedAt = &boostedAt
+			job.ScheduledAt = nil
+			q.wake()
+			return true
+		}
+	}
+	return false
+}
+
+// Remove drops a waiting job from the queue and reports whether it was there
+func (q *Queue) Remove(jobID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.pending {
+		if job.ID == jobID {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.wake()
+			return true
+		}
+	}
+	return false
+}
+
+// Queued reports whether a job is waiting in the queue
+func (q *Queue) Queued(jobID string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	for _, job := range q.pending {
+		if job.ID == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// Expire drops pending jobs that have waited longer than maxAge and
+// returns them. A scheduled job's wait starts at its scheduled time.
+// Jobs waiting to be retried are left alone.
+func (q *Queue) Expire(maxAge time.Duration, now time.Time) []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var expired []*Job
+	kept := q.pending[:0]
+	for _, job := range q.pending {
+		since := job.CreatedAt
+		if job.ScheduledAt != nil && job.ScheduledAt.After(since) {
+			since = *job.ScheduledAt
+		}
+		if job.Status == StatusPending && now.Sub(since) > maxAge {
+			expired = append(expired, job)
+			continue
+		}
+		kept = append(kept, job)
+	}
+	clear(q.pending[len(kept):])
+	q.pending = kept
+	if len(expired) > 0 {
+		q.wake()
+	}
+	return expired
 }
 
 // Dequeue retrieves the next job from the queue (blocking)
@@ -42,11 +161,149 @@ func (q *Queue) Jobs() <-chan *Job {
 	return q.jobs
 }
 
-// MarkRunning marks a job as currently being processed
-func (q *Queue) MarkRunning(jobID string) {
+// dispatch hands the best ready job to the next idle worker. Any change to
+// the queue interrupts a pending hand-off so the choice is re-evaluated.
+func (q *Queue) dispatch() {
+	defer close(q.jobs)
+
+	for {
+		job, wait := q.next()
+
+		var due <-chan time.Time
+		if job == nil && wait > 0 {
+			due = q.clock.After(wait)
+		}
+
+		var out chan *Job
+		if job != nil {
+			out = q.jobs
+		}
+
+		select {
+		case out <- job:
+			q.Remove(job.ID)
+		case <-q.notify:
+		case <-due:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// next returns the best job that is ready to run. If none is ready it
+// returns how long until the earliest scheduled job becomes ready (zero
+// when there is nothing scheduled).
+func (q *Queue) next() (*Job, time.Duration) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if len(q.paused) > 0 || q.draining {
+		return nil, 0
+	}
+
+	now := q.clock.Now()
+	var best *Job
+	var wait time.Duration
+	for _, job := range q.pending {
+		if job.ScheduledAt != nil && job.ScheduledAt.After(now) {
+			if until := job.ScheduledAt.Sub(now); wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+		if best == nil || runsBefore(job, best) {
+			best = job
+		}
+	}
+	return best, wait
+}
+
+// Pause stops handing jobs to workers until Resume is called for the same
+// cause, such as "resources"; reason explains why and is reported by
+// Paused. Jobs already running carry on and new jobs can still be queued.
+func (q *Queue) Pause(cause, reason string) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.running[jobID] = true
+	if q.paused[cause] != reason {
+		log.Printf("Dispatch paused: %s", reason)
+	}
+	q.paused[cause] = reason
+	q.wake()
+}
+
+// Resume clears a pause for cause; dispatch resumes once no cause is left
+func (q *Queue) Resume(cause string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.paused[cause]; !ok {
+		return
+	}
+	delete(q.paused, cause)
+	if len(q.paused) == 0 {
+		log.Printf("Dispatch resumed")
+	}
+	q.wake()
+}
+
+// Paused returns why dispatch is paused, or "" when it is not
+func (q *Queue) Paused() string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.draining {
+		return "shutting down"
+	}
+	reasons := make([]string, 0, len(q.paused))
+	for _, reason := range q.paused {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, "; ")
+}
+
+// Drain stops handing jobs to workers for good, ahead of shutting down.
+// Unlike Pause it is not undone by Resume.
+func (q *Queue) Drain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.draining = true
+	q.wake()
+}
+
+// Draining reports whether Drain has been called
+func (q *Queue) Draining() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.draining
+}
+
+// runsBefore orders boosted jobs first, the latest boost first, then jobs
+// by priority (highest first), then creation time
+func runsBefore(a, b *Job) bool {
+	if a.BoostedAt != nil || b.BoostedAt != nil {
+		if a.BoostedAt == nil || b.BoostedAt == nil {
+			return a.BoostedAt != nil
+		}
+		return a.BoostedAt.After(*b.BoostedAt)
+	}
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+// wake signals the dispatcher; callers must hold q.mu
+func (q *Queue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// MarkRunning marks a job as currently being processed; cancel stops it
+func (q *Queue) MarkRunning(jobID string, cancel context.CancelFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running[jobID] = cancel
 }
 
 // MarkDone removes a job from the running set
@@ -54,23 +311,109 @@ func (q *Queue) MarkDone(jobID string) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	delete(q.running, jobID)
+	delete(q.progress, jobID)
+}
+
+// liveProgress is a running job's latest progress, which is only saved to
+// the database periodically
+type liveProgress struct {
+	progress  int
+	updatedAt time.Time
+	stats     *EncodeStats
+}
+
+// SetProgress records a running job's latest progress
+func (q *Queue) SetProgress(jobID string, progress int, updatedAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.running[jobID]; ok {
+		live := q.progress[jobID]
+		live.progress, live.updatedAt = progress, updatedAt
+		q.progress[jobID] = live
+	}
+}
+
+// SetStats records a running job's latest encoding speed figures, which
+// are only kept in memory until the encode finishes
+func (q *Queue) SetStats(jobID string, stats EncodeStats) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.running[jobID]; ok {
+		live := q.progress[jobID]
+		live.stats = &stats
+		q.progress[jobID] = live
+	}
+}
+
+// ApplyProgress updates a job loaded from the database with its live
+// progress, if it is running and has progressed since it was last saved,
+// and its live encoding stats
+func (q *Queue) ApplyProgress(job *Job) {
+	if job.Status != StatusProcessing {
+		return
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	live, ok := q.progress[job.ID]
+	if !ok {
+		return
+	}
+	if live.updatedAt.After(job.UpdatedAt) {
+		job.Progress = live.progress
+		job.UpdatedAt = live.updatedAt
+	}
+	if live.stats != nil {
+		job.EncodeStats = live.stats
+	}
 }
 
 // IsRunning checks if a job is currently being processed
 func (q *Queue) IsRunning(jobID string) bool {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
-	return q.running[jobID]
+	_, ok := q.running[jobID]
+	return ok
+}
+
+// Cancel stops a job, whether it is running or still waiting, and reports
+// whether it was found. A job already handed to a worker is skipped by the
+// processor once its status is no longer pending.
+func (q *Queue) Cancel(jobID string) bool {
+	if q.Remove(jobID) {
+		return true
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	cancel, ok := q.running[jobID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Running returns the number of jobs workers are processing
+func (q *Queue) Running() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.running)
 }
 
 // Size returns the current number of jobs in the queue
 func (q *Queue) Size() int {
-	return len(q.jobs)
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.pending)
 }
 
-// Close closes the job queue channel
+// Close stops dispatching and closes the job channel
 func (q *Queue) Close() {
-	close(q.jobs)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
 }
 
 // Custom errors
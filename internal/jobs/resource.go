@@ -0,0 +1,227 @@
+package jobs
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JobResource is the v2 representation of a job. Unlike the flat v1
+// JobResponse it groups the input, outputs, processing stages, and retry
+// state, and can embed the job's event history.
+type JobResource struct {
+	ID          string           `json:"id"`
+	Status      JobStatus        `json:"status"`
+	Progress    int              `json:"progress"`
+	Error       string           `json:"error,omitempty"`
+	Input       InputResource    `json:"input"`
+	DuplicateOf string           `json:"duplicate_of,omitempty"` // an earlier job with the same input
+	Outputs     []OutputResource `json:"outputs"`
+	Stages      []StageResource  `json:"stages"`
+	Retry       RetryResource    `json:"retry"`
+	EncodeStats *EncodeStats     `json:"encode_stats,omitempty"`
+	Preset      string           `json:"preset,omitempty"`
+	Labels      []string         `json:"labels"`
+	Priority    int              `json:"priority"`
+	ScheduledAt *time.Time       `json:"scheduled_at,omitempty"`
+	BoostedAt   *time.Time       `json:"boosted_at,omitempty"`
+	WebhookURL  string           `json:"webhook_url,omitempty"`
+	HookEvents  []string         `json:"webhook_events,omitempty"`
+	Recipients  []string         `json:"notify_emails,omitempty"`
+	Owner       string           `json:"owner,omitempty"`
+	TenantID    string           `json:"tenant_id,omitempty"`
+	RequestID   string           `json:"request_id,omitempty"`
+	Events      []JobEvent       `json:"events,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+}
+
+// InputResource describes the uploaded source file, or the files
+// concatenated into one job
+type InputResource struct {
+	Name     string     `json:"name"`
+	Format   string     `json:"format,omitempty"`
+	Size     int64      `json:"size"`
+	SHA256   string     `json:"sha256,omitempty"`
+	Duration float64    `json:"duration,omitempty"`
+	Files    []string   `json:"files,omitempty"`
+	Probe    *MediaInfo `json:"probe,omitempty"`
+}
+
+// OutputResource describes one produced file
+type OutputResource struct {
+	Name        string     `json:"name"`
+	Format      string     `json:"format"`
+	FileName    string     `json:"file_name"`
+	Storage     string     `json:"storage"`
+	Destination string     `json:"destination,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	DriveFileID string     `json:"drive_file_id,omitempty"`
+	Probe       *MediaInfo `json:"probe,omitempty"`
+}
+
+// Stage status values
+const (
+	StagePending   = "pending"
+	StageRunning   = "running"
+	StageCompleted = "completed"
+	StageFailed    = "failed"
+	StageSkipped   = "skipped"
+	StageCancelled = "cancelled"
+)
+
+// StageResource reports the state of one processing stage
+type StageResource struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// RetryResource reports how often the job has been attempted
+type RetryResource struct {
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorCode string `json:"last_error_code,omitempty"`
+}
+
+// pipeline lists the stages every job runs through, in order
+var pipeline = []string{StageTranscoding, StageUploading}
+
+// ToResource builds the v2 representation; events may be nil to omit them
+func (j *Job) ToResource(events []JobEvent) JobResource {
+	labels := []string(j.Labels)
+	if labels == nil {
+		labels = []string{}
+	}
+
+	return JobResource{
+		ID:       j.ID,
+		Status:   j.Status,
+		Progress: j.Progress,
+		Error:    j.Error,
+		Input: InputResource{
+			Name:     j.OriginalName,
+			Format:   strings.TrimPrefix(strings.ToLower(filepath.Ext(j.OriginalName)), "."),
+			Size:     j.InputSize,
+			SHA256:   j.InputHash,
+			Duration: j.Duration,
+			Files:    j.InputNames,
+			Probe:    j.InputProbe,
+		},
+		DuplicateOf: j.DuplicateOf,
+		Outputs:     j.outputs(),
+		Stages:      j.stages(),
+		Retry:       RetryResource{Attempts: j.Attempts, LastError: j.Error, LastErrorCode: j.ErrorCode},
+		EncodeStats: j.EncodeStats,
+		Preset:      j.Preset,
+		Labels:      labels,
+		Priority:    j.Priority,
+		ScheduledAt: j.ScheduledAt,
+		BoostedAt:   j.BoostedAt,
+		WebhookURL:  j.WebhookURL,
+		HookEvents:  j.HookEvents,
+		Recipients:  j.NotifyEmails,
+		Owner:       j.Owner,
+		TenantID:    j.TenantID,
+		RequestID:   j.RequestID,
+		Events:      events,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+		CompletedAt: j.CompletedAt,
+	}
+}
+
+// outputs lists the files a completed job produced
+func (j *Job) outputs() []OutputResource {
+	if j.Status != StatusCompleted {
+		return []OutputResource{}
+	}
+
+	format := strings.TrimPrefix(j.OutputFileExt(), ".")
+	output := OutputResource{
+		Name:     "main",
+		Format:   format,
+		FileName: j.OutputName(),
+		Storage:  "local",
+		Probe:    j.OutputProbe,
+	}
+	j.locate(&output, j.DriveFileID, j.DriveURL)
+	outputs := []OutputResource{output}
+
+	for _, variant := range j.Variants {
+		output := OutputResource{
+			Name:     variant.Name,
+			Format:   strings.TrimPrefix(j.variantExt(variant.Name, j.OutputFileExt()), "."),
+			FileName: j.VariantName(variant.Name),
+			Storage:  "local",
+		}
+		j.locate(&output, variant.FileID, variant.URL)
+		outputs = append(outputs, output)
+	}
+	for _, caption := range j.ClosedCaps {
+		output := OutputResource{
+			Name:     "cc-" + caption.Format,
+			Format:   caption.Format,
+			FileName: j.CaptionName(caption.Format),
+			Storage:  "local",
+		}
+		j.locate(&output, caption.FileID, caption.URL)
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+// locate fills in where an uploaded output went
+func (j *Job) locate(output *OutputResource, fileID, url string) {
+	if fileID == "" {
+		return
+	}
+	output.Storage = "drive"
+	if j.StorageType != "" && j.StorageType != "google_drive" {
+		output.Storage = j.StorageType
+	}
+	output.Destination = j.Destination
+	output.URL = url
+	output.DriveFileID = fileID
+}
+
+// stages derives each pipeline stage's status from the job's current stage
+// and status
+func (j *Job) stages() []StageResource {
+	current, waiting := -1, j.Stage == StageUploadWaiting
+	for i, name := range pipeline {
+		if name == j.Stage || waiting && name == StageUploading {
+			current = i
+		}
+	}
+
+	stages := make([]StageResource, len(pipeline))
+	for i, name := range pipeline {
+		stage := StageResource{Name: name, Status: StagePending}
+		switch {
+		case j.Status == StatusCompleted:
+			stage.Status = StageCompleted
+			if name == StageUploading && j.DriveFileID == "" {
+				stage.Status = StageSkipped
+			}
+		case i < current:
+			stage.Status = StageCompleted
+		case i == current:
+			switch j.Status {
+			case StatusProcessing:
+				stage.Status = StageRunning
+				if waiting {
+					stage.Status = StagePending
+				}
+			case StatusFailed, StatusDeadLetter:
+				stage.Status = StageFailed
+			case StatusCancelled, StatusExpired:
+				stage.Status = StageCancelled
+			}
+		case j.Status == StatusCancelled || j.Status == StatusExpired:
+			stage.Status = StageCancelled
+		}
+		stages[i] = stage
+	}
+	return stages
+}
@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/clock"
+)
+
+// Actors that change a job's status outside of API requests, which record
+// the caller's identity instead
+const (
+	ActorWorker    = "worker"
+	ActorRecovery  = "recovery"
+	ActorExpiry    = "expiry"
+	ActorReconcile = "reconcile"
+)
+
+// ErrInvalidTransition is wrapped by Transition for status changes the
+// state machine doesn't allow
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// transitions lists the statuses a job may move to from each status.
+// Processing jobs go back to pending when recovered after a restart, and
+// queued jobs fail when their uploads turn out to be missing; completed,
+// failed, cancelled, dead-lettered and expired jobs never change again.
+var transitions = map[JobStatus][]JobStatus{
+	StatusPending:    {StatusProcessing, StatusCancelled, StatusExpired, StatusFailed},
+	StatusProcessing: {StatusCompleted, StatusFailed, StatusRetrying, StatusDeadLetter, StatusCancelled, StatusPending},
+	StatusRetrying:   {StatusProcessing, StatusCancelled, StatusFailed},
+}
+
+// CanTransition reports whether a job may move from one status to another
+func CanTransition(from, to JobStatus) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether a job in this status has yet to finish
+func (s JobStatus) Active() bool {
+	return s == StatusPending || s == StatusProcessing || s == StatusRetrying
+}
+
+// StatusChange is a transition waiting to be recorded as a job event
+type StatusChange struct {
+	From      JobStatus
+	To        JobStatus
+	Actor     string
+	RequestID string
+	At        time.Time
+}
+
+// Event renders the change as a status_changed event
+func (c StatusChange) Event(jobID string) JobEvent {
+	return JobEvent{
+		JobID:     jobID,
+		Type:      EventStatusChanged,
+		Message:   fmt.Sprintf("%s -> %s", c.From, c.To),
+		Actor:     c.Actor,
+		RequestID: c.RequestID,
+		CreatedAt: c.At,
+	}
+}
+
+// Transition moves the job to status to on behalf of actor, rejecting
+// changes the state machine doesn't allow. The change is recorded, as of
+// clk's time, when the job is next saved.
+func (j *Job) Transition(to JobStatus, actor, requestID string, clk clock.Clock) error {
+	if !CanTransition(j.Status, to) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, j.Status, to)
+	}
+	j.changes = append(j.changes, StatusChange{
+		From:      j.Status,
+		To:        to,
+		Actor:     actor,
+		RequestID: requestID,
+		At:        clk.Now().UTC(),
+	})
+	j.Status = to
+	return nil
+}
+
+// StatusChanges returns the transitions not yet recorded
+func (j *Job) StatusChanges() []StatusChange {
+	return j.changes
+}
+
+// ClearStatusChanges forgets transitions once they have been recorded
+func (j *Job) ClearStatusChanges() {
+	j.changes = nil
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/skillcape/transcoder/internal/requestid"
 )
 
 type ProcessorFunc func(ctx context.Context, job *Job) error
@@ -46,6 +48,27 @@ func (wp *WorkerPool) Stop() {
 	log.Println("Worker pool stopped")
 }
 
+// Drain waits for the jobs being processed to finish, until ctx is done,
+// then stops the workers. Jobs still running are cancelled and left for
+// recovery. The queue must already be draining so no new jobs start.
+func (wp *WorkerPool) Drain(ctx context.Context) {
+	if running := wp.queue.Running(); running > 0 {
+		log.Printf("Waiting for %d running jobs to finish...", running)
+	}
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for wp.queue.Running() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown timeout reached, interrupting %d running jobs", wp.queue.Running())
+			wp.Stop()
+			return
+		case <-ticker.C:
+		}
+	}
+	wp.Stop()
+}
+
 func (wp *WorkerPool) worker(id int) {
 	defer wp.wg.Done()
 	log.Printf("Worker %d started", id)
@@ -66,21 +89,23 @@ func (wp *WorkerPool) worker(id int) {
 }
 
 func (wp *WorkerPool) processJob(workerID int, job *Job) {
-	log.Printf("Worker %d: processing job %s", workerID, job.ID)
-	wp.queue.MarkRunning(job.ID)
-	defer wp.queue.MarkDone(job.ID)
-
-	// Create a context with cancellation for this job
-	jobCtx, cancel := context.WithCancel(wp.ctx)
+	// Create a context with cancellation for this job, tagged with the
+	// request that created it so worker logs can be traced back
+	jobCtx, cancel := context.WithCancel(requestid.NewContext(wp.ctx, job.RequestID))
 	defer cancel()
 
+	requestid.Logf(jobCtx, "Worker %d: processing job %s", workerID, job.ID)
+
+	wp.queue.MarkRunning(job.ID, cancel)
+	defer wp.queue.MarkDone(job.ID)
+
 	start := time.Now()
 	err := wp.processor(jobCtx, job)
 	duration := time.Since(start)
 
 	if err != nil {
-		log.Printf("Worker %d: job %s failed after %v: %v", workerID, job.ID, duration, err)
+		requestid.Logf(jobCtx, "Worker %d: job %s failed after %v: %v", workerID, job.ID, duration, err)
 	} else {
-		log.Printf("Worker %d: job %s completed in %v", workerID, job.ID, duration)
+		requestid.Logf(jobCtx, "Worker %d: job %s completed in %v", workerID, job.ID, duration)
 	}
 }
@@ -0,0 +1,146 @@
+// Package kube runs encodes as Kubernetes Jobs, each in its own pod with
+// its own resource requests. It talks to the API server's REST API with
+// the service account Kubernetes mounts into the server's pod.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir is where Kubernetes mounts a pod's API credentials
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client calls the Kubernetes API from inside the cluster
+type Client struct {
+	baseURL   string
+	tokenFile string
+	http      *http.Client
+}
+
+// InClusterClient returns a client using the pod's service account, and
+// the namespace the pod runs in
+func InClusterClient() (*Client, string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, "", fmt.Errorf("not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST is not set)")
+	}
+
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, "", fmt.Errorf("service account CA is not valid PEM")
+	}
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read service account namespace: %w", err)
+	}
+
+	client := &Client{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			},
+		},
+	}
+	return client, strings.TrimSpace(string(namespace)), nil
+}
+
+// request sends a request to the API. The token is read for every request
+// since Kubernetes rotates it.
+func (c *Client) request(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	token, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.http.Do(req)
+}
+
+// do sends a request and decodes the JSON response into out, if not nil
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return apiError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stream sends a GET request and returns the response body as it arrives
+func (c *Client) stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := c.request(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp.Body, nil
+}
+
+// StatusError is a failed API response
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("kubernetes API returned %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+	}
+	return fmt.Sprintf("kubernetes API returned %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// apiError describes a failed API response, using the message of the
+// Status object Kubernetes returns when there is one
+func apiError(resp *http.Response) error {
+	var status struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	json.Unmarshal(data, &status)
+	return &StatusError{Code: resp.StatusCode, Message: status.Message}
+}
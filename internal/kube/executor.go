@@ -0,0 +1,388 @@
+package kube
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// pollInterval is how often pod and Job status are checked
+const pollInterval = 2 * time.Second
+
+// Config describes the pods encodes run in. The volume claim must hold
+// the server's TEMP_DIR and is mounted at the same path, so inputs and
+// outputs have the same paths in both.
+type Config struct {
+	Namespace      string // defaults to the server's namespace
+	Image          string // must include ffmpeg; usually the server's own image
+	ServiceAccount string
+	VolumeClaim    string
+	MountPath      string
+	CPURequest     string // quantities such as "2" or "500m"; empty sets none
+	MemoryRequest  string
+	CPULimit       string
+	MemoryLimit    string
+	OOMRequest     string // memory for retries of encodes that ran out of it; empty keeps MemoryRequest
+	OOMLimit       string // likewise for MemoryLimit
+	GPUs           int
+	GPUResource    string        // such as nvidia.com/gpu
+	TTL            time.Duration // how long finished Jobs are kept; 0 keeps them
+}
+
+// Executor creates a Kubernetes Job for each encode
+type Executor struct {
+	client *Client
+	config Config
+}
+
+// NewExecutor connects to the cluster the server runs in
+func NewExecutor(config Config) (*Executor, error) {
+	client, namespace, err := InClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	if config.Namespace == "" {
+		config.Namespace = namespace
+	}
+	return &Executor{client: client, config: config}, nil
+}
+
+// Runner returns a runner for one job's encode. Encodes retried because
+// the last attempt ran out of memory get the OOM request and limit.
+func (e *Executor) Runner(jobID string, outOfMemory bool) *JobRunner {
+	return &JobRunner{executor: e, jobID: jobID, outOfMemory: outOfMemory}
+}
+
+// JobRunner runs ffmpeg in a Kubernetes Job, streaming the pod's log as
+// ffmpeg's output
+type JobRunner struct {
+	executor    *Executor
+	jobID       string
+	outOfMemory bool
+}
+
+// Run creates the Job and follows it to completion. If ctx is cancelled
+// the Job is deleted, stopping the encode.
+func (r *JobRunner) Run(ctx context.Context, args []string, stdout io.Writer) error {
+	e := r.executor
+	name := jobName(r.jobID)
+	base := "/apis/batch/v1/namespaces/" + url.PathEscape(e.config.Namespace) + "/jobs"
+	if err := e.client.do(ctx, http.MethodPost, base, e.manifest(name, r.jobID, args, r.outOfMemory), nil); err != nil {
+		return fmt.Errorf("failed to create Kubernetes Job: %w", err)
+	}
+	log.Printf("Job %s: encoding in Kubernetes Job %s", r.jobID, name)
+
+	// Jobs that finish are kept for TTL for inspection; ones cancelled or
+	// that can't start are deleted
+	started := false
+	defer func() {
+		if started && ctx.Err() == nil {
+			return
+		}
+		deleteCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		path := base + "/" + url.PathEscape(name) + "?propagationPolicy=Background"
+		if err := e.client.do(deleteCtx, http.MethodDelete, path, nil, nil); err != nil {
+			log.Printf("Job %s: failed to delete Kubernetes Job %s: %v", r.jobID, name, err)
+		}
+	}()
+
+	pod, err := r.waitForPod(ctx, name)
+	if err != nil {
+		return err
+	}
+	started = true
+
+	// Errors are the only log lines besides progress; keep the last few
+	// to explain a failure
+	tail := &tailWriter{}
+	logPath := "/api/v1/namespaces/" + url.PathEscape(e.config.Namespace) + "/pods/" + url.PathEscape(pod) + "/log?follow=true&container=ffmpeg"
+	if logs, err := e.client.stream(ctx, logPath); err != nil {
+		log.Printf("Job %s: failed to follow pod %s log: %v", r.jobID, pod, err)
+	} else {
+		io.Copy(io.MultiWriter(stdout, tail), logs)
+		logs.Close()
+	}
+
+	return r.waitForJob(ctx, base+"/"+url.PathEscape(name), pod, tail)
+}
+
+// manifest builds the Job running ffmpeg with args, with the OOM memory
+// request and limit, where set, if outOfMemory
+func (e *Executor) manifest(name, jobID string, args []string, outOfMemory bool) map[string]interface{} {
+	labels := map[string]string{
+		"app.kubernetes.io/name":         "skillcape-transcoder",
+		"app.kubernetes.io/component":    "encode",
+		"transcoder.skillcape.io/job-id": labelValue(jobID),
+	}
+
+	requests, limits := map[string]string{}, map[string]string{}
+	set := func(m map[string]string, key, value string) {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	set(requests, "cpu", e.config.CPURequest)
+	set(requests, "memory", e.config.MemoryRequest)
+	set(limits, "cpu", e.config.CPULimit)
+	set(limits, "memory", e.config.MemoryLimit)
+	if outOfMemory {
+		set(requests, "memory", e.config.OOMRequest)
+		set(limits, "memory", e.config.OOMLimit)
+	}
+	if e.config.GPUs > 0 {
+		limits[e.config.GPUResource] = fmt.Sprint(e.config.GPUs)
+	}
+
+	// Only progress and errors reach the log
+	ffmpegArgs := append([]string{"-nostats", "-loglevel", "error"}, args...)
+
+	pod := map[string]interface{}{
+		"restartPolicy": "Never",
+		"containers": []interface{}{map[string]interface{}{
+			"name":                     "ffmpeg",
+			"image":                    e.config.Image,
+			"command":                  []string{"ffmpeg"},
+			"args":                     ffmpegArgs,
+			"resources":                map[string]interface{}{"requests": requests, "limits": limits},
+			"volumeMounts":             []interface{}{map[string]string{"name": "work", "mountPath": e.config.MountPath}},
+			"terminationMessagePolicy": "FallbackToLogsOnError",
+		}},
+		"volumes": []interface{}{map[string]interface{}{
+			"name":                  "work",
+			"persistentVolumeClaim": map[string]string{"claimName": e.config.VolumeClaim},
+		}},
+	}
+	if e.config.ServiceAccount != "" {
+		pod["serviceAccountName"] = e.config.ServiceAccount
+	}
+
+	spec := map[string]interface{}{
+		// Retries are the server's decision, not the Job's
+		"backoffLimit": 0,
+		"template": map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": labels},
+			"spec":     pod,
+		},
+	}
+	if e.config.TTL > 0 {
+		spec["ttlSecondsAfterFinished"] = int(e.config.TTL.Seconds())
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]interface{}{"name": name, "labels": labels},
+		"spec":       spec,
+	}
+}
+
+// podStatus is the part of a pod the runner looks at
+type podStatus struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status struct {
+		Phase             string `json:"phase"`
+		Reason            string `json:"reason"`  // such as Evicted
+		Message           string `json:"message"` // why, for evictions
+		ContainerStatuses []struct {
+			State struct {
+				Waiting *struct {
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"waiting"`
+				Terminated *struct {
+					ExitCode int    `json:"exitCode"`
+					Reason   string `json:"reason"`
+				} `json:"terminated"`
+			} `json:"state"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// unrecoverable are reasons a container waits that won't resolve by
+// themselves
+var unrecoverable = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// waitForPod waits until the Job's pod has started, returning its name.
+// Pods can wait a long time to be scheduled, such as for a GPU node; only
+// ctx bounds that.
+func (r *JobRunner) waitForPod(ctx context.Context, job string) (string, error) {
+	path := "/api/v1/namespaces/" + url.PathEscape(r.executor.config.Namespace) + "/pods?labelSelector=" + url.QueryEscape("job-name="+job)
+	for {
+		var pods struct {
+			Items []podStatus `json:"items"`
+		}
+		if err := r.executor.client.do(ctx, http.MethodGet, path, nil, &pods); err != nil {
+			log.Printf("Job %s: failed to check pod status: %v", r.jobID, err)
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != "Pending" {
+				return pod.Metadata.Name, nil
+			}
+			for _, container := range pod.Status.ContainerStatuses {
+				if waiting := container.State.Waiting; waiting != nil && unrecoverable[waiting.Reason] {
+					return "", fmt.Errorf("pod %s cannot start: %s: %s", pod.Metadata.Name, waiting.Reason, waiting.Message)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// waitForJob waits for the Job to succeed or fail
+func (r *JobRunner) waitForJob(ctx context.Context, path, pod string, tail *tailWriter) error {
+	for {
+		var job struct {
+			Status struct {
+				Succeeded int `json:"succeeded"`
+				Failed    int `json:"failed"`
+			} `json:"status"`
+		}
+		err := r.executor.client.do(ctx, http.MethodGet, path, nil, &job)
+		var status *StatusError
+		if errors.As(err, &status) && status.Code == http.StatusNotFound {
+			return fmt.Errorf("Kubernetes Job was deleted before it finished")
+		}
+		if err != nil {
+			log.Printf("Job %s: failed to check Kubernetes Job status: %v", r.jobID, err)
+		}
+		if job.Status.Succeeded > 0 {
+			return nil
+		}
+		if job.Status.Failed > 0 {
+			return r.failure(ctx, pod, tail)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// failure describes why the pod's ffmpeg failed, wrapping the cause the
+// pod's status or ffmpeg's output points at, as transcoder.Classify does
+func (r *JobRunner) failure(ctx context.Context, pod string, tail *tailWriter) error {
+	reason, code := "pod failed", -1
+	var status podStatus
+	path := "/api/v1/namespaces/" + url.PathEscape(r.executor.config.Namespace) + "/pods/" + url.PathEscape(pod)
+	if r.executor.client.do(ctx, http.MethodGet, path, nil, &status) == nil {
+		for _, container := range status.Status.ContainerStatuses {
+			if t := container.State.Terminated; t != nil {
+				reason, code = fmt.Sprintf("exit code %d (%s)", t.ExitCode, t.Reason), t.ExitCode
+				// Past the container's memory limit, whatever the code
+				if t.Reason == "OOMKilled" {
+					code = 137
+				}
+			}
+		}
+	}
+	message := tail.String()
+	err := fmt.Errorf("ffmpeg failed in pod %s: %s", pod, reason)
+	if message != "" {
+		err = fmt.Errorf("ffmpeg failed in pod %s: %s: %s", pod, reason, message)
+	}
+	// Evicted pods are killed for what their node ran short of, which the
+	// eviction message names
+	if status.Status.Reason == "Evicted" {
+		err = fmt.Errorf("%w (evicted: %s)", err, status.Status.Message)
+		code, message = -1, status.Status.Message
+	}
+	return transcoder.Classify(err, code, message)
+}
+
+var invalidName = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// jobName returns a unique, valid Job name for a transcode job. Retries
+// get their own Job, so a suffix is added.
+func jobName(jobID string) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	id := strings.Trim(invalidName.ReplaceAllString(strings.ToLower(jobID), "-"), "-")
+	if len(id) > 40 {
+		id = id[:40]
+	}
+	return "transcode-" + id + "-" + hex.EncodeToString(suffix)
+}
+
+// labelValue shortens a job ID to fit a label value
+func labelValue(jobID string) string {
+	if len(jobID) > 63 {
+		return jobID[:63]
+	}
+	return jobID
+}
+
+// tailWriter keeps the last few lines written that aren't ffmpeg progress
+// (key=value) lines
+type tailWriter struct {
+	mu      sync.Mutex
+	partial string
+	lines   []string
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	scanner := bufio.NewScanner(strings.NewReader(w.partial + string(p)))
+	w.partial = ""
+	complete := strings.HasSuffix(string(p), "\n")
+	var last string
+	for scanner.Scan() {
+		if last != "" {
+			w.keep(last)
+		}
+		last = scanner.Text()
+	}
+	if complete {
+		w.keep(last)
+	} else {
+		w.partial = last
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) keep(line string) {
+	key, _, found := strings.Cut(line, "=")
+	if strings.TrimSpace(line) == "" || (found && !strings.ContainsAny(key, " \t:")) {
+		return
+	}
+	w.lines = append(w.lines, line)
+	if len(w.lines) > 5 {
+		w.lines = w.lines[1:]
+	}
+}
+
+// String returns the kept lines joined by "; "
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.Join(w.lines, "; ")
+}
@@ -0,0 +1,242 @@
+// Package metrics keeps the server's metrics in memory and renders them in
+// the Prometheus text exposition format for scraping.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text Write produces
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric is one named family of series
+type metric interface {
+	write(w io.Writer) error
+}
+
+var (
+	mu         sync.Mutex
+	registered []metric
+	names      = make(map[string]bool)
+)
+
+// register adds m to the metrics Write renders. Names must be unique.
+func register(name string, m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	names[name] = true
+	registered = append(registered, m)
+}
+
+// Write renders every metric in the order they were created
+func Write(w io.Writer) error {
+	mu.Lock()
+	metrics := append([]metric(nil), registered...)
+	mu.Unlock()
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge is a value that can go up and down, optionally split by labels
+type Gauge struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge creates and registers a gauge. Set must then be given one value
+// for each label name.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(name, g)
+	return g
+}
+
+// Set sets the gauge for the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	key := seriesKey(g.name, g.labels, labelValues)
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(g.values) {
+		if _, err := fmt.Fprintf(w, "%s %s\n", key, formatValue(g.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counter is a value that only goes up, optionally split by labels
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates and registers a counter. Inc and Add must then be
+// given one value for each label name.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(name, c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the counter for the
+// given label values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	key := seriesKey(c.name, c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s %s\n", key, formatValue(c.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram counts observations into buckets, optionally split by labels
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// NewHistogram creates and registers a histogram with the given upper
+// bucket bounds, in increasing order; +Inf is added
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	register(name, h)
+	return h
+}
+
+// Observe records one value for the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := seriesKey(h.name, h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			bucket := seriesKey(h.name+"_bucket", labels, append(append([]string(nil), s.labelValues...), formatValue(bound)))
+			if _, err := fmt.Fprintf(w, "%s %d\n", bucket, cumulative); err != nil {
+				return err
+			}
+		}
+		bucket := seriesKey(h.name+"_bucket", labels, append(append([]string(nil), s.labelValues...), "+Inf"))
+		sum := seriesKey(h.name+"_sum", h.labels, s.labelValues)
+		count := seriesKey(h.name+"_count", h.labels, s.labelValues)
+		if _, err := fmt.Fprintf(w, "%s %d\n%s %s\n%s %d\n", bucket, s.count, sum, formatValue(s.sum), count, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesKey renders a metric name with its labels, such as
+// name{preset="web"}, which identifies the series
+func seriesKey(name string, labels, values []string) string {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", name, len(labels), len(values)))
+	}
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = label + "=" + strconv.Quote(values[i])
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// pluginMethod is the one method plugins serve; see plugin.proto
+const pluginMethod = "/transcoder.plugin.v1.StepPlugin/Run"
+
+// PluginStep runs a step in an external process serving the StepPlugin
+// gRPC service described in plugin.proto, so it can be written in any
+// language
+type PluginStep struct {
+	name    string
+	phase   string
+	conn    *grpc.ClientConn
+	timeout time.Duration
+}
+
+// ParsePlugin splits a PIPELINE_PLUGINS entry, phase:name=target
+func ParsePlugin(spec string) (phase, name, target string, err error) {
+	phase, rest, ok := strings.Cut(spec, ":")
+	if ok {
+		name, target, ok = strings.Cut(rest, "=")
+	}
+	if !ok || name == "" || target == "" {
+		return "", "", "", fmt.Errorf("%q is not phase:name=target", spec)
+	}
+	if err := ValidatePhase(phase); err != nil {
+		return "", "", "", err
+	}
+	return phase, name, target, nil
+}
+
+// DialPlugin connects to a plugin at target, host:port in plaintext or
+// tls://host:port. The connection is made lazily, so a plugin that isn't
+// up yet fails the jobs reaching it rather than the server's startup.
+func DialPlugin(name, phase, target string, timeout time.Duration) (*PluginStep, error) {
+	creds := insecure.NewCredentials()
+	if rest, ok := strings.CutPrefix(target, "tls://"); ok {
+		target = rest
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to plugin %s: %w", name, err)
+	}
+	return &PluginStep{name: name, phase: phase, conn: conn, timeout: timeout}, nil
+}
+
+// Name returns the step's configured name
+func (p *PluginStep) Name() string {
+	return p.name
+}
+
+// Run calls the plugin with the job. Its reply may rename the output with
+// an output_name field.
+func (p *PluginStep) Run(ctx context.Context, job *jobs.Job) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	// The job goes through JSON so it has the same fields as in the API
+	encoded, err := json.Marshal(job.ToResponse())
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return err
+	}
+	inputs := make([]interface{}, 0, len(job.InputFiles()))
+	for _, path := range job.InputFiles() {
+		inputs = append(inputs, path)
+	}
+	request, err := structpb.NewStruct(map[string]interface{}{
+		"step":        p.name,
+		"phase":       p.phase,
+		"request_id":  job.RequestID,
+		"job":         fields,
+		"input_paths": inputs,
+		"output_path": job.OutputPath,
+	})
+	if err != nil {
+		return err
+	}
+
+	reply := &structpb.Struct{}
+	if err := p.conn.Invoke(ctx, pluginMethod, request, reply); err != nil {
+		return fmt.Errorf("plugin call failed: %w", err)
+	}
+	if name, ok := reply.AsMap()["output_name"].(string); ok && name != "" {
+		if err := jobs.ValidateOutputName(name); err != nil {
+			return fmt.Errorf("plugin returned an invalid output name: %v", err)
+		}
+		job.NameTemplate = name
+	}
+	return nil
+}
+
+// Close closes the connection to the plugin
+func (p *PluginStep) Close() error {
+	return p.conn.Close()
+}
@@ -0,0 +1,78 @@
+// Package pipeline lets extra steps, such as notifying an LMS, run as part
+// of processing every job without changing the processor. Steps are
+// compiled in, registering themselves from an init function in a package
+// the server imports, or run by an external gRPC plugin.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// Phases steps run in. A step failing before delivery fails the job; after
+// delivery the failure is only recorded.
+const (
+	BeforeTranscode = "before_transcode" // after the pre hook, before ffmpeg
+	AfterTranscode  = "after_transcode"  // once the output is encoded and probed
+	AfterDelivery   = "after_delivery"   // once the output is uploaded, before the post hook
+)
+
+// Phases lists every phase in the order they run
+var Phases = []string{BeforeTranscode, AfterTranscode, AfterDelivery}
+
+// Step is an extra stage of job processing. It may change the job, such as
+// its output name or labels; the processor saves it afterwards.
+type Step interface {
+	Name() string
+	Run(ctx context.Context, job *jobs.Job) error
+}
+
+var (
+	mu    sync.Mutex
+	steps = make(map[string][]Step)
+)
+
+// Register adds a step to run in phase, after those already registered.
+// Compiled-in steps call it from an init function.
+func Register(phase string, step Step) {
+	if err := ValidatePhase(phase); err != nil {
+		panic("pipeline: " + err.Error())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	steps[phase] = append(steps[phase], step)
+}
+
+// Steps returns the steps registered for phase, in order
+func Steps(phase string) []Step {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Step(nil), steps[phase]...)
+}
+
+// ValidatePhase checks phase is one steps can run in
+func ValidatePhase(phase string) error {
+	for _, p := range Phases {
+		if p == phase {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown phase %q (want before_transcode, after_transcode or after_delivery)", phase)
+}
+
+// Func adapts a function to a Step
+func Func(name string, run func(ctx context.Context, job *jobs.Job) error) Step {
+	return funcStep{name: name, run: run}
+}
+
+type funcStep struct {
+	name string
+	run  func(ctx context.Context, job *jobs.Job) error
+}
+
+func (s funcStep) Name() string { return s.name }
+
+func (s funcStep) Run(ctx context.Context, job *jobs.Job) error { return s.run(ctx, job) }
@@ -0,0 +1,48 @@
+package requestid
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Header carries the request ID on API requests, responses, and webhooks
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+// validID limits client-supplied IDs to characters that are safe in logs
+// and headers
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Normalize returns the client-supplied ID if it is acceptable, otherwise a
+// newly generated one
+func Normalize(id string) string {
+	if validID.MatchString(id) {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// NewContext returns a context carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or ""
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logf logs like log.Printf, prefixed with the request ID from ctx if any
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if id := FromContext(ctx); id != "" {
+		message = "[" + id + "] " + message
+	}
+	log.Output(2, message)
+}
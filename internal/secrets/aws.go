@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/sigv4"
+)
+
+// fetchAWS reads a secret from AWS Secrets Manager by name or ARN, using
+// the standard AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN variables
+func fetchAWS(ctx context.Context, id string) (string, error) {
+	region, creds := sigv4.EnvRegion(), sigv4.EnvCredentials()
+	if region == "" || creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return "", fmt.Errorf("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	url := "https://secretsmanager." + region + ".amazonaws.com/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, sigv4.HashHex(body), creds, region, "secretsmanager", time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("secrets manager returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var secret struct {
+		SecretString string
+		SecretBinary []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("invalid secrets manager response: %v", err)
+	}
+	if secret.SecretBinary != nil {
+		return string(secret.SecretBinary), nil
+	}
+	return secret.SecretString, nil
+}
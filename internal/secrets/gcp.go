@@ -0,0 +1,27 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// fetchGCP reads a secret version from Google Secret Manager, such as
+// projects/my-project/secrets/api-key/versions/latest, with the
+// application default credentials
+func fetchGCP(ctx context.Context, name string) (string, error) {
+	service, err := secretmanager.NewService(ctx)
+	if err != nil {
+		return "", err
+	}
+	version, err := service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
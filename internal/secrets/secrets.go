@@ -0,0 +1,119 @@
+// Package secrets resolves settings that name a secret held in HashiCorp
+// Vault, AWS Secrets Manager or Google Secret Manager instead of holding
+// the value itself. References look like
+//
+//	vault://secret/data/transcoder#api_key
+//	awssm://transcoder/production#api_key
+//	gcpsm://projects/my-project/secrets/api-key/versions/latest
+//
+// The #field suffix picks one key out of a JSON secret; Vault secrets are
+// always JSON, so it is required there.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchTimeout bounds each request to a secret manager
+const fetchTimeout = 10 * time.Second
+
+// fetchFunc reads the secret named by the part of a reference after the
+// scheme, without its field
+type fetchFunc func(ctx context.Context, name string) (string, error)
+
+var fetchers = map[string]fetchFunc{
+	"vault": fetchVault,
+	"awssm": fetchAWS,
+	"gcpsm": fetchGCP,
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	mu    sync.Mutex
+	cache = make(map[string]cachedSecret)
+	ttl   = 5 * time.Minute
+)
+
+// SetCacheTTL sets how long resolved secrets are reused before they are
+// fetched again, which is how rotated secrets are picked up. 0 disables
+// the cache.
+func SetCacheTTL(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	ttl = d
+}
+
+// IsReference reports whether value names a secret rather than holding one
+func IsReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	return ok && fetchers[scheme] != nil
+}
+
+// Resolve returns the secret named by ref, from the cache while fresh
+func Resolve(ctx context.Context, ref string) (string, error) {
+	scheme, rest, _ := strings.Cut(ref, "://")
+	fetch := fetchers[scheme]
+	if fetch == nil {
+		return "", fmt.Errorf("unknown secret reference %q", ref)
+	}
+	name, field, _ := strings.Cut(rest, "#")
+	if scheme == "vault" && field == "" {
+		return "", fmt.Errorf("vault reference %q needs a #field", ref)
+	}
+
+	mu.Lock()
+	cached, ok := cache[ref]
+	mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	raw, err := fetch(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", scheme+"://"+name, err)
+	}
+	value, err := pickField(raw, field)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", ref, err)
+	}
+
+	mu.Lock()
+	if ttl > 0 {
+		cache[ref] = cachedSecret{value: value, expires: time.Now().Add(ttl)}
+	}
+	mu.Unlock()
+	return value, nil
+}
+
+// pickField returns field from a JSON object secret, or the whole secret
+// when field is empty
+func pickField(raw, field string) (string, error) {
+	if field == "" {
+		return raw, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object")
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	// Nested objects, such as service account keys, are returned as JSON
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
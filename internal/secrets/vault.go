@@ -0,0 +1,56 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// fetchVault reads a secret over Vault's HTTP API, using the standard
+// VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE variables. path is the API
+// path, such as secret/data/transcoder for the KV version 2 engine.
+func fetchVault(ctx context.Context, path string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+
+	url := strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid vault response: %v", err)
+	}
+
+	// KV version 2 nests the secret under data.data, next to its metadata
+	var kv2 struct {
+		Data     json.RawMessage `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if json.Unmarshal(body.Data, &kv2) == nil && kv2.Data != nil && kv2.Metadata != nil {
+		return string(kv2.Data), nil
+	}
+	return string(body.Data), nil
+}
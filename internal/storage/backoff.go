@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Limits on backing off from Drive's rate limits: the first pause lasts
+// minDriveBackoff, doubling with every rate limited response up to
+// maxDriveBackoff, and a request is tried at most driveAttempts times
+const (
+	minDriveBackoff = time.Second
+	maxDriveBackoff = 64 * time.Second
+	driveAttempts   = 6
+)
+
+// driveQuota is shared by every Drive client, since the quota belongs to
+// the project and service account rather than to one upload. When Drive
+// says the server is going too fast, all Drive requests pause, not just
+// the one that was refused.
+var driveQuota = &quotaBackoff{}
+
+// quotaBackoff pauses requests after rate limited responses. The pause
+// grows while responses stay rate limited and shrinks again as requests
+// succeed.
+type quotaBackoff struct {
+	mu    sync.Mutex
+	delay time.Duration // the next pause; zero when not rate limited
+	until time.Time     // requests wait until then
+}
+
+// wait blocks until the current pause, if any, is over
+func (b *quotaBackoff) wait(ctx context.Context) error {
+	b.mu.Lock()
+	pause := time.Until(b.until)
+	b.mu.Unlock()
+	if pause <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limited starts or extends a pause after a rate limited response, for at
+// least retryAfter if the server asked for that
+func (b *quotaBackoff) limited(retryAfter time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delay = min(max(b.delay*2, minDriveBackoff), maxDriveBackoff)
+	// Jitter keeps the waiting requests from all retrying at once
+	pause := max(b.delay+time.Duration(rand.Int63n(int64(b.delay/2))), retryAfter)
+	if until := time.Now().Add(pause); until.After(b.until) {
+		b.until = until
+	}
+	return pause
+}
+
+// succeeded shortens the next pause after a request went through
+func (b *quotaBackoff) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.delay /= 2; b.delay < minDriveBackoff {
+		b.delay = 0
+	}
+}
+
+// withDriveQuota runs a Drive request, waiting out any pause first and
+// trying again after rate limited responses, up to attempts times in all
+func withDriveQuota(ctx context.Context, attempts int, request func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := driveQuota.wait(ctx); err != nil {
+			return err
+		}
+		err := request()
+		retryAfter, limited := rateLimited(err)
+		if !limited {
+			if err == nil {
+				driveQuota.succeeded()
+			}
+			return err
+		}
+		pause := driveQuota.limited(retryAfter)
+		if attempt >= attempts {
+			return err
+		}
+		log.Printf("Drive rate limit reached; pausing Drive requests for %s (attempt %d of %d)", pause.Round(time.Second), attempt, attempts)
+	}
+}
+
+// rateLimited reports whether err is Drive refusing a request for going
+// too fast: a 429, or a 403 with a rate limit reason. The Retry-After
+// header, if any, is returned too.
+func rateLimited(err error) (retryAfter time.Duration, limited bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+	switch apiErr.Code {
+	case http.StatusTooManyRequests:
+		limited = true
+	case http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == "userRateLimitExceeded" || item.Reason == "rateLimitExceeded" {
+				limited = true
+			}
+		}
+	}
+	if seconds, err := strconv.Atoi(apiErr.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return retryAfter, limited
+}
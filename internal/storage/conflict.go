@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// What a Drive upload does when its folder already has a file of the same
+// name
+const (
+	ConflictDuplicate = "duplicate" // upload beside it; Drive allows repeated names
+	ConflictVersion   = "version"   // upload as "name-2.ext", "name-3.ext", ...
+	ConflictOverwrite = "overwrite" // replace its content, keeping its file ID and link
+	ConflictFail      = "fail"      // fail the upload
+)
+
+// maxNameVersions bounds the search for a free versioned name
+const maxNameVersions = 1000
+
+// ValidateConflict checks an on_conflict setting; empty means duplicate
+func ValidateConflict(mode string) error {
+	switch mode {
+	case "", ConflictDuplicate, ConflictVersion, ConflictOverwrite, ConflictFail:
+		return nil
+	}
+	return fmt.Errorf("must be %s, %s, %s or %s", ConflictDuplicate, ConflictVersion, ConflictOverwrite, ConflictFail)
+}
+
+// SetOnConflict sets what uploads do when the folder already has a file
+// of the same name (empty keeps ConflictDuplicate)
+func (gd *GoogleDriveClient) SetOnConflict(mode string) {
+	gd.onConflict = mode
+}
+
+// resolveConflict applies the client's conflict mode to an upload of
+// fileName into the folder. It returns the name to upload as, and the ID
+// of the file to overwrite, if any.
+func (gd *GoogleDriveClient) resolveConflict(ctx context.Context, folderID, fileName string) (name, existingID string, err error) {
+	if gd.onConflict == "" || gd.onConflict == ConflictDuplicate {
+		return fileName, "", nil
+	}
+	existingID, err = gd.findFile(ctx, folderID, fileName)
+	if err != nil || existingID == "" {
+		return fileName, "", err
+	}
+
+	switch gd.onConflict {
+	case ConflictOverwrite:
+		return fileName, existingID, nil
+	case ConflictFail:
+		return "", "", fmt.Errorf("a file named %q is already in the Drive folder", fileName)
+	}
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for n := 2; n <= maxNameVersions; n++ {
+		name = fmt.Sprintf("%s-%d%s", base, n, ext)
+		taken, err := gd.findFile(ctx, folderID, name)
+		if err != nil {
+			return "", "", err
+		}
+		if taken == "" {
+			return name, "", nil
+		}
+	}
+	return "", "", fmt.Errorf("no free name for %q in the Drive folder", fileName)
+}
+
+// findFile returns the ID of a file named name in the folder, or "" if
+// there is none. Of several, the most recently modified is returned.
+func (gd *GoogleDriveClient) findFile(ctx context.Context, folderID, name string) (string, error) {
+	query := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", escapeQuery(name), escapeQuery(folderID))
+	var list *drive.FileList
+	err := withDriveQuota(ctx, driveAttempts, func() error {
+		var err error
+		list, err = gd.service.Files.List().
+			Q(query).
+			Fields("files(id)").
+			OrderBy("modifiedTime desc").
+			PageSize(1).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look for existing file: %w", err)
+	}
+	if len(list.Files) == 0 {
+		return "", nil
+	}
+	return list.Files[0].Id, nil
+}
+
+// escapeQuery escapes a string literal in a Drive search query
+func escapeQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Destination is somewhere finished outputs are delivered. UploadFile returns
+// an ID for the stored file and a link to it.
+type Destination interface {
+	UploadFile(ctx context.Context, filePath, fileName string) (fileID, link string, err error)
+}
+
+// DestinationFunc lets a function act as a Destination, such as to pick
+// the folder of each upload
+type DestinationFunc func(ctx context.Context, filePath, fileName string) (fileID, link string, err error)
+
+// UploadFile calls f
+func (f DestinationFunc) UploadFile(ctx context.Context, filePath, fileName string) (fileID, link string, err error) {
+	return f(ctx, filePath, fileName)
+}
+
+// Deleter is a Destination that can remove a file it stored, given the ID
+// UploadFile returned. Removing a file that is already gone succeeds.
+type Deleter interface {
+	DeleteFile(ctx context.Context, fileID string) error
+}
+
+// Presigner is a Destination that can hand out a link to a file it stored,
+// which downloads it without credentials until ttl has passed
+type Presigner interface {
+	PresignGet(fileID, fileName string, ttl time.Duration) (string, error)
+}
+
+// Checker is a Destination that can tell whether it is reachable, without
+// leaving anything behind
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// UploadDir uploads the files in dir to dest, in name order, each named
+// prefix plus its own name, such as the segments of an HLS playlist.
+// Files skip reports as done are left out, and uploaded is told of every
+// other file once stored, so an interrupted upload can carry on where it
+// stopped.
+func UploadDir(ctx context.Context, dest Destination, dir, prefix string, skip func(name string) bool, uploaded func(name, fileID, link string)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || skip(name) {
+			continue
+		}
+		fileID, link, err := dest.UploadFile(ctx, filepath.Join(dir, name), prefix+name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		uploaded(name, fileID, link)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DirectoryDestination copies outputs into a directory, such as a mounted
+// network share or a folder served by a web server during development
+type DirectoryDestination struct {
+	dir     string
+	baseURL string
+}
+
+// NewDirectoryDestination creates dir if needed. Links are baseURL plus
+// the file name, or empty when baseURL is.
+func NewDirectoryDestination(dir, baseURL string) (*DirectoryDestination, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return &DirectoryDestination{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// UploadFile copies a file into the directory and returns its path
+func (d *DirectoryDestination) UploadFile(ctx context.Context, filePath, fileName string) (fileID, link string, err error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	path := filepath.Join(d.dir, filepath.Base(fileName))
+	dst, err := os.Create(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create file: %w", quotaExceeded(err))
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(path)
+		return "", "", fmt.Errorf("failed to copy file: %w", quotaExceeded(err))
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path)
+		return "", "", fmt.Errorf("failed to copy file: %w", quotaExceeded(err))
+	}
+
+	if d.baseURL != "" {
+		link = d.baseURL + "/" + url.PathEscape(filepath.Base(fileName))
+	}
+	log.Printf("File copied to %s", path)
+	return path, link, nil
+}
+
+// DeleteFile removes a file copied into the directory, given its path
+func (d *DirectoryDestination) DeleteFile(ctx context.Context, fileID string) error {
+	if fileID == "" {
+		return nil
+	}
+	// Only files directly in the directory were put there by UploadFile
+	if filepath.Dir(filepath.Clean(fileID)) != filepath.Clean(d.dir) {
+		return fmt.Errorf("%s is not in %s", fileID, d.dir)
+	}
+	if err := os.Remove(fileID); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	log.Printf("File deleted: %s", fileID)
+	return nil
+}
+
+// Check makes sure files can be created in the directory
+func (d *DirectoryDestination) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(d.dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
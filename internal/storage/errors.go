@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ErrQuotaExceeded is wrapped by the errors of uploads refused because
+// the destination is full, or its owner is over their storage quota
+var ErrQuotaExceeded = errors.New("destination storage quota exceeded")
+
+// s3QuotaCodes are the error codes S3-compatible services refuse uploads
+// to a full bucket or disk with
+var s3QuotaCodes = []string{"QuotaExceeded", "XMinioStorageFull", "XMinioAdminBucketQuotaExceeded"}
+
+// quotaExceeded wraps err with ErrQuotaExceeded when it is a refusal for
+// lack of space: Drive's storageQuotaExceeded, an S3 507 or quota error
+// code, or a full disk under a directory
+func quotaExceeded(err error) error {
+	if err == nil {
+		return nil
+	}
+	full := errors.Is(err, syscall.ENOSPC)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		for _, item := range apiErr.Errors {
+			full = full || item.Reason == "storageQuotaExceeded"
+		}
+	}
+	if !full {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrQuotaExceeded, err)
+}
+
+// s3QuotaExceeded reports whether an S3 error response refuses an upload
+// for lack of space
+func s3QuotaExceeded(status int, body string) bool {
+	if status == http.StatusInsufficientStorage {
+		return true
+	}
+	for _, code := range s3QuotaCodes {
+		if strings.Contains(body, "<Code>"+code+"</Code>") {
+			return true
+		}
+	}
+	return false
+}
@@ -2,30 +2,29 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
 type GoogleDriveClient struct {
-	service  *drive.Service
-	folderID string
+	service    *drive.Service
+	folderID   string
+	onConflict string // see SetOnConflict
 }
 
-func NewGoogleDriveClient(ctx context.Context, credentialsFile, folderID string) (*GoogleDriveClient, error) {
-	// Read credentials file
-	credBytes, err := os.ReadFile(credentialsFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read credentials file: %w", err)
-	}
-
+// NewGoogleDriveClient creates a client from a service account key
+func NewGoogleDriveClient(ctx context.Context, credentials []byte, folderID string) (*GoogleDriveClient, error) {
 	// Create JWT config from service account credentials
-	config, err := google.JWTConfigFromJSON(credBytes, drive.DriveFileScope)
+	config, err := google.JWTConfigFromJSON(credentials, drive.DriveFileScope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse credentials: %w", err)
 	}
@@ -46,6 +45,11 @@ func NewGoogleDriveClient(ctx context.Context, credentialsFile, folderID string)
 
 // UploadFile uploads a file to Google Drive and returns the file ID and shareable link
 func (gd *GoogleDriveClient) UploadFile(ctx context.Context, filePath, fileName string) (fileID, webViewLink string, err error) {
+	return gd.UploadFileTo(ctx, gd.folderID, filePath, fileName)
+}
+
+// UploadFileTo uploads a file into a specific Drive folder
+func (gd *GoogleDriveClient) UploadFileTo(ctx context.Context, folderID, filePath, fileName string) (fileID, webViewLink string, err error) {
 	// Open the file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -53,76 +57,86 @@ func (gd *GoogleDriveClient) UploadFile(ctx context.Context, filePath, fileName
 	}
 	defer file.Close()
 
-	// Create file metadata
-	driveFile := &drive.File{
-		Name:    fileName,
-		Parents: []string{gd.folderID},
-	}
-
-	// Upload the file
-	uploadedFile, err := gd.service.Files.Create(driveFile).
-		Media(file).
-		Fields("id, webViewLink").
-		Context(ctx).
-		Do()
-	if err != nil {
-		return "", "", fmt.Errorf("failed to upload file: %w", err)
-	}
+	return gd.upload(ctx, folderID, file, fileName)
+}
 
-	// Make the file accessible via link
-	_, err = gd.service.Permissions.Create(uploadedFile.Id, &drive.Permission{
-		Type: "anyone",
-		Role: "reader",
-	}).Context(ctx).Do()
-	if err != nil {
-		log.Printf("Warning: failed to set file permissions: %v", err)
-	}
+// UploadFileFromReader uploads a file from an io.Reader. Unless the reader
+// is also an io.Seeker, it is not retried after Drive's rate limits.
+func (gd *GoogleDriveClient) UploadFileFromReader(ctx context.Context, reader io.Reader, fileName string) (fileID, webViewLink string, err error) {
+	return gd.upload(ctx, gd.folderID, reader, fileName)
+}
 
-	// Get the updated file with webViewLink
-	uploadedFile, err = gd.service.Files.Get(uploadedFile.Id).
-		Fields("id, webViewLink").
-		Context(ctx).
-		Do()
+// upload creates a file in the folder, or overwrites one of the same name
+// if the conflict mode says so, and shares it by link. Every request goes
+// through withDriveQuota, so rate limits pause all uploads.
+func (gd *GoogleDriveClient) upload(ctx context.Context, folderID string, media io.Reader, fileName string) (fileID, webViewLink string, err error) {
+	fileName, existingID, err := gd.resolveConflict(ctx, folderID, fileName)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get file info: %w", err)
+		return "", "", err
 	}
 
-	log.Printf("File uploaded to Drive: %s (ID: %s)", fileName, uploadedFile.Id)
-	return uploadedFile.Id, uploadedFile.WebViewLink, nil
-}
-
-// UploadFileFromReader uploads a file from an io.Reader
-func (gd *GoogleDriveClient) UploadFileFromReader(ctx context.Context, reader io.Reader, fileName string) (fileID, webViewLink string, err error) {
 	// Create file metadata
 	driveFile := &drive.File{
 		Name:    fileName,
-		Parents: []string{gd.folderID},
+		Parents: []string{folderID},
 	}
 
-	// Upload the file
-	uploadedFile, err := gd.service.Files.Create(driveFile).
-		Media(reader).
-		Fields("id, webViewLink").
-		Context(ctx).
-		Do()
+	// Upload the file, from the start again on each attempt
+	seeker, rewindable := media.(io.Seeker)
+	attempts := 1
+	if rewindable {
+		attempts = driveAttempts
+	}
+	var uploadedFile *drive.File
+	err = withDriveQuota(ctx, attempts, func() error {
+		if rewindable {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		var err error
+		if existingID != "" {
+			// Parents can't be set on update, and the file is in the folder already
+			uploadedFile, err = gd.service.Files.Update(existingID, &drive.File{}).
+				Media(media).
+				Fields("id, webViewLink").
+				Context(ctx).
+				Do()
+			return err
+		}
+		uploadedFile, err = gd.service.Files.Create(driveFile).
+			Media(media).
+			Fields("id, webViewLink").
+			Context(ctx).
+			Do()
+		return err
+	})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to upload file: %w", err)
+		return "", "", fmt.Errorf("failed to upload file: %w", quotaExceeded(err))
 	}
 
 	// Make the file accessible via link
-	_, err = gd.service.Permissions.Create(uploadedFile.Id, &drive.Permission{
-		Type: "anyone",
-		Role: "reader",
-	}).Context(ctx).Do()
+	err = withDriveQuota(ctx, driveAttempts, func() error {
+		_, err := gd.service.Permissions.Create(uploadedFile.Id, &drive.Permission{
+			Type: "anyone",
+			Role: "reader",
+		}).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		log.Printf("Warning: failed to set file permissions: %v", err)
 	}
 
 	// Get the updated file with webViewLink
-	uploadedFile, err = gd.service.Files.Get(uploadedFile.Id).
-		Fields("id, webViewLink").
-		Context(ctx).
-		Do()
+	id := uploadedFile.Id
+	err = withDriveQuota(ctx, driveAttempts, func() error {
+		var err error
+		uploadedFile, err = gd.service.Files.Get(id).
+			Fields("id, webViewLink").
+			Context(ctx).
+			Do()
+		return err
+	})
 	if err != nil {
 		return "", "", fmt.Errorf("failed to get file info: %w", err)
 	}
@@ -131,20 +145,46 @@ func (gd *GoogleDriveClient) UploadFileFromReader(ctx context.Context, reader io
 	return uploadedFile.Id, uploadedFile.WebViewLink, nil
 }
 
-// DeleteFile removes a file from Google Drive
+// DeleteFile removes a file from Google Drive. Files already gone are
+// not an error.
 func (gd *GoogleDriveClient) DeleteFile(ctx context.Context, fileID string) error {
 	if fileID == "" {
 		return nil
 	}
-	return gd.service.Files.Delete(fileID).Context(ctx).Do()
+	err := withDriveQuota(ctx, driveAttempts, func() error {
+		return gd.service.Files.Delete(fileID).Context(ctx).Do()
+	})
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// Check makes sure Drive accepts the credentials and can list the folder
+func (gd *GoogleDriveClient) Check(ctx context.Context) error {
+	return withDriveQuota(ctx, 1, func() error {
+		_, err := gd.service.Files.List().
+			Q(fmt.Sprintf("'%s' in parents", escapeQuery(gd.folderID))).
+			Fields("files(id)").
+			PageSize(1).
+			Context(ctx).
+			Do()
+		return err
+	})
 }
 
 // GetFileLink returns the shareable link for a file
 func (gd *GoogleDriveClient) GetFileLink(ctx context.Context, fileID string) (string, error) {
-	file, err := gd.service.Files.Get(fileID).
-		Fields("webViewLink").
-		Context(ctx).
-		Do()
+	var file *drive.File
+	err := withDriveQuota(ctx, driveAttempts, func() error {
+		var err error
+		file, err = gd.service.Files.Get(fileID).
+			Fields("webViewLink").
+			Context(ctx).
+			Do()
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
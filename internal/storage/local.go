@@ -16,6 +16,7 @@ func NewLocalStorage(baseDir string) (*LocalStorage, error) {
 	dirs := []string{
 		filepath.Join(baseDir, "uploads"),
 		filepath.Join(baseDir, "outputs"),
+		filepath.Join(baseDir, "branding"),
 	}
 
 	for _, dir := range dirs {
@@ -46,9 +47,35 @@ func (ls *LocalStorage) SaveUpload(jobID string, filename string, reader io.Read
 	return savePath, nil
 }
 
-// GetOutputPath returns the path for a transcoded output file
-func (ls *LocalStorage) GetOutputPath(jobID string) string {
-	return filepath.Join(ls.baseDir, "outputs", jobID+".mp4")
+// GetOutputPath returns the path for a transcoded output file with the
+// given extension, such as ".mp4"
+func (ls *LocalStorage) GetOutputPath(jobID, ext string) string {
+	return filepath.Join(ls.baseDir, "outputs", jobID+ext)
+}
+
+// SaveBranding stores a tenant's watermark or intro as name, replacing any
+// earlier file of that name without disturbing encodes reading it
+func (ls *LocalStorage) SaveBranding(name string, reader io.Reader) error {
+	path := ls.BrandingPath(name)
+	file, err := os.CreateTemp(filepath.Dir(path), name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// BrandingPath returns the path of a tenant's branding file
+func (ls *LocalStorage) BrandingPath(name string) string {
+	return filepath.Join(ls.baseDir, "branding", name)
 }
 
 // DeleteFile removes a file from storage
@@ -59,14 +86,34 @@ func (ls *LocalStorage) DeleteFile(path string) error {
 	return os.Remove(path)
 }
 
-// CleanupJob removes both input and output files for a job
-func (ls *LocalStorage) CleanupJob(inputPath, outputPath string) {
-	if inputPath != "" {
-		os.Remove(inputPath)
+// CleanupJob removes the input and output files for a job, and output
+// directories such as those of HLS segments
+func (ls *LocalStorage) CleanupJob(inputPaths []string, outputPaths ...string) {
+	for _, paths := range [][]string{inputPaths, outputPaths} {
+		for _, path := range paths {
+			if path != "" {
+				os.RemoveAll(path)
+			}
+		}
 	}
-	if outputPath != "" {
-		os.Remove(outputPath)
+}
+
+// Files returns the paths of the files in the uploads and outputs
+// directories, and of the directories in outputs
+func (ls *LocalStorage) Files() ([]string, error) {
+	var paths []string
+	for _, dir := range []string{"uploads", "outputs"} {
+		entries, err := os.ReadDir(filepath.Join(ls.baseDir, dir))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() || (dir == "outputs" && entry.IsDir()) {
+				paths = append(paths, filepath.Join(ls.baseDir, dir, entry.Name()))
+			}
+		}
 	}
+	return paths, nil
 }
 
 // FileExists checks if a file exists
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// contentTypes covers the output formats, which mime.TypeByExtension only
+// knows if the system's MIME tables list them. ".ts" in particular is
+// often listed as TypeScript or Qt translations.
+var contentTypes = map[string]string{
+	".mp4": "video/mp4",
+	".m4a": "audio/mp4",
+	".mp3": "audio/mpeg",
+	".mkv": "video/x-matroska",
+	".mov": "video/quicktime",
+	".ts":  "video/mp2t",
+	".mxf": "application/mxf",
+	".vtt": "text/vtt",
+	// HLS playlists and their segments, .ts or fragmented MP4
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m4s":  "video/iso.segment",
+}
+
+// ContentType returns the MIME type for a file name's extension, or "" if
+// it is unknown
+func ContentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
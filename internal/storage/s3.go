@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/skillcape/transcoder/internal/sigv4"
+)
+
+// S3Config describes a bucket on AWS S3 or an S3-compatible service
+type S3Config struct {
+	Bucket      string
+	Region      string
+	Endpoint    string // e.g. https://minio.internal:9000; empty for AWS
+	Prefix      string // prepended to object keys
+	BaseURL     string // public origin for links, e.g. a CDN; empty links to the object
+	Credentials sigv4.Credentials
+
+	// Sent with every upload; empty leaves the bucket's defaults
+	StorageClass string            // see ValidateStorageClass
+	Tags         map[string]string // see ValidateTags
+	CacheControl string
+}
+
+// Storage classes an upload can ask for. GLACIER and DEEP_ARCHIVE objects
+// must be restored before they can be downloaded.
+var s3StorageClasses = []string{"STANDARD", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"}
+
+// ValidateStorageClass checks an S3 storage class ("" for the bucket's
+// default)
+func ValidateStorageClass(class string) error {
+	if class == "" || slices.Contains(s3StorageClasses, class) {
+		return nil
+	}
+	return fmt.Errorf("must be one of %s", strings.Join(s3StorageClasses, ", "))
+}
+
+// ValidateTags checks object tags against S3's limits: at most 10, with
+// keys of 1 to 128 characters and values of up to 256
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > 10 {
+		return fmt.Errorf("at most 10 tags are allowed")
+	}
+	for key, value := range tags {
+		if key == "" || utf8.RuneCountInString(key) > 128 {
+			return fmt.Errorf("tag keys must be 1 to 128 characters")
+		}
+		if utf8.RuneCountInString(value) > 256 {
+			return fmt.Errorf("tag %q: values must be at most 256 characters", key)
+		}
+	}
+	return nil
+}
+
+// S3Client uploads outputs to an S3 bucket
+type S3Client struct {
+	config S3Config
+	client *http.Client
+}
+
+// NewS3Client creates a client for the bucket in config
+func NewS3Client(config S3Config) (*S3Client, error) {
+	if config.Bucket == "" || config.Region == "" {
+		return nil, fmt.Errorf("bucket and region are required")
+	}
+	if config.Credentials.AccessKeyID == "" || config.Credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("access key ID and secret access key are required")
+	}
+	log.Printf("S3 client initialized for bucket %s", config.Bucket)
+	return &S3Client{
+		config: config,
+		client: &http.Client{},
+	}, nil
+}
+
+// UploadFile stores a file under the configured prefix and returns its key
+// and URL
+func (s *S3Client) UploadFile(ctx context.Context, filePath, fileName string) (fileID, link string, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// The signature covers the body's hash, so the file is read twice
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	key := s.config.Prefix + fileName
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), file)
+	if err != nil {
+		return "", "", err
+	}
+	req.ContentLength = size
+	if contentType := ContentType(fileName); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.config.StorageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", s.config.StorageClass)
+	}
+	if len(s.config.Tags) > 0 {
+		req.Header.Set("X-Amz-Tagging", encodeTags(s.config.Tags))
+	}
+	if s.config.CacheControl != "" {
+		req.Header.Set("Cache-Control", s.config.CacheControl)
+	}
+	payloadHash := hex.EncodeToString(hash.Sum(nil))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	sigv4.Sign(req, payloadHash, s.config.Credentials, s.config.Region, "s3", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("S3 returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+		if s3QuotaExceeded(resp.StatusCode, string(message)) {
+			err = fmt.Errorf("%w: %w", ErrQuotaExceeded, err)
+		}
+		return "", "", fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	link = s.objectURL(key)
+	if s.config.BaseURL != "" {
+		link = strings.TrimRight(s.config.BaseURL, "/") + "/" + escapeKey(key)
+	}
+	log.Printf("File uploaded to S3: %s (key: %s)", fileName, key)
+	return key, link, nil
+}
+
+// DeleteFile removes the object with the given key
+func (s *S3Client) DeleteFile(ctx context.Context, fileID string) error {
+	if fileID == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(fileID), nil)
+	if err != nil {
+		return err
+	}
+	payloadHash := hex.EncodeToString(sha256.New().Sum(nil))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	sigv4.Sign(req, payloadHash, s.config.Credentials, s.config.Region, "s3", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	defer resp.Body.Close()
+	// S3 answers 204 whether or not the object existed; other stores may 404
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to delete file: S3 returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	log.Printf("File deleted from S3: %s", fileID)
+	return nil
+}
+
+// PresignGet returns a link that downloads the object with the given key,
+// as fileName, without credentials until ttl has passed
+func (s *S3Client) PresignGet(fileID, fileName string, ttl time.Duration) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(fileID), nil)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	req.URL.RawQuery = query.Encode()
+	return sigv4.Presign(req, s.config.Credentials, s.config.Region, "s3", time.Now(), ttl), nil
+}
+
+// Check makes sure the bucket answers with the configured credentials
+func (s *S3Client) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(""), nil)
+	if err != nil {
+		return err
+	}
+	payloadHash := hex.EncodeToString(sha256.New().Sum(nil))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	sigv4.Sign(req, payloadHash, s.config.Credentials, s.config.Region, "s3", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach bucket: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to reach bucket: S3 returned %s", resp.Status)
+	}
+	return nil
+}
+
+// objectURL addresses an object virtual-host style on AWS, and path style
+// on custom endpoints, which rarely have wildcard DNS
+func (s *S3Client) objectURL(key string) string {
+	if s.config.Endpoint != "" {
+		return strings.TrimRight(s.config.Endpoint, "/") + "/" + s.config.Bucket + "/" + escapeKey(key)
+	}
+	return "https://" + s.config.Bucket + ".s3." + s.config.Region + ".amazonaws.com/" + escapeKey(key)
+}
+
+// encodeTags writes tags as the query string x-amz-tagging expects, with
+// spaces as %20
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+// escapeKey percent-encodes an object key the way S3 signs it: everything
+// but unreserved characters and "/"
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
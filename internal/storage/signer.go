@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrLinkExpired      = errors.New("link expired")
+)
+
+// URLSigner creates and checks HMAC signatures for expiring download links
+type URLSigner struct {
+	key []byte
+}
+
+// NewURLSigner returns a signer using key. Without a key a random one is
+// generated, so links stop working when the process restarts.
+func NewURLSigner(key string) *URLSigner {
+	if key != "" {
+		return &URLSigner{key: []byte(key)}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		log.Fatalf("Failed to generate download signing key: %v", err)
+	}
+	log.Println("DOWNLOAD_SIGNING_KEY not set, download links will not survive a restart")
+	return &URLSigner{key: random}
+}
+
+// Sign returns the signature granting access to resource until expires
+func (s *URLSigner) Sign(resource string, expires time.Time) string {
+	return s.sign(resource, expires.Unix())
+}
+
+// Verify checks a signature produced by Sign for resource and the given
+// expiry (Unix seconds)
+func (s *URLSigner) Verify(resource string, expires int64, signature string, now time.Time) error {
+	if !hmac.Equal([]byte(s.sign(resource, expires)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	if now.Unix() > expires {
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+func (s *URLSigner) sign(resource string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(resource + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,205 @@
+// Package sysstat samples the machine's CPU, memory and disk so the
+// server can report them and hold back work when the node runs short.
+// It reads /proc and statfs, so readings are only available on Linux.
+package sysstat
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Sample is one reading of the machine's resources. Percentages are of the
+// total in use; DiskPercent and DiskFree describe the filesystem holding
+// the sampled directory.
+type Sample struct {
+	Time            time.Time `json:"time"`
+	CPUPercent      float64   `json:"cpu_percent"`
+	Load1           float64   `json:"load1"`
+	Load5           float64   `json:"load5"`
+	Load15          float64   `json:"load15"`
+	MemoryPercent   float64   `json:"memory_percent"`
+	MemoryAvailable uint64    `json:"memory_available_bytes"`
+	MemoryTotal     uint64    `json:"memory_total_bytes"`
+	DiskPercent     float64   `json:"disk_percent"`
+	DiskFree        uint64    `json:"disk_free_bytes"`
+	DiskTotal       uint64    `json:"disk_total_bytes"`
+}
+
+// Sampler takes samples of the machine and the filesystem holding dir.
+// CPU usage is measured between consecutive samples, so the first sample
+// reports none.
+type Sampler struct {
+	dir       string
+	mu        sync.Mutex
+	prevBusy  uint64
+	prevTotal uint64
+}
+
+// latest is the most recent sample taken by any sampler
+var latest atomic.Pointer[Sample]
+
+// NewSampler returns a sampler for the filesystem holding dir
+func NewSampler(dir string) *Sampler {
+	return &Sampler{dir: dir}
+}
+
+// Sample reads the current resource usage. A reading that fails is left
+// at zero and reported in the error; the rest of the sample is still set.
+func (s *Sampler) Sample() (Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := Sample{Time: time.Now().UTC()}
+	var errs []string
+	if err := s.readCPU(&sample); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := readLoad(&sample); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := readMemory(&sample); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := readDisk(s.dir, &sample); err != nil {
+		errs = append(errs, err.Error())
+	}
+	latest.Store(&sample)
+
+	if len(errs) > 0 {
+		return sample, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return sample, nil
+}
+
+// Latest returns the most recent sample, or nil before the first
+func Latest() *Sample {
+	return latest.Load()
+}
+
+// readCPU sets the share of CPU time spent busy since the previous sample
+func (s *Sampler) readCPU(sample *Sample) error {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return fmt.Errorf("cpu: %v", err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return fmt.Errorf("cpu: unexpected /proc/stat format")
+	}
+
+	// user nice system idle iowait irq softirq steal; idle and iowait are
+	// the time spent doing nothing
+	var busy, total uint64
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return fmt.Errorf("cpu: %v", err)
+		}
+		total += value
+		if i != 3 && i != 4 {
+			busy += value
+		}
+	}
+
+	if s.prevTotal > 0 && total > s.prevTotal {
+		sample.CPUPercent = percent(busy-s.prevBusy, total-s.prevTotal)
+	}
+	s.prevBusy, s.prevTotal = busy, total
+	return nil
+}
+
+// readLoad sets the load averages
+func readLoad(sample *Sample) error {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return fmt.Errorf("load: %v", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return fmt.Errorf("load: unexpected /proc/loadavg format")
+	}
+	loads := make([]float64, 3)
+	for i := range loads {
+		if loads[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return fmt.Errorf("load: %v", err)
+		}
+	}
+	sample.Load1, sample.Load5, sample.Load15 = loads[0], loads[1], loads[2]
+	return nil
+}
+
+// readMemory sets memory use from MemTotal and MemAvailable, the memory
+// that can be allocated without swapping
+func readMemory(sample *Sample) error {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return fmt.Errorf("memory: %v", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || (key != "MemTotal" && key != "MemAvailable") {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("memory: %s: %v", key, err)
+		}
+		values[key] = kb * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("memory: %v", err)
+	}
+
+	total, available := values["MemTotal"], values["MemAvailable"]
+	if total == 0 {
+		return fmt.Errorf("memory: MemTotal missing from /proc/meminfo")
+	}
+	sample.MemoryTotal = total
+	sample.MemoryAvailable = available
+	sample.MemoryPercent = percent(total-min(available, total), total)
+	return nil
+}
+
+// readDisk sets the usage of the filesystem holding dir. Free space is
+// what an unprivileged process may use, as df reports it.
+func readDisk(dir string, sample *Sample) error {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return fmt.Errorf("disk: %v", err)
+	}
+	size := uint64(fs.Bsize)
+	total := fs.Blocks * size
+	free := fs.Bavail * size
+	used := (fs.Blocks - fs.Bfree) * size
+
+	sample.DiskTotal = total
+	sample.DiskFree = free
+	if used+free > 0 {
+		sample.DiskPercent = percent(used, used+free)
+	}
+	return nil
+}
+
+// percent returns part as a percentage of whole, to one decimal place
+func percent(part, whole uint64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*1000) / 10
+}
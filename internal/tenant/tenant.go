@@ -0,0 +1,49 @@
+package tenant
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/transcoder"
+)
+
+// Tenant is a team sharing this instance. Its settings override the
+// global configuration for the tenant's jobs.
+type Tenant struct {
+	ID                string          `json:"id" gorm:"primaryKey"`
+	Name              string          `json:"name"`
+	DriveFolderID     string          `json:"drive_folder_id,omitempty"`
+	WebhookURL        string          `json:"webhook_url,omitempty"`
+	WebhookEvents     jobs.StringList `json:"webhook_events,omitempty" gorm:"type:text"` // events sent to WebhookURL
+	DefaultPreset     string          `json:"default_preset,omitempty"`                  // preset for jobs that name none
+	Watermark         string          `json:"watermark,omitempty"`                       // file name of the uploaded watermark
+	WatermarkPosition string          `json:"watermark_position,omitempty"`
+	Intro             string          `json:"intro,omitempty"` // file name of the uploaded intro clip
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+}
+
+var idRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidateID checks that a tenant ID is a short lowercase slug
+func ValidateID(id string) error {
+	if !idRegex.MatchString(id) {
+		return fmt.Errorf("tenant id must be 1-63 lowercase letters, digits, or '-'")
+	}
+	return nil
+}
+
+// Branding returns the tenant's watermark and intro, finding the uploaded
+// files with path
+func (t *Tenant) Branding(path func(name string) string) transcoder.Branding {
+	branding := transcoder.Branding{WatermarkPosition: t.WatermarkPosition}
+	if t.Watermark != "" {
+		branding.Watermark = path(t.Watermark)
+	}
+	if t.Intro != "" {
+		branding.Intro = path(t.Intro)
+	}
+	return branding
+}
@@ -0,0 +1,90 @@
+package transcoder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AcceptanceRules reject inputs that aren't worth transcoding, checked
+// against an input's probe before the transcode starts. Zero values and
+// empty lists don't restrict anything.
+type AcceptanceRules struct {
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	MaxWidth    int // of the longer side, so portrait videos fit the same rules
+	MaxHeight   int // of the shorter side
+	VideoCodecs []string
+	AudioCodecs []string
+	Containers  []string // ffprobe format names, e.g. "mov" or "matroska"
+}
+
+// Rejection codes, stable for programs to act on
+const (
+	RejectDurationTooShort     = "duration_too_short"
+	RejectDurationTooLong      = "duration_too_long"
+	RejectResolutionTooLarge   = "resolution_too_large"
+	RejectVideoCodecNotAllowed = "video_codec_not_allowed"
+	RejectAudioCodecNotAllowed = "audio_codec_not_allowed"
+	RejectContainerNotAllowed  = "container_not_allowed"
+)
+
+// Rejection is why an input broke the acceptance rules
+type Rejection struct {
+	Code    string // one of the Reject constants
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+// Validate checks the rules are usable
+func (r AcceptanceRules) Validate() error {
+	if r.MinDuration < 0 || r.MaxDuration < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	if r.MaxDuration > 0 && r.MinDuration > r.MaxDuration {
+		return fmt.Errorf("minimum duration is longer than the maximum")
+	}
+	if r.MaxWidth < 0 || r.MaxHeight < 0 {
+		return fmt.Errorf("maximum resolution must look like 3840x2160")
+	}
+	return nil
+}
+
+// IsZero reports whether the rules accept everything
+func (r AcceptanceRules) IsZero() bool {
+	return r.MinDuration == 0 && r.MaxDuration == 0 && r.MaxWidth == 0 && r.MaxHeight == 0 &&
+		len(r.VideoCodecs) == 0 && len(r.AudioCodecs) == 0 && len(r.Containers) == 0
+}
+
+// Check returns why the probed input breaks the rules, or nil if it
+// doesn't. Limits the probe has no value for are not checked.
+func (r AcceptanceRules) Check(info *MediaInfo) *Rejection {
+	duration := time.Duration(info.Duration * float64(time.Second))
+	if r.MinDuration > 0 && duration > 0 && duration < r.MinDuration {
+		return &Rejection{RejectDurationTooShort, fmt.Sprintf("input is %s long, shorter than the minimum of %s", duration.Round(time.Second), r.MinDuration)}
+	}
+	if r.MaxDuration > 0 && duration > r.MaxDuration {
+		return &Rejection{RejectDurationTooLong, fmt.Sprintf("input is %s long, longer than the maximum of %s", duration.Round(time.Second), r.MaxDuration)}
+	}
+	long, short := max(info.Width, info.Height), min(info.Width, info.Height)
+	if (r.MaxWidth > 0 && long > r.MaxWidth) || (r.MaxHeight > 0 && short > r.MaxHeight) {
+		return &Rejection{RejectResolutionTooLarge, fmt.Sprintf("input is %dx%d, larger than the maximum of %dx%d", info.Width, info.Height, r.MaxWidth, r.MaxHeight)}
+	}
+	if len(r.VideoCodecs) > 0 && info.VideoCodec != "" && !slices.Contains(r.VideoCodecs, info.VideoCodec) {
+		return &Rejection{RejectVideoCodecNotAllowed, fmt.Sprintf("video codec %s is not allowed (want %s)", info.VideoCodec, strings.Join(r.VideoCodecs, ", "))}
+	}
+	if len(r.AudioCodecs) > 0 && info.AudioCodec != "" && !slices.Contains(r.AudioCodecs, info.AudioCodec) {
+		return &Rejection{RejectAudioCodecNotAllowed, fmt.Sprintf("audio codec %s is not allowed (want %s)", info.AudioCodec, strings.Join(r.AudioCodecs, ", "))}
+	}
+	// ffprobe names formats by every name the demuxer answers to
+	if len(r.Containers) > 0 && info.Format != "" && !slices.ContainsFunc(strings.Split(info.Format, ","), func(name string) bool {
+		return slices.Contains(r.Containers, name)
+	}) {
+		return &Rejection{RejectContainerNotAllowed, fmt.Sprintf("container %s is not allowed (want %s)", info.Format, strings.Join(r.Containers, ", "))}
+	}
+	return nil
+}
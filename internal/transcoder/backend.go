@@ -0,0 +1,86 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Backend runs the ffmpeg and ffprobe commands everything in this package
+// builds. The default runs them on this machine; FakeBackend simulates
+// them so the whole pipeline can be exercised without ffmpeg installed.
+type Backend interface {
+	// Runner returns what runs ffmpeg for an encode within limits
+	Runner(limits Limits) Runner
+	// Probe runs ffprobe with args and returns its standard output
+	Probe(ctx context.Context, args []string) ([]byte, error)
+}
+
+var (
+	backendMu sync.RWMutex
+	backend   Backend = localBackend{}
+)
+
+// SetBackend replaces the backend; call it at startup, before any job runs
+func SetBackend(b Backend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backend = b
+}
+
+// currentBackend returns the backend in use
+func currentBackend() Backend {
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+	return backend
+}
+
+// ffprobe runs ffprobe through the backend
+func ffprobe(ctx context.Context, args ...string) ([]byte, error) {
+	return currentBackend().Probe(ctx, args)
+}
+
+// localBackend runs ffmpeg and ffprobe on this machine
+type localBackend struct{}
+
+func (localBackend) Runner(limits Limits) Runner {
+	return &localRunner{limits: limits}
+}
+
+func (localBackend) Probe(ctx context.Context, args []string) ([]byte, error) {
+	return exec.CommandContext(ctx, "ffprobe", args...).Output()
+}
+
+// tailBuffer keeps the last max bytes written to it, for the end of
+// ffmpeg's error output
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = b.buf[over:]
+	}
+	return len(p), nil
+}
+
+// String returns what was kept
+func (b *tailBuffer) String() string {
+	return string(b.buf)
+}
+
+// lastLine returns the last line written, usually ffmpeg's reason for
+// failing. Progress lines ending in \r count as lines.
+func (b *tailBuffer) lastLine() string {
+	lines := strings.FieldsFunc(string(bytes.TrimSpace(b.buf)), func(r rune) bool {
+		return r == '\n' || r == '\r'
+	})
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
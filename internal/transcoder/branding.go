@@ -0,0 +1,80 @@
+package transcoder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Branding is added to every video output of a tenant: a watermark drawn
+// over the video and an intro clip played before it. Presets that copy
+// the video or drop it are left unbranded, as branding means re-encoding.
+type Branding struct {
+	Watermark         string // path of a PNG image; "" for none
+	WatermarkPosition string // corner of the video, from WatermarkPositions; bottom-right if empty
+	Intro             string // path of a video clip; "" for none
+}
+
+// Where the watermark is drawn, inset from the edges of the video
+var watermarkPositions = map[string]string{
+	"top-left":     "main_w*0.03:main_h*0.03",
+	"top-right":    "main_w-overlay_w-main_w*0.03:main_h*0.03",
+	"bottom-left":  "main_w*0.03:main_h-overlay_h-main_h*0.03",
+	"bottom-right": "main_w-overlay_w-main_w*0.03:main_h-overlay_h-main_h*0.03",
+}
+
+// ValidateWatermarkPosition checks position names a corner; empty is allowed
+func ValidateWatermarkPosition(position string) error {
+	if _, ok := watermarkPositions[position]; !ok && position != "" {
+		names := make([]string, 0, len(watermarkPositions))
+		for name := range watermarkPositions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("watermark position must be one of %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// UseBranding adds a tenant's watermark and intro to the output
+func (f *FFmpeg) UseBranding(branding Branding) {
+	f.branding = branding
+}
+
+// watermarked reports whether the output gets a watermark
+func (f *FFmpeg) watermarked() bool {
+	return f.branding.Watermark != "" && f.preset.VideoCodec != "none" && f.preset.VideoCodec != "copy"
+}
+
+// hasIntro reports whether the intro is joined to the front of the inputs.
+// Joining re-encodes audio as well as video.
+func (f *FFmpeg) hasIntro() bool {
+	p := f.preset
+	return f.branding.Intro != "" && p.VideoCodec != "none" && p.VideoCodec != "copy" && p.AudioCodec != "copy"
+}
+
+// watermarkFilter draws input n, the watermark, over the video labelled
+// in, scaled to an eighth of the video's width, and labels the result out
+func (f *FFmpeg) watermarkFilter(in string, n int, out string) string {
+	position := watermarkPositions[f.branding.WatermarkPosition]
+	if position == "" {
+		position = watermarkPositions["bottom-right"]
+	}
+	return fmt.Sprintf("[%d:v]format=rgba[wm];[wm]%sscale2ref=w=main_w/8:h=ow/dar[wms][base];[base][wms]overlay=%s%s",
+		n, in, position, out)
+}
+
+// watermarkArgs builds the input and output options for a single input
+// with a watermark, which needs a filter graph instead of -vf
+func (f *FFmpeg) watermarkArgs() (inputs, output []string) {
+	p := f.preset
+	inputs = []string{"-i", f.inputPaths[0], "-i", f.branding.Watermark}
+	video, filters := "[0:v:0]", ""
+	if scale := p.scaleFilter(); scale != "" {
+		filters = video + scale + "[vs];"
+		video = "[vs]"
+	}
+	output = []string{"-filter_complex", filters + f.watermarkFilter(video, 1, "[vw]"), "-map", "[vw]"}
+	output = append(output, p.audioMaps()...)
+	return inputs, append(output, p.outputArgs("", p.syncFilter())...)
+}
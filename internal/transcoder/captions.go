@@ -0,0 +1,126 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Closed caption handling in presets
+const (
+	CaptionsKeep = "keep" // carry CEA-608/708 captions into re-encoded H.264/H.265 video
+	CaptionsDrop = "drop" // leave them out
+)
+
+// Formats closed captions can be extracted to
+const (
+	CaptionFormatVTT = "vtt"
+	CaptionFormatSCC = "scc"
+)
+
+// validateCaptions checks the preset's closed caption options
+func (p *Preset) validateCaptions() error {
+	switch p.ClosedCaptions {
+	case "":
+	case CaptionsKeep, CaptionsDrop:
+		if p.VideoCodec != "libx264" && p.VideoCodec != "libx265" {
+			return fmt.Errorf("closed_captions %s needs video_codec libx264 or libx265", p.ClosedCaptions)
+		}
+	default:
+		return fmt.Errorf("closed_captions must be keep or drop")
+	}
+	for _, format := range p.CaptionFormats() {
+		if format != CaptionFormatVTT && format != CaptionFormatSCC {
+			return fmt.Errorf("unsupported extract_captions format %q (want vtt or scc)", format)
+		}
+	}
+	return nil
+}
+
+// CaptionFormats returns the formats closed captions are extracted to
+func (p *Preset) CaptionFormats() []string {
+	var formats []string
+	for _, format := range strings.Split(p.ExtractCaptions, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// captionArgs returns the encoder options for the preset's closed caption
+// handling. Encoders otherwise decide for themselves.
+func (p *Preset) captionArgs() []string {
+	switch p.ClosedCaptions {
+	case CaptionsKeep:
+		return []string{"-a53cc", "1"}
+	case CaptionsDrop:
+		return []string{"-a53cc", "0"}
+	}
+	return nil
+}
+
+// HasClosedCaptions reports whether the probe found CEA-608/708 captions,
+// embedded in the video or as a caption track of their own
+func (m *MediaInfo) HasClosedCaptions() bool {
+	return m.ClosedCaptions || m.captionTrack() >= 0
+}
+
+// captionTrack returns the index of the first EIA-608 caption track, as
+// QuickTime files carry them, or -1 if there is none
+func (m *MediaInfo) captionTrack() int {
+	for _, stream := range m.Streams {
+		if stream.Type == "subtitle" && stream.Codec == "eia_608" {
+			return stream.Index
+		}
+	}
+	return -1
+}
+
+// ExtractClosedCaptions writes the input's closed captions to output in
+// format, shifted by the length of any intro joined before it
+func ExtractClosedCaptions(ctx context.Context, input string, info *MediaInfo, format, output string, intro time.Duration) error {
+	args := []string{"-y", "-v", "error"}
+	if intro > 0 {
+		args = append(args, "-itsoffset", formatSeconds(intro))
+	}
+	if track := info.captionTrack(); track >= 0 {
+		args = append(args, "-i", input, "-map", "0:"+strconv.Itoa(track))
+	} else {
+		// Captions embedded in the video come out of the decoder as a
+		// subtitle stream of their own
+		args = append(args, "-f", "lavfi", "-i", "movie="+filterPath(input)+"[out0+subcc]", "-map", "0:s")
+	}
+	switch format {
+	case CaptionFormatVTT:
+		args = append(args, "-c:s", "webvtt", "-f", "webvtt")
+	case CaptionFormatSCC:
+		args = append(args, "-c:s", "copy", "-f", "scc")
+	default:
+		return fmt.Errorf("unsupported caption format %q", format)
+	}
+	args = append(args, output)
+
+	if err := currentBackend().Runner(Limits{}).Run(ctx, args, io.Discard); err != nil {
+		return fmt.Errorf("failed to extract %s captions: %v", format, err)
+	}
+	return nil
+}
+
+// filterPath quotes a file path for use as a filter option inside a
+// filter graph, which escapes at two levels
+func filterPath(path string) string {
+	quoted := "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	var b strings.Builder
+	for _, r := range quoted {
+		switch r {
+		case '\\', '\'', '[', ']', ',', ';':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package transcoder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupPeriod is the cpu.max period, in microseconds
+const cgroupPeriod = 100000
+
+// Cgroups creates a cgroup v2 group for each encode below the server's
+// own, so the kernel holds it to its CPU quota and memory limit. An
+// encode that goes over its memory is killed instead of the node.
+type Cgroups struct {
+	dir  string
+	next atomic.Int64
+}
+
+// EnableCgroups prepares the server's cgroup to hold a group per encode.
+// The cgroup must be writable, as it is in a container with a private
+// cgroup namespace and a writable /sys/fs/cgroup. A cgroup with groups
+// below it can't hold processes itself, so the server moves into one of
+// its own.
+func EnableCgroups() (*Cgroups, error) {
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	var path string
+	for _, line := range strings.Split(string(self), "\n") {
+		if p, ok := strings.CutPrefix(line, "0::"); ok {
+			path = p
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("cgroup v2 is not in use")
+	}
+	dir := filepath.Join(cgroupRoot, path)
+
+	controllers, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("cgroup v2 is not mounted at %s", cgroupRoot)
+	} else if err != nil {
+		return nil, err
+	}
+	for _, controller := range []string{"cpu", "memory"} {
+		if !slices.Contains(strings.Fields(string(controllers)), controller) {
+			return nil, fmt.Errorf("the %s controller is not available in %s", controller, dir)
+		}
+	}
+
+	// A server restarted in the same cgroup has already moved
+	if filepath.Base(dir) == "server" {
+		dir = filepath.Dir(dir)
+	} else {
+		server := filepath.Join(dir, "server")
+		if err := os.Mkdir(server, 0755); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+		if err != nil {
+			return nil, err
+		}
+		for _, pid := range strings.Fields(string(procs)) {
+			if err := os.WriteFile(filepath.Join(server, "cgroup.procs"), []byte(pid), 0644); err != nil {
+				return nil, fmt.Errorf("failed to move process %s into %s: %w", pid, server, err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to enable controllers in %s: %w", dir, err)
+	}
+
+	// Groups left by encodes running when the server last stopped
+	if leftover, err := filepath.Glob(filepath.Join(dir, "transcode-*")); err == nil {
+		for _, group := range leftover {
+			os.Remove(group)
+		}
+	}
+	return &Cgroups{dir: dir}, nil
+}
+
+// cgroup is the group one encode runs in
+type cgroup struct {
+	dir string
+	fd  *os.File
+}
+
+// create makes a group with limits' CPUs and memory. It returns nil
+// without cgroups or without limits to enforce.
+func (c *Cgroups) create(limits Limits) (*cgroup, error) {
+	if c == nil || (limits.CPUs <= 0 && limits.MemoryMB <= 0) {
+		return nil, nil
+	}
+	dir := filepath.Join(c.dir, fmt.Sprintf("transcode-%d-%d", os.Getpid(), c.next.Add(1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	group := &cgroup{dir: dir}
+
+	var files [][2]string
+	if limits.CPUs > 0 {
+		quota := max(int(limits.CPUs*cgroupPeriod), 1000)
+		files = append(files, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, cgroupPeriod)})
+	}
+	if limits.MemoryMB > 0 {
+		files = append(files, [2]string{"memory.max", strconv.Itoa(limits.MemoryMB * 1024 * 1024)})
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(dir, file[0]), []byte(file[1]), 0644); err != nil {
+			group.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", file[0], err)
+		}
+	}
+	if limits.MemoryMB > 0 {
+		// Swapping would let the encode grow past its limit; the file is
+		// missing when swap isn't accounted for
+		os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		group.remove()
+		return nil, err
+	}
+	group.fd = fd
+	return group, nil
+}
+
+// attach starts cmd inside the group, so ffmpeg never runs outside it
+func (g *cgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(g.fd.Fd())
+}
+
+// oomKilled reports whether the kernel killed a process in the group for
+// going over its memory limit
+func (g *cgroup) oomKilled() bool {
+	events, err := os.Open(filepath.Join(g.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer events.Close()
+	scanner := bufio.NewScanner(events)
+	for scanner.Scan() {
+		if count, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			return count != "0"
+		}
+	}
+	return false
+}
+
+// remove deletes the group once its processes have exited
+func (g *cgroup) remove() {
+	if g.fd != nil {
+		g.fd.Close()
+	}
+	os.Remove(g.dir)
+}
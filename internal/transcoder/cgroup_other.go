@@ -0,0 +1,23 @@
+//go:build !linux
+
+package transcoder
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Cgroups is only supported on Linux
+type Cgroups struct{}
+
+// EnableCgroups fails outside Linux
+func EnableCgroups() (*Cgroups, error) {
+	return nil, fmt.Errorf("cgroups are only supported on Linux")
+}
+
+type cgroup struct{}
+
+func (c *Cgroups) create(limits Limits) (*cgroup, error) { return nil, nil }
+func (g *cgroup) attach(cmd *exec.Cmd)                   {}
+func (g *cgroup) oomKilled() bool                        { return false }
+func (g *cgroup) remove()                                {}
@@ -0,0 +1,173 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// streamInfo summarizes the streams of one concat input
+type streamInfo struct {
+	width, height int
+	hasVideo      bool
+	hasAudio      bool
+	audioStreams  int
+	duration      float64
+}
+
+// probeStreams reads the first video stream's size, whether audio is
+// present, and the duration of a file
+func probeStreams(ctx context.Context, path string) (*streamInfo, error) {
+	output, err := ffprobe(ctx,
+		"-v", "error",
+		"-show_entries", "stream=codec_type,width,height:format=duration",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("ffprobe output unreadable: %w", err)
+	}
+
+	info := &streamInfo{}
+	info.duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	for _, stream := range result.Streams {
+		switch stream.CodecType {
+		case "video":
+			if !info.hasVideo {
+				info.hasVideo = true
+				info.width, info.height = stream.Width, stream.Height
+			}
+		case "audio":
+			info.hasAudio = true
+			info.audioStreams++
+		}
+	}
+	return info, nil
+}
+
+// concatArgs builds the input and output options that join every input in
+// order with the concat filter. Inputs are fitted to the frame size of the
+// first input after any branding intro (letterboxed as needed), so that a
+// small intro doesn't shrink the main video, and inputs without audio
+// contribute silence, since the filter requires matching streams in every
+// segment.
+func (f *FFmpeg) concatArgs(ctx context.Context) (inputs, output []string, err error) {
+	p := f.preset
+	if p.VideoCodec == "copy" || p.AudioCodec == "copy" {
+		return nil, nil, fmt.Errorf("presets that copy streams cannot join multiple inputs")
+	}
+	if len(p.AudioTracks) > 1 {
+		return nil, nil, fmt.Errorf("presets with more than one audio track cannot join multiple inputs")
+	}
+	keepVideo, keepAudio := p.VideoCodec != "none", p.AudioCodec != "none"
+
+	infos := make([]*streamInfo, len(f.inputPaths))
+	for i, path := range f.inputPaths {
+		info, err := probeStreams(ctx, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input %d: %w", i+1, err)
+		}
+		if keepVideo && !info.hasVideo {
+			return nil, nil, fmt.Errorf("input %d has no video stream", i+1)
+		}
+		if stream := f.audioStream(i); info.hasAudio && stream >= info.audioStreams {
+			return nil, nil, fmt.Errorf("input %d has no audio stream %d", i+1, stream)
+		}
+		infos[i] = info
+	}
+
+	// Frame size of the joined video, rounded down to even numbers
+	main := 0
+	if f.hasIntro() {
+		main = 1
+	}
+	width, height := infos[main].width&^1, infos[main].height&^1
+
+	var filters, segments []string
+	for i, path := range f.inputPaths {
+		inputs = append(inputs, "-i", path)
+
+		if keepVideo {
+			filters = append(filters, fmt.Sprintf(
+				"[%d:v:0]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1[v%d]",
+				i, width, height, width, height, i))
+			segments = append(segments, fmt.Sprintf("[v%d]", i))
+		}
+		if keepAudio {
+			if infos[i].hasAudio {
+				resample := "aresample=48000"
+				if p.AudioSync {
+					resample += ":" + audioSyncOptions
+				}
+				filters = append(filters, fmt.Sprintf(
+					"[%d:a:%d]%s,aformat=channel_layouts=stereo[a%d]", i, f.audioStream(i), resample, i))
+			} else {
+				filters = append(filters, fmt.Sprintf(
+					"anullsrc=r=48000:cl=stereo,atrim=duration=%.3f[a%d]", infos[i].duration, i))
+			}
+			segments = append(segments, fmt.Sprintf("[a%d]", i))
+		}
+	}
+
+	videoOut, audioOut := "[v]", "[a]"
+	concat := fmt.Sprintf("%sconcat=n=%d:v=%d:a=%d", strings.Join(segments, ""), len(f.inputPaths), boolInt(keepVideo), boolInt(keepAudio))
+	if keepVideo {
+		concat += videoOut
+	}
+	if keepAudio {
+		concat += audioOut
+	}
+	filters = append(filters, concat)
+
+	// The preset's own scaling applies to the joined video
+	if scale := p.scaleFilter(); keepVideo && scale != "" {
+		filters = append(filters, videoOut+scale+"[vs]")
+		videoOut = "[vs]"
+	}
+	if f.watermarked() {
+		inputs = append(inputs, "-i", f.branding.Watermark)
+		filters = append(filters, f.watermarkFilter(videoOut, len(f.inputPaths), "[vw]"))
+		videoOut = "[vw]"
+	}
+
+	output = []string{"-filter_complex", strings.Join(filters, ";")}
+	if keepVideo {
+		output = append(output, "-map", videoOut)
+	}
+	if keepAudio {
+		output = append(output, "-map", audioOut)
+	}
+	return inputs, append(output, p.outputArgs("", "")...), nil
+}
+
+// audioStream returns which of input i's audio streams is joined: the
+// preset's audio track, or the first. The intro always gives its first.
+func (f *FFmpeg) audioStream(i int) int {
+	if len(f.preset.AudioTracks) == 0 || (i == 0 && f.hasIntro()) {
+		return 0
+	}
+	return f.preset.AudioTracks[0].Stream
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
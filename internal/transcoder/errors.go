@@ -0,0 +1,96 @@
+package transcoder
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Causes of failed ffmpeg runs, told apart by how ffmpeg exited and what
+// it printed. The errors runs return wrap them, so they can be found with
+// errors.Is.
+var (
+	ErrInputCorrupt     = errors.New("input is corrupt")
+	ErrUnsupportedCodec = errors.New("codec is not supported")
+	ErrDiskFull         = errors.New("no space left on device")
+	ErrOutOfMemory      = errors.New("out of memory")
+	ErrInterrupted      = errors.New("interrupted")
+)
+
+// exitCauses are the causes of exit codes, as shells report them: 128
+// plus the signal for runs killed by one
+var exitCauses = map[int]error{
+	128 + int(syscall.SIGKILL): ErrOutOfMemory, // what the kernel's OOM killer sends, as do container runtimes past a memory limit
+	128 + int(syscall.SIGINT):  ErrInterrupted,
+	128 + int(syscall.SIGTERM): ErrInterrupted, // such as a node draining
+	255:                        ErrInterrupted, // ffmpeg's own exit code after a signal it caught
+}
+
+// failureCauses lists what ffmpeg prints for each cause, or Kubernetes
+// when it evicts an encode's pod, checked in order
+var failureCauses = []struct {
+	cause    error
+	messages []string
+}{
+	{ErrDiskFull, []string{"No space left on device", "low on resource: ephemeral-storage"}},
+	{ErrOutOfMemory, []string{"Cannot allocate memory", "Out of memory", "std::bad_alloc", "low on resource: memory"}},
+	{ErrUnsupportedCodec, []string{
+		"Unknown encoder",
+		"Unknown decoder",
+		"not found for output stream",
+		"not found for input stream",
+		"Could not find tag for codec",
+		"codec not currently supported in container",
+		"Automatic encoder selection failed",
+		"Unsupported codec",
+	}},
+	{ErrInputCorrupt, []string{
+		"Invalid data found when processing input",
+		"moov atom not found",
+		"Error while decoding stream",
+		"Invalid NAL unit size",
+		"error reading header",
+	}},
+}
+
+// failure is an error of an ffmpeg run with the cause ffmpeg's output
+// pointed at
+type failure struct {
+	err   error
+	cause error
+}
+
+func (f *failure) Error() string {
+	return f.err.Error()
+}
+
+func (f *failure) Unwrap() []error {
+	return []error{f.err, f.cause}
+}
+
+// Classify wraps err, the error of an ffmpeg run, with the cause its exit
+// code, or else output, the end of what ffmpeg printed, points at, if any.
+// exitCode is 128 plus the signal for runs killed by one, or -1 if unknown.
+func Classify(err error, exitCode int, output string) error {
+	if cause, ok := exitCauses[exitCode]; ok {
+		return &failure{err: err, cause: cause}
+	}
+	for _, c := range failureCauses {
+		for _, message := range c.messages {
+			if strings.Contains(output, message) {
+				return &failure{err: err, cause: c.cause}
+			}
+		}
+	}
+	return err
+}
+
+// exitCode returns the exit code of a finished process, or 128 plus the
+// signal that killed it
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
@@ -0,0 +1,219 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FakeBackend simulates ffmpeg and ffprobe for testing. Probes describe
+// every file as MediaDuration seconds of 720p H.264 with AAC audio (or a
+// 1920x1080 image); encodes report progress for EncodeTime, then copy
+// their first input to the output unless it is "-". HLS runs write empty
+// segments instead.
+type FakeBackend struct {
+	EncodeTime    time.Duration
+	MediaDuration time.Duration
+	FailureRate   float64 // share of encodes, 0 to 1, that fail halfway through
+	CPUCores      float64 // cores an encode keeps busy, scaling its time into the CPU time reported
+}
+
+// ErrFakeFailure is the error injected into failing fake encodes
+var ErrFakeFailure = errors.New("simulated ffmpeg failure")
+
+// Validate checks the settings are usable
+func (b *FakeBackend) Validate() error {
+	if b.EncodeTime < 0 {
+		return fmt.Errorf("encode time must not be negative")
+	}
+	if b.MediaDuration <= 0 {
+		return fmt.Errorf("media duration must be positive")
+	}
+	if b.FailureRate < 0 || b.FailureRate > 1 {
+		return fmt.Errorf("failure rate must be between 0 and 1")
+	}
+	if b.CPUCores < 0 {
+		return fmt.Errorf("CPU cores must not be negative")
+	}
+	return nil
+}
+
+// Runner returns a runner of fake encodes, which ignore limits
+func (b *FakeBackend) Runner(Limits) Runner {
+	return &fakeRunner{backend: b}
+}
+
+// fakeRunner runs fake encodes, reporting the time each took, spread over
+// CPUCores, as its CPU time
+type fakeRunner struct {
+	backend *FakeBackend
+	cpu     time.Duration
+}
+
+func (r *fakeRunner) Run(ctx context.Context, args []string, stdout io.Writer) error {
+	start := time.Now()
+	err := r.backend.Run(ctx, args, stdout)
+	r.cpu = time.Duration(float64(time.Since(start)) * r.backend.CPUCores)
+	return err
+}
+
+func (r *fakeRunner) CPUTime() time.Duration {
+	return r.cpu
+}
+
+// fakeProgressInterval is how often fake encodes report progress
+const fakeProgressInterval = 250 * time.Millisecond
+
+// Run simulates an ffmpeg run, writing -progress output to stdout
+func (b *FakeBackend) Run(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("ffmpeg failed: no output")
+	}
+	input, output := argAfter(args, "-i"), args[len(args)-1]
+	fail := b.FailureRate > 0 && rand.Float64() < b.FailureRate
+
+	steps := max(int(b.EncodeTime/fakeProgressInterval), 1)
+	interval := b.EncodeTime / time.Duration(steps)
+	for step := 1; step <= steps; step++ {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("ffmpeg failed: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+		if fail && step*2 >= steps {
+			return fmt.Errorf("ffmpeg failed: %w", ErrFakeFailure)
+		}
+		encoded := b.MediaDuration * time.Duration(step) / time.Duration(steps)
+		state := "continue"
+		if step == steps {
+			state = "end"
+		}
+		fmt.Fprintf(stdout, "frame=%d\nfps=60.00\nbitrate=1000.0kbits/s\nout_time_ms=%d\nspeed=%.2fx\nprogress=%s\n",
+			int64(encoded.Seconds()*30), encoded.Microseconds(), b.speed(), state)
+	}
+
+	// Analysis runs write nothing
+	if output == "-" {
+		return nil
+	}
+	if argAfter(args, "-f") == "hls" {
+		if err := b.writeHLS(args, output); err != nil {
+			return fmt.Errorf("ffmpeg failed: %w", err)
+		}
+		return nil
+	}
+	if err := copyFile(input, output); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return nil
+}
+
+// writeHLS writes a playlist at output listing a segment for every
+// -hls_time of MediaDuration, and the segments, which hold nothing
+func (b *FakeBackend) writeHLS(args []string, output string) error {
+	segment, err := strconv.ParseFloat(argAfter(args, "-hls_time"), 64)
+	if err != nil || segment <= 0 {
+		return fmt.Errorf("bad -hls_time")
+	}
+	pattern := argAfter(args, "-hls_segment_filename")
+	var playlist strings.Builder
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n", int(math.Ceil(segment)))
+	if init := argAfter(args, "-hls_fmp4_init_filename"); init != "" {
+		if err := os.WriteFile(filepath.Join(filepath.Dir(output), init), nil, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(&playlist, "#EXT-X-MAP:URI=\"%s\"\n", init)
+	}
+	left := b.MediaDuration.Seconds()
+	for i := 0; left > 0; i++ {
+		name := fmt.Sprintf(pattern, i)
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", min(segment, left), filepath.Base(name))
+		left -= segment
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	return os.WriteFile(output, []byte(playlist.String()), 0644)
+}
+
+// speed is how much faster than real time the fake encodes appear to run
+func (b *FakeBackend) speed() float64 {
+	if b.EncodeTime <= 0 {
+		return 100
+	}
+	return b.MediaDuration.Seconds() / b.EncodeTime.Seconds()
+}
+
+// Probe answers the ffprobe queries this package makes: a bare duration
+// for csv output, otherwise JSON
+func (b *FakeBackend) Probe(ctx context.Context, args []string) ([]byte, error) {
+	path := argAfter(args, "-i")
+	if path == "" && len(args) > 0 {
+		path = args[len(args)-1]
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: no such file", path)
+	}
+	duration := strconv.FormatFloat(b.MediaDuration.Seconds(), 'f', 3, 64)
+	if argAfter(args, "-of") == "csv=p=0" {
+		return []byte(duration + "\n"), nil
+	}
+
+	type stream map[string]interface{}
+	format := map[string]interface{}{
+		"format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+		"duration":    duration,
+		"size":        strconv.FormatInt(stat.Size(), 10),
+		"bit_rate":    "1128000",
+	}
+	streams := []stream{
+		{"index": 0, "codec_type": "video", "codec_name": "h264", "profile": "High", "width": 1280, "height": 720,
+			"avg_frame_rate": "30/1", "r_frame_rate": "30/1", "pix_fmt": "yuv420p", "bit_rate": "1000000", "duration": duration},
+		{"index": 1, "codec_type": "audio", "codec_name": "aac", "profile": "LC", "sample_rate": "48000",
+			"channels": 2, "channel_layout": "stereo", "bit_rate": "128000", "duration": duration},
+	}
+	if container, _ := sniffFile(path); IsImage(container) {
+		format = map[string]interface{}{"format_name": container + "_pipe", "size": strconv.FormatInt(stat.Size(), 10)}
+		streams = []stream{{"index": 0, "codec_type": "video", "codec_name": container, "width": 1920, "height": 1080}}
+	}
+	return json.Marshal(map[string]interface{}{"format": format, "streams": streams})
+}
+
+// argAfter returns the argument following flag, or "" if there is none
+func argAfter(args []string, flag string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// copyFile copies the file at src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os/exec"
 	"regexp"
@@ -14,16 +15,49 @@ import (
 
 type ProgressCallback func(progress int)
 
+// StatsCallback receives the encode's speed figures as ffmpeg reports them
+type StatsCallback func(stats EncodeStats)
+
+// Runner runs ffmpeg with args, copying its standard output, where the
+// progress is written, to stdout. By default ffmpeg runs locally; a Runner
+// can run it elsewhere, such as in a Kubernetes pod.
+type Runner interface {
+	Run(ctx context.Context, args []string, stdout io.Writer) error
+}
+
+// CPUTimer is implemented by runners that measure the CPU time, user and
+// system, their last run took
+type CPUTimer interface {
+	CPUTime() time.Duration
+}
+
 type FFmpeg struct {
-	inputPath  string
+	inputPaths []string
 	outputPath string
+	preset     *Preset
+	limits     Limits
+	runner     Runner
 	onProgress ProgressCallback
+	onStats    StatsCallback
+	metadata   *Metadata
+	cover      string
+	branding   Branding
+	duration   time.Duration
+	intro      time.Duration // length of the branding intro, if it is joined
+	stats      *EncodeStats
+	cpu        time.Duration // measured by the runner, if it can
 }
 
 func New(inputPath, outputPath string) *FFmpeg {
+	return NewConcat([]string{inputPath}, outputPath)
+}
+
+// NewConcat transcodes several inputs joined end to end into one output
+func NewConcat(inputPaths []string, outputPath string) *FFmpeg {
 	return &FFmpeg{
-		inputPath:  inputPath,
+		inputPaths: inputPaths,
 		outputPath: outputPath,
+		preset:     DefaultPreset(),
 	}
 }
 
@@ -31,64 +65,162 @@ func (f *FFmpeg) OnProgress(callback ProgressCallback) {
 	f.onProgress = callback
 }
 
-// Transcode converts the input video to H.264/AAC MP4
+// OnStats registers a callback for the encode's live speed figures,
+// called about twice a second
+func (f *FFmpeg) OnStats(callback StatsCallback) {
+	f.onStats = callback
+}
+
+// UsePreset sets the encoding settings (DefaultPreset if never called)
+func (f *FFmpeg) UsePreset(preset *Preset) {
+	f.preset = preset
+}
+
+// UseMetadata writes tags and chapters into the output and, for audio-only
+// presets, embeds the image at cover (if not empty) as its cover art
+func (f *FFmpeg) UseMetadata(metadata *Metadata, cover string) {
+	f.metadata = metadata
+	f.cover = cover
+}
+
+// UseLimits restricts the resources the encode may use
+func (f *FFmpeg) UseLimits(limits Limits) {
+	f.limits = limits
+}
+
+// UseRunner runs ffmpeg with r instead of locally. Limits other than
+// Threads are then up to the runner.
+func (f *FFmpeg) UseRunner(r Runner) {
+	f.runner = r
+}
+
+// Transcode converts the input to the preset's format using its settings
 func (f *FFmpeg) Transcode(ctx context.Context) error {
-	// First, get the duration of the input file
-	duration, err := f.getDuration(ctx)
-	if err != nil {
-		log.Printf("Warning: could not get duration: %v", err)
-		duration = 0
+	// The intro is joined like any other input
+	if f.hasIntro() {
+		f.inputPaths = append([]string{f.branding.Intro}, f.inputPaths...)
 	}
 
+	// First, get the duration of the input
+	var duration int64
+	for i, inputPath := range f.inputPaths {
+		d, err := getDuration(ctx, inputPath)
+		if err != nil {
+			log.Printf("Warning: could not get duration: %v", err)
+			duration = 0
+			break
+		}
+		if i == 0 && f.hasIntro() {
+			f.intro = time.Duration(d) * time.Millisecond
+		}
+		duration += d
+	}
+	f.duration = time.Duration(duration) * time.Millisecond
+
 	// Build FFmpeg command
-	args := []string{
-		"-i", f.inputPath,
-		"-c:v", "libx264",
-		"-preset", "medium",
-		"-crf", "23",
-		"-c:a", "aac",
-		"-b:a", "128k",
-		"-movflags", "+faststart",
+	var inputs, output []string
+	if len(f.inputPaths) > 1 {
+		var err error
+		inputs, output, err = f.concatArgs(ctx)
+		if err != nil {
+			return err
+		}
+	} else if f.watermarked() {
+		inputs, output = f.watermarkArgs()
+	} else {
+		inputs = []string{"-i", f.inputPaths[0]}
+		output = f.preset.args()
+		if len(f.preset.AudioTracks) > 0 {
+			maps := append([]string{"-map", "0:v:0?"}, f.preset.audioMaps()...)
+			output = append(maps, output...)
+		}
+	}
+	output = append(output, f.preset.trackArgs()...)
+	if !f.metadata.IsEmpty() || f.cover != "" {
+		if f.metadata == nil {
+			f.metadata = &Metadata{}
+		}
+		first := len(f.inputPaths)
+		if f.watermarked() {
+			first++
+		}
+		extra, withMetadata, cleanup, err := f.metadataArgs(first, output)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		inputs, output = append(inputs, extra...), withMetadata
+	}
+	args := append(inputs, output...)
+	if f.limits.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(f.limits.Threads))
+	}
+	args = append(args, f.preset.muxerArgs()...)
+	args = append(args,
 		"-progress", "pipe:1",
 		"-y",
 		f.outputPath,
-	}
-
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	)
 
-	// Capture stdout for progress
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+	runner := f.runner
+	if runner == nil {
+		runner = currentBackend().Runner(f.limits)
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start ffmpeg: %w", err)
-	}
+	// Run ffmpeg, capturing stdout for progress
+	stdout, progressOut := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runner.Run(ctx, args, progressOut)
+		progressOut.Close()
+		done <- err
+	}()
 
-	// Parse progress from stdout
+	// Parse progress from stdout. ffmpeg writes blocks of key=value lines,
+	// each ending with a progress= line.
+	start := time.Now()
+	var (
+		stats   EncodeStats
+		encoded time.Duration
+	)
 	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "out_time_ms=") {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "out_time_ms":
+			// Despite the name, microseconds
+			timeMs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			encoded = time.Duration(timeMs) * time.Microsecond
 			if f.onProgress != nil && duration > 0 {
-				timeStr := strings.TrimPrefix(line, "out_time_ms=")
-				if timeMs, err := strconv.ParseInt(timeStr, 10, 64); err == nil {
-					progress := int((float64(timeMs) / float64(duration*1000)) * 100)
-					if progress > 100 {
-						progress = 100
-					}
-					f.onProgress(progress)
+				progress := int((float64(timeMs) / float64(duration*1000)) * 100)
+				if progress > 100 {
+					progress = 100
 				}
+				f.onProgress(progress)
 			}
+		case "progress":
+			if f.onStats != nil {
+				stats.ETA = stats.eta(encoded, f.duration)
+				f.onStats(stats)
+			}
+		default:
+			stats.parse(key, value)
 		}
 	}
 
-	// Wait for completion
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("ffmpeg failed: %w", err)
+	// Wait for completion, reading anything left should parsing stop early
+	io.Copy(io.Discard, stdout)
+	err := <-done
+	if timer, ok := runner.(CPUTimer); ok {
+		f.cpu = timer.CPUTime()
 	}
+	if err != nil {
+		return err
+	}
+	f.stats = stats.averages(encoded, time.Since(start))
 
 	if f.onProgress != nil {
 		f.onProgress(100)
@@ -97,17 +229,86 @@ func (f *FFmpeg) Transcode(ctx context.Context) error {
 	return nil
 }
 
-// getDuration returns the duration of the input file in milliseconds
-func (f *FFmpeg) getDuration(ctx context.Context) (int64, error) {
+// CPUTime returns the CPU time Transcode's ffmpeg run took, failed or not,
+// or zero if the runner can't measure it
+func (f *FFmpeg) CPUTime() time.Duration {
+	return f.cpu
+}
+
+// Stats returns the averages over a finished encode, or nil if Transcode
+// did not succeed
+func (f *FFmpeg) Stats() *EncodeStats {
+	return f.stats
+}
+
+// Duration returns the input duration probed by Transcode (zero if unknown)
+func (f *FFmpeg) Duration() time.Duration {
+	return f.duration
+}
+
+// IntroDuration returns the length of the branding intro Transcode joined
+// before the inputs, or zero if there was none
+func (f *FFmpeg) IntroDuration() time.Duration {
+	return f.intro
+}
+
+// localRunner runs ffmpeg on this machine within limits
+type localRunner struct {
+	limits Limits
+	cpu    time.Duration
+}
+
+func (r *localRunner) CPUTime() time.Duration {
+	return r.cpu
+}
+
+func (r *localRunner) Run(ctx context.Context, args []string, stdout io.Writer) error {
+	cmd := r.limits.command(ctx, args)
+	cmd.Stdout = stdout
+	stderr := &tailBuffer{max: 4096}
+	cmd.Stderr = stderr
+	group, err := r.limits.Cgroups.create(r.limits)
+	if err != nil {
+		return fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	if group != nil {
+		group.attach(cmd)
+		defer group.remove()
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	err = cmd.Wait()
+	if cmd.ProcessState != nil {
+		r.cpu = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	}
+	if err != nil {
+		if group != nil && group.oomKilled() {
+			return &failure{err: fmt.Errorf("ffmpeg exceeded its memory limit of %d MB", r.limits.MemoryMB), cause: ErrOutOfMemory}
+		}
+		// Runs stopped by ctx were killed by us, not the kernel
+		code := -1
+		if cmd.ProcessState != nil && ctx.Err() == nil {
+			code = exitCode(cmd.ProcessState)
+		}
+		if reason := stderr.lastLine(); reason != "" {
+			return Classify(fmt.Errorf("ffmpeg failed: %w: %s", err, reason), code, stderr.String())
+		}
+		return Classify(fmt.Errorf("ffmpeg failed: %w", err), code, "")
+	}
+	return nil
+}
+
+// getDuration returns the duration of a media file in milliseconds
+func getDuration(ctx context.Context, inputPath string) (int64, error) {
 	args := []string{
-		"-i", f.inputPath,
+		"-i", inputPath,
 		"-show_entries", "format=duration",
 		"-v", "quiet",
 		"-of", "csv=p=0",
 	}
 
-	cmd := exec.CommandContext(ctx, "ffprobe", args...)
-	output, err := cmd.Output()
+	output, err := ffprobe(ctx, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -140,8 +341,7 @@ func GetVideoInfo(ctx context.Context, inputPath string) (*VideoInfo, error) {
 		"-of", "json",
 	}
 
-	cmd := exec.CommandContext(ctx, "ffprobe", args...)
-	output, err := cmd.Output()
+	output, err := ffprobe(ctx, args...)
 	if err != nil {
 		return nil, fmt.Errorf("ffprobe failed: %w", err)
 	}
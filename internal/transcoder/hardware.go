@@ -0,0 +1,142 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// How a job's video is encoded, set on presets and jobs. Jobs override
+// their preset; both default to EncodingAuto.
+const (
+	EncodingAuto     = "auto"     // the hardware encoder when there is one for the codec, else software
+	EncodingSoftware = "software" // always software, such as for quality-critical content
+	EncodingHardware = "hardware" // always hardware; the job fails when it can't be
+)
+
+// ValidateEncoding checks an encoding choice; empty means EncodingAuto
+func ValidateEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingAuto, EncodingSoftware, EncodingHardware:
+		return nil
+	}
+	return fmt.Errorf("encoding must be auto, software or hardware")
+}
+
+// Hardware encoders: NVIDIA's NVENC and Intel's Quick Sync Video
+const (
+	HardwareNVENC = "nvenc"
+	HardwareQSV   = "qsv"
+)
+
+// hardwareCodecs maps the software codecs of presets to the hardware
+// encoders standing in for them
+var hardwareCodecs = map[string]map[string]string{
+	HardwareNVENC: {"libx264": "h264_nvenc", "libx265": "hevc_nvenc", "libsvtav1": "av1_nvenc"},
+	HardwareQSV:   {"libx264": "h264_qsv", "libx265": "hevc_qsv", "libvpx-vp9": "vp9_qsv", "libsvtav1": "av1_qsv"},
+}
+
+// nvencPresets maps the x264 speed names to NVENC's, p1 fastest to p7
+var nvencPresets = map[string]string{
+	"ultrafast": "p1", "superfast": "p1", "veryfast": "p2", "faster": "p3", "fast": "p3",
+	"medium": "p4", "slow": "p5", "slower": "p6", "veryslow": "p7",
+}
+
+// qsvPresets maps the x264 speed names to Quick Sync's, which stop at
+// veryfast
+var qsvPresets = map[string]string{
+	"ultrafast": "veryfast", "superfast": "veryfast", "veryfast": "veryfast", "faster": "faster", "fast": "fast",
+	"medium": "medium", "slow": "slow", "slower": "slower", "veryslow": "veryslow",
+}
+
+// ValidateHardware checks the name of a hardware encoder
+func ValidateHardware(hardware string) error {
+	if _, ok := hardwareCodecs[hardware]; !ok {
+		return fmt.Errorf("unsupported hardware encoder %q (want nvenc or qsv)", hardware)
+	}
+	return nil
+}
+
+// hardwareCapable reports whether some hardware encoder can stand in for
+// the preset's video codec. Presets that keep or drop closed captions
+// need the software encoders, which handle them.
+func (p *Preset) hardwareCapable() bool {
+	if p.ClosedCaptions != "" {
+		return false
+	}
+	for _, codecs := range hardwareCodecs {
+		if _, ok := codecs[p.VideoCodec]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithHardware returns a copy of the preset encoding its video with the
+// named hardware encoder, or an error when that has no stand-in for the
+// preset's codec
+func (p *Preset) WithHardware(hardware string) (*Preset, error) {
+	codec, ok := hardwareCodecs[hardware][p.VideoCodec]
+	if !ok || p.ClosedCaptions != "" {
+		return nil, fmt.Errorf("%s can't encode preset %s's %s video", hardware, p.Name, p.VideoCodec)
+	}
+	copied := *p
+	copied.VideoCodec = codec
+	return &copied, nil
+}
+
+// hardwareArgs returns the speed and quality options of a hardware
+// encoder, which take their own in place of -preset and -crf, and
+// whether the preset's codec is one
+func (p *Preset) hardwareArgs() ([]string, bool) {
+	var hardware string
+	for name, codecs := range hardwareCodecs {
+		for _, codec := range codecs {
+			if codec == p.VideoCodec {
+				hardware = name
+			}
+		}
+	}
+	if hardware == "" {
+		return nil, false
+	}
+
+	var args []string
+	if p.EncoderPreset != "" {
+		preset := nvencPresets[p.EncoderPreset]
+		if hardware == HardwareQSV {
+			preset = qsvPresets[p.EncoderPreset]
+		}
+		args = append(args, "-preset", preset)
+	}
+	switch {
+	case p.VideoBitrate != "":
+		args = append(args, "-b:v", p.VideoBitrate)
+	case p.CRF != nil && hardware == HardwareNVENC:
+		args = append(args, "-rc", "vbr", "-cq", strconv.Itoa(*p.CRF), "-b:v", "0")
+	case p.CRF != nil:
+		args = append(args, "-global_quality", strconv.Itoa(*p.CRF))
+	}
+	return args, true
+}
+
+// CheckHardware encodes a few frames of a test pattern with the hardware
+// encoder's H.264 encoder, to tell whether the device and drivers are
+// there; ffmpeg lists the encoders it was built with either way
+func CheckHardware(ctx context.Context, hardware string) error {
+	if err := ValidateHardware(hardware); err != nil {
+		return err
+	}
+	args := []string{
+		"-v", "error",
+		"-f", "lavfi",
+		"-i", "testsrc2=size=256x256:rate=25:duration=0.2",
+		"-c:v", hardwareCodecs[hardware]["libx264"],
+		"-f", "null", "-",
+	}
+	if err := currentBackend().Runner(Limits{}).Run(ctx, args, io.Discard); err != nil {
+		return fmt.Errorf("%s is not available: %v", hardware, err)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package transcoder
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How a job's output is delivered, chosen per job
+const (
+	PackagingFile    = "file"     // a single file, such as an MP4
+	PackagingHLS     = "hls"      // an HLS playlist of MPEG-TS segments
+	PackagingHLSFMP4 = "hls_fmp4" // an HLS playlist of fragmented MP4 segments
+)
+
+// ValidatePackaging checks a packaging choice; empty means PackagingFile
+func ValidatePackaging(packaging string) error {
+	switch packaging {
+	case "", PackagingFile, PackagingHLS, PackagingHLSFMP4:
+		return nil
+	}
+	return fmt.Errorf("packaging must be file, hls or hls_fmp4")
+}
+
+// IsHLS reports whether packaging delivers an HLS playlist
+func IsHLS(packaging string) bool {
+	return packaging == PackagingHLS || packaging == PackagingHLSFMP4
+}
+
+// CheckHLS reports whether the preset's output can be packaged for HLS,
+// which copies its streams into the segments rather than encoding them
+// again. MPEG-TS segments carry H.264, HEVC, AAC and MP3; fragmented MP4
+// ones VP9, AV1 and Opus as well. The mezzanine codecs and PCM audio fit
+// neither.
+func (p *Preset) CheckHLS(fmp4 bool) error {
+	switch p.VideoCodec {
+	case "libx264", "libx265", "copy", "none":
+	case "libvpx-vp9", "libsvtav1":
+		if !fmp4 {
+			return fmt.Errorf("preset %s's %s video needs packaging hls_fmp4", p.Name, p.VideoCodec)
+		}
+	default:
+		return fmt.Errorf("preset %s's %s video can't be packaged for HLS", p.Name, p.VideoCodec)
+	}
+	switch p.AudioCodec {
+	case "aac", "libmp3lame", "copy", "none":
+	case "libopus":
+		if !fmp4 {
+			return fmt.Errorf("preset %s's %s audio needs packaging hls_fmp4", p.Name, p.AudioCodec)
+		}
+	default:
+		return fmt.Errorf("preset %s's %s audio can't be packaged for HLS", p.Name, p.AudioCodec)
+	}
+	return nil
+}
+
+// hlsPlaylist is the name ffmpeg writes the playlist as, beside the
+// segments, before PackageHLS moves it out
+const hlsPlaylist = "index.m3u8"
+
+// playlistURI matches the URI attribute of tags such as EXT-X-MAP
+var playlistURI = regexp.MustCompile(`URI="([^"]*)"`)
+
+// PackageHLS splits the file at input, without encoding it again, into
+// segments of about segmentDuration in dir, and writes the playlist
+// listing them, by name, at playlist. fmp4 picks fragmented MP4 segments,
+// which share an init segment, over MPEG-TS. It returns the number of
+// segments.
+func PackageHLS(ctx context.Context, input, playlist, dir string, segmentDuration time.Duration, fmp4 bool) (int, error) {
+	// Segments of an earlier attempt may differ in number
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, fmt.Errorf("failed to package HLS: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to package HLS: %w", err)
+	}
+	segmentType, ext := "mpegts", ".ts"
+	if fmp4 {
+		segmentType, ext = "fmp4", ".m4s"
+	}
+	args := []string{
+		"-y", "-v", "error",
+		"-i", input,
+		"-map", "0:v?", "-map", "0:a?",
+		"-c", "copy",
+		"-f", "hls",
+		"-hls_time", formatSeconds(segmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", segmentType,
+		"-hls_segment_filename", filepath.Join(dir, "%05d"+ext),
+	}
+	if fmp4 {
+		args = append(args, "-hls_fmp4_init_filename", "init.mp4")
+	}
+	written := filepath.Join(dir, hlsPlaylist)
+	args = append(args, written)
+	if err := currentBackend().Runner(Limits{}).Run(ctx, args, io.Discard); err != nil {
+		return 0, fmt.Errorf("failed to package HLS: %w", err)
+	}
+	defer os.Remove(written)
+
+	return RewritePlaylist(written, playlist, func(name string) string { return name })
+}
+
+// RewritePlaylist copies the HLS playlist at src to dst, pointing each
+// segment, and the init segment, at uri of its file name. It returns the
+// number of segments.
+func RewritePlaylist(src, dst string, uri func(name string) string) (int, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	var (
+		b     strings.Builder
+		count int
+	)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			line = playlistURI.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + uri(path.Base(playlistURI.FindStringSubmatch(attr)[1])) + `"`
+			})
+		default:
+			line = uri(path.Base(line))
+			count++
+		}
+		b.WriteString(line + "\n")
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("failed to package HLS: the playlist lists no segments")
+	}
+	if err := os.WriteFile(dst, []byte(b.String()), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return count, nil
+}
+
+// PeakBandwidth returns the highest bit rate of the segments the playlist
+// lists, found by name in dir, which master playlists give as BANDWIDTH
+func PeakBandwidth(playlist, dir string) (int64, error) {
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	var (
+		peak     int64
+		duration float64
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			seconds, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(seconds, 64)
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			info, err := os.Stat(filepath.Join(dir, path.Base(line)))
+			if err != nil {
+				return 0, fmt.Errorf("failed to measure segment: %w", err)
+			}
+			if duration > 0 {
+				peak = max(peak, int64(float64(info.Size()*8)/duration))
+			}
+		}
+	}
+	return peak, nil
+}
+
+// StreamCodecs returns the RFC 6381 codecs of the first video and audio
+// streams of a probed file, as master playlists give them in CODECS, or
+// "" if either has no name there, since players take a partial list as
+// the whole
+func StreamCodecs(info *MediaInfo) string {
+	var codecs []string
+	seen := map[string]bool{}
+	for _, stream := range info.Streams {
+		if (stream.Type != "video" && stream.Type != "audio") || seen[stream.Type] {
+			continue
+		}
+		seen[stream.Type] = true
+		codec := streamCodec(stream)
+		if codec == "" {
+			return ""
+		}
+		codecs = append(codecs, codec)
+	}
+	return strings.Join(codecs, ",")
+}
+
+// streamCodec names a stream's codec as RFC 6381 does, with the profile
+// and level for H.264 and HEVC, or returns "" for those it doesn't know
+func streamCodec(stream MediaStream) string {
+	switch stream.Codec {
+	case "h264":
+		if stream.Level <= 0 {
+			return ""
+		}
+		profile := map[string]string{
+			"Constrained Baseline": "42E0", "Baseline": "4200", "Main": "4D40", "High": "6400",
+		}[stream.Profile]
+		if profile == "" {
+			return ""
+		}
+		return fmt.Sprintf("avc1.%s%02X", profile, stream.Level)
+	case "hevc":
+		if stream.Level <= 0 {
+			return ""
+		}
+		switch stream.Profile {
+		case "Main":
+			return fmt.Sprintf("hvc1.1.6.L%d.B0", stream.Level)
+		case "Main 10":
+			return fmt.Sprintf("hvc1.2.4.L%d.B0", stream.Level)
+		}
+		return ""
+	case "aac":
+		switch stream.Profile {
+		case "LC", "":
+			return "mp4a.40.2"
+		case "HE-AAC":
+			return "mp4a.40.5"
+		case "HE-AACv2":
+			return "mp4a.40.29"
+		}
+		return ""
+	case "mp3":
+		return "mp4a.40.34"
+	case "ac3":
+		return "ac-3"
+	case "eac3":
+		return "ec-3"
+	case "opus":
+		return "Opus"
+	}
+	return ""
+}
+
+// MasterStream is a stream listed in a master playlist
+type MasterStream struct {
+	URI       string // of the stream's playlist
+	Bandwidth int64  // peak bits per second
+	Width     int    // zero if unknown, as for audio
+	Height    int
+	Codecs    string // RFC 6381 codecs, empty if unknown
+}
+
+// WriteMasterPlaylist writes an HLS master playlist at path listing the
+// streams of an adaptive ladder, highest bandwidth first, as players take
+// the first as where to start
+func WriteMasterPlaylist(path string, streams []MasterStream) error {
+	streams = slices.Clone(streams)
+	slices.SortStableFunc(streams, func(a, b MasterStream) int {
+		return cmp.Compare(b.Bandwidth, a.Bandwidth)
+	})
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, stream := range streams {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", stream.Bandwidth)
+		if stream.Width > 0 && stream.Height > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", stream.Width, stream.Height)
+		}
+		if stream.Codecs != "" {
+			fmt.Fprintf(&b, ",CODECS=%q", stream.Codecs)
+		}
+		b.WriteString("\n" + stream.URI + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write master playlist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Image output formats, by file extension
+var imageFormats = map[string]bool{"webp": true, "jpeg": true, "png": true}
+
+// ImageSettings describe the web variants made from an uploaded image
+type ImageSettings struct {
+	Widths        []int  // widths of the variants; none is made wider than the image
+	ThumbnailSize int    // thumbnails fit within this square; 0 makes none
+	Format        string // webp, jpeg or png
+	Quality       int    // 1-100, for webp and jpeg
+}
+
+// Validate checks the settings are usable
+func (s ImageSettings) Validate() error {
+	if len(s.Widths) == 0 {
+		return fmt.Errorf("at least one width is needed")
+	}
+	for _, width := range s.Widths {
+		if width < 16 || width > maxProbeDimension {
+			return fmt.Errorf("widths must be between 16 and %d", maxProbeDimension)
+		}
+	}
+	if s.ThumbnailSize < 0 || s.ThumbnailSize > maxProbeDimension {
+		return fmt.Errorf("thumbnail size must be between 0 and %d", maxProbeDimension)
+	}
+	if !imageFormats[s.Format] {
+		return fmt.Errorf("unsupported format %q (want webp, jpeg or png)", s.Format)
+	}
+	if s.Quality < 1 || s.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+	return nil
+}
+
+// Ext returns the file extension of the variants, with the dot
+func (s ImageSettings) Ext() string {
+	if s.Format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + s.Format
+}
+
+// ImageFormatForExt returns the format of variants with the extension
+// Ext returned
+func ImageFormatForExt(ext string) string {
+	if ext == ".jpg" {
+		return "jpeg"
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+// ImageVariant is one resized copy of an image
+type ImageVariant struct {
+	Name   string // the width, or "thumbnail"
+	Path   string
+	Width  int
+	Height int
+}
+
+// ResizeImage makes the variants of the image at input, writing each to
+// pathFor(name). Variants are made largest first; widths larger than the
+// image are left out, but the image is always made at least once, at its
+// own width if every width is larger. HEIC images are converted with
+// heif-convert first, as ffmpeg can't decode them.
+func ResizeImage(ctx context.Context, input string, settings ImageSettings, pathFor func(name string) string, onProgress func(percent int)) ([]ImageVariant, error) {
+	source := input
+	if container, err := sniffFile(input); err == nil && container == "heic" {
+		converted := strings.TrimSuffix(input, filepath.Ext(input)) + ".converted.png"
+		defer os.Remove(converted)
+		if output, err := exec.CommandContext(ctx, "heif-convert", input, converted).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to convert HEIC image: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+		source = converted
+	}
+
+	info, err := Probe(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if info.Width == 0 || info.Height == 0 {
+		return nil, fmt.Errorf("image dimensions are unknown")
+	}
+
+	type variant struct {
+		name   string
+		filter string
+		width  int
+	}
+	var planned []variant
+	for _, width := range sortedWidths(settings.Widths) {
+		if width > info.Width {
+			continue
+		}
+		planned = append(planned, variant{strconv.Itoa(width), fmt.Sprintf("scale=%d:-2", width), width})
+	}
+	if len(planned) == 0 {
+		planned = append(planned, variant{strconv.Itoa(info.Width), "scale=trunc(iw/2)*2:trunc(ih/2)*2", info.Width})
+	}
+	if size := settings.ThumbnailSize; size > 0 {
+		filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", size, size)
+		planned = append(planned, variant{"thumbnail", filter, min(size, info.Width)})
+	}
+
+	variants := make([]ImageVariant, 0, len(planned))
+	for i, v := range planned {
+		path := pathFor(v.name)
+		args := []string{"-y", "-v", "error", "-i", source, "-vf", v.filter, "-frames:v", "1", "-map_metadata", "-1"}
+		args = append(args, settings.codecArgs()...)
+		if err := currentBackend().Runner(Limits{}).Run(ctx, append(args, path), io.Discard); err != nil {
+			return variants, fmt.Errorf("failed to make %s variant: %v", v.name, err)
+		}
+		made := ImageVariant{Name: v.name, Path: path, Width: v.width}
+		if probed, err := Probe(ctx, path); err == nil {
+			made.Width, made.Height = probed.Width, probed.Height
+		}
+		variants = append(variants, made)
+		if onProgress != nil {
+			onProgress((i + 1) * 100 / len(planned))
+		}
+	}
+	return variants, nil
+}
+
+// codecArgs returns the ffmpeg options for the output format. Quality maps
+// onto the encoders' own scales.
+func (s ImageSettings) codecArgs() []string {
+	switch s.Format {
+	case "webp":
+		return []string{"-c:v", "libwebp", "-quality", strconv.Itoa(s.Quality)}
+	case "jpeg":
+		// mjpeg's qscale runs from 2 (best) to 31
+		return []string{"-c:v", "mjpeg", "-q:v", strconv.Itoa(2 + (100-s.Quality)*29/100), "-pix_fmt", "yuvj420p"}
+	default:
+		return []string{"-c:v", "png", "-compression_level", "9"}
+	}
+}
+
+// sortedWidths returns widths largest first, without duplicates
+func sortedWidths(widths []int) []int {
+	seen := make(map[int]bool)
+	var sorted []int
+	for _, width := range widths {
+		if !seen[width] {
+			seen[width] = true
+			sorted = append(sorted, width)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	return sorted
+}
+
+// sniffFile identifies the container of the file at path
+func sniffFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	header := make([]byte, SniffHeaderSize)
+	n, _ := file.Read(header)
+	return SniffContainer(header[:n])
+}
@@ -0,0 +1,164 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ErrNotMedia is wrapped by the errors returned for uploads that are not
+// usable audio or video
+var ErrNotMedia = errors.New("not a supported media file")
+
+// SniffHeaderSize is how many leading bytes SniffContainer needs
+const SniffHeaderSize = 512
+
+// maxProbeDimension rejects streams no encoder here could handle, which
+// are usually crafted to exhaust memory during decoding
+const maxProbeDimension = 16384
+
+type signature struct {
+	offset int
+	magic  []byte
+	name   string
+}
+
+// mediaSignatures identify the containers ffmpeg is expected to ingest
+var mediaSignatures = []signature{
+	{4, []byte("ftyp"), "mp4"}, // MP4, MOV, M4A, 3GP
+	{4, []byte("moov"), "mov"},
+	{4, []byte("mdat"), "mov"},
+	{4, []byte("wide"), "mov"},
+	{4, []byte("free"), "mov"},
+	{0, []byte{0x1A, 0x45, 0xDF, 0xA3}, "matroska"}, // MKV, WebM
+	{0, []byte("FLV"), "flv"},
+	{0, []byte("OggS"), "ogg"},
+	{0, []byte("fLaC"), "flac"},
+	{0, []byte("ID3"), "mp3"},
+	{0, []byte{0x00, 0x00, 0x01, 0xBA}, "mpeg-ps"},
+	{0, []byte{0x00, 0x00, 0x01, 0xB3}, "mpeg-video"},
+	{0, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}, "asf"}, // WMV, WMA
+	{0, []byte(".RMF"), "realmedia"},
+}
+
+// imageSignatures identify the still image formats accepted for image
+// jobs. They are checked before mediaSignatures, as HEIC shares MP4's box
+// structure.
+var imageSignatures = []signature{
+	{0, []byte("\x89PNG\r\n\x1a\n"), "png"},
+	{0, []byte{0xFF, 0xD8, 0xFF}, "jpeg"},
+	{4, []byte("ftypheic"), "heic"},
+	{4, []byte("ftypheix"), "heic"},
+	{4, []byte("ftypheim"), "heic"},
+	{4, []byte("ftypheis"), "heic"},
+	{4, []byte("ftypmif1"), "heic"},
+	{4, []byte("ftypmsf1"), "heic"},
+}
+
+// IsImage reports whether a container SniffContainer returned is a still
+// image format
+func IsImage(container string) bool {
+	for _, sig := range imageSignatures {
+		if sig.name == container {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectedSignatures are common non-media files, named in the error
+var rejectedSignatures = []signature{
+	{0, []byte("PK\x03\x04"), "a zip archive"},
+	{0, []byte{0x1F, 0x8B}, "a gzip archive"},
+	{0, []byte("BZh"), "a bzip2 archive"},
+	{0, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}, "an xz archive"},
+	{0, []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}, "a 7z archive"},
+	{0, []byte("Rar!"), "a rar archive"},
+	{0, []byte{0x7F, 'E', 'L', 'F'}, "an ELF executable"},
+	{0, []byte("MZ"), "a Windows executable"},
+	{0, []byte{0xCF, 0xFA, 0xED, 0xFE}, "a Mach-O executable"},
+	{0, []byte{0xCA, 0xFE, 0xBA, 0xBE}, "a Mach-O or Java class file"},
+	{0, []byte("#!"), "a script"},
+	{0, []byte("%PDF"), "a PDF document"},
+}
+
+// SniffContainer identifies the container from a file's first bytes,
+// returning an error wrapping ErrNotMedia if it is not a known media format
+func SniffContainer(header []byte) (string, error) {
+	for _, sig := range rejectedSignatures {
+		if sig.matches(header) {
+			return "", fmt.Errorf("%w: file is %s", ErrNotMedia, sig.name)
+		}
+	}
+	for _, sig := range imageSignatures {
+		if sig.matches(header) {
+			return sig.name, nil
+		}
+	}
+	for _, sig := range mediaSignatures {
+		if sig.matches(header) {
+			return sig.name, nil
+		}
+	}
+
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")):
+		switch string(header[8:12]) {
+		case "AVI ":
+			return "avi", nil
+		case "WAVE":
+			return "wav", nil
+		}
+	case len(header) > 188 && header[0] == 0x47 && header[188] == 0x47:
+		return "mpeg-ts", nil
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return "mpeg-audio", nil // MP3 or ADTS AAC frame sync
+	}
+
+	return "", fmt.Errorf("%w: unrecognized file format", ErrNotMedia)
+}
+
+func (s signature) matches(header []byte) bool {
+	end := s.offset + len(s.magic)
+	return len(header) >= end && bytes.Equal(header[s.offset:end], s.magic)
+}
+
+// ProbeMedia runs a quick ffprobe over the file and checks it has at least
+// one decodable audio or video stream of sane dimensions. It returns what
+// the probe found.
+func ProbeMedia(ctx context.Context, path string, timeout time.Duration) (*MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	info, err := Probe(ctx, path)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%w: inspection timed out", ErrNotMedia)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("%w: ffprobe could not read the file", ErrNotMedia)
+		}
+		return nil, err
+	}
+
+	hasMedia := false
+	for _, stream := range info.Streams {
+		switch stream.Type {
+		case "video":
+			if stream.Width > maxProbeDimension || stream.Height > maxProbeDimension {
+				return nil, fmt.Errorf("%w: video dimensions %dx%d are too large", ErrNotMedia, stream.Width, stream.Height)
+			}
+			hasMedia = true
+		case "audio":
+			hasMedia = true
+		}
+	}
+	if !hasMedia {
+		return nil, fmt.Errorf("%w: no audio or video streams found", ErrNotMedia)
+	}
+	return info, nil
+}
@@ -0,0 +1,96 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// Limits keep encodes from starving the API and database on a shared
+// machine. The zero value leaves ffmpeg unrestricted.
+type Limits struct {
+	Threads  int     // encoder threads per job; 0 lets ffmpeg choose
+	Nice     int     // scheduling niceness from 0 to 19, via nice
+	IOClass  string  // "", "best-effort" or "idle", via ionice
+	CPUs     float64 // CPU time per job in CPUs, enforced by Cgroups; 0 for no limit
+	MemoryMB int     // memory per job; 0 for no limit
+
+	// Cgroups, when set, enforces CPUs and MemoryMB through a cgroup per
+	// encode. Without it memory is capped with prlimit and CPUs only
+	// through Threads.
+	Cgroups *Cgroups
+}
+
+// Validate checks the limits are usable
+func (l Limits) Validate() error {
+	if l.Threads < 0 {
+		return fmt.Errorf("threads must not be negative")
+	}
+	if l.Nice < 0 || l.Nice > 19 {
+		return fmt.Errorf("nice must be between 0 and 19")
+	}
+	switch l.IOClass {
+	case "", "best-effort", "idle":
+	default:
+		return fmt.Errorf("unsupported I/O class %q (want best-effort or idle)", l.IOClass)
+	}
+	if l.CPUs < 0 {
+		return fmt.Errorf("CPUs must not be negative")
+	}
+	if l.MemoryMB < 0 {
+		return fmt.Errorf("memory must not be negative")
+	}
+	return nil
+}
+
+// Share returns the limits for each of n encodes run at once in place of
+// one, so that together they stay within l: threads and CPUs are divided
+// between them, leaving each at least one thread. Memory is per encode and
+// isn't divided.
+func (l Limits) Share(n int) Limits {
+	if n <= 1 {
+		return l
+	}
+	if l.Threads > 0 {
+		l.Threads = max(l.Threads/n, 1)
+	}
+	l.CPUs /= float64(n)
+	return l
+}
+
+// ThreadsForBudget splits a CPU budget, as a percentage of all CPUs,
+// evenly between the workers that may encode at once. 0 means no budget.
+// CPUs are counted as GOMAXPROCS, which can be set to a container's quota.
+func ThreadsForBudget(percent, workers int) int {
+	if percent <= 0 {
+		return 0
+	}
+	return max(runtime.GOMAXPROCS(0)*percent/100/max(workers, 1), 1)
+}
+
+// command runs ffmpeg with args through ionice and nice when set, so
+// every thread ffmpeg starts inherits the priorities. Without cgroups,
+// prlimit caps ffmpeg's data segment, which covers its heap and buffers.
+func (l Limits) command(ctx context.Context, args []string) *exec.Cmd {
+	name := "ffmpeg"
+	if l.MemoryMB > 0 && l.Cgroups == nil {
+		args = append([]string{"--data=" + strconv.Itoa(l.MemoryMB*1024*1024), name}, args...)
+		name = "prlimit"
+	}
+	if l.Nice > 0 {
+		args = append([]string{"-n", strconv.Itoa(l.Nice), name}, args...)
+		name = "nice"
+	}
+	switch l.IOClass {
+	case "best-effort":
+		// The lowest priority within the default class
+		args = append([]string{"-c", "2", "-n", "7", name}, args...)
+		name = "ionice"
+	case "idle":
+		args = append([]string{"-c", "3", name}, args...)
+		name = "ionice"
+	}
+	return exec.CommandContext(ctx, name, args...)
+}
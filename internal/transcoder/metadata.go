@@ -0,0 +1,185 @@
+package transcoder
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tags that may be set on an output. MP3 outputs carry them as ID3v2
+// frames, MP4 and M4A outputs as iTunes-style atoms.
+var metadataTags = map[string]bool{
+	"title": true, "artist": true, "album_artist": true, "album": true, "genre": true, "date": true,
+	"track": true, "comment": true, "composer": true, "copyright": true, "publisher": true, "language": true,
+}
+
+// Limits on metadata supplied with a job
+const (
+	maxTagLength    = 1024
+	maxChapters     = 500
+	maxChapterTitle = 255
+)
+
+// Metadata is written into a job's output: tags and chapter markers.
+// Stored as a JSON column.
+type Metadata struct {
+	Tags     map[string]string `json:"tags,omitempty"`
+	Chapters []Chapter         `json:"chapters,omitempty"`
+}
+
+// Chapter marks where a chapter starts; it runs until the next one starts,
+// or the end of the output
+type Chapter struct {
+	Start float64 `json:"start"` // seconds from the start of the output
+	Title string  `json:"title"`
+}
+
+// Validate checks the tags are known and the chapters are in order
+func (m *Metadata) Validate() error {
+	for key, value := range m.Tags {
+		if !metadataTags[key] {
+			return fmt.Errorf("unsupported metadata tag %q", key)
+		}
+		if len(value) > maxTagLength || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("metadata tag %q must be at most %d characters", key, maxTagLength)
+		}
+	}
+	if len(m.Chapters) > maxChapters {
+		return fmt.Errorf("at most %d chapters are allowed", maxChapters)
+	}
+	for i, chapter := range m.Chapters {
+		if chapter.Start < 0 {
+			return fmt.Errorf("chapter %d starts before the output", i+1)
+		}
+		if i > 0 && chapter.Start <= m.Chapters[i-1].Start {
+			return fmt.Errorf("chapters must be in order of start time")
+		}
+		if strings.TrimSpace(chapter.Title) == "" || len(chapter.Title) > maxChapterTitle {
+			return fmt.Errorf("chapter %d needs a title of at most %d characters", i+1, maxChapterTitle)
+		}
+	}
+	return nil
+}
+
+// IsEmpty reports whether there is nothing to write
+func (m *Metadata) IsEmpty() bool {
+	return m == nil || (len(m.Tags) == 0 && len(m.Chapters) == 0)
+}
+
+// Value implements driver.Valuer
+func (m Metadata) Value() (driver.Value, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (m *Metadata) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	default:
+		return fmt.Errorf("unsupported type %T for Metadata", value)
+	}
+}
+
+// metadataArgs returns the inputs and output options that write f's tags,
+// chapters and cover art. Inputs are numbered from first. The chapters go
+// through an FFMETADATA file, written beside the output; cleanup removes it.
+func (f *FFmpeg) metadataArgs(first int, output []string) (inputs, options []string, cleanup func(), err error) {
+	cleanup = func() {}
+	keys := make([]string, 0, len(f.metadata.Tags))
+	for key := range f.metadata.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		options = append(options, "-metadata", key+"="+f.metadata.Tags[key])
+	}
+
+	if len(f.metadata.Chapters) > 0 {
+		path := f.outputPath + ".chapters.txt"
+		if err := os.WriteFile(path, []byte(f.chapterFile()), 0o644); err != nil {
+			return nil, nil, cleanup, fmt.Errorf("failed to write chapters: %w", err)
+		}
+		cleanup = func() { os.Remove(path) }
+		inputs = append(inputs, "-f", "ffmetadata", "-i", path)
+		options = append(options, "-map_chapters", strconv.Itoa(first))
+		first++
+	}
+
+	// Cover art is an attached picture, which only audio outputs carry, and
+	// only in MP3 and QuickTime files; Matroska and MPEG-TS have no place
+	// for it
+	if f.cover != "" && f.preset.VideoCodec == "none" && (f.preset.Format == FormatMP3 || f.preset.quickTime()) {
+		inputs = append(inputs, "-i", f.cover)
+		if len(f.inputPaths) == 1 && len(f.preset.AudioTracks) == 0 {
+			// Mapping the cover stops ffmpeg choosing streams itself
+			options = append(options, "-map", "0:a")
+		}
+		options = append(options, "-map", strconv.Itoa(first)+":v", "-c:v", "copy", "-disposition:v:0", "attached_pic")
+		// The comment sets the ID3 picture type, which players look for
+		options = append(options, "-metadata:s:v", "comment=Cover (front)")
+		output = removeArg(output, "-vn")
+	}
+	if f.preset.Format == FormatMP3 {
+		// ID3v2.3 is the version podcast apps read most reliably
+		options = append(options, "-id3v2_version", "3")
+	}
+	return inputs, append(output, options...), cleanup, nil
+}
+
+// chapterFile renders the chapters in ffmpeg's FFMETADATA format. Each
+// ends where the next starts; the last at the end of the input, if known.
+// Chapters are timed from the end of any branding intro.
+func (f *FFmpeg) chapterFile() string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	chapters := f.metadata.Chapters
+	offset := f.intro.Milliseconds()
+	for i, chapter := range chapters {
+		start := offset + int64(chapter.Start*1000)
+		end := start + 1
+		if i+1 < len(chapters) {
+			end = offset + int64(chapters[i+1].Start*1000)
+		} else if ms := f.duration.Milliseconds(); ms > start {
+			end = ms
+		}
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", start, end, escapeMetadata(chapter.Title))
+	}
+	return b.String()
+}
+
+// escapeMetadata escapes the characters FFMETADATA files treat specially
+func escapeMetadata(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// removeArg returns args without any occurrence of arg
+func removeArg(args []string, arg string) []string {
+	kept := make([]string, 0, len(args))
+	for _, a := range args {
+		if a != arg {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
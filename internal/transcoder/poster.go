@@ -0,0 +1,186 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPosterWidth caps the width of posters; smaller videos keep their own
+const maxPosterWidth = 1280
+
+// How posters are chosen: frames within posterWindow around the poster
+// time are sampled posterSamples times a second and scored
+const (
+	posterWindow  = 4 * time.Second
+	posterSamples = 4
+)
+
+// PosterTime is where in a video its poster is taken from: an offset from
+// the start, or a share of the duration
+type PosterTime struct {
+	Offset  time.Duration
+	Percent float64 // of the duration, 0 to 100; used when non-zero
+}
+
+// ParsePosterTime parses a number of seconds, like "5" or "12.5", or a
+// percentage of the duration, like "10%"
+func ParsePosterTime(value string) (PosterTime, error) {
+	value = strings.TrimSpace(value)
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p >= 100 {
+			return PosterTime{}, fmt.Errorf("percentage must be between 0 and 100")
+		}
+		return PosterTime{Percent: p}, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return PosterTime{}, fmt.Errorf("must be a number of seconds or a percentage, like 10%%")
+	}
+	return PosterTime{Offset: time.Duration(seconds * float64(time.Second))}, nil
+}
+
+// In returns the poster time in a video of the given duration, kept
+// within it
+func (t PosterTime) In(duration time.Duration) time.Duration {
+	at := t.Offset
+	if t.Percent > 0 {
+		at = time.Duration(float64(duration) * t.Percent / 100)
+	}
+	if duration > 0 && at >= duration {
+		at = duration / 2
+	}
+	return at
+}
+
+// ExtractPoster saves a frame near at in the video at input as a JPEG at
+// output, for players to show before playback starts. Of the frames
+// around at, the sharpest that isn't black or blank is taken; if none
+// can be scored, the frame at at is.
+func ExtractPoster(ctx context.Context, input, output string, at time.Duration) error {
+	runner := currentBackend().Runner(Limits{})
+	if best, err := choosePosterFrame(ctx, runner, input, at); err == nil {
+		at = best
+	} else if ctx.Err() != nil {
+		return err
+	}
+
+	args := []string{
+		"-y", "-v", "error",
+		"-ss", formatSeconds(at),
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", maxPosterWidth),
+		"-q:v", "3",
+		output,
+	}
+	if err := runner.Run(ctx, args, io.Discard); err != nil {
+		return fmt.Errorf("failed to make poster: %w", err)
+	}
+	return nil
+}
+
+// errNoPosterFrame is returned when every sampled frame is blank
+var errNoPosterFrame = errors.New("no usable poster frame")
+
+// choosePosterFrame scores frames around at with the signalstats and
+// blurdetect filters and returns the time of the sharpest one that isn't
+// blank
+func choosePosterFrame(ctx context.Context, runner Runner, input string, at time.Duration) (time.Duration, error) {
+	start := max(at-posterWindow/2, 0)
+	args := []string{
+		"-v", "error",
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(posterWindow),
+		"-i", input,
+		"-an", "-sn", "-dn",
+		"-vf", fmt.Sprintf("fps=%d,scale=320:-2,format=yuv420p,signalstats,blurdetect,metadata=mode=print:file=-", posterSamples),
+		"-f", "null", "-",
+	}
+	var out bytes.Buffer
+	if err := runner.Run(ctx, args, &out); err != nil {
+		return 0, err
+	}
+
+	var best *frameScore
+	for _, frame := range parseFrameScores(&out) {
+		if frame.blank() {
+			continue
+		}
+		if best == nil || frame.blur < best.blur {
+			best = &frame
+		}
+	}
+	if best == nil {
+		return 0, errNoPosterFrame
+	}
+	return start + best.time, nil
+}
+
+// frameScore is what the analysis filters measured of one frame
+type frameScore struct {
+	time       time.Duration // from the start of the analysed window
+	luma       float64       // average brightness, 16 to 235
+	lumaMin    float64
+	lumaMax    float64
+	blur       float64 // higher is blurrier
+	hasMeasure bool
+}
+
+// blank reports whether the frame is black, washed out or a flat colour
+func (f frameScore) blank() bool {
+	return !f.hasMeasure || f.luma < 24 || f.luma > 235 || f.lumaMax-f.lumaMin < 32
+}
+
+// parseFrameScores reads the output of the metadata filter: a
+// "frame:N pts:P pts_time:T" line per frame, then its key=value pairs
+func parseFrameScores(r io.Reader) []frameScore {
+	var frames []frameScore
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "frame:") {
+			var frame frameScore
+			for _, field := range strings.Fields(line) {
+				if value, ok := strings.CutPrefix(field, "pts_time:"); ok {
+					seconds, _ := strconv.ParseFloat(value, 64)
+					frame.time = time.Duration(seconds * float64(time.Second))
+				}
+			}
+			frames = append(frames, frame)
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || len(frames) == 0 {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		frame := &frames[len(frames)-1]
+		switch key {
+		case "lavfi.signalstats.YAVG":
+			frame.luma, frame.hasMeasure = number, true
+		case "lavfi.signalstats.YMIN":
+			frame.lumaMin = number
+		case "lavfi.signalstats.YMAX":
+			frame.lumaMax = number
+		case "lavfi.blur":
+			frame.blur = number
+		}
+	}
+	return frames
+}
+
+// formatSeconds formats d as seconds for ffmpeg's -ss and -t
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,395 @@
+package transcoder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/features"
+)
+
+// Preset is a named set of encoding settings. Presets with an empty
+// TenantID are global; a tenant's own preset shadows a global one of the
+// same name.
+type Preset struct {
+	ID               uint        `json:"-" gorm:"primaryKey"`
+	TenantID         string      `json:"tenant_id,omitempty" gorm:"uniqueIndex:idx_presets_tenant_name"`
+	Name             string      `json:"name" gorm:"uniqueIndex:idx_presets_tenant_name;not null"`
+	Description      string      `json:"description,omitempty"`
+	Format           string      `json:"format,omitempty"` // output container, FormatMP4 if empty
+	VideoCodec       string      `json:"video_codec"`
+	VideoProfile     string      `json:"video_profile,omitempty"` // ProRes or DNxHR profile of mezzanine presets
+	EncoderPreset    string      `json:"encoder_preset,omitempty"`
+	Encoding         string      `json:"encoding,omitempty"` // EncodingAuto if empty; jobs may override it
+	CRF              *int        `json:"crf,omitempty" gorm:"column:constant_rate_factor"`
+	VideoBitrate     string      `json:"video_bitrate,omitempty"`
+	Width            int         `json:"width,omitempty"`
+	Height           int         `json:"height,omitempty"`
+	AudioCodec       string      `json:"audio_codec"`
+	AudioBitrate     string      `json:"audio_bitrate,omitempty"`
+	AudioChannels    int         `json:"audio_channels,omitempty"`
+	AudioSampleRate  int         `json:"audio_sample_rate,omitempty"`
+	AudioSync        bool        `json:"audio_sync,omitempty"`
+	MovFlags         string      `json:"movflags,omitempty"`                      // MP4 layout as +-separated movflags; faststart if empty
+	FragmentDuration float64     `json:"fragment_duration,omitempty"`             // seconds per fragment of fragmented MP4s
+	ClosedCaptions   string      `json:"closed_captions,omitempty"`               // CaptionsKeep or CaptionsDrop; encoder default if empty
+	ExtractCaptions  string      `json:"extract_captions,omitempty"`              // comma-separated formats to extract closed captions to
+	AudioTracks      AudioTracks `json:"audio_tracks,omitempty" gorm:"type:text"` // input audio streams to keep, in order; ffmpeg picks one if empty
+	Destination      string      `json:"destination,omitempty"`                   // destination profile for jobs using the preset
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// DefaultPreset returns the settings used when a job names no preset
+func DefaultPreset() *Preset {
+	crf := 23
+	return &Preset{
+		Name:          "default",
+		VideoCodec:    "libx264",
+		EncoderPreset: "medium",
+		CRF:           &crf,
+		AudioCodec:    "aac",
+		AudioBitrate:  "128k",
+	}
+}
+
+// Output formats. MP3 and M4A are audio-only, for podcasts and the like;
+// MKV takes any codec for archiving, MOV suits editing software and
+// MPEG-TS broadcast ingest. MXF carries DNxHR mezzanines for editing
+// systems that want it over MOV.
+const (
+	FormatMP4 = "mp4"
+	FormatMP3 = "mp3"
+	FormatM4A = "m4a"
+	FormatMKV = "mkv"
+	FormatMOV = "mov"
+	FormatTS  = "ts"
+	FormatMXF = "mxf"
+)
+
+// Codec names accepted in presets. "none" drops the stream entirely.
+var (
+	videoCodecs     = map[string]bool{"libx264": true, "libx265": true, "libvpx-vp9": true, "libsvtav1": true, "prores_ks": true, "dnxhd": true, "copy": true, "none": true}
+	audioCodecs     = map[string]bool{"aac": true, "libopus": true, "libmp3lame": true, "pcm_s16le": true, "pcm_s24le": true, "copy": true, "none": true}
+	encoderPresets  = map[string]bool{"ultrafast": true, "superfast": true, "veryfast": true, "faster": true, "fast": true, "medium": true, "slow": true, "slower": true, "veryslow": true}
+	audioRates      = map[int]bool{22050: true, 32000: true, 44100: true, 48000: true}
+	movFlags        = map[string]bool{"faststart": true, "frag_keyframe": true, "empty_moov": true, "default_base_moof": true, "separate_moof": true, "frag_every_frame": true, "omit_tfhd_offset": true, "global_sidx": true, "skip_sidx": true, "skip_trailer": true, "negative_cts_offsets": true, "dash": true, "cmaf": true}
+	presetNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+	bitrateRegex    = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmM]?$`)
+)
+
+// videoProfiles maps the profiles of the mezzanine codecs to the pixel
+// format each is encoded in. ProRes 4444 keeps an alpha channel; DNxHR
+// LB, SQ and HQ are 8-bit.
+var videoProfiles = map[string]map[string]string{
+	"prores_ks": {
+		"proxy": "yuv422p10le", "lt": "yuv422p10le", "standard": "yuv422p10le", "hq": "yuv422p10le",
+		"4444": "yuva444p10le", "4444xq": "yuva444p10le",
+	},
+	"dnxhd": {
+		"dnxhr_lb": "yuv422p", "dnxhr_sq": "yuv422p", "dnxhr_hq": "yuv422p",
+		"dnxhr_hqx": "yuv422p10le", "dnxhr_444": "yuv444p10le",
+	},
+}
+
+// Validate checks a preset for values ffmpeg would reject or misinterpret
+func (p *Preset) Validate() error {
+	if !presetNameRegex.MatchString(p.Name) {
+		return fmt.Errorf("name must be 1-64 lowercase letters, digits, '.', '_' or '-'")
+	}
+	if !videoCodecs[p.VideoCodec] {
+		return fmt.Errorf("unsupported video_codec %q", p.VideoCodec)
+	}
+	if !audioCodecs[p.AudioCodec] {
+		return fmt.Errorf("unsupported audio_codec %q", p.AudioCodec)
+	}
+	if p.VideoCodec == "none" && p.AudioCodec == "none" {
+		return fmt.Errorf("a preset must keep at least one of video or audio")
+	}
+	if err := p.validateMezzanine(); err != nil {
+		return err
+	}
+	switch p.Format {
+	case "", FormatMP4:
+	case FormatMP3:
+		if p.VideoCodec != "none" || p.AudioCodec != "libmp3lame" {
+			return fmt.Errorf("mp3 presets need video_codec none and audio_codec libmp3lame")
+		}
+	case FormatM4A:
+		if p.VideoCodec != "none" || (p.AudioCodec != "aac" && p.AudioCodec != "copy") {
+			return fmt.Errorf("m4a presets need video_codec none and audio_codec aac or copy")
+		}
+	case FormatMKV:
+	case FormatMOV, FormatTS:
+		// Neither container carries VP9, AV1 or Opus in a way players expect
+		if p.VideoCodec == "libvpx-vp9" || p.VideoCodec == "libsvtav1" || p.AudioCodec == "libopus" {
+			return fmt.Errorf("%s presets can't use libvpx-vp9, libsvtav1 or libopus; use mkv or mp4", p.Format)
+		}
+	case FormatMXF:
+		if p.VideoCodec != "dnxhd" && p.VideoCodec != "copy" {
+			return fmt.Errorf("mxf presets need video_codec dnxhd or copy")
+		}
+		if p.AudioCodec != "pcm_s16le" && p.AudioCodec != "pcm_s24le" && p.AudioCodec != "none" {
+			return fmt.Errorf("mxf presets need audio_codec pcm_s16le, pcm_s24le or none")
+		}
+	default:
+		return fmt.Errorf("unsupported format %q (want mp4, mp3, m4a, mkv, mov, ts or mxf)", p.Format)
+	}
+	if p.EncoderPreset != "" && !encoderPresets[p.EncoderPreset] {
+		return fmt.Errorf("unsupported encoder_preset %q", p.EncoderPreset)
+	}
+	if err := ValidateEncoding(p.Encoding); err != nil {
+		return err
+	}
+	if p.Encoding == EncodingHardware && !p.hardwareCapable() {
+		return fmt.Errorf("encoding hardware needs video_codec libx264, libx265, libvpx-vp9 or libsvtav1, without closed_captions")
+	}
+	if p.CRF != nil && (*p.CRF < 0 || *p.CRF > 51) {
+		return fmt.Errorf("crf must be between 0 and 51")
+	}
+	if p.VideoBitrate != "" && !bitrateRegex.MatchString(p.VideoBitrate) {
+		return fmt.Errorf("video_bitrate must look like 2500k or 4M")
+	}
+	if p.AudioBitrate != "" && !bitrateRegex.MatchString(p.AudioBitrate) {
+		return fmt.Errorf("audio_bitrate must look like 128k")
+	}
+	for _, dim := range []int{p.Width, p.Height} {
+		if dim < 0 || dim > 7680 || dim%2 != 0 {
+			return fmt.Errorf("width and height must be even and between 0 and 7680")
+		}
+	}
+	if p.AudioChannels < 0 || p.AudioChannels > 8 {
+		return fmt.Errorf("audio_channels must be between 0 and 8")
+	}
+	if p.AudioSampleRate != 0 && !audioRates[p.AudioSampleRate] {
+		return fmt.Errorf("unsupported audio_sample_rate %d", p.AudioSampleRate)
+	}
+	if p.AudioSync && (p.AudioCodec == "copy" || p.AudioCodec == "none") {
+		return fmt.Errorf("audio_sync needs the audio re-encoded, not copied or dropped")
+	}
+	if err := p.validateCaptions(); err != nil {
+		return err
+	}
+	if err := p.validateTracks(); err != nil {
+		return err
+	}
+	return p.validateLayout()
+}
+
+// validateMezzanine checks the editing codecs, ProRes and DNxHR with PCM
+// audio. They are intra-frame codecs picked by profile rather than by
+// rate or quality, and only MOV, MXF and MKV carry them.
+func (p *Preset) validateMezzanine() error {
+	profiles, mezzanine := videoProfiles[p.VideoCodec]
+	if !mezzanine {
+		if p.VideoProfile != "" {
+			return fmt.Errorf("video_profile only applies to prores_ks and dnxhd")
+		}
+	} else {
+		if p.VideoProfile == "" {
+			return fmt.Errorf("%s presets need a video_profile", p.VideoCodec)
+		}
+		if _, ok := profiles[p.VideoProfile]; !ok {
+			return fmt.Errorf("unsupported video_profile %q for %s", p.VideoProfile, p.VideoCodec)
+		}
+		if p.EncoderPreset != "" || p.CRF != nil || p.VideoBitrate != "" {
+			return fmt.Errorf("encoder_preset, crf and video_bitrate don't apply to %s", p.VideoCodec)
+		}
+		if p.VideoCodec == "prores_ks" && p.Format != FormatMOV && p.Format != FormatMKV {
+			return fmt.Errorf("prores_ks presets need format mov or mkv")
+		}
+		if p.VideoCodec == "dnxhd" && p.Format != FormatMOV && p.Format != FormatMXF && p.Format != FormatMKV {
+			return fmt.Errorf("dnxhd presets need format mov, mxf or mkv")
+		}
+	}
+	if strings.HasPrefix(p.AudioCodec, "pcm_") {
+		if p.Format != FormatMOV && p.Format != FormatMXF && p.Format != FormatMKV {
+			return fmt.Errorf("%s presets need format mov, mxf or mkv", p.AudioCodec)
+		}
+		if p.AudioBitrate != "" {
+			return fmt.Errorf("audio_bitrate doesn't apply to %s", p.AudioCodec)
+		}
+	}
+	return nil
+}
+
+// validateLayout checks the MP4 layout options. Fragmented MP4s have no
+// single moov to move to the front, so faststart can't be combined with
+// fragmenting.
+func (p *Preset) validateLayout() error {
+	if !p.quickTime() && (p.MovFlags != "" || p.FragmentDuration != 0) {
+		return fmt.Errorf("movflags and fragment_duration don't apply to %s presets", p.Format)
+	}
+	if p.MovFlags != "" && p.MovFlags != "none" {
+		for _, flag := range strings.Split(strings.TrimPrefix(p.MovFlags, "+"), "+") {
+			if !movFlags[flag] {
+				return fmt.Errorf("unsupported movflags flag %q", flag)
+			}
+		}
+	}
+	if p.fragmented() && strings.Contains(p.MovFlags, "faststart") {
+		return fmt.Errorf("faststart can't be combined with fragmented movflags or fragment_duration")
+	}
+	if p.FragmentDuration != 0 && (p.FragmentDuration < 0.1 || p.FragmentDuration > 60) {
+		return fmt.Errorf("fragment_duration must be between 0.1 and 60 seconds")
+	}
+	return nil
+}
+
+// quickTime reports whether outputs are MP4, M4A or MOV files, which share
+// the QuickTime layout that movflags control
+func (p *Preset) quickTime() bool {
+	switch p.Format {
+	case "", FormatMP4, FormatM4A, FormatMOV:
+		return true
+	}
+	return false
+}
+
+// fragmented reports whether outputs are written as fragmented MP4
+func (p *Preset) fragmented() bool {
+	if p.FragmentDuration > 0 {
+		return true
+	}
+	for _, flag := range []string{"frag_keyframe", "empty_moov", "frag_every_frame", "dash", "cmaf"} {
+		if strings.Contains(p.MovFlags, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// muxerArgs returns the MP4 and MOV muxer options for the preset's
+// layout: the moov atom at the front for progressive download unless the
+// preset says otherwise
+func (p *Preset) muxerArgs() []string {
+	if !p.quickTime() {
+		return nil
+	}
+	var args []string
+	switch p.MovFlags {
+	case "":
+		if !p.fragmented() {
+			args = append(args, "-movflags", "+faststart")
+		}
+	case "none":
+	default:
+		args = append(args, "-movflags", "+"+strings.TrimPrefix(p.MovFlags, "+"))
+	}
+	if p.FragmentDuration > 0 {
+		args = append(args, "-frag_duration", strconv.FormatInt(int64(p.FragmentDuration*1e6), 10))
+	}
+	return args
+}
+
+// Ext returns the file extension of the preset's outputs, with the dot
+func (p *Preset) Ext() string {
+	if p.Format == "" {
+		return ".mp4"
+	}
+	return "." + p.Format
+}
+
+// Features lists the experimental features the preset relies on
+func (p *Preset) Features() []string {
+	if p.VideoCodec == "libsvtav1" {
+		return []string{features.AV1}
+	}
+	return nil
+}
+
+// args returns the ffmpeg output options for this preset
+func (p *Preset) args() []string {
+	return p.outputArgs(p.scaleFilter(), p.syncFilter())
+}
+
+// outputArgs returns the codec options, with videoFilter and audioFilter
+// (if any) applied through -vf and -af
+func (p *Preset) outputArgs(videoFilter, audioFilter string) []string {
+	var args []string
+
+	switch p.VideoCodec {
+	case "none":
+		args = append(args, "-vn")
+	case "copy":
+		args = append(args, "-c:v", "copy")
+	default:
+		args = append(args, "-c:v", p.VideoCodec)
+		if pixFmt, ok := videoProfiles[p.VideoCodec][p.VideoProfile]; ok {
+			args = append(args, "-profile:v", p.VideoProfile, "-pix_fmt", pixFmt)
+		}
+		if hardware, ok := p.hardwareArgs(); ok {
+			args = append(args, hardware...)
+		} else {
+			// VP9 and SVT-AV1 take numeric speed settings instead of the x264 names
+			if p.EncoderPreset != "" && p.VideoCodec != "libvpx-vp9" && p.VideoCodec != "libsvtav1" {
+				args = append(args, "-preset", p.EncoderPreset)
+			}
+			if p.VideoBitrate != "" {
+				args = append(args, "-b:v", p.VideoBitrate)
+			} else if p.CRF != nil {
+				args = append(args, "-crf", strconv.Itoa(*p.CRF))
+				if p.VideoCodec == "libvpx-vp9" {
+					// VP9 only honors CRF in constant-quality mode
+					args = append(args, "-b:v", "0")
+				}
+			}
+		}
+		args = append(args, p.captionArgs()...)
+		if videoFilter != "" {
+			args = append(args, "-vf", videoFilter)
+		}
+	}
+
+	switch p.AudioCodec {
+	case "none":
+		args = append(args, "-an")
+	case "copy":
+		args = append(args, "-c:a", "copy")
+	default:
+		args = append(args, "-c:a", p.AudioCodec)
+		if p.AudioBitrate != "" {
+			args = append(args, "-b:a", p.AudioBitrate)
+		}
+		if p.AudioChannels > 0 {
+			args = append(args, "-ac", strconv.Itoa(p.AudioChannels))
+		}
+		if p.AudioSampleRate > 0 {
+			args = append(args, "-ar", strconv.Itoa(p.AudioSampleRate))
+		}
+		if audioFilter != "" {
+			args = append(args, "-af", audioFilter)
+		}
+	}
+
+	return args
+}
+
+// syncFilter resamples the audio to follow its timestamps when the preset
+// asks for it: a late start is padded with silence from the first video
+// frame, and audio that drifts is stretched or squeezed by up to 1000
+// samples a second to keep up, as with cheap capture devices
+func (p *Preset) syncFilter() string {
+	if !p.AudioSync {
+		return ""
+	}
+	return "aresample=" + audioSyncOptions
+}
+
+// audioSyncOptions are the aresample options of syncFilter
+const audioSyncOptions = "async=1000:first_pts=0"
+
+// scaleFilter fits the video within Width x Height, keeping aspect ratio
+func (p *Preset) scaleFilter() string {
+	switch {
+	case p.Width > 0 && p.Height > 0:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,scale=trunc(iw/2)*2:trunc(ih/2)*2", p.Width, p.Height)
+	case p.Width > 0:
+		return fmt.Sprintf("scale=%d:-2", p.Width)
+	case p.Height > 0:
+		return fmt.Sprintf("scale=-2:%d", p.Height)
+	}
+	return ""
+}
@@ -0,0 +1,205 @@
+package transcoder
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo is what ffprobe reports about a file. The top-level video and
+// audio fields describe the first stream of each kind.
+type MediaInfo struct {
+	Format         string        `json:"format"`   // ffprobe format name, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	Duration       float64       `json:"duration"` // seconds
+	Size           int64         `json:"size"`     // bytes
+	BitRate        int64         `json:"bit_rate,omitempty"`
+	Width          int           `json:"width,omitempty"`
+	Height         int           `json:"height,omitempty"`
+	FrameRate      float64       `json:"frame_rate,omitempty"`
+	VideoCodec     string        `json:"video_codec,omitempty"`
+	AudioCodec     string        `json:"audio_codec,omitempty"`
+	ClosedCaptions bool          `json:"closed_captions,omitempty"` // CEA-608/708 captions embedded in the first video stream
+	Streams        []MediaStream `json:"streams"`
+}
+
+// MediaStream describes one stream of a probed file
+type MediaStream struct {
+	Index          int     `json:"index"`
+	Type           string  `json:"type"` // video, audio, subtitle, data
+	Codec          string  `json:"codec"`
+	Profile        string  `json:"profile,omitempty"`
+	Level          int     `json:"level,omitempty"` // as ffprobe reports it, e.g. 40 for H.264 level 4.0
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	FrameRate      float64 `json:"frame_rate,omitempty"`
+	PixelFormat    string  `json:"pixel_format,omitempty"`
+	SampleRate     int     `json:"sample_rate,omitempty"`
+	Channels       int     `json:"channels,omitempty"`
+	ChannelLayout  string  `json:"channel_layout,omitempty"`
+	BitRate        int64   `json:"bit_rate,omitempty"`
+	Duration       float64 `json:"duration,omitempty"`
+	Language       string  `json:"language,omitempty"`
+	ClosedCaptions bool    `json:"closed_captions,omitempty"` // CEA-608/708 captions embedded in the video
+}
+
+// Value implements driver.Valuer, storing the info as JSON
+func (m MediaInfo) Value() (driver.Value, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (m *MediaInfo) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	default:
+		return fmt.Errorf("unsupported type %T for MediaInfo", value)
+	}
+}
+
+// AudioDrift returns how many seconds longer the first audio stream runs
+// than the first video stream, negative if it is shorter. It is 0 when
+// either stream is missing or its duration unknown.
+func (m *MediaInfo) AudioDrift() float64 {
+	var video, audio float64
+	for _, stream := range m.Streams {
+		switch {
+		case stream.Type == "video" && video == 0:
+			video = stream.Duration
+		case stream.Type == "audio" && audio == 0:
+			audio = stream.Duration
+		}
+	}
+	if video == 0 || audio == 0 {
+		return 0
+	}
+	return audio - video
+}
+
+// Probe runs ffprobe over a file and summarizes its format and streams
+func Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	output, err := ffprobe(ctx,
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	return parseProbe(output)
+}
+
+// parseProbe reads ffprobe's JSON output. ffprobe reports most numbers as
+// strings; any it leaves out or can't determine are left zero.
+func parseProbe(output []byte) (*MediaInfo, error) {
+	var result struct {
+		Streams []struct {
+			Index          int    `json:"index"`
+			CodecType      string `json:"codec_type"`
+			CodecName      string `json:"codec_name"`
+			Profile        string `json:"profile"`
+			Level          int    `json:"level"`
+			Width          int    `json:"width"`
+			Height         int    `json:"height"`
+			AvgFrameRate   string `json:"avg_frame_rate"`
+			PixFmt         string `json:"pix_fmt"`
+			SampleRate     string `json:"sample_rate"`
+			Channels       int    `json:"channels"`
+			ChannelLayout  string `json:"channel_layout"`
+			BitRate        string `json:"bit_rate"`
+			Duration       string `json:"duration"`
+			ClosedCaptions int    `json:"closed_captions"`
+			Tags           struct {
+				Language string `json:"language"`
+			} `json:"tags"`
+		} `json:"streams"`
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			Size       string `json:"size"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("ffprobe output unreadable: %w", err)
+	}
+
+	info := &MediaInfo{
+		Format:   result.Format.FormatName,
+		Duration: parseFloat(result.Format.Duration),
+		Size:     parseInt(result.Format.Size),
+		BitRate:  parseInt(result.Format.BitRate),
+		Streams:  make([]MediaStream, 0, len(result.Streams)),
+	}
+	for _, s := range result.Streams {
+		stream := MediaStream{
+			Index:         s.Index,
+			Type:          s.CodecType,
+			Codec:         s.CodecName,
+			Profile:       s.Profile,
+			Level:         s.Level,
+			Width:         s.Width,
+			Height:        s.Height,
+			PixelFormat:   s.PixFmt,
+			SampleRate:    int(parseInt(s.SampleRate)),
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+			BitRate:       parseInt(s.BitRate),
+			Duration:      parseFloat(s.Duration),
+			Language:      s.Tags.Language,
+		}
+		if s.CodecType == "video" {
+			stream.FrameRate = parseRate(s.AvgFrameRate)
+			stream.ClosedCaptions = s.ClosedCaptions == 1
+		}
+		info.Streams = append(info.Streams, stream)
+
+		switch {
+		case s.CodecType == "video" && info.VideoCodec == "":
+			info.VideoCodec = stream.Codec
+			info.Width, info.Height = stream.Width, stream.Height
+			info.FrameRate = stream.FrameRate
+			info.ClosedCaptions = stream.ClosedCaptions
+		case s.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = stream.Codec
+		}
+	}
+	return info, nil
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+// parseRate converts a frame rate fraction such as "30000/1001", rounded
+// to two decimals
+func parseRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return float64(int(parseFloat(num)/d*100+0.5)) / 100
+}
@@ -0,0 +1,90 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sprite sheets hold at most maxSprites thumbnails, spriteColumns to a row;
+// longer videos get a wider interval between thumbnails
+const (
+	maxSprites    = 100
+	spriteColumns = 10
+)
+
+// SpriteSheet describes a sprite sheet: Count thumbnails of Width by
+// Height, one every Interval, laid out Columns to a row
+type SpriteSheet struct {
+	Interval time.Duration
+	Width    int
+	Height   int
+	Columns  int
+	Count    int
+}
+
+// ExtractSprites saves thumbnails of the video at input, one every
+// interval and width pixels wide, tiled into a JPEG sprite sheet at output
+// for players to preview seeking with. srcWidth and srcHeight, the video's
+// dimensions, give the thumbnails' height.
+func ExtractSprites(ctx context.Context, input, output string, duration, interval time.Duration, width, srcWidth, srcHeight int) (SpriteSheet, error) {
+	if duration <= 0 || interval <= 0 || width <= 0 || srcWidth <= 0 || srcHeight <= 0 {
+		return SpriteSheet{}, fmt.Errorf("failed to make sprites: unknown video duration or size")
+	}
+	count := int(math.Ceil(float64(duration) / float64(interval)))
+	if count > maxSprites {
+		interval = time.Duration(math.Ceil(float64(duration) / maxSprites))
+		count = int(math.Ceil(float64(duration) / float64(interval)))
+	}
+	sheet := SpriteSheet{
+		Interval: interval,
+		Width:    width,
+		// Even, as the scale filter rounds to
+		Height:  max(int(math.Round(float64(width*srcHeight)/float64(srcWidth)/2))*2, 2),
+		Columns: min(count, spriteColumns),
+		Count:   count,
+	}
+	rows := (count + sheet.Columns - 1) / sheet.Columns
+
+	args := []string{
+		"-y", "-v", "error",
+		"-i", input,
+		"-an", "-sn", "-dn",
+		"-vf", fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d", formatSeconds(interval), sheet.Width, sheet.Height, sheet.Columns, rows),
+		"-frames:v", "1",
+		"-q:v", "5",
+		output,
+	}
+	if err := currentBackend().Runner(Limits{}).Run(ctx, args, io.Discard); err != nil {
+		return SpriteSheet{}, fmt.Errorf("failed to make sprites: %w", err)
+	}
+	return sheet, nil
+}
+
+// WriteThumbnailsVTT writes the WebVTT file players read thumbnails from:
+// a cue per thumbnail, pointing at its area of the sprite sheet, which is
+// referred to as sprite, relative to the VTT file
+func WriteThumbnailsVTT(path, sprite string, sheet SpriteSheet, duration time.Duration) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < sheet.Count; i++ {
+		start := time.Duration(i) * sheet.Interval
+		end := min(start+sheet.Interval, duration)
+		x, y := i%sheet.Columns*sheet.Width, i/sheet.Columns*sheet.Height
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTimestamp(start), vttTimestamp(end), sprite, x, y, sheet.Width, sheet.Height)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write thumbnails: %w", err)
+	}
+	return nil
+}
+
+// vttTimestamp formats d as a WebVTT timestamp, hh:mm:ss.ttt
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,91 @@
+package transcoder
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodeStats reports how fast an encode runs. While it runs they are
+// ffmpeg's latest figures; once it finishes, averages over the whole encode.
+type EncodeStats struct {
+	Frames      int64   `json:"frames,omitempty"`
+	FPS         float64 `json:"fps"`          // frames encoded per second
+	Speed       float64 `json:"speed"`        // seconds of media encoded per second
+	BitrateKbps float64 `json:"bitrate_kbps"` // output bitrate so far
+	ETA         float64 `json:"eta_seconds,omitempty"`
+}
+
+// Value implements driver.Valuer
+func (s EncodeStats) Value() (driver.Value, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (s *EncodeStats) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case []byte:
+		return json.Unmarshal(v, s)
+	default:
+		return fmt.Errorf("unsupported type %T for EncodeStats", value)
+	}
+}
+
+// parse reads one key=value line of ffmpeg's -progress output. Values of
+// "N/A", reported before the first frame, leave the figure unchanged.
+func (s *EncodeStats) parse(key, value string) {
+	switch key {
+	case "frame":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.Frames = n
+		}
+	case "fps":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			s.FPS = n
+		}
+	case "bitrate":
+		if n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "kbits/s")), 64); err == nil {
+			s.BitrateKbps = n
+		}
+	case "speed":
+		if n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "x")), 64); err == nil {
+			s.Speed = n
+		}
+	}
+}
+
+// eta estimates the time left to encode duration of media, given how much
+// is done
+func (s *EncodeStats) eta(done, duration time.Duration) float64 {
+	if s.Speed <= 0 || duration <= 0 || done >= duration {
+		return 0
+	}
+	return round2((duration - done).Seconds() / s.Speed)
+}
+
+// averages returns the stats over a whole encode of encoded media that
+// took elapsed
+func (s EncodeStats) averages(encoded, elapsed time.Duration) *EncodeStats {
+	avg := &EncodeStats{Frames: s.Frames, BitrateKbps: s.BitrateKbps}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		avg.FPS = round2(float64(s.Frames) / seconds)
+		avg.Speed = round2(encoded.Seconds() / seconds)
+	}
+	return avg
+}
+
+func round2(n float64) float64 {
+	return math.Round(n*100) / 100
+}
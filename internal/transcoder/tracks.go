@@ -0,0 +1,162 @@
+package transcoder
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// AudioTrack picks one of the input's audio streams for the output and
+// labels it for players' audio track menus
+type AudioTrack struct {
+	Stream   int    `json:"stream"`             // among the input's audio streams, from 0
+	Language string `json:"language,omitempty"` // ISO 639 code, e.g. "es" or "spa"
+	Title    string `json:"title,omitempty"`    // track name, e.g. "Español"
+	Default  bool   `json:"default,omitempty"`  // the track players start with
+}
+
+// AudioTracks is a preset's list of audio tracks, stored as a JSON column
+type AudioTracks []AudioTrack
+
+// Value implements driver.Valuer
+func (t AudioTracks) Value() (driver.Value, error) {
+	if t == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (t *AudioTracks) Scan(value interface{}) error {
+	var data []byte
+	switch value := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return fmt.Errorf("unsupported type %T for AudioTracks", value)
+	}
+	if len(data) == 0 {
+		*t = nil
+		return nil
+	}
+	return json.Unmarshal(data, t)
+}
+
+// Limits on a preset's audio tracks
+const (
+	maxAudioTracks = 8
+	maxAudioStream = 31
+	maxTrackTitle  = 128
+)
+
+var languageRegex = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// iso639 maps common two-letter ISO 639-1 codes to the three-letter ISO
+// 639-2 codes containers store
+var iso639 = map[string]string{
+	"ar": "ara", "bg": "bul", "bn": "ben", "ca": "cat", "cs": "cze", "cy": "wel", "da": "dan", "de": "ger",
+	"el": "gre", "en": "eng", "es": "spa", "et": "est", "fa": "per", "fi": "fin", "fr": "fre", "ga": "gle",
+	"he": "heb", "hi": "hin", "hr": "hrv", "hu": "hun", "id": "ind", "is": "ice", "it": "ita", "ja": "jpn",
+	"ko": "kor", "lt": "lit", "lv": "lav", "ms": "may", "nl": "dut", "no": "nor", "pl": "pol", "pt": "por",
+	"ro": "rum", "ru": "rus", "sk": "slo", "sl": "slv", "sr": "srp", "sv": "swe", "sw": "swa", "ta": "tam",
+	"th": "tha", "tl": "tgl", "tr": "tur", "uk": "ukr", "ur": "urd", "vi": "vie", "zh": "chi",
+}
+
+// language returns the track's language as an ISO 639-2 code
+func (t AudioTrack) language() string {
+	if code, ok := iso639[t.Language]; ok {
+		return code
+	}
+	return t.Language
+}
+
+// validateTracks checks the preset's audio tracks
+func (p *Preset) validateTracks() error {
+	if len(p.AudioTracks) == 0 {
+		return nil
+	}
+	if p.AudioCodec == "none" {
+		return fmt.Errorf("audio_tracks need an audio_codec other than none")
+	}
+	if len(p.AudioTracks) > maxAudioTracks {
+		return fmt.Errorf("at most %d audio_tracks are allowed", maxAudioTracks)
+	}
+	if p.Format == FormatMP3 && len(p.AudioTracks) > 1 {
+		return fmt.Errorf("mp3 presets can only have one audio track")
+	}
+	defaults := 0
+	for i, track := range p.AudioTracks {
+		if track.Stream < 0 || track.Stream > maxAudioStream {
+			return fmt.Errorf("audio track %d: stream must be between 0 and %d", i+1, maxAudioStream)
+		}
+		if track.Language != "" {
+			if !languageRegex.MatchString(track.Language) {
+				return fmt.Errorf("audio track %d: language must be a lowercase ISO 639 code, like es or spa", i+1)
+			}
+			if _, ok := iso639[track.Language]; len(track.Language) == 2 && !ok {
+				return fmt.Errorf("audio track %d: unknown language %q; use its three-letter ISO 639-2 code", i+1, track.Language)
+			}
+		}
+		if len(track.Title) > maxTrackTitle || strings.IndexFunc(track.Title, unicode.IsControl) >= 0 {
+			return fmt.Errorf("audio track %d: title must be at most %d characters", i+1, maxTrackTitle)
+		}
+		if track.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("only one audio track can be the default")
+	}
+	return nil
+}
+
+// audioMaps maps the preset's audio tracks from input 0, or its first
+// audio stream, if it has one, when the preset picks none
+func (p *Preset) audioMaps() []string {
+	if len(p.AudioTracks) == 0 {
+		return []string{"-map", "0:a:0?"}
+	}
+	var args []string
+	for _, track := range p.AudioTracks {
+		args = append(args, "-map", "0:a:"+strconv.Itoa(track.Stream))
+	}
+	return args
+}
+
+// trackArgs labels the output's audio tracks with their language and
+// title, and marks the default one
+func (p *Preset) trackArgs() []string {
+	var args []string
+	hasDefault := false
+	for _, track := range p.AudioTracks {
+		hasDefault = hasDefault || track.Default
+	}
+	for i, track := range p.AudioTracks {
+		stream := "a:" + strconv.Itoa(i)
+		if track.Language != "" {
+			args = append(args, "-metadata:s:"+stream, "language="+track.language())
+		}
+		if track.Title != "" {
+			args = append(args, "-metadata:s:"+stream, "title="+track.Title)
+		}
+		disposition := "0"
+		if track.Default || (!hasDefault && i == 0) {
+			disposition = "default"
+		}
+		args = append(args, "-disposition:"+stream, disposition)
+	}
+	return args
+}
@@ -3,106 +3,312 @@ package webhook
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/skillcape/transcoder/internal/clock"
+	"github.com/skillcape/transcoder/internal/requestid"
 )
 
 type Client struct {
 	httpClient *http.Client
 	retryCount int
+	mu         sync.RWMutex
+	secret     string
+	format     string
+	source     string
+	onResult   func(payload *Payload, err error)
+	onAttempt  func(delivery *Delivery)
+	clock      clock.Clock
+	random     func() float64
+	timing     Timing
 }
 
+// Payload is the body of a webhook delivery. Event names what happened;
+// the remaining fields beyond the job's identity and status are set only
+// for the events they apply to.
 type Payload struct {
+	Event        string `json:"event"`
+	EventID      string `json:"event_id"`
 	JobID        string `json:"job_id"`
 	Status       string `json:"status"`
+	Progress     int    `json:"progress,omitempty"`
 	DriveURL     string `json:"drive_url,omitempty"`
 	DriveFileID  string `json:"drive_file_id,omitempty"`
+	PlaylistURL  string `json:"playlist_url,omitempty"` // of the HLS playlist, for jobs packaged for HLS
+	OutputName   string `json:"output_name,omitempty"`
+	Destination  string `json:"destination,omitempty"`
 	Error        string `json:"error,omitempty"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	Rejection    string `json:"rejection,omitempty"`
 	OriginalName string `json:"original_name"`
-	CompletedAt  string `json:"completed_at"`
+	CompletedAt  string `json:"completed_at,omitempty"`
+	Timestamp    string `json:"timestamp"`
+	RequestID    string `json:"request_id,omitempty"`
+}
+
+// Target is an endpoint a delivery is sent to. When Secret is set, the
+// delivery is signed in the SignatureHeader header.
+type Target struct {
+	URL        string
+	Secret     string
+	EndpointID string // registered endpoint, empty for WEBHOOK_URL and job/tenant URLs
+	Format     string // body format; empty uses the client's default
+	Timing     Timing // zero fields use the client's; see None
 }
 
-func NewClient(retryCount int) *Client {
+// NewClient creates a webhook client. secret signs deliveries to the
+// configured WEBHOOK_URL and job/tenant URLs.
+func NewClient(retryCount int, secret string) *Client {
 	return &Client{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		// Attempts are timed out by their context, per Timing
+		httpClient: &http.Client{},
 		retryCount: retryCount,
+		secret:     secret,
+		format:     FormatJSON,
+		source:     DefaultCloudEventsSource,
+		clock:      clock.System,
+		random:     rand.Float64,
+		timing:     DefaultTiming,
 	}
 }
 
+// SetClock replaces the clock that times retries and signs deliveries
+func (c *Client) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+// SetRand replaces math/rand as the source, from 0 to 1, of the jitter
+// added to retry waits
+func (c *Client) SetRand(random func() float64) {
+	c.random = random
+}
+
+// SetSecret replaces the secret given to NewClient, such as after it was
+// rotated in a secret manager
+func (c *Client) SetSecret(secret string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.secret = secret
+}
+
+// SetTiming replaces DefaultTiming for targets that don't set their own
+func (c *Client) SetTiming(timing Timing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timing = timing
+}
+
+// Timing returns the timing of targets that don't set their own
+func (c *Client) Timing() Timing {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.timing
+}
+
+// Secret returns the secret that signs deliveries to WEBHOOK_URL and
+// job/tenant URLs
+func (c *Client) Secret() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.secret
+}
+
+// SetFormat sets the body format for targets that don't choose their own,
+// and the source attribute of CloudEvents (empty keeps the default)
+func (c *Client) SetFormat(format, source string) {
+	c.format = format
+	if source != "" {
+		c.source = source
+	}
+}
+
+// OnResult registers a callback run after each delivery attempt sequence
+// finishes, with nil on success or the final error
+func (c *Client) OnResult(callback func(payload *Payload, err error)) {
+	c.onResult = callback
+}
+
+// OnAttempt registers a callback run after every delivery attempt,
+// including redeliveries
+func (c *Client) OnAttempt(callback func(delivery *Delivery)) {
+	c.onAttempt = callback
+}
+
 // Send sends a webhook notification with retry logic
-func (c *Client) Send(ctx context.Context, url string, payload *Payload) error {
-	if url == "" {
+func (c *Client) Send(ctx context.Context, target Target, payload *Payload) error {
+	if target.URL == "" {
 		log.Printf("No webhook URL configured, skipping notification for job %s", payload.JobID)
 		return nil
 	}
 
-	jsonData, err := json.Marshal(payload)
+	ctx = requestid.NewContext(ctx, payload.RequestID)
+	err := c.send(ctx, target, payload)
+	recordDelivery(target.EndpointID, target.URL, payload.Event, err)
+	if c.onResult != nil {
+		c.onResult(payload, err)
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, target Target, payload *Payload) error {
+	format := target.Format
+	if format == "" {
+		format = c.format
+	}
+	body, err := encode(format, c.source, payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	timing := target.Timing.or(c.Timing())
+	var deadline time.Time
+	if timing.Deadline > 0 {
+		deadline = c.clock.Now().Add(timing.Deadline)
+	}
+
 	var lastErr error
 	for attempt := 0; attempt <= c.retryCount; attempt++ {
 		if attempt > 0 {
-			// Exponential backoff: 1s, 2s, 4s, 8s...
-			backoff := time.Duration(1<<uint(attempt-1)) * time.Second
-			log.Printf("Webhook retry %d/%d for job %s in %v", attempt, c.retryCount, payload.JobID, backoff)
+			// Exponential backoff: 1s, 2s, 4s, 8s..., unless the deadline
+			// would pass before the retry could start
+			backoff := timing.backoff(attempt, c.random())
+			if !deadline.IsZero() && !c.clock.Now().Add(backoff).Before(deadline) {
+				return fmt.Errorf("webhook failed after %d attempts, out of time for more: %w", attempt, lastErr)
+			}
+			requestid.Logf(ctx, "Webhook %s retry %d/%d for job %s in %v", payload.Event, attempt, c.retryCount, payload.JobID, backoff)
 
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(backoff):
+			case <-c.clock.After(backoff):
 			}
 		}
 
-		err := c.sendRequest(ctx, url, jsonData)
+		// An attempt ends at its timeout or the deadline, whichever is
+		// sooner
+		timeout := timing.Timeout
+		if !deadline.IsZero() {
+			if remaining := deadline.Sub(c.clock.Now()); timeout == 0 || remaining < timeout {
+				timeout = remaining
+			}
+		}
+		err := c.attempt(ctx, target.Secret, timeout, &Delivery{
+			JobID:      payload.JobID,
+			Event:      payload.Event,
+			URL:        target.URL,
+			EndpointID: target.EndpointID,
+			Attempt:    attempt + 1,
+			Format:     format,
+			Payload:    string(body),
+		})
 		if err == nil {
-			log.Printf("Webhook sent successfully for job %s", payload.JobID)
+			requestid.Logf(ctx, "Webhook %s sent successfully for job %s", payload.Event, payload.JobID)
 			return nil
 		}
 
 		lastErr = err
-		log.Printf("Webhook attempt %d failed for job %s: %v", attempt+1, payload.JobID, err)
+		requestid.Logf(ctx, "Webhook %s attempt %d failed for job %s: %v", payload.Event, attempt+1, payload.JobID, err)
 	}
 
 	return fmt.Errorf("webhook failed after %d attempts: %w", c.retryCount+1, lastErr)
 }
 
-func (c *Client) sendRequest(ctx context.Context, url string, jsonData []byte) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+// Redeliver sends a previously recorded delivery's body to the same URL
+// once more, freshly signed with secret and timed out per timing, whose
+// zero fields use the client's, and returns the record of the new attempt
+func (c *Client) Redeliver(ctx context.Context, previous *Delivery, secret string, timing Timing, requestID string) *Delivery {
+	delivery := &Delivery{
+		JobID:      previous.JobID,
+		Event:      previous.Event,
+		URL:        previous.URL,
+		EndpointID: previous.EndpointID,
+		Attempt:    1,
+		Redelivery: true,
+		Format:     previous.Format,
+		Payload:    previous.Payload,
+	}
+	ctx = requestid.NewContext(ctx, requestID)
+	err := c.attempt(ctx, secret, timing.or(c.Timing()).Timeout, delivery)
+	recordDelivery(delivery.EndpointID, delivery.URL, delivery.Event, err)
+	if c.onResult != nil {
+		c.onResult(&Payload{JobID: delivery.JobID, Event: delivery.Event, RequestID: requestID}, err)
+	}
+	return delivery
+}
+
+// attempt makes one delivery attempt, of at most timeout unless it is 0,
+// filling in its outcome
+func (c *Client) attempt(ctx context.Context, secret string, timeout time.Duration, delivery *Delivery) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := c.clock.Now()
+	statusCode, response, err := c.sendRequest(ctx, delivery.URL, secret, contentType(delivery.Format), []byte(delivery.Payload))
+
+	delivery.StatusCode = statusCode
+	delivery.Response = response
+	delivery.Success = err == nil
+	delivery.LatencyMS = c.clock.Now().Sub(start).Milliseconds()
+	delivery.CreatedAt = start.UTC()
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	recordAttempt(delivery)
+	if c.onAttempt != nil {
+		c.onAttempt(delivery)
+	}
+	return err
+}
+
+// sendRequest POSTs a body, returning the response status and the start of
+// the response body
+func (c *Client) sendRequest(ctx context.Context, url, secret, contentType string, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("User-Agent", "Skillcape-Transcoder/1.0")
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, c.clock.Now(), body))
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return 0, "", fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSnippet))
+	response := strings.ToValidUTF8(string(snippet), "")
+
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return nil
+		return resp.StatusCode, response, nil
 	}
 
-	return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	return resp.StatusCode, response, fmt.Errorf("webhook returned status %d", resp.StatusCode)
 }
 
 // SendAsync sends a webhook notification asynchronously
-func (c *Client) SendAsync(url string, payload *Payload) {
+func (c *Client) SendAsync(target Target, payload *Payload) {
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		defer cancel()
-
-		if err := c.Send(ctx, url, payload); err != nil {
-			log.Printf("Async webhook failed for job %s: %v", payload.JobID, err)
+		// Deliveries are bounded by their Timing's deadline
+		ctx := context.Background()
+		if err := c.Send(ctx, target, payload); err != nil {
+			requestid.Logf(requestid.NewContext(ctx, payload.RequestID), "Async webhook failed for job %s: %v", payload.JobID, err)
 		}
 	}()
 }
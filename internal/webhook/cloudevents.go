@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Delivery body formats
+const (
+	FormatJSON        = "json"
+	FormatCloudEvents = "cloudevents"
+)
+
+// DefaultCloudEventsSource identifies this service in CloudEvents when no
+// source is configured
+const DefaultCloudEventsSource = "/skillcape-transcoder"
+
+// cloudEventTypePrefix namespaces event names as CloudEvents types, e.g.
+// com.skillcape.transcoder.job.completed
+const cloudEventTypePrefix = "com.skillcape.transcoder."
+
+// cloudEvent is a CloudEvents 1.0 event in the structured JSON format
+type cloudEvent struct {
+	SpecVersion     string   `json:"specversion"`
+	Type            string   `json:"type"`
+	Source          string   `json:"source"`
+	ID              string   `json:"id"`
+	Time            string   `json:"time"`
+	Subject         string   `json:"subject"`
+	DataContentType string   `json:"datacontenttype"`
+	Data            *Payload `json:"data"`
+}
+
+// ValidateFormat accepts an empty format (the default) or a known one
+func ValidateFormat(format string) error {
+	switch format {
+	case "", FormatJSON, FormatCloudEvents:
+		return nil
+	}
+	return fmt.Errorf("format must be %s or %s", FormatJSON, FormatCloudEvents)
+}
+
+// contentType returns the Content-Type of a body in format
+func contentType(format string) string {
+	if format == FormatCloudEvents {
+		return "application/cloudevents+json"
+	}
+	return "application/json"
+}
+
+// encode renders payload as a delivery body in format. CloudEvents wrap the
+// webhook payload as data, identified by its event ID.
+func encode(format, source string, payload *Payload) ([]byte, error) {
+	if format != FormatCloudEvents {
+		return json.Marshal(payload)
+	}
+	return json.Marshal(&cloudEvent{
+		SpecVersion:     "1.0",
+		Type:            cloudEventTypePrefix + payload.Event,
+		Source:          source,
+		ID:              payload.EventID,
+		Time:            payload.Timestamp,
+		Subject:         "jobs/" + payload.JobID,
+		DataContentType: "application/json",
+		Data:            payload,
+	})
+}
@@ -0,0 +1,31 @@
+package webhook
+
+import "time"
+
+// maxResponseSnippet caps how much of a receiver's response body is kept
+const maxResponseSnippet = 512
+
+// Delivery records one attempt to deliver a webhook. The signed body is
+// kept so the event can be redelivered later.
+type Delivery struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	JobID      string    `json:"job_id" gorm:"index"`
+	Event      string    `json:"event"`
+	URL        string    `json:"url"`
+	EndpointID string    `json:"endpoint_id,omitempty" gorm:"index"`
+	Attempt    int       `json:"attempt"`
+	Redelivery bool      `json:"redelivery,omitempty"`
+	Format     string    `json:"format,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Success    bool      `json:"success"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Response   string    `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Payload    string    `json:"payload" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName keeps deliveries from sharing a generic table name
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/jobs"
+)
+
+// secretPrefix marks generated signing secrets
+const secretPrefix = "whsec_"
+
+// Endpoint is a registered webhook receiver. Endpoints with neither a
+// tenant nor a job receive events for every job; tenant endpoints receive
+// their tenant's jobs, and job endpoints a single job. The secret is only
+// returned when the endpoint is created.
+type Endpoint struct {
+	ID          string          `json:"id" gorm:"primaryKey"`
+	URL         string          `json:"url"`
+	Secret      string          `json:"-"`
+	Events      jobs.StringList `json:"events" gorm:"type:text"`
+	Format      string          `json:"format,omitempty"`
+	TenantID    string          `json:"tenant_id,omitempty" gorm:"index"`
+	JobID       string          `json:"job_id,omitempty" gorm:"index"`
+	Description string          `json:"description,omitempty"`
+	Disabled    bool            `json:"disabled"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+
+	// Delivery timing; zero uses the client's, from WEBHOOK_TIMEOUT and
+	// the like, and None turns off its backoff cap, jitter or deadline.
+	// See Timing.
+	TimeoutSeconds    int     `json:"timeout_seconds,omitempty"`
+	MaxBackoffSeconds int     `json:"max_backoff_seconds,omitempty"`
+	BackoffJitter     float64 `json:"backoff_jitter,omitempty"`
+	DeadlineSeconds   int     `json:"deadline_seconds,omitempty"`
+}
+
+// TableName keeps endpoints from sharing a generic table name
+func (Endpoint) TableName() string {
+	return "webhook_endpoints"
+}
+
+// Target returns where deliveries to the endpoint go
+func (e *Endpoint) Target() Target {
+	return Target{URL: e.URL, Secret: e.Secret, EndpointID: e.ID, Format: e.Format, Timing: e.Timing()}
+}
+
+// Timing returns the endpoint's delivery timing
+func (e *Endpoint) Timing() Timing {
+	return Timing{
+		Timeout:    time.Duration(e.TimeoutSeconds) * time.Second,
+		MaxBackoff: time.Duration(e.MaxBackoffSeconds) * time.Second,
+		Jitter:     e.BackoffJitter,
+		Deadline:   time.Duration(e.DeadlineSeconds) * time.Second,
+	}
+}
+
+// GenerateSecret returns a new random signing secret
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(buf), nil
+}
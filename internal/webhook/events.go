@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Job lifecycle events delivered to webhook endpoints
+const (
+	EventJobCreated        = "job.created"
+	EventJobStarted        = "job.started"
+	EventJobProgress       = "job.progress"
+	EventJobOutputUploaded = "job.output_uploaded"
+	EventJobCompleted      = "job.completed"
+	EventJobFailed         = "job.failed"
+	EventJobCancelled      = "job.cancelled"
+	EventJobExpired        = "job.expired"
+	EventJobPublished      = "job.published"
+	EventJobPublishFailed  = "job.publish_failed"
+)
+
+// AllEvents subscribes an endpoint to every event
+const AllEvents = "*"
+
+var knownEvents = map[string]bool{
+	EventJobCreated:        true,
+	EventJobStarted:        true,
+	EventJobProgress:       true,
+	EventJobOutputUploaded: true,
+	EventJobCompleted:      true,
+	EventJobFailed:         true,
+	EventJobCancelled:      true,
+	EventJobExpired:        true,
+	EventJobPublished:      true,
+	EventJobPublishFailed:  true,
+	AllEvents:              true,
+}
+
+// DefaultEvents are sent to endpoints that haven't chosen their own: the
+// terminal notifications webhooks have always received
+var DefaultEvents = []string{EventJobCompleted, EventJobFailed}
+
+// ValidateEvents checks that every entry names a known event or "*"
+func ValidateEvents(events []string) error {
+	for _, event := range events {
+		if !knownEvents[event] {
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	return nil
+}
+
+// NormalizeEvents trims and lowercases a subscription list, dropping blanks
+// and duplicates
+func NormalizeEvents(events []string) []string {
+	seen := make(map[string]bool, len(events))
+	result := make([]string, 0, len(events))
+	for _, event := range events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if event == "" || seen[event] {
+			continue
+		}
+		seen[event] = true
+		result = append(result, event)
+	}
+	return result
+}
+
+// Subscribed reports whether a subscription list includes event
+func Subscribed(events []string, event string) bool {
+	for _, e := range events {
+		if e == event || e == AllEvents {
+			return true
+		}
+	}
+	return false
+}
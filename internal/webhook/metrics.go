@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/metrics"
+)
+
+// Delivery metrics share the endpoint and event labels so they can be
+// joined with each other and with the recorded deliveries
+var (
+	attemptCounter = metrics.NewCounter("transcoder_webhook_attempts_total",
+		"Webhook delivery attempts, including retries and redeliveries.", "endpoint", "event", "result")
+	deliveryCounter = metrics.NewCounter("transcoder_webhook_deliveries_total",
+		"Webhook deliveries by final outcome, after any retries.", "endpoint", "event", "result")
+	latencyHistogram = metrics.NewHistogram("transcoder_webhook_latency_seconds",
+		"Time for a webhook receiver to respond to one attempt.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "endpoint")
+	lastSuccessGauge = metrics.NewGauge("transcoder_webhook_last_success_timestamp_seconds",
+		"When an attempt to the endpoint last succeeded, as a Unix time.", "endpoint")
+	lastErrorGauge = metrics.NewGauge("transcoder_webhook_last_error_timestamp_seconds",
+		"When an attempt to the endpoint last failed, as a Unix time.", "endpoint")
+	lastStatusGauge = metrics.NewGauge("transcoder_webhook_last_error_status_code",
+		"HTTP status of the endpoint's last failed attempt; 0 when it sent no response.", "endpoint")
+)
+
+// recordAttempt updates the metrics for one finished attempt
+func recordAttempt(delivery *Delivery) {
+	endpoint := endpointLabel(delivery.EndpointID, delivery.URL)
+	attemptCounter.Inc(endpoint, delivery.Event, result(delivery.Success))
+	latencyHistogram.Observe(float64(delivery.LatencyMS)/1000, endpoint)
+
+	at := float64(delivery.CreatedAt.Add(time.Duration(delivery.LatencyMS) * time.Millisecond).Unix())
+	if delivery.Success {
+		lastSuccessGauge.Set(at, endpoint)
+		return
+	}
+	lastErrorGauge.Set(at, endpoint)
+	lastStatusGauge.Set(float64(delivery.StatusCode), endpoint)
+}
+
+// recordDelivery counts the final outcome of a delivery
+func recordDelivery(endpointID, rawURL, event string, err error) {
+	deliveryCounter.Inc(endpointLabel(endpointID, rawURL), event, result(err == nil))
+}
+
+// endpointLabel identifies a receiver in metrics: its endpoint ID when it
+// is registered, otherwise the host of its URL so per-job URLs don't each
+// get their own series
+func endpointLabel(endpointID, rawURL string) string {
+	if endpointID != "" {
+		return endpointID
+	}
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "unknown"
+}
+
+func result(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
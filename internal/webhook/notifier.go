@@ -0,0 +1,268 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/skillcape/transcoder/internal/clock"
+	"github.com/skillcape/transcoder/internal/ids"
+	"github.com/skillcape/transcoder/internal/jobs"
+	"github.com/skillcape/transcoder/internal/requestid"
+	"github.com/skillcape/transcoder/internal/tenant"
+)
+
+// EndpointLookup returns the enabled registered endpoints that receive
+// events for a job: global ones, the tenant's, and the job's own
+type EndpointLookup func(tenantID, jobID string) ([]Endpoint, error)
+
+// Publisher sends events to a message bus; see the eventbus package
+type Publisher interface {
+	Publish(ctx context.Context, key string, body []byte) error
+}
+
+// publishTimeout bounds each message bus publish
+const publishTimeout = 30 * time.Second
+
+// publishQueueSize is how many events may wait to be published before
+// more are dropped, so a bus that is down can't hold up jobs
+const publishQueueSize = 1024
+
+// busMessage is an event waiting to be published
+type busMessage struct {
+	event     string
+	jobID     string
+	requestID string
+	body      []byte
+}
+
+// Notifier delivers job lifecycle events to every endpoint responsible for
+// a job that subscribes to them
+type Notifier struct {
+	mu            sync.RWMutex
+	client        *Client
+	defaultURL    string
+	defaultEvents []string
+	endpoints     EndpointLookup
+	static        []Endpoint
+	progress      ProgressThrottle
+	bus           Publisher
+	busEvents     []string
+	busQueue      chan busMessage
+	clock         clock.Clock
+	ids           ids.Generator
+}
+
+// NewNotifier creates a notifier. defaultURL and defaultEvents apply to
+// jobs whose tenant and job settings don't name a webhook URL, and
+// defaultEvents to URLs without their own subscription list. endpoints
+// finds registered endpoints and may be nil.
+func NewNotifier(client *Client, defaultURL string, defaultEvents []string, endpoints EndpointLookup) *Notifier {
+	if len(defaultEvents) == 0 {
+		defaultEvents = DefaultEvents
+	}
+	return &Notifier{
+		client:        client,
+		defaultURL:    defaultURL,
+		defaultEvents: defaultEvents,
+		endpoints:     endpoints,
+		progress:      ProgressThrottle{Step: 10},
+		clock:         clock.System,
+		ids:           ids.UUIDs,
+	}
+}
+
+// SetClock replaces the clock that stamps events
+func (n *Notifier) SetClock(c clock.Clock) {
+	n.clock = c
+}
+
+// SetIDs replaces the generator of event IDs, random UUIDs by default
+func (n *Notifier) SetIDs(generator ids.Generator) {
+	n.ids = generator
+}
+
+// SetProgressThresholds configures when job.progress events are sent; see
+// ProgressThrottle
+func (n *Notifier) SetProgressThresholds(step int, interval, minInterval time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.progress = ProgressThrottle{Step: step, Interval: interval, MinInterval: minInterval}
+}
+
+// SetStaticEndpoints adds endpoints that receive events for every job
+// without being registered, such as those in the config file
+func (n *Notifier) SetStaticEndpoints(endpoints []Endpoint) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.static = endpoints
+}
+
+// StaticEndpoint returns the static endpoint with the given ID, or nil
+func (n *Notifier) StaticEndpoint(id string) *Endpoint {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, endpoint := range n.static {
+		if endpoint.ID == id {
+			return &endpoint
+		}
+	}
+	return nil
+}
+
+// SetDefaults replaces the default webhook URL and events given to
+// NewNotifier
+func (n *Notifier) SetDefaults(defaultURL string, defaultEvents []string) {
+	if len(defaultEvents) == 0 {
+		defaultEvents = DefaultEvents
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.defaultURL = defaultURL
+	n.defaultEvents = defaultEvents
+}
+
+// SetPublisher also publishes the given events to a message bus, keyed by
+// job ID, with the same JSON body as webhooks. They are published one at a
+// time in the order they happened, so that each job's events reach the bus
+// in order.
+func (n *Notifier) SetPublisher(bus Publisher, events []string) {
+	n.bus = bus
+	n.busEvents = events
+	n.busQueue = make(chan busMessage, publishQueueSize)
+	go n.publishQueued()
+}
+
+// ProgressThrottle returns a fresh throttle for one job's progress updates
+func (n *Notifier) ProgressThrottle() *ProgressThrottle {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	throttle := n.progress
+	return &throttle
+}
+
+// Endpoint returns the webhook URL and subscribed events for a job: its
+// own webhook_url, then its tenant's, then WEBHOOK_URL. t may be nil.
+func (n *Notifier) Endpoint(job *jobs.Job, t *tenant.Tenant) (string, []string) {
+	switch {
+	case job.WebhookURL != "":
+		return job.WebhookURL, n.eventsOr(job.HookEvents)
+	case t != nil && t.WebhookURL != "":
+		return t.WebhookURL, n.eventsOr(t.WebhookEvents)
+	default:
+		n.mu.RLock()
+		defer n.mu.RUnlock()
+		return n.defaultURL, n.defaultEvents
+	}
+}
+
+func (n *Notifier) eventsOr(events []string) []string {
+	if len(events) == 0 {
+		n.mu.RLock()
+		defer n.mu.RUnlock()
+		return n.defaultEvents
+	}
+	return events
+}
+
+// targets lists where event for job goes: the webhook URL from Endpoint and
+// each subscribed registered endpoint, once per URL
+func (n *Notifier) targets(job *jobs.Job, t *tenant.Tenant, event string) []Target {
+	var targets []Target
+	seen := make(map[string]bool)
+	if url, events := n.Endpoint(job, t); url != "" && Subscribed(events, event) {
+		targets = append(targets, Target{URL: url, Secret: n.client.Secret()})
+		seen[url] = true
+	}
+
+	n.mu.RLock()
+	endpoints := n.static
+	n.mu.RUnlock()
+	if n.endpoints != nil {
+		registered, err := n.endpoints(job.TenantID, job.ID)
+		if err != nil {
+			log.Printf("Failed to look up webhook endpoints for job %s: %v", job.ID, err)
+		}
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], registered...)
+	}
+	for i := range endpoints {
+		endpoint := &endpoints[i]
+		if seen[endpoint.URL] || !Subscribed(endpoint.Events, event) {
+			continue
+		}
+		targets = append(targets, endpoint.Target())
+		seen[endpoint.URL] = true
+	}
+	return targets
+}
+
+// Notify sends event for job in the background to every endpoint that
+// subscribes to it, and to the message bus if one is set. payload may be
+// nil or carry event-specific fields; the job's identity and status are
+// filled in. A nil Notifier does nothing.
+func (n *Notifier) Notify(job *jobs.Job, t *tenant.Tenant, event string, payload *Payload) {
+	if n == nil {
+		return
+	}
+	targets := n.targets(job, t, event)
+	publish := n.bus != nil && Subscribed(n.busEvents, event)
+	if len(targets) == 0 && !publish {
+		return
+	}
+
+	if payload == nil {
+		payload = &Payload{}
+	}
+	payload.Event = event
+	payload.EventID = n.ids.NewID()
+	payload.JobID = job.ID
+	payload.Status = string(job.Status)
+	payload.OriginalName = job.OriginalName
+	payload.RequestID = job.RequestID
+	payload.Timestamp = n.clock.Now().UTC().Format(time.RFC3339)
+	for _, target := range targets {
+		n.client.SendAsync(target, payload)
+	}
+	if publish {
+		n.enqueue(payload)
+	}
+}
+
+// enqueue adds an event to those waiting to be published, encoding it now
+// as the payload is shared with webhook deliveries
+func (n *Notifier) enqueue(payload *Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode %s for job %s: %v", payload.Event, payload.JobID, err)
+		return
+	}
+	select {
+	case n.busQueue <- busMessage{event: payload.Event, jobID: payload.JobID, requestID: payload.RequestID, body: body}:
+	default:
+		log.Printf("Dropped %s for job %s: %d events are waiting to be published", payload.Event, payload.JobID, publishQueueSize)
+	}
+}
+
+// publishQueued publishes queued events, one at a time
+func (n *Notifier) publishQueued() {
+	for message := range n.busQueue {
+		ctx, cancel := context.WithTimeout(requestid.NewContext(context.Background(), message.requestID), publishTimeout)
+		if err := n.bus.Publish(ctx, message.jobID, message.body); err != nil {
+			requestid.Logf(ctx, "Failed to publish %s for job %s: %v", message.event, message.jobID, err)
+		}
+		cancel()
+	}
+}
+
+// Redeliver resends a recorded delivery; see Client.Redeliver. endpoint is
+// the registered endpoint it was sent to, whose secret and timing it uses,
+// or nil for webhook URLs signed with WEBHOOK_SECRET.
+func (n *Notifier) Redeliver(ctx context.Context, previous *Delivery, endpoint *Endpoint, requestID string) *Delivery {
+	secret, timing := n.client.Secret(), Timing{}
+	if endpoint != nil {
+		secret, timing = endpoint.Secret, endpoint.Timing()
+	}
+	return n.client.Redeliver(ctx, previous, secret, timing, requestID)
+}
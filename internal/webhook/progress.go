@@ -0,0 +1,43 @@
+package webhook
+
+import "time"
+
+// ProgressThrottle decides which progress updates of one job are worth a
+// job.progress webhook. Updates are due when progress crosses a multiple of
+// Step percent or Interval has passed since the last event, but never
+// sooner than MinInterval after it; thresholds skipped meanwhile are folded
+// into the next event. Zero Step or Interval disables that trigger.
+type ProgressThrottle struct {
+	Step        int
+	Interval    time.Duration
+	MinInterval time.Duration
+
+	lastProgress int
+	lastSent     time.Time
+}
+
+// Due reports whether progress should be sent now, and if so records it as
+// sent. 100% is left to job.completed.
+func (p *ProgressThrottle) Due(progress int, now time.Time) bool {
+	if p.lastSent.IsZero() {
+		// The clock for Interval starts with the first update
+		p.lastSent = now
+	}
+	if progress >= 100 || progress <= p.lastProgress {
+		return false
+	}
+
+	elapsed := now.Sub(p.lastSent)
+	stepDue := p.Step > 0 && progress/p.Step > p.lastProgress/p.Step
+	timeDue := p.Interval > 0 && elapsed >= p.Interval
+	if !stepDue && !timeDue {
+		return false
+	}
+	if p.lastProgress > 0 && elapsed < p.MinInterval {
+		return false
+	}
+
+	p.lastProgress = progress
+	p.lastSent = now
+	return true
+}
@@ -0,0 +1,22 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// SignatureHeader carries the HMAC signature of a webhook delivery
+const SignatureHeader = "X-Webhook-Signature"
+
+// Sign returns the signature header value for body sent at timestamp, in
+// the form "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := timestamp.Unix()
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
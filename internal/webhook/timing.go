@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timing bounds how long deliveries take and spaces out their retries.
+// Retries wait 1s, doubling each time up to MaxBackoff.
+type Timing struct {
+	Timeout    time.Duration // of each attempt, until the response is read
+	MaxBackoff time.Duration // longest wait between attempts; 0 doesn't cap it
+	Jitter     float64       // fraction of each wait added or taken away at random, from 0 to 1
+	Deadline   time.Duration // for all attempts and the waits between them; 0 is none
+}
+
+// DefaultTiming is the client's timing until SetTiming replaces it
+var DefaultTiming = Timing{Timeout: 30 * time.Second, Deadline: 5 * time.Minute}
+
+// None, as an endpoint's max backoff, jitter or deadline, turns off the
+// client's rather than inheriting it as zero does. In a Timing it is -1
+// second for the durations and -1 for Jitter.
+const None = -1
+
+// Validate checks a timing. A zero field is allowed, as for endpoints it
+// means the client's, and so is None where it applies.
+func (t Timing) Validate() error {
+	switch {
+	case t.Timeout < 0:
+		return fmt.Errorf("timeout must not be negative")
+	case t.MaxBackoff < 0 && t.MaxBackoff != None*time.Second:
+		return fmt.Errorf("max backoff must not be negative, other than -1 for none")
+	case t.Jitter != None && (t.Jitter < 0 || t.Jitter > 1):
+		return fmt.Errorf("jitter must be between 0 and 1, or -1 for none")
+	case t.Deadline < 0 && t.Deadline != None*time.Second:
+		return fmt.Errorf("deadline must not be negative, other than -1 for none")
+	}
+	return nil
+}
+
+// or returns t with its zero fields taken from defaults, and those set to
+// None, by either, turned off
+func (t Timing) or(defaults Timing) Timing {
+	if t.Timeout == 0 {
+		t.Timeout = defaults.Timeout
+	}
+	if t.MaxBackoff == 0 {
+		t.MaxBackoff = defaults.MaxBackoff
+	}
+	if t.Jitter == 0 {
+		t.Jitter = defaults.Jitter
+	}
+	if t.Deadline == 0 {
+		t.Deadline = defaults.Deadline
+	}
+	if t.MaxBackoff == None*time.Second {
+		t.MaxBackoff = 0
+	}
+	if t.Jitter == None {
+		t.Jitter = 0
+	}
+	if t.Deadline == None*time.Second {
+		t.Deadline = 0
+	}
+	return t
+}
+
+// backoff returns the wait before the given retry, counting from 1.
+// random, from 0 to 1, picks where within the jitter the wait falls.
+func (t Timing) backoff(retry int, random float64) time.Duration {
+	wait := time.Second << min(retry-1, 30)
+	if t.MaxBackoff > 0 {
+		wait = min(wait, t.MaxBackoff)
+	}
+	return time.Duration(float64(wait) * (1 + t.Jitter*(2*random-1)))
+}
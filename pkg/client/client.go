@@ -0,0 +1,176 @@
+// Package client is a Go client for the Skillcape Transcoder API.
+//
+//	c := client.New("https://transcoder.example.com", os.Getenv("TRANSCODER_API_KEY"))
+//	job, err := c.CreateJobFromFile(ctx, "talk.mov", &client.JobOptions{Preset: "720p"})
+//	job, err = c.WaitForJob(ctx, job.ID, 5*time.Second, nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the transcoder API with an API key or bearer token
+type Client struct {
+	baseURL    string
+	apiKey     string
+	token      string
+	httpClient *http.Client
+	userAgent  string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client. Uploads can take a long
+// time, so the default has no overall timeout; rely on contexts instead.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBearerToken authenticates with a JWT instead of an API key
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithUserAgent sets the User-Agent sent with every request
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New creates a client for the server at baseURL (e.g.
+// "http://localhost:8080") authenticating with apiKey
+func New(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+		userAgent:  "skillcape-transcoder-go",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is returned when the API responds with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Message    string
+	RequestID  string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("transcoder: %d %s", e.StatusCode, e.Message)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is an APIError with status 404
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// newRequest builds an authenticated request for an API path
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	return req, nil
+}
+
+// doJSON sends in as a JSON body (if non-nil) and decodes the response into
+// out (if non-nil)
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+// do sends req and decodes a successful response into out
+func (c *Client) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("transcoder: decoding response: %w", err)
+	}
+	return nil
+}
+
+// decodeError turns an error response into an APIError
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Error != "" {
+		apiErr.Message = body.Error
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(retryAfter, "%d", &seconds); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return apiErr
+}
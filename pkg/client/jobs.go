@@ -0,0 +1,754 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+	StatusRetrying   = "retrying"
+	StatusDeadLetter = "dead_letter"
+	StatusExpired    = "expired"
+)
+
+// Encoder choices of jobs; the preset decides when a job makes none
+const (
+	EncodingAuto     = "auto"     // the server's hardware encoder when it can stand in for the preset's codec
+	EncodingSoftware = "software" // never the hardware encoder
+	EncodingHardware = "hardware" // only the hardware encoder; the job fails without one
+)
+
+// How jobs deliver their output
+const (
+	PackagingFile    = "file"     // a single file
+	PackagingHLS     = "hls"      // an HLS playlist of MPEG-TS segments
+	PackagingHLSFMP4 = "hls_fmp4" // an HLS playlist of fragmented MP4 segments
+)
+
+// Error codes of failed jobs, see Job.ErrorCode
+const (
+	ErrorInputCorrupt      = "INPUT_CORRUPT"
+	ErrorUnsupportedCodec  = "UNSUPPORTED_CODEC"
+	ErrorDiskFull          = "DISK_FULL"
+	ErrorOutOfMemory       = "OUT_OF_MEMORY"
+	ErrorInterrupted       = "INTERRUPTED"
+	ErrorDestQuotaExceeded = "DEST_QUOTA_EXCEEDED"
+	ErrorTimeout           = "TIMEOUT"
+	ErrorInputRejected     = "INPUT_REJECTED"
+	ErrorInputMissing      = "INPUT_MISSING"
+	ErrorInvalidSettings   = "INVALID_SETTINGS"
+	ErrorHookFailed        = "HOOK_FAILED"
+	ErrorTranscodeFailed   = "TRANSCODE_FAILED"
+	ErrorUploadFailed      = "UPLOAD_FAILED"
+)
+
+// Job is a transcoding job as returned by the v1 API
+type Job struct {
+	ID           string       `json:"id"`
+	Status       string       `json:"status"`
+	Progress     int          `json:"progress"`
+	Stage        string       `json:"stage,omitempty"`
+	DriveURL     string       `json:"drive_url,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	ErrorCode    string       `json:"error_code,omitempty"`
+	Rejection    string       `json:"rejection,omitempty"` // why the server's acceptance rules rejected the input
+	OriginalName string       `json:"original_name"`
+	InputNames   []string     `json:"input_names,omitempty"`
+	OutputName   string       `json:"output_name"`
+	Metadata     *Metadata    `json:"metadata,omitempty"`
+	Cover        bool         `json:"cover,omitempty"`
+	Captions     bool         `json:"captions,omitempty"`
+	Poster       bool         `json:"poster,omitempty"`
+	PosterURL    string       `json:"poster_url,omitempty"`
+	Player       *PlayerFiles `json:"player_assets,omitempty"`
+	HLS          *HLSFiles    `json:"hls,omitempty"`
+	Preset       string       `json:"preset,omitempty"`
+	Renditions   []string     `json:"renditions,omitempty"`
+	Parallelism  int          `json:"rendition_parallelism,omitempty"`
+	Encoding     string       `json:"encoding,omitempty"`
+	Packaging    string       `json:"packaging,omitempty"`
+	VideoEncoder string       `json:"video_encoder,omitempty"` // ffmpeg's encoder of the video, e.g. h264_nvenc
+	Destination  string       `json:"destination,omitempty"`
+	Labels       []string     `json:"labels,omitempty"`
+	Priority     int          `json:"priority"`
+	ScheduledAt  *time.Time   `json:"scheduled_at,omitempty"`
+	BoostedAt    *time.Time   `json:"boosted_at,omitempty"` // when an operator moved the job to the front of the queue
+	WebhookURL   string       `json:"webhook_url,omitempty"`
+	HookEvents   []string     `json:"webhook_events,omitempty"`
+	NotifyEmails []string     `json:"notify_emails,omitempty"`
+	Owner        string       `json:"owner,omitempty"`
+	TenantID     string       `json:"tenant_id,omitempty"`
+	RequestID    string       `json:"request_id,omitempty"`
+	InputSize    int64        `json:"input_size"`
+	InputHash    string       `json:"input_hash,omitempty"`   // SHA-256 of the inputs
+	DuplicateOf  string       `json:"duplicate_of,omitempty"` // an earlier job with the same input, if any
+	Duration     float64      `json:"duration,omitempty"`
+	InputProbe   *MediaInfo   `json:"input_probe,omitempty"`
+	OutputProbe  *MediaInfo   `json:"output_probe,omitempty"`
+	AudioDrift   float64      `json:"audio_drift,omitempty"`  // seconds the output's audio outlasts its video, if out of sync
+	CPUSeconds   float64      `json:"cpu_seconds,omitempty"`  // CPU time ffmpeg took, over every attempt
+	WallSeconds  float64      `json:"wall_seconds,omitempty"` // time workers spent on the job, over every attempt
+	BytesOut     int64        `json:"bytes_out,omitempty"`    // bytes delivered to destinations
+	EncodeStats  *EncodeStats `json:"encode_stats,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	CompletedAt  *time.Time   `json:"completed_at,omitempty"`
+}
+
+// PlayerFiles are the files the server makes for a video player beside a
+// job's output. URL is of the manifest, a JSON file linking to the output,
+// its renditions, poster, thumbnails and captions, which a player can be
+// configured from.
+type PlayerFiles struct {
+	Interval      float64 `json:"interval"` // seconds between thumbnails
+	Width         int     `json:"width"`    // of each thumbnail
+	Height        int     `json:"height"`
+	Columns       int     `json:"columns"`
+	Count         int     `json:"count"`
+	SpriteURL     string  `json:"sprite_url,omitempty"`
+	ThumbnailsURL string  `json:"thumbnails_url,omitempty"`
+	CaptionsURL   string  `json:"captions_url,omitempty"`
+	URL           string  `json:"url,omitempty"`
+}
+
+// HLSFiles describe a job's output packaged for HLS. URL is of the
+// playlist, which is also the job's DriveURL. Jobs with renditions list
+// the streams of their adaptive ladder, and the playlist is the master
+// playlist.
+type HLSFiles struct {
+	SegmentType string      `json:"segment_type"` // ts or fmp4
+	Segments    int         `json:"segments"`
+	URL         string      `json:"url,omitempty"`
+	Streams     []HLSStream `json:"streams,omitempty"`
+}
+
+// HLSStream is a stream of an adaptive ladder: the job's output, named
+// main, or a rendition, named after its preset
+type HLSStream struct {
+	Name      string `json:"name"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Bandwidth int64  `json:"bandwidth"` // peak bits per second
+	Segments  int    `json:"segments"`
+	URL       string `json:"url,omitempty"` // of its playlist
+}
+
+// Metadata is written into a job's output: tags such as title and artist,
+// and chapter markers. MP3 outputs carry it as ID3v2 tags.
+type Metadata struct {
+	Tags     map[string]string `json:"tags,omitempty"`
+	Chapters []Chapter         `json:"chapters,omitempty"`
+}
+
+// Chapter marks where a chapter starts, in seconds
+type Chapter struct {
+	Start float64 `json:"start"`
+	Title string  `json:"title"`
+}
+
+// EncodeStats are a job's encoding speed figures: ffmpeg's latest while it
+// is processing, averages over the encode once it has finished
+type EncodeStats struct {
+	Frames      int64   `json:"frames,omitempty"`
+	FPS         float64 `json:"fps"`
+	Speed       float64 `json:"speed"`
+	BitrateKbps float64 `json:"bitrate_kbps"`
+	ETA         float64 `json:"eta_seconds,omitempty"`
+}
+
+// MediaInfo is the server's ffprobe summary of a job's input or output
+type MediaInfo struct {
+	Format         string        `json:"format"`
+	Duration       float64       `json:"duration"`
+	Size           int64         `json:"size"`
+	BitRate        int64         `json:"bit_rate,omitempty"`
+	Width          int           `json:"width,omitempty"`
+	Height         int           `json:"height,omitempty"`
+	FrameRate      float64       `json:"frame_rate,omitempty"`
+	VideoCodec     string        `json:"video_codec,omitempty"`
+	AudioCodec     string        `json:"audio_codec,omitempty"`
+	ClosedCaptions bool          `json:"closed_captions,omitempty"` // CEA-608/708 captions in the video
+	Streams        []MediaStream `json:"streams"`
+}
+
+// MediaStream describes one stream of a probed file
+type MediaStream struct {
+	Index          int     `json:"index"`
+	Type           string  `json:"type"`
+	Codec          string  `json:"codec"`
+	Profile        string  `json:"profile,omitempty"`
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	FrameRate      float64 `json:"frame_rate,omitempty"`
+	PixelFormat    string  `json:"pixel_format,omitempty"`
+	SampleRate     int     `json:"sample_rate,omitempty"`
+	Channels       int     `json:"channels,omitempty"`
+	ChannelLayout  string  `json:"channel_layout,omitempty"`
+	BitRate        int64   `json:"bit_rate,omitempty"`
+	Duration       float64 `json:"duration,omitempty"`
+	Language       string  `json:"language,omitempty"`
+	ClosedCaptions bool    `json:"closed_captions,omitempty"`
+}
+
+// Done reports whether the job has reached a final status
+func (j *Job) Done() bool {
+	switch j.Status {
+	case StatusCompleted, StatusFailed, StatusCancelled, StatusDeadLetter, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// JobEvent is one entry in a job's history
+type JobEvent struct {
+	JobID     string    `json:"job_id"`
+	Type      string    `json:"type"`
+	Message   string    `json:"message,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JobOptions are the optional settings for a new job
+type JobOptions struct {
+	Preset      string
+	Renditions  []string
+	Parallelism int    // renditions encoded at once, at most the server's RENDITION_PARALLELISM; 0 for that
+	Encoding    string // EncodingSoftware or EncodingHardware overrides the preset's
+	Packaging   string // PackagingHLS or PackagingHLSFMP4 delivers an HLS playlist instead of a file
+	Destination string // destination profile, e.g. "s3-staging"
+	Labels      []string
+	Priority    int
+	ScheduledAt time.Time
+	WebhookURL  string
+	HookEvents  []string // events sent to WebhookURL, e.g. EventJobProgress
+	Recipients  []string // addresses emailed when the job finishes
+	OutputName  string   // file name or template, e.g. "{{original_basename}}-{{preset}}.mp4"
+	Metadata    *Metadata
+	Cover       string // path of a PNG or JPEG embedded as cover art by audio presets
+	Captions    string // path of WebVTT captions shown on the playback page
+	UploadID    string // names the upload so GetUpload can follow it from elsewhere; 8-64 letters, digits, '_' or '-'
+	Attach      bool   // return a pending or processing job of the same file and settings instead of creating one
+}
+
+// attachments returns the files uploaded alongside the input, by part name
+func (o *JobOptions) attachments() [][2]string {
+	var files [][2]string
+	if o.Cover != "" {
+		files = append(files, [2]string{"cover", o.Cover})
+	}
+	if o.Captions != "" {
+		files = append(files, [2]string{"captions", o.Captions})
+	}
+	return files
+}
+
+// payload encodes the options as the multipart "payload" part
+func (o *JobOptions) payload() ([]byte, error) {
+	body := map[string]interface{}{}
+	if o.Preset != "" {
+		body["preset"] = o.Preset
+	}
+	if len(o.Renditions) > 0 {
+		body["renditions"] = o.Renditions
+	}
+	if o.Parallelism > 0 {
+		body["rendition_parallelism"] = o.Parallelism
+	}
+	if o.Encoding != "" {
+		body["encoding"] = o.Encoding
+	}
+	if o.Packaging != "" {
+		body["packaging"] = o.Packaging
+	}
+	if o.Destination != "" {
+		body["destination"] = o.Destination
+	}
+	if len(o.Labels) > 0 {
+		body["labels"] = o.Labels
+	}
+	if o.Priority != 0 {
+		body["priority"] = o.Priority
+	}
+	if !o.ScheduledAt.IsZero() {
+		body["scheduled_at"] = o.ScheduledAt.UTC().Format(time.RFC3339)
+	}
+	if o.WebhookURL != "" {
+		body["webhook_url"] = o.WebhookURL
+	}
+	if len(o.Recipients) > 0 {
+		body["notify_emails"] = o.Recipients
+	}
+	if len(o.HookEvents) > 0 {
+		body["webhook_events"] = o.HookEvents
+	}
+	if o.OutputName != "" {
+		body["output_name"] = o.OutputName
+	}
+	if o.Metadata != nil {
+		body["metadata"] = o.Metadata
+	}
+	return json.Marshal(body)
+}
+
+// CreateJob uploads the contents of r as fileName and creates a job. The
+// body is streamed, so r is never buffered in memory.
+func (c *Client) CreateJob(ctx context.Context, fileName string, r io.Reader, opts *JobOptions) (*Job, error) {
+	var fields, attachments [][2]string
+	if opts != nil {
+		payload, err := opts.payload()
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			fields = append(fields, [2]string{"payload", string(payload)})
+		}
+		if opts.Attach {
+			fields = append(fields, [2]string{"attach", "true"})
+		}
+		attachments = opts.attachments()
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUpload(mw, fileName, r, fields, attachments))
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/jobs", nil, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if opts != nil && opts.UploadID != "" {
+		req.Header.Set("X-Upload-ID", opts.UploadID)
+	}
+
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// writeUpload writes the multipart body for CreateJob: the form fields,
+// by name, then each attachment's file as the part it names
+func writeUpload(mw *multipart.Writer, fileName string, r io.Reader, fields, attachments [][2]string) error {
+	for _, field := range fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range attachments {
+		if err := writeAttachment(mw, attachment[0], attachment[1]); err != nil {
+			return err
+		}
+	}
+	part, err := mw.CreateFormFile("file", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// writeAttachment writes the file at path as the multipart part name
+func writeAttachment(mw *multipart.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := mw.CreateFormFile(name, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+// CreateJobFromFile uploads a local file and creates a job
+func (c *Client) CreateJobFromFile(ctx context.Context, path string, opts *JobOptions) (*Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.CreateJob(ctx, filepath.Base(path), f, opts)
+}
+
+// GetJob fetches a job by ID
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// ListOptions filters, sorts, and pages ListJobs
+type ListOptions struct {
+	Status        []string
+	OriginalName  string
+	Preset        string
+	Label         string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Sort          string
+	Ascending     bool
+	Limit         int
+	Offset        int
+}
+
+// query encodes the options as list query parameters
+func (o *ListOptions) query() url.Values {
+	q := url.Values{}
+	if len(o.Status) > 0 {
+		q.Set("status", strings.Join(o.Status, ","))
+	}
+	if o.OriginalName != "" {
+		q.Set("original_name", o.OriginalName)
+	}
+	if o.Preset != "" {
+		q.Set("preset", o.Preset)
+	}
+	if o.Label != "" {
+		q.Set("label", o.Label)
+	}
+	if !o.CreatedAfter.IsZero() {
+		q.Set("created_after", o.CreatedAfter.UTC().Format(time.RFC3339))
+	}
+	if !o.CreatedBefore.IsZero() {
+		q.Set("created_before", o.CreatedBefore.UTC().Format(time.RFC3339))
+	}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if o.Ascending {
+		q.Set("order", "asc")
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return q
+}
+
+// JobList is one page of ListJobs results
+type JobList struct {
+	Jobs   []Job `json:"jobs"`
+	Total  int64 `json:"total"`
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+}
+
+// ListJobs returns one page of jobs matching opts (nil lists the newest)
+func (c *Client) ListJobs(ctx context.Context, opts *ListOptions) (*JobList, error) {
+	var query url.Values
+	if opts != nil {
+		query = opts.query()
+	}
+
+	var list JobList
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/jobs", query, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// JobUpdate lists the settings to change on a pending job; nil fields are
+// left untouched
+type JobUpdate struct {
+	Priority    *int      `json:"priority,omitempty"`
+	WebhookURL  *string   `json:"webhook_url,omitempty"`
+	Preset      *string   `json:"preset,omitempty"`
+	Renditions  *[]string `json:"renditions,omitempty"`
+	Parallelism *int      `json:"rendition_parallelism,omitempty"`
+	Encoding    *string   `json:"encoding,omitempty"`
+	Packaging   *string   `json:"packaging,omitempty"`
+	Destination *string   `json:"destination,omitempty"`
+	Labels      *[]string `json:"labels,omitempty"`
+	ScheduledAt *string   `json:"scheduled_at,omitempty"`
+	OutputName  *string   `json:"output_name,omitempty"`
+	HookEvents  *[]string `json:"webhook_events,omitempty"`
+	Recipients  *[]string `json:"notify_emails,omitempty"`
+	Metadata    *Metadata `json:"metadata,omitempty"`
+}
+
+// UpdateJob changes the settings of a pending job
+func (c *Client) UpdateJob(ctx context.Context, id string, update *JobUpdate) (*Job, error) {
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.doJSON(ctx, http.MethodPatch, "/api/v1/jobs/"+url.PathEscape(id), nil, update, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// PrioritizeJob moves a pending job to the front of the queue. It needs
+// the admin or operator role.
+func (c *Client) PrioritizeJob(ctx context.Context, id string) (*Job, error) {
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/api/v1/jobs/"+url.PathEscape(id)+"/prioritize", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// UploadProgress is how much of an upload sent with JobOptions.UploadID
+// has arrived
+type UploadProgress struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"` // "receiving", "completed" or "failed"
+	Received   int64      `json:"bytes_received"`
+	Expected   int64      `json:"bytes_expected"` // -1 if unknown
+	Percent    *int       `json:"percent,omitempty"`
+	JobIDs     []string   `json:"job_ids,omitempty"`
+	HTTPStatus int        `json:"http_status,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// GetUpload reports the progress of an upload sent with
+// JobOptions.UploadID, from the server receiving it
+func (c *Client) GetUpload(ctx context.Context, id string) (*UploadProgress, error) {
+	var progress UploadProgress
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/uploads/"+url.PathEscape(id), nil, nil, &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// CancelJob cancels a pending or processing job, or deletes a finished one
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/api/v1/jobs/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// GetJobEvents returns a job's event history, oldest first
+func (c *Client) GetJobEvents(ctx context.Context, id string) ([]JobEvent, error) {
+	var resp struct {
+		Events []JobEvent `json:"events"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id)+"/events", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// DownloadLink is a signed URL for a job's output
+type DownloadLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateDownloadLink issues a signed link to a completed job's output.
+// A zero expiresIn uses the server's default lifetime.
+func (c *Client) CreateDownloadLink(ctx context.Context, id string, expiresIn time.Duration) (*DownloadLink, error) {
+	var body interface{}
+	if expiresIn > 0 {
+		body = map[string]int{"expires_in": int(expiresIn / time.Second)}
+	}
+
+	var link DownloadLink
+	if err := c.doJSON(ctx, http.MethodPost, "/api/v1/jobs/"+url.PathEscape(id)+"/download-link", nil, body, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// CreatePlaybackLink issues a signed link to a web page that plays a
+// completed job's output. The server must have playback pages enabled.
+func (c *Client) CreatePlaybackLink(ctx context.Context, id string, expiresIn time.Duration) (*DownloadLink, error) {
+	var body interface{}
+	if expiresIn > 0 {
+		body = map[string]int{"expires_in": int(expiresIn / time.Second)}
+	}
+
+	var link DownloadLink
+	if err := c.doJSON(ctx, http.MethodPost, "/api/v1/jobs/"+url.PathEscape(id)+"/playback-link", nil, body, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Publication is a copy of a completed job's output to another destination
+type Publication struct {
+	ID          uint       `json:"id"`
+	JobID       string     `json:"job_id"`
+	Destination string     `json:"destination"`
+	Status      string     `json:"status"` // pending, uploading, completed or failed
+	FileID      string     `json:"file_id,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// PublishJob starts copying a completed job's output to the named
+// destination profile. The copy runs on the server; follow it with
+// ListPublications or the job.published and job.publish_failed webhooks.
+func (c *Client) PublishJob(ctx context.Context, id, destination string) (*Publication, error) {
+	var publication Publication
+	body := map[string]string{"destination": destination}
+	if err := c.doJSON(ctx, http.MethodPost, "/api/v1/jobs/"+url.PathEscape(id)+"/publish", nil, body, &publication); err != nil {
+		return nil, err
+	}
+	return &publication, nil
+}
+
+// ListPublications returns a job's publications, oldest first
+func (c *Client) ListPublications(ctx context.Context, id string) ([]Publication, error) {
+	var resp struct {
+		Publications []Publication `json:"publications"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id)+"/publications", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Publications, nil
+}
+
+// Download writes a completed job's output to w. It works for outputs kept
+// on the server; for Drive outputs use Job.DriveURL.
+func (c *Client) Download(ctx context.Context, id string, w io.Writer) (int64, error) {
+	link, err := c.CreateDownloadLink(ctx, id, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, decodeError(resp)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// getJobIfChanged fetches a job unless it still matches etag, in which case
+// it returns a nil job
+func (c *Client) getJobIfChanged(ctx context.Context, id, etag string) (*Job, string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, etag, nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, "", decodeError(resp)
+	}
+
+	var body struct {
+		Job Job `json:"job"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, "", fmt.Errorf("transcoder: decoding response: %w", err)
+	}
+	return &body.Job, resp.Header.Get("ETag"), nil
+}
+
+// WatchJob polls a job every interval and calls fn each time its state
+// changes, until the job finishes, fn returns an error, or ctx is done. It
+// returns the last job seen. Polls revalidate with the job's ETag, so
+// unchanged jobs cost the server no response body.
+func (c *Client) WatchJob(ctx context.Context, id string, interval time.Duration, fn func(*Job) error) (*Job, error) {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var job *Job
+	var etag string
+	for {
+		current, currentETag, err := c.getJobIfChanged(ctx, id, etag)
+		if err != nil {
+			return job, err
+		}
+
+		// A nil job means it is unchanged since the last poll
+		if current != nil {
+			job, etag = current, currentETag
+			if fn != nil {
+				if err := fn(job); err != nil {
+					return job, err
+				}
+			}
+			if job.Done() {
+				return job, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForJob watches a job until it finishes and returns an error if it did
+// not complete successfully
+func (c *Client) WaitForJob(ctx context.Context, id string, interval time.Duration, fn func(*Job) error) (*Job, error) {
+	job, err := c.WatchJob(ctx, id, interval, fn)
+	if err != nil {
+		return job, err
+	}
+	switch job.Status {
+	case StatusFailed, StatusDeadLetter:
+		return job, fmt.Errorf("transcoder: job %s failed: %s", job.ID, job.Error)
+	case StatusCancelled:
+		return job, fmt.Errorf("transcoder: job %s was cancelled", job.ID)
+	case StatusExpired:
+		return job, fmt.Errorf("transcoder: job %s expired in the queue", job.ID)
+	}
+	return job, nil
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader carries the signature of a webhook delivery when
+// the server has WEBHOOK_SECRET set
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// DefaultWebhookTolerance is how old a signed delivery may be before
+// VerifyWebhookSignature rejects it as a possible replay
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// Webhook verification errors
+var (
+	ErrMissingSignature = errors.New("transcoder: webhook signature missing")
+	ErrInvalidSignature = errors.New("transcoder: webhook signature invalid")
+	ErrSignatureExpired = errors.New("transcoder: webhook signature too old")
+)
+
+// Webhook events
+const (
+	EventJobCreated        = "job.created"
+	EventJobStarted        = "job.started"
+	EventJobProgress       = "job.progress"
+	EventJobOutputUploaded = "job.output_uploaded"
+	EventJobCompleted      = "job.completed"
+	EventJobFailed         = "job.failed"
+	EventJobCancelled      = "job.cancelled"
+	EventJobExpired        = "job.expired"
+	EventJobPublished      = "job.published"
+	EventJobPublishFailed  = "job.publish_failed"
+)
+
+// WebhookPayload is the body POSTed for a job lifecycle event
+type WebhookPayload struct {
+	Event        string `json:"event"`
+	EventID      string `json:"event_id"`
+	JobID        string `json:"job_id"`
+	Status       string `json:"status"`
+	Progress     int    `json:"progress,omitempty"`
+	DriveURL     string `json:"drive_url,omitempty"`
+	DriveFileID  string `json:"drive_file_id,omitempty"`
+	OutputName   string `json:"output_name,omitempty"`
+	Destination  string `json:"destination,omitempty"`
+	Error        string `json:"error,omitempty"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	Rejection    string `json:"rejection,omitempty"`
+	OriginalName string `json:"original_name"`
+	CompletedAt  string `json:"completed_at,omitempty"`
+	Timestamp    string `json:"timestamp"`
+	RequestID    string `json:"request_id,omitempty"`
+}
+
+// VerifyWebhookSignature checks a signature header ("t=<unix>,v1=<hex>")
+// against the raw request body. Deliveries older than tolerance are
+// rejected; a zero tolerance uses DefaultWebhookTolerance.
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration) error {
+	if header == "" {
+		return ErrMissingSignature
+	}
+	if tolerance == 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+
+	var timestamp int64
+	var signatures []string
+	for _, field := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = t
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	valid := false
+	for _, sig := range signatures {
+		decoded, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(decoded, expected) {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// ParseWebhook reads a webhook request, verifies its signature with secret,
+// and decodes the payload, unwrapping CloudEvents deliveries. Pass an empty
+// secret to skip verification when the server does not sign deliveries.
+func ParseWebhook(r *http.Request, secret string) (*WebhookPayload, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		if err := VerifyWebhookSignature(secret, r.Header.Get(WebhookSignatureHeader), body, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	var payload WebhookPayload
+	target := any(&payload)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/cloudevents+json") {
+		target = &struct {
+			Data *WebhookPayload `json:"data"`
+		}{&payload}
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return nil, fmt.Errorf("transcoder: decoding webhook: %w", err)
+	}
+	return &payload, nil
+}